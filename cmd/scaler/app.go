@@ -0,0 +1,635 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github/poller"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github/webhook"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/queue"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/election"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/placement"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/provider"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scheduling"
+	uhttp "github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils/http"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+	"github.com/spf13/viper"
+)
+
+// App is the scaler application.
+type App interface {
+	// Start starts the app and blocks until the context is cancelled.
+	Start(ctx context.Context) error
+}
+
+type app struct {
+	// vip is the application config.
+	vip *viper.Viper
+
+	// vc is the vault client.
+	vc vault.Client
+
+	// srv is the HTTP server.
+	srv *http.Server
+
+	// events is the durable queue of inbound events.
+	events *queue.Queue
+
+	// scaler turns the queued events into runners.
+	scaler *scaler.Service
+
+	// poller discovers queued jobs by polling the GitHub API. It is nil unless polling is enabled.
+	poller *poller.Poller
+
+	// placer picks the node of every runner VM and keeps the circuit breakers of the nodes.
+	placer *placement.Placer
+
+	// store keeps the state of the runners.
+	store scaler.Store
+
+	// elector elects the replica that runs the scaler. It is nil unless high availability is enabled.
+	elector *election.Elector
+
+	// dispatcher hands the queued events to the scaler, or to the leader on the other replicas.
+	dispatcher webhook.Dispatcher
+}
+
+func newApp(ctx context.Context, v *viper.Viper, vc vault.Client) (App, error) {
+	a := &app{
+		vip: v,
+		vc:  vc,
+	}
+
+	events, err := queue.Open(queue.Config{
+		Path:        v.GetString("queue.path"),
+		MaxAttempts: v.GetInt("queue.max_attempts"),
+		MinBackoff:  v.GetDuration("queue.min_backoff"),
+		MaxBackoff:  v.GetDuration("queue.max_backoff"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open event queue: %w", err)
+	}
+	a.events = events
+
+	gh, err := github.NewClient(ctx, github.Config{
+		AppID:   v.GetInt64("github.app_id"),
+		BaseURL: v.GetString("github.base_url"),
+		PrivateKey: github.KeyRef{
+			Mount: v.GetString("github.private_key.mount"),
+			Path:  v.GetString("github.private_key.path"),
+			Key:   v.GetString("github.private_key.key"),
+		},
+	}, vc)
+	if err != nil {
+		return nil, fmt.Errorf("create github client: %w", err)
+	}
+
+	px, err := proxmox.NewClient(ctx, proxmox.Config{
+		URL:       v.GetString("proxmox.url"),
+		TokenPath: v.GetString("proxmox.token_path"),
+		TLS: proxmox.TLSConfig{
+			CAFile:             v.GetString("proxmox.tls.ca_file"),
+			Fingerprint:        v.GetString("proxmox.tls.fingerprint"),
+			InsecureSkipVerify: v.GetBool("proxmox.tls.insecure_skip_verify"),
+		},
+		Timeout:          v.GetDuration("proxmox.timeout"),
+		TaskPollInterval: v.GetDuration("proxmox.task_poll_interval"),
+	}, vc)
+	if err != nil {
+		return nil, fmt.Errorf("create proxmox client: %w", err)
+	}
+
+	placer, err := newPlacer(v, px)
+	if err != nil {
+		return nil, fmt.Errorf("create placer: %w", err)
+	}
+	a.placer = placer
+
+	a.elector, err = newElector(v, vc)
+	if err != nil {
+		return nil, fmt.Errorf("create elector: %w", err)
+	}
+
+	store, err := scaler.NewFileStore(v.GetString("scaler.state_path"))
+	if err != nil {
+		return nil, fmt.Errorf("open state store: %w", err)
+	}
+	if a.elector != nil {
+		// A replica that stepped down must not overwrite the state of the new leader.
+		store = scaler.NewFencedStore(store, a.elector.IsLeader)
+	}
+	a.store = store
+
+	backends, err := newBackends(v, px, placer, store)
+	if err != nil {
+		return nil, err
+	}
+
+	warmPools := make([]scaler.WarmPool, 0)
+	if err := v.UnmarshalKey("scaler.warm_pools", &warmPools); err != nil {
+		return nil, fmt.Errorf("read warm pools: %w", err)
+	}
+	router, err := newRouter(v, backends)
+	if err != nil {
+		return nil, fmt.Errorf("create router: %w", err)
+	}
+	if err := checkWarmPools(warmPools, router, backends); err != nil {
+		return nil, err
+	}
+
+	scheduler, err := newScheduler(v)
+	if err != nil {
+		return nil, fmt.Errorf("create scheduler: %w", err)
+	}
+
+	a.scaler = scaler.NewService(scaler.Config{
+		RunnerScope:        github.ScopeKind(v.GetString("github.runners.scope")),
+		Enterprise:         v.GetString("github.runners.enterprise"),
+		RunnerGroupID:      v.GetInt64("github.runners.group_id"),
+		RunnerLabels:       v.GetStringSlice("github.runners.labels"),
+		RunnerPrefix:       v.GetString("github.runners.prefix"),
+		Router:             router,
+		Scheduler:          scheduler,
+		WarmPools:          warmPools,
+		WarmRefillInterval: v.GetDuration("scaler.warm_refill_interval"),
+		QueueInterval:      v.GetDuration("scaler.queue_interval"),
+		SweepInterval:      v.GetDuration("scaler.sweep_interval"),
+		ReconcileInterval:  v.GetDuration("scaler.reconcile_interval"),
+		StuckAfter:         v.GetDuration("scaler.stuck_after"),
+		Dispatcher:         webhook.NewQueueDispatcher(a.events),
+	}, store, gh, vc, backends)
+
+	if v.GetBool("github.poll.enabled") {
+		a.poller = poller.NewPoller(poller.Config{
+			Interval:              v.GetDuration("github.poll.interval"),
+			Repositories:          v.GetStringSlice("github.poll.repositories"),
+			Organizations:         v.GetStringSlice("github.poll.organizations"),
+			MinRateLimitRemaining: v.GetInt("github.poll.min_rate_limit_remaining"),
+		}, gh, webhook.NewMemoryDeliveryStore(v.GetDuration("github.webhook.dedup_ttl")), webhook.NewQueueDispatcher(a.events))
+	}
+
+	a.dispatcher = &leaderDispatcher{
+		elector:   a.elector,
+		scaler:    a.scaler,
+		forwarder: webhook.NewForwarder(vc, webhookSecret(v), &http.Client{Timeout: v.GetDuration("ha.forward_timeout")}, a.leaderAddress),
+	}
+
+	a.srv = &http.Server{
+		Addr:    fmt.Sprintf(":%s", *port),
+		Handler: a.routes(),
+	}
+
+	return a, nil
+}
+
+// webhookSecret returns where the webhook secret is stored.
+func webhookSecret(v *viper.Viper) webhook.SecretRef {
+	return webhook.SecretRef{
+		Mount: v.GetString("github.webhook.secret_mount"),
+		Path:  v.GetString("github.webhook.secret_path"),
+		Key:   v.GetString("github.webhook.secret_key"),
+	}
+}
+
+// newBackends creates the providers of the backends the runner pools can ask for. The lxc backend is only available
+// when a container template is configured.
+func newBackends(v *viper.Viper, px proxmox.Client, placer provider.Placer, store scaler.Store) (provider.Backends, error) {
+	snippets, err := provider.NewDirSnippetStore(v.GetString("proxmox.snippets.dir"), v.GetString("proxmox.snippets.storage"))
+	if err != nil {
+		return nil, fmt.Errorf("open snippet store: %w", err)
+	}
+
+	// The backends share the allocator, as VMIDs are unique across the cluster whatever the guest.
+	vmids := provider.VMIDConfig{ReservationTTL: v.GetDuration("proxmox.vmid_reservation_ttl")}
+	if v.IsSet("proxmox.vmid_range") {
+		vmids.Range = new(routing.VMIDRange)
+		if err := v.UnmarshalKey("proxmox.vmid_range", vmids.Range); err != nil {
+			return nil, fmt.Errorf("read vmid range: %w", err)
+		}
+	}
+	ids, err := provider.NewVMIDAllocator(vmids, px, store)
+	if err != nil {
+		return nil, fmt.Errorf("create vmid allocator: %w", err)
+	}
+
+	qemu, err := newQemuProvider(v, px, snippets, placer, ids)
+	if err != nil {
+		return nil, fmt.Errorf("create qemu provider: %w", err)
+	}
+	backends := provider.Backends{routing.BackendQemu: qemu}
+
+	if v.GetInt("proxmox.lxc.template_id") != 0 {
+		lxc, err := newLxcProvider(v, px, snippets, placer, ids)
+		if err != nil {
+			return nil, fmt.Errorf("create lxc provider: %w", err)
+		}
+		backends[routing.BackendLXC] = lxc
+	}
+
+	return backends, nil
+}
+
+// newQemuProvider creates the provider that clones the runner VMs.
+func newQemuProvider(v *viper.Viper, px proxmox.Client, snippets provider.SnippetStore, placer provider.Placer, ids *provider.VMIDAllocator) (*provider.Qemu, error) {
+	var userDataTemplate string
+	if path := v.GetString("proxmox.cloud_init.user_data_template"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read user data template: %w", err)
+		}
+		userDataTemplate = string(b)
+	}
+
+	var bootstrapTemplate string
+	if path := v.GetString("proxmox.agent.bootstrap_template"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read agent bootstrap template: %w", err)
+		}
+		bootstrapTemplate = string(b)
+	}
+
+	return provider.NewQemu(provider.QemuConfig{
+		Node:              v.GetString("proxmox.node"),
+		TemplateID:        v.GetInt("proxmox.template_id"),
+		FullClone:         v.GetBool("proxmox.full_clone"),
+		Storage:           v.GetString("proxmox.storage"),
+		Pool:              v.GetString("proxmox.pool"),
+		Disk:              v.GetString("proxmox.disk"),
+		User:              v.GetString("proxmox.cloud_init.user"),
+		SSHKeys:           v.GetStringSlice("proxmox.cloud_init.ssh_keys"),
+		IPConfig0:         v.GetString("proxmox.cloud_init.ipconfig0"),
+		UserDataTemplate:  userDataTemplate,
+		RunnerURL:         v.GetString("proxmox.cloud_init.runner_url"),
+		CallbackURL:       v.GetString("proxmox.cloud_init.callback_url"),
+		Bootstrap:         v.GetString("proxmox.bootstrap"),
+		BootstrapTemplate: bootstrapTemplate,
+		WaitForAgent:      v.GetBool("proxmox.agent.wait"),
+		AgentTimeout:      v.GetDuration("proxmox.agent.timeout"),
+		AgentPollInterval: v.GetDuration("proxmox.agent.poll_interval"),
+		Attempts:          v.GetInt("proxmox.retry.attempts"),
+		MinBackoff:        v.GetDuration("proxmox.retry.min_backoff"),
+		MaxBackoff:        v.GetDuration("proxmox.retry.max_backoff"),
+	}, px, snippets, placer, ids)
+}
+
+// newLxcProvider creates the provider that clones the runner containers.
+func newLxcProvider(v *viper.Viper, px proxmox.Client, snippets provider.SnippetStore, placer provider.Placer, ids *provider.VMIDAllocator) (*provider.Lxc, error) {
+	var bootstrapTemplate string
+	if path := v.GetString("proxmox.lxc.bootstrap_template"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read bootstrap template: %w", err)
+		}
+		bootstrapTemplate = string(b)
+	}
+
+	return provider.NewLxc(provider.LxcConfig{
+		Node:              v.GetString("proxmox.node"),
+		TemplateID:        v.GetInt("proxmox.lxc.template_id"),
+		FullClone:         v.GetBool("proxmox.full_clone"),
+		Storage:           v.GetString("proxmox.storage"),
+		Pool:              v.GetString("proxmox.pool"),
+		Disk:              v.GetString("proxmox.lxc.disk"),
+		Net0:              v.GetString("proxmox.lxc.net0"),
+		User:              v.GetString("proxmox.cloud_init.user"),
+		SSHKeys:           v.GetStringSlice("proxmox.cloud_init.ssh_keys"),
+		BootstrapTemplate: bootstrapTemplate,
+		HookScript:        v.GetString("proxmox.lxc.hook_script"),
+		RunnerURL:         v.GetString("proxmox.cloud_init.runner_url"),
+		CallbackURL:       v.GetString("proxmox.cloud_init.callback_url"),
+		Attempts:          v.GetInt("proxmox.retry.attempts"),
+		MinBackoff:        v.GetDuration("proxmox.retry.min_backoff"),
+		MaxBackoff:        v.GetDuration("proxmox.retry.max_backoff"),
+	}, px, snippets, placer, ids)
+}
+
+// newPlacer creates the placer that picks the node of every runner VM.
+func newPlacer(v *viper.Viper, px proxmox.Client) (*placement.Placer, error) {
+	strategy, err := placement.NewStrategy(v.GetString("placement.strategy"))
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]placement.NodeConfig)
+	if err := v.UnmarshalKey("placement.nodes", &nodes); err != nil {
+		return nil, fmt.Errorf("read node config: %w", err)
+	}
+
+	return placement.NewPlacer(placement.Config{
+		MaxLoad:         v.GetFloat64("placement.max_load"),
+		MemoryReserve:   v.GetInt64("placement.memory_reserve_mb") << 20,
+		Storage:         v.GetString("placement.storage"),
+		StorageHeadroom: v.GetFloat64("placement.storage_headroom"),
+		MaxVMs:          v.GetInt("placement.max_vms"),
+		MaxCores:        v.GetInt("placement.max_cores"),
+		MaxMemory:       v.GetInt64("placement.max_memory_mb") << 20,
+		Nodes:           nodes,
+		Breaker: placement.BreakerConfig{
+			Failures: v.GetInt("placement.breaker.failures"),
+			CoolDown: v.GetDuration("placement.breaker.cool_down"),
+		},
+	}, px, strategy), nil
+}
+
+// newRouter creates the router that routes the jobs to the pools. It returns nil when no pools are configured, every
+// job then gets the default VM.
+func newRouter(v *viper.Viper, backends provider.Backends) (*routing.Router, error) {
+	pools := make([]routing.Pool, 0)
+	if err := v.UnmarshalKey("scaler.pools", &pools); err != nil {
+		return nil, fmt.Errorf("read pools: %w", err)
+	} else if len(pools) == 0 {
+		return nil, nil
+	}
+
+	implicit := append(append([]string(nil), scaler.ImplicitLabels...), v.GetStringSlice("github.runners.labels")...)
+	router, err := routing.NewRouter(pools, implicit)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pool := range router.Pools() {
+		if _, ok := backends[cmp.Or(pool.Backend, routing.BackendQemu)]; !ok {
+			return nil, fmt.Errorf("pool %q: backend %q is not configured", pool.Name, pool.Backend)
+		}
+	}
+
+	return router, nil
+}
+
+// checkWarmPools checks that every warm pool fills a known pool with a recycle mode its backend supports. The warm
+// pools fill the qemu backend when the jobs are not routed to pools.
+func checkWarmPools(warmPools []scaler.WarmPool, router *routing.Router, backends provider.Backends) error {
+	for _, wp := range warmPools {
+		switch wp.Recycle {
+		case "", scaler.RecycleDestroy, scaler.RecycleSnapshot:
+		default:
+			return fmt.Errorf("warm pool %q: unknown recycle mode %q", wp.Name, wp.Recycle)
+		}
+
+		backend := routing.BackendQemu
+		if router != nil {
+			pool, ok := router.Pool(wp.Pool)
+			if !ok {
+				return fmt.Errorf("warm pool %q: unknown pool %q", wp.Name, wp.Pool)
+			}
+			backend = cmp.Or(pool.Backend, routing.BackendQemu)
+		}
+
+		if _, ok := backends[backend].(scaler.Recycler); !ok && wp.Recycle == scaler.RecycleSnapshot {
+			return fmt.Errorf("warm pool %q: the %s backend cannot recycle machines", wp.Name, backend)
+		}
+	}
+
+	return nil
+}
+
+// newScheduler creates the scheduler that orders the queued jobs by their priority class and fair share.
+func newScheduler(v *viper.Viper) (*scheduling.Scheduler, error) {
+	cfg := scheduling.Config{}
+	if err := v.UnmarshalKey("scaler.scheduling.classes", &cfg.Classes); err != nil {
+		return nil, fmt.Errorf("read priority classes: %w", err)
+	}
+	if err := v.UnmarshalKey("scaler.scheduling.weights", &cfg.Weights); err != nil {
+		return nil, fmt.Errorf("read weights: %w", err)
+	}
+
+	return scheduling.NewScheduler(cfg)
+}
+
+// routes builds the HTTP handler with the common middlewares applied.
+func (a *app) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		uhttp.SendMessage(w, "OK")
+	})
+
+	mux.Handle("POST /webhooks/github", webhook.NewHandler(a.vc, webhookSecret(a.vip),
+		webhook.NewMemoryDeliveryStore(a.vip.GetDuration("github.webhook.dedup_ttl")), webhook.NewQueueDispatcher(a.events)))
+
+	mux.HandleFunc("GET /api/queue", uhttp.InternalOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := uhttp.Encode(w, http.StatusOK, a.events.Stats()); err != nil {
+			slog.Error("unable to encode queue stats", slog.String(logging.KeyError, err.Error()))
+		}
+	})))
+
+	mux.HandleFunc("GET /api/queue/dead", uhttp.InternalOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := uhttp.Encode(w, http.StatusOK, a.events.DeadLetters()); err != nil {
+			slog.Error("unable to encode dead letters", slog.String(logging.KeyError, err.Error()))
+		}
+	})))
+
+	mux.HandleFunc("GET /api/pools", uhttp.InternalOnly(a.leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		pools, err := a.scaler.Pools(r.Context())
+		if err != nil {
+			slog.Error("unable to get pools", slog.String(logging.KeyError, err.Error()))
+			uhttp.SendErrorMessageWithStatus(w, http.StatusInternalServerError, "Unable to get pools", err)
+			return
+		}
+
+		if err := uhttp.Encode(w, http.StatusOK, pools); err != nil {
+			slog.Error("unable to encode pools", slog.String(logging.KeyError, err.Error()))
+		}
+	})))
+
+	mux.HandleFunc("GET /api/jobs", uhttp.InternalOnly(a.leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := a.scaler.QueuedJobs(r.Context())
+		if err != nil {
+			slog.Error("unable to get queued jobs", slog.String(logging.KeyError, err.Error()))
+			uhttp.SendErrorMessageWithStatus(w, http.StatusInternalServerError, "Unable to get queued jobs", err)
+			return
+		}
+
+		if err := uhttp.Encode(w, http.StatusOK, jobs); err != nil {
+			slog.Error("unable to encode queued jobs", slog.String(logging.KeyError, err.Error()))
+		}
+	})))
+
+	mux.HandleFunc("GET /api/jobs/{id}", uhttp.InternalOnly(a.leaderOnly(a.queuedJob)))
+
+	mux.HandleFunc("GET /api/breakers", uhttp.InternalOnly(a.leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		if err := uhttp.Encode(w, http.StatusOK, a.placer.Breakers()); err != nil {
+			slog.Error("unable to encode circuit breakers", slog.String(logging.KeyError, err.Error()))
+		}
+	})))
+
+	mux.HandleFunc("GET /api/reconcile", uhttp.InternalOnly(a.leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		report := a.scaler.LastReport()
+		if report == nil {
+			uhttp.SendMessageWithStatus(w, http.StatusNotFound, "No reconciliation pass has run yet")
+			return
+		}
+
+		if err := uhttp.Encode(w, http.StatusOK, report); err != nil {
+			slog.Error("unable to encode reconcile report", slog.String(logging.KeyError, err.Error()))
+		}
+	})))
+
+	mux.HandleFunc("GET /api/leader", a.leader)
+
+	mux.HandleFunc("POST /api/runners/{name}/exited", a.leaderOnly(a.runnerExited))
+
+	mux.HandleFunc("GET /api/runners/{name}/service", uhttp.InternalOnly(a.leaderOnly(a.runnerService("status"))))
+
+	mux.HandleFunc("POST /api/runners/{name}/service/start", uhttp.InternalOnly(a.leaderOnly(a.runnerService("start"))))
+
+	mux.Handle("/", uhttp.NotFoundHandler())
+
+	var h http.Handler = mux
+	h = uhttp.AuthHeaderToContextMux()(h)
+	h = uhttp.RequestIDToContextMux()(h)
+
+	return h
+}
+
+// queuedJob returns the position of a queued job and why it is waiting.
+func (a *app) queuedJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		uhttp.SendMessageWithStatus(w, http.StatusBadRequest, "Invalid job ID %q", r.PathValue("id"))
+		return
+	}
+
+	jobs, err := a.scaler.QueuedJobs(r.Context())
+	if err != nil {
+		slog.Error("unable to get queued jobs", slog.String(logging.KeyError, err.Error()))
+		uhttp.SendErrorMessageWithStatus(w, http.StatusInternalServerError, "Unable to get queued jobs", err)
+		return
+	}
+
+	for _, job := range jobs {
+		if job.JobID != id {
+			continue
+		}
+
+		if err := uhttp.Encode(w, http.StatusOK, job); err != nil {
+			slog.Error("unable to encode queued job", slog.String(logging.KeyError, err.Error()))
+		}
+		return
+	}
+
+	uhttp.SendMessageWithStatus(w, http.StatusNotFound, "Job %d is not queued", id)
+}
+
+// runnerExited tears down a runner whose machine reported that the runner process exited.
+func (a *app) runnerExited(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	token := strings.TrimPrefix(uhttp.AuthHeaderFromContext(r.Context()), "Bearer ")
+
+	err := a.scaler.Exited(r.Context(), name, token)
+	switch {
+	case err == nil:
+		uhttp.SendMessage(w, "Runner torn down")
+	case errors.Is(err, scaler.ErrInvalidCallbackToken):
+		uhttp.UnauthorizedHandler().ServeHTTP(w, r)
+	case errors.Is(err, scaler.ErrRunnerNotFound):
+		uhttp.SendMessageWithStatus(w, http.StatusNotFound, "Runner %s not found", name)
+	default:
+		slog.Error("unable to tear down exited runner",
+			slog.String(logging.KeyRunner, name),
+			slog.String(logging.KeyError, err.Error()),
+		)
+		uhttp.SendErrorMessageWithStatus(w, http.StatusInternalServerError, "Unable to tear down runner", err)
+	}
+}
+
+// runnerService returns the handler that runs systemctl with the action on the runner service in the machine of a
+// runner, through the guest agent.
+func (a *app) runnerService(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+
+		res, err := a.scaler.RunnerService(r.Context(), name, action)
+		switch {
+		case err == nil:
+			if err := uhttp.Encode(w, http.StatusOK, res); err != nil {
+				slog.Error("unable to encode runner service result", slog.String(logging.KeyError, err.Error()))
+			}
+		case errors.Is(err, scaler.ErrRunnerNotFound):
+			uhttp.SendMessageWithStatus(w, http.StatusNotFound, "Runner %s not found", name)
+		case errors.Is(err, scaler.ErrNoMachine):
+			uhttp.SendMessageWithStatus(w, http.StatusConflict, "Runner %s has no machine yet", name)
+		case errors.Is(err, scaler.ErrCommandsUnsupported):
+			uhttp.SendMessageWithStatus(w, http.StatusNotImplemented, "The machine of runner %s cannot run commands", name)
+		default:
+			slog.Error("unable to run command on runner service",
+				slog.String(logging.KeyRunner, name),
+				slog.String("action", action),
+				slog.String(logging.KeyError, err.Error()),
+			)
+			uhttp.SendErrorMessageWithStatus(w, http.StatusBadGateway, "Unable to reach the runner service", err)
+		}
+	}
+}
+
+func (a *app) Start(ctx context.Context) error {
+	defer func() {
+		if err := a.events.Close(); err != nil {
+			slog.Error("unable to close event queue", slog.String(logging.KeyError, err.Error()))
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 3)
+
+	go func() {
+		slog.Info("starting http server", slog.String("addr", a.srv.Addr))
+		if err := a.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs <- fmt.Errorf("http server: %w", err)
+		}
+	}()
+
+	go func() {
+		slog.Info("starting event workers", slog.Int("workers", a.vip.GetInt("queue.workers")))
+		if err := a.events.Run(ctx, a.vip.GetInt("queue.workers"), webhook.MessageHandler(a.dispatcher)); err != nil {
+			errs <- fmt.Errorf("event workers: %w", err)
+		}
+	}()
+
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		if err := a.runLeader(ctx); err != nil {
+			errs <- err
+		}
+	}()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+	case runErr = <-errs:
+	}
+
+	cancel()
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), a.vip.GetDuration("http.shutdown_timeout"))
+	defer cancelShutdown()
+
+	if err := a.srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("unable to shutdown http server", slog.String(logging.KeyError, err.Error()))
+	}
+
+	// The leader frees its lease once its loops have stopped, so that another replica takes over straight away.
+	select {
+	case <-leaderDone:
+	case <-shutdownCtx.Done():
+		slog.Error("timed out waiting for the leader loops to stop")
+	}
+
+	return runErr
+}
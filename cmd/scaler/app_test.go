@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/provider"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckWarmPools(t *testing.T) {
+	router, err := routing.NewRouter([]routing.Pool{
+		{Name: "vms", Labels: []string{"vm"}},
+		{Name: "containers", Labels: []string{"container"}, Backend: routing.BackendLXC},
+	}, scaler.ImplicitLabels)
+	require.NoError(t, err)
+
+	backends := provider.Backends{
+		routing.BackendQemu: new(provider.Qemu),
+		routing.BackendLXC:  new(provider.Lxc),
+	}
+
+	tests := []struct {
+		name    string
+		router  *routing.Router
+		pool    scaler.WarmPool
+		wantErr string
+	}{
+		{
+			name:   "snapshot on qemu",
+			router: router,
+			pool:   scaler.WarmPool{Name: "warm", Pool: "vms", Recycle: scaler.RecycleSnapshot},
+		},
+		{
+			name:   "destroy on lxc",
+			router: router,
+			pool:   scaler.WarmPool{Name: "warm", Pool: "containers", Recycle: scaler.RecycleDestroy},
+		},
+		{
+			name:    "snapshot on lxc",
+			router:  router,
+			pool:    scaler.WarmPool{Name: "warm", Pool: "containers", Recycle: scaler.RecycleSnapshot},
+			wantErr: `warm pool "warm": the lxc backend cannot recycle machines`,
+		},
+		{
+			name:   "snapshot without pools",
+			router: nil,
+			pool:   scaler.WarmPool{Name: "warm", Recycle: scaler.RecycleSnapshot},
+		},
+		{
+			name:    "unknown pool",
+			router:  router,
+			pool:    scaler.WarmPool{Name: "warm", Pool: "gpus"},
+			wantErr: `warm pool "warm": unknown pool "gpus"`,
+		},
+		{
+			name:    "unknown recycle mode",
+			router:  router,
+			pool:    scaler.WarmPool{Name: "warm", Pool: "vms", Recycle: "reboot"},
+			wantErr: `warm pool "warm": unknown recycle mode "reboot"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkWarmPools([]scaler.WarmPool{tt.pool}, tt.router, backends)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
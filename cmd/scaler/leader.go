@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github/webhook"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/election"
+	uhttp "github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils/http"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+	"github.com/spf13/viper"
+)
+
+const (
+	// headerProxiedBy marks a request a replica proxied to the leader, so that it is never proxied twice.
+	headerProxiedBy = "X-Scaler-Proxied-By"
+)
+
+// errNoLeader is returned while no replica is known to lead.
+var errNoLeader = errors.New("no leader elected")
+
+// leaderStatus is the body of GET /api/leader.
+type leaderStatus struct {
+	// Replica is the ID of the replica serving the request.
+	Replica string `json:"replica"`
+
+	// Leading is true if the replica serving the request leads.
+	Leading bool `json:"leading"`
+
+	// Leader is the lease of the leader, if one is known.
+	Leader *election.Lease `json:"leader,omitempty"`
+}
+
+// newElector creates the elector that elects the replica running the scaler. It returns nil when high availability is
+// not enabled, the only replica then always leads.
+func newElector(v *viper.Viper, vc vault.Client) (*election.Elector, error) {
+	if !v.GetBool("ha.enabled") {
+		return nil, nil
+	}
+
+	address := v.GetString("ha.advertise_url")
+	if address == "" {
+		return nil, errors.New("ha.advertise_url is required when ha.enabled is set")
+	} else if _, err := url.Parse(address); err != nil {
+		return nil, fmt.Errorf("parse ha.advertise_url: %w", err)
+	}
+
+	return election.NewElector(election.Config{
+		ID:            v.GetString("ha.id"),
+		Address:       address,
+		LeaseDuration: v.GetDuration("ha.lease_duration"),
+		RenewInterval: v.GetDuration("ha.renew_interval"),
+	}, election.NewVaultStore(vc, v.GetString("ha.lease.mount"), v.GetString("ha.lease.path")))
+}
+
+// runLeader runs the loops only the leader runs, for as long as the replica leads.
+func (a *app) runLeader(ctx context.Context) error {
+	if a.elector == nil {
+		return a.lead(ctx)
+	}
+
+	slog.Info("joining leader election", slog.String("replica", a.elector.ID()))
+	return a.elector.Run(ctx, a.lead)
+}
+
+// lead runs the loops only the leader runs until the context is cancelled or one of them fails. The state is read
+// again first, as another replica may have changed it while it led.
+func (a *app) lead(ctx context.Context) error {
+	if err := a.store.Reload(ctx); err != nil {
+		return fmt.Errorf("reload state: %w", err)
+	}
+
+	loops := map[string]func(ctx context.Context) error{
+		"warm pools":   a.scaler.RunWarmPools,
+		"queue":        a.scaler.RunQueue,
+		"runner sweep": a.scaler.RunSweep,
+		"reconciler":   a.scaler.RunReconciler,
+	}
+	if a.poller != nil {
+		loops["poller"] = a.poller.Run
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		once    sync.Once
+		leadErr error
+	)
+	for name, run := range loops {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := run(ctx); err != nil {
+				once.Do(func() {
+					leadErr = fmt.Errorf("%s: %w", name, err)
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	return leadErr
+}
+
+// leaderAddress returns the URL of the leader.
+func (a *app) leaderAddress() (string, error) {
+	leader := a.elector.Leader()
+	if leader == nil {
+		return "", errNoLeader
+	}
+	return leader.Address, nil
+}
+
+// leaderDispatcher hands the queued events to the scaler on the leader, and forwards them to the leader on the other
+// replicas.
+type leaderDispatcher struct {
+	elector   *election.Elector
+	scaler    webhook.Dispatcher
+	forwarder webhook.Dispatcher
+}
+
+// Dispatch implements webhook.Dispatcher.
+func (d *leaderDispatcher) Dispatch(ctx context.Context, event webhook.Event) error {
+	if d.elector == nil || d.elector.IsLeader() {
+		return d.scaler.Dispatch(ctx, event)
+	}
+	return d.forwarder.Dispatch(ctx, event)
+}
+
+// leaderOnly serves the request on the leader, which alone has the current state of the runners, and proxies it to
+// the leader on the other replicas.
+func (a *app) leaderOnly(h http.HandlerFunc) http.HandlerFunc {
+	if a.elector == nil {
+		return h
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.elector.IsLeader() {
+			h(w, r)
+			return
+		}
+
+		if by := r.Header.Get(headerProxiedBy); by != "" {
+			uhttp.SendMessageWithStatus(w, http.StatusServiceUnavailable, "Replica %s is not the leader either", by)
+			return
+		}
+
+		address, err := a.leaderAddress()
+		if err != nil {
+			uhttp.SendMessageWithStatus(w, http.StatusServiceUnavailable, "No leader elected")
+			return
+		}
+
+		target, err := url.Parse(address)
+		if err != nil {
+			slog.Error("unable to parse leader address", slog.String(logging.KeyError, err.Error()))
+			uhttp.SendErrorMessageWithStatus(w, http.StatusBadGateway, "Unable to reach the leader", err)
+			return
+		}
+
+		// The proxy must not set X-Forwarded-For, or the leader takes the request for an external one and refuses it.
+		r.Header.Set(headerProxiedBy, a.elector.ID())
+		proxy := &httputil.ReverseProxy{Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+		}}
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// leader returns the state of the election as seen by this replica.
+func (a *app) leader(w http.ResponseWriter, _ *http.Request) {
+	status := &leaderStatus{Leading: true}
+	if a.elector != nil {
+		status = &leaderStatus{
+			Replica: a.elector.ID(),
+			Leading: a.elector.IsLeader(),
+			Leader:  a.elector.Leader(),
+		}
+	}
+
+	if err := uhttp.Encode(w, http.StatusOK, status); err != nil {
+		slog.Error("unable to encode leader status", slog.String(logging.KeyError, err.Error()))
+	}
+}
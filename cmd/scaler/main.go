@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/placement"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/provider"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+	"github.com/spf13/viper"
+)
+
+const (
+	// appName is the name of the application.
+	appName = "scaler"
+)
+
+var (
+	configLocation = flag.String("config", "config.json", "The location of the config file")
+	port           = flag.String("port", "8080", "The port to run the HTTP server on")
+)
+
+func main() {
+	flag.Parse()
+
+	if err := logging.GeneralLogger(appName); err != nil {
+		fmt.Println("unable to initialize logger:", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	v, err := initializeConfig()
+	if err != nil {
+		slog.Error("unable to initialize config", slog.String(logging.KeyError, err.Error()))
+		os.Exit(1)
+	}
+
+	vc, err := newVaultClient(v)
+	if err != nil {
+		slog.Error("unable to initialize vault client", slog.String(logging.KeyError, err.Error()))
+		os.Exit(1)
+	}
+
+	a, err := newApp(ctx, v, vc)
+	if err != nil {
+		slog.Error("unable to create app", slog.String(logging.KeyError, err.Error()))
+		os.Exit(1)
+	}
+
+	if err := a.Start(ctx); err != nil {
+		slog.Error("app exited with error", slog.String(logging.KeyError, err.Error()))
+		os.Exit(1)
+	}
+
+	slog.Info("app stopped")
+}
+
+// initializeConfig reads the config file into a new viper instance.
+func initializeConfig() (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigFile(*configLocation)
+
+	v.SetDefault("queue.path", "data/events.wal")
+	v.SetDefault("queue.workers", 4)
+	v.SetDefault("queue.max_attempts", 10)
+	v.SetDefault("queue.min_backoff", "5s")
+	v.SetDefault("queue.max_backoff", "5m")
+	v.SetDefault("http.shutdown_timeout", "10s")
+	v.SetDefault("vault.auth_method", "approle")
+	v.SetDefault("ha.lease.mount", "secret")
+	v.SetDefault("ha.lease.path", "proxmox-github-runners/leader")
+	v.SetDefault("ha.lease_duration", "10s")
+	v.SetDefault("ha.renew_interval", "2s")
+	v.SetDefault("ha.forward_timeout", "10s")
+	v.SetDefault("scaler.state_path", "data/state.json")
+	v.SetDefault("scaler.warm_refill_interval", "30s")
+	v.SetDefault("scaler.queue_interval", "15s")
+	v.SetDefault("scaler.sweep_interval", "1m")
+	v.SetDefault("scaler.reconcile_interval", "5m")
+	v.SetDefault("scaler.stuck_after", "15m")
+	v.SetDefault("github.base_url", github.DefaultBaseURL)
+	v.SetDefault("github.private_key.mount", "secret")
+	v.SetDefault("github.private_key.key", "private_key")
+	v.SetDefault("github.runners.scope", string(github.ScopeOrganization))
+	v.SetDefault("github.runners.group_id", 1)
+	v.SetDefault("github.runners.prefix", "pgr")
+	v.SetDefault("github.webhook.secret_mount", "secret")
+	v.SetDefault("github.webhook.secret_key", "secret")
+	v.SetDefault("github.webhook.dedup_ttl", "24h")
+	v.SetDefault("github.poll.interval", "30s")
+	v.SetDefault("github.poll.min_rate_limit_remaining", 100)
+	v.SetDefault("proxmox.timeout", "30s")
+	v.SetDefault("proxmox.task_poll_interval", "1s")
+	v.SetDefault("proxmox.disk", "scsi0")
+	v.SetDefault("proxmox.cloud_init.user", "runner")
+	v.SetDefault("proxmox.cloud_init.ipconfig0", "ip=dhcp")
+	v.SetDefault("proxmox.vmid_reservation_ttl", "10m")
+	v.SetDefault("proxmox.bootstrap", provider.BootstrapCloudInit)
+	v.SetDefault("proxmox.agent.timeout", "5m")
+	v.SetDefault("proxmox.agent.poll_interval", "2s")
+	v.SetDefault("proxmox.lxc.disk", "rootfs")
+	v.SetDefault("proxmox.lxc.net0", "name=eth0,bridge=vmbr0,ip=dhcp")
+	v.SetDefault("proxmox.lxc.hook_script", provider.DefaultHookScriptName)
+	v.SetDefault("proxmox.retry.attempts", 3)
+	v.SetDefault("proxmox.retry.min_backoff", "2s")
+	v.SetDefault("proxmox.retry.max_backoff", "30s")
+	v.SetDefault("placement.strategy", placement.StrategySpread)
+	v.SetDefault("placement.max_load", 0.9)
+	v.SetDefault("placement.memory_reserve_mb", 2048)
+	v.SetDefault("placement.storage_headroom", 0.1)
+	v.SetDefault("placement.breaker.failures", 3)
+	v.SetDefault("placement.breaker.cool_down", "5m")
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("unable to read config file: %w", err)
+	}
+
+	return v, nil
+}
+
+// newVaultClient logs in to Vault with the auth method set in the config.
+func newVaultClient(v *viper.Viper) (vault.Client, error) {
+	switch method := v.GetString("vault.auth_method"); method {
+	case "approle":
+		return vault.NewClientAppRole(v)
+	case "userpass":
+		return vault.NewClientUserPass(v)
+	default:
+		return nil, fmt.Errorf("unsupported vault auth method %q", method)
+	}
+}
@@ -0,0 +1,230 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+)
+
+const (
+	// DefaultBaseURL is the base URL of the public GitHub API.
+	DefaultBaseURL = "https://api.github.com"
+
+	// apiVersion is the REST API version the client is written against.
+	apiVersion = "2022-11-28"
+)
+
+// Client is a GitHub API client that authenticates as a GitHub App.
+type Client interface {
+	// AppJWT returns a JWT that authenticates as the app itself.
+	AppJWT() (string, error)
+
+	// InstallationToken returns an installation access token. Tokens are cached until shortly before they expire.
+	InstallationToken(ctx context.Context, installationID int64) (string, error)
+
+	// Installation returns the ID of the app installation on the scope.
+	Installation(ctx context.Context, scope Scope) (int64, error)
+
+	// GenerateJITConfig registers a runner with the scope and returns its just-in-time configuration.
+	GenerateJITConfig(ctx context.Context, installationID int64, scope Scope, req *JITConfigRequest) (*JITConfig, error)
+
+	// ListRunners returns the self-hosted runners registered with the scope.
+	ListRunners(ctx context.Context, installationID int64, scope Scope) ([]*Runner, error)
+
+	// DeleteRunner removes the runner registration from the scope.
+	DeleteRunner(ctx context.Context, installationID int64, scope Scope, runnerID int64) error
+
+	// GetWorkflowJob returns the workflow job of the repository.
+	GetWorkflowJob(ctx context.Context, installationID int64, repository string, jobID int64) (*WorkflowJob, error)
+
+	// ListInstallationRepositories returns the full names of the repositories the installation can access. It is a
+	// conditional request when etag is set, see ErrNotModified.
+	ListInstallationRepositories(ctx context.Context, installationID int64, etag string) ([]string, *Response, error)
+
+	// ListWorkflowRuns returns the workflow runs of the repository with the given status. It is a conditional request
+	// when etag is set, see ErrNotModified.
+	ListWorkflowRuns(ctx context.Context, installationID int64, repository, status, etag string) ([]*WorkflowRun, *Response, error)
+
+	// ListWorkflowRunJobs returns the jobs of the latest attempt of the workflow run. It is a conditional request when
+	// etag is set, see ErrNotModified.
+	ListWorkflowRunJobs(ctx context.Context, installationID int64, repository string, runID int64, etag string) ([]*WorkflowJob, *Response, error)
+}
+
+// KeyRef is where the app private key is stored in Vault KV v2.
+type KeyRef struct {
+	// Mount is the KV v2 mount path.
+	Mount string
+
+	// Path is the path of the secret within the mount.
+	Path string
+
+	// Key is the key of the PEM encoded private key within the secret data.
+	Key string
+}
+
+// Config is the configuration for the app client.
+type Config struct {
+	// AppID is the ID of the GitHub App.
+	AppID int64
+
+	// BaseURL is the base URL of the API. Defaults to DefaultBaseURL, set it for GitHub Enterprise Server.
+	BaseURL string
+
+	// PrivateKey is where the app private key is stored in Vault.
+	PrivateKey KeyRef
+
+	// HTTPClient is the HTTP client to use. Defaults to a client with a 30 second timeout.
+	HTTPClient *http.Client
+}
+
+type appClient struct {
+	appID   int64
+	baseURL string
+	key     *rsa.PrivateKey
+	hc      *http.Client
+
+	// mu guards tokens and minting.
+	mu sync.Mutex
+
+	// tokens are the cached installation tokens keyed by installation ID.
+	tokens map[int64]*installationToken
+
+	// minting are the installation tokens being minted keyed by installation ID.
+	minting map[int64]*tokenMint
+
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+}
+
+// NewClient creates a client for the app, reading the app private key from Vault.
+func NewClient(ctx context.Context, cfg Config, vc vault.Client) (Client, error) {
+	if cfg.AppID == 0 {
+		return nil, errors.New("app id is required")
+	}
+
+	secret, err := vc.GetKvSecretV2(ctx, cfg.PrivateKey.Mount, cfg.PrivateKey.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read app private key: %w", err)
+	}
+
+	pemData, ok := secret.Data[cfg.PrivateKey.Key].(string)
+	if !ok || pemData == "" {
+		return nil, fmt.Errorf("app private key not found at key %q", cfg.PrivateKey.Key)
+	}
+
+	key, err := ParsePrivateKey([]byte(pemData))
+	if err != nil {
+		return nil, err
+	}
+
+	return newAppClient(cfg, key), nil
+}
+
+func newAppClient(cfg Config, key *rsa.PrivateKey) *appClient {
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	hc := cfg.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return &appClient{
+		appID:   cfg.AppID,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		key:     key,
+		hc:      hc,
+		tokens:  make(map[int64]*installationToken),
+		minting: make(map[int64]*tokenMint),
+		now:     time.Now,
+	}
+}
+
+func (c *appClient) AppJWT() (string, error) {
+	return signJWT(c.key, c.appID, c.now())
+}
+
+// doJSON sends a request with the given authorization and decodes the JSON response into out if it is not nil.
+func (c *appClient) doJSON(ctx context.Context, authorization, method, path string, in, out any) error {
+	_, err := c.do(ctx, authorization, method, path, "", in, out)
+	return err
+}
+
+// do sends a request with the given authorization and decodes the JSON response into out if it is not nil. If etag
+// is set the request is conditional, and ErrNotModified is returned when the resource has not changed. The metadata of
+// the response is returned whenever a response was received.
+func (c *appClient) do(ctx context.Context, authorization, method, path, etag string, in, out any) (*Response, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("encode request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", apiVersion)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	meta := newResponse(resp)
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return meta, ErrNotModified
+	case resp.StatusCode >= http.StatusBadRequest:
+		return meta, fmt.Errorf("%s %s: %w", method, path, responseError(resp))
+	case out == nil || resp.StatusCode == http.StatusNoContent:
+		return meta, nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return meta, fmt.Errorf("decode response: %w", err)
+	}
+
+	return meta, nil
+}
+
+// responseError builds the error for an unsuccessful response.
+func responseError(resp *http.Response) error {
+	apiErr := new(struct {
+		Message string `json:"message"`
+	})
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err := json.Unmarshal(b, apiErr); err != nil || apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+
+	return utils.NewHttpError(resp.StatusCode, apiErr.Message)
+}
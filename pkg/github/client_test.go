@@ -0,0 +1,245 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func TestSignJWT(t *testing.T) {
+	key := newTestKey(t)
+	now := time.Unix(1700000000, 0)
+
+	token, err := signJWT(key, 1234, now)
+	require.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	claims := new(jwtClaims)
+	require.NoError(t, json.Unmarshal(rawClaims, claims))
+	require.Equal(t, "1234", claims.Issuer)
+	require.Equal(t, now.Add(-jwtClockSkew).Unix(), claims.IssuedAt)
+	require.Equal(t, now.Add(jwtLifetime).Unix(), claims.ExpiresAt)
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key := newTestKey(t)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{
+			name: "pkcs1",
+			data: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		},
+		{
+			name: "pkcs8",
+			data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+		},
+		{
+			name:    "not pem",
+			data:    []byte("nope"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePrivateKey(tt.data)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, key.Equal(got))
+		})
+	}
+}
+
+func TestNewClientReadsKeyFromVault(t *testing.T) {
+	key := newTestKey(t)
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	vc := vault.NewMockClient(t)
+	vc.On("GetKvSecretV2", mock.Anything, "secret", "github/app").
+		Return(&vaultapi.KVSecret{Data: map[string]any{"private_key": string(pemData)}}, nil)
+
+	c, err := NewClient(context.Background(), Config{
+		AppID:      1,
+		PrivateKey: KeyRef{Mount: "secret", Path: "github/app", Key: "private_key"},
+	}, vc)
+	require.NoError(t, err)
+	require.True(t, key.Equal(c.(*appClient).key))
+}
+
+type TokenSuite struct {
+	suite.Suite
+
+	srv    *httptest.Server
+	minted atomic.Int32
+	now    time.Time
+	client *appClient
+
+	// gate, when set, holds the mints of installation 1 until it is closed. arrived is signalled as they reach it.
+	gate    chan struct{}
+	arrived chan struct{}
+}
+
+func TestTokenSuite(t *testing.T) {
+	suite.Run(t, new(TokenSuite))
+}
+
+func (s *TokenSuite) SetupTest() {
+	s.minted.Store(0)
+	s.gate = nil
+	s.arrived = make(chan struct{}, 10)
+	s.now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/installations/404/access_tokens" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+
+		s.Equal(http.MethodPost, r.Method)
+		s.True(strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "))
+
+		if s.gate != nil && r.URL.Path == "/app/installations/1/access_tokens" {
+			s.arrived <- struct{}{}
+			<-s.gate
+		}
+
+		n := s.minted.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"token":      fmt.Sprintf("token-%d", n),
+			"expires_at": s.now.Add(time.Hour),
+		})
+	}))
+
+	s.client = newAppClient(Config{AppID: 1, BaseURL: s.srv.URL}, newTestKey(s.T()))
+	s.client.now = func() time.Time { return s.now }
+}
+
+func (s *TokenSuite) TearDownTest() {
+	s.srv.Close()
+}
+
+func (s *TokenSuite) TestTokenIsCached() {
+	first, err := s.client.InstallationToken(context.Background(), 1)
+	s.Require().NoError(err)
+
+	s.now = s.now.Add(50 * time.Minute)
+	second, err := s.client.InstallationToken(context.Background(), 1)
+	s.Require().NoError(err)
+
+	s.Equal("token-1", first)
+	s.Equal(first, second)
+	s.Equal(int32(1), s.minted.Load())
+}
+
+func (s *TokenSuite) TestTokenIsRefreshedBeforeExpiry() {
+	_, err := s.client.InstallationToken(context.Background(), 1)
+	s.Require().NoError(err)
+
+	s.now = s.now.Add(56 * time.Minute)
+	got, err := s.client.InstallationToken(context.Background(), 1)
+	s.Require().NoError(err)
+
+	s.Equal("token-2", got)
+}
+
+func (s *TokenSuite) TestTokensArePerInstallation() {
+	a, err := s.client.InstallationToken(context.Background(), 1)
+	s.Require().NoError(err)
+	b, err := s.client.InstallationToken(context.Background(), 2)
+	s.Require().NoError(err)
+
+	s.NotEqual(a, b)
+}
+
+func (s *TokenSuite) TestConcurrentCallersShareMint() {
+	s.gate = make(chan struct{})
+
+	tokens := make(chan string, 5)
+	for range cap(tokens) {
+		go func() {
+			tok, err := s.client.InstallationToken(context.Background(), 1)
+			s.NoError(err)
+			tokens <- tok
+		}()
+	}
+	<-s.arrived
+
+	other, err := s.client.InstallationToken(context.Background(), 2)
+	s.Require().NoError(err, "a mint must not hold up the tokens of other installations")
+	s.Equal("token-1", other)
+
+	close(s.gate)
+	for range cap(tokens) {
+		s.Equal("token-2", <-tokens)
+	}
+	s.Equal(int32(2), s.minted.Load())
+}
+
+func (s *TokenSuite) TestErrorIsHttpError() {
+	_, err := s.client.InstallationToken(context.Background(), 404)
+	s.Require().Error(err)
+
+	s.ErrorIs(err, utils.NewHttpError(http.StatusNotFound, ""))
+}
+
+func TestInstallation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/repos/octo/repo/installation", r.URL.Path)
+		require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "))
+		_, _ = w.Write([]byte(`{"id":99}`))
+	}))
+	defer srv.Close()
+
+	c := newAppClient(Config{AppID: 1, BaseURL: srv.URL}, newTestKey(t))
+
+	id, err := c.Installation(context.Background(), Scope{Kind: ScopeRepository, Name: "octo/repo"})
+	require.NoError(t, err)
+	require.Equal(t, int64(99), id)
+}
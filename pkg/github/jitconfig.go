@@ -0,0 +1,59 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// JITConfigRequest is the request for a just-in-time runner configuration.
+type JITConfigRequest struct {
+	// Name is the name of the runner.
+	Name string `json:"name"`
+
+	// RunnerGroupID is the runner group the runner is added to. 1 is the default group.
+	RunnerGroupID int64 `json:"runner_group_id"`
+
+	// Labels are the custom labels of the runner.
+	Labels []string `json:"labels"`
+
+	// WorkFolder is the working directory of the runner, relative to the runner install directory.
+	WorkFolder string `json:"work_folder,omitempty"`
+}
+
+// JITConfig is a single-use runner configuration. The runner it registers is removed by GitHub after it has run one
+// job, so it can never pick up a second one.
+type JITConfig struct {
+	// Runner is the runner that was registered.
+	Runner Runner `json:"runner"`
+
+	// EncodedJITConfig is passed to the runner with `run.sh --jitconfig`. It is a credential, so it must only ever be
+	// given to the machine the runner runs on.
+	EncodedJITConfig string `json:"encoded_jit_config"`
+}
+
+// GenerateJITConfig registers a runner with the scope and returns its just-in-time configuration.
+func (c *appClient) GenerateJITConfig(ctx context.Context, installationID int64, scope Scope, req *JITConfigRequest) (*JITConfig, error) {
+	if req.Name == "" {
+		return nil, errors.New("runner name is required")
+	} else if len(req.Labels) == 0 {
+		return nil, errors.New("at least one runner label is required")
+	}
+
+	base, err := scope.runnersPath()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.RunnerGroupID == 0 {
+		req.RunnerGroupID = 1
+	}
+
+	cfg := new(JITConfig)
+	if err := c.installationJSON(ctx, installationID, http.MethodPost, base+"/generate-jitconfig", req, cfg); err != nil {
+		return nil, fmt.Errorf("generate jit config for %s: %w", scope, err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,81 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateJITConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		scope    Scope
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "organization",
+			scope:    Scope{Kind: ScopeOrganization, Name: "octo"},
+			wantPath: "/orgs/octo/actions/runners/generate-jitconfig",
+		},
+		{
+			name:     "repository",
+			scope:    Scope{Kind: ScopeRepository, Name: "octo/repo"},
+			wantPath: "/repos/octo/repo/actions/runners/generate-jitconfig",
+		},
+		{
+			name:     "enterprise",
+			scope:    Scope{Kind: ScopeEnterprise, Name: "acme"},
+			wantPath: "/enterprises/acme/actions/runners/generate-jitconfig",
+		},
+		{
+			name:    "no name",
+			scope:   Scope{Kind: ScopeOrganization},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			got := new(JITConfigRequest)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/app/installations/1/access_tokens" {
+					_ = json.NewEncoder(w).Encode(map[string]any{"token": "tok", "expires_at": time.Now().Add(time.Hour)})
+					return
+				}
+
+				gotPath = r.URL.Path
+				require.Equal(t, "token tok", r.Header.Get("Authorization"))
+				require.NoError(t, json.NewDecoder(r.Body).Decode(got))
+
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`{"runner":{"id":23,"name":"runner-1"},"encoded_jit_config":"abc"}`))
+			}))
+			defer srv.Close()
+
+			c := newAppClient(Config{AppID: 1, BaseURL: srv.URL}, newTestKey(t))
+			cfg, err := c.GenerateJITConfig(context.Background(), 1, tt.scope, &JITConfigRequest{
+				Name:   "runner-1",
+				Labels: []string{"self-hosted"},
+			})
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Equal(t, tt.wantPath, gotPath)
+			require.Equal(t, "abc", cfg.EncodedJITConfig)
+			require.Equal(t, int64(23), cfg.Runner.ID)
+			require.Equal(t, int64(1), got.RunnerGroupID, "the default runner group should be used")
+			require.Equal(t, []string{"self-hosted"}, got.Labels)
+		})
+	}
+}
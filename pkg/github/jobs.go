@@ -0,0 +1,43 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// JobStatusCompleted is the status of a workflow job that has finished, whatever its conclusion.
+const JobStatusCompleted = "completed"
+
+// WorkflowJob is a job of a workflow run.
+type WorkflowJob struct {
+	ID           int64      `json:"id"`
+	RunID        int64      `json:"run_id"`
+	RunAttempt   int        `json:"run_attempt"`
+	Name         string     `json:"name"`
+	WorkflowName string     `json:"workflow_name"`
+	HeadBranch   string     `json:"head_branch"`
+	Status       string     `json:"status"`
+	Conclusion   string     `json:"conclusion"`
+	Labels       []string   `json:"labels"`
+	RunnerID     int64      `json:"runner_id"`
+	RunnerName   string     `json:"runner_name"`
+	CreatedAt    time.Time  `json:"created_at"`
+	StartedAt    time.Time  `json:"started_at"`
+	CompletedAt  *time.Time `json:"completed_at"`
+}
+
+// IsCompleted returns true if the job has finished.
+func (j *WorkflowJob) IsCompleted() bool {
+	return j.Status == JobStatusCompleted
+}
+
+func (c *appClient) GetWorkflowJob(ctx context.Context, installationID int64, repository string, jobID int64) (*WorkflowJob, error) {
+	job := new(WorkflowJob)
+	path := fmt.Sprintf("/repos/%s/actions/jobs/%d", repository, jobID)
+	if err := c.installationJSON(ctx, installationID, http.MethodGet, path, nil, job); err != nil {
+		return nil, fmt.Errorf("get workflow job %d: %w", jobID, err)
+	}
+	return job, nil
+}
@@ -0,0 +1,32 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetWorkflowJob(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/installations/1/access_tokens" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": "tok", "expires_at": time.Now().Add(time.Hour)})
+			return
+		}
+
+		require.Equal(t, "/repos/octo/repo/actions/jobs/42", r.URL.Path)
+		_, _ = w.Write([]byte(`{"id":42,"status":"completed","conclusion":"success","runner_name":"pgr-42"}`))
+	}))
+	defer srv.Close()
+
+	c := newAppClient(Config{AppID: 1, BaseURL: srv.URL}, newTestKey(t))
+
+	job, err := c.GetWorkflowJob(context.Background(), 1, "octo/repo", 42)
+	require.NoError(t, err)
+	require.True(t, job.IsCompleted())
+	require.Equal(t, "pgr-42", job.RunnerName)
+}
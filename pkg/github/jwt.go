@@ -0,0 +1,80 @@
+package github
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// jwtLifetime is how long an app JWT is valid for. GitHub rejects JWTs that are valid for more than 10 minutes.
+	jwtLifetime = 9 * time.Minute
+
+	// jwtClockSkew is how far the issued at time is backdated to allow for clock drift with GitHub.
+	jwtClockSkew = 60 * time.Second
+)
+
+// jwtHeader is the encoded header of every app JWT.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+
+// jwtClaims are the claims GitHub requires in an app JWT.
+type jwtClaims struct {
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Issuer    string `json:"iss"`
+}
+
+// ParsePrivateKey parses a PEM encoded RSA private key in either PKCS#1 or PKCS#8 form.
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is %T, not RSA", parsed)
+	}
+
+	return key, nil
+}
+
+// signJWT returns an RS256 JWT that authenticates as the app.
+func signJWT(key *rsa.PrivateKey, appID int64, now time.Time) (string, error) {
+	claims, err := json.Marshal(&jwtClaims{
+		IssuedAt:  now.Add(-jwtClockSkew).Unix(),
+		ExpiresAt: now.Add(jwtLifetime).Unix(),
+		Issuer:    strconv.FormatInt(appID, 10),
+	})
+	if err != nil {
+		return "", fmt.Errorf("encode claims: %w", err)
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign jwt: %w", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,332 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github/webhook"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+)
+
+// errRateLimited is returned for a request that was not made because the rate limit of the installation is low.
+var errRateLimited = errors.New("rate limited")
+
+// Config is the configuration for the Poller.
+type Config struct {
+	// Interval is how often the repositories are polled. Defaults to 30 seconds.
+	Interval time.Duration
+
+	// Repositories are the full names of the repositories to poll.
+	Repositories []string
+
+	// Organizations are the organizations whose repositories are polled, every repository the app installation on the
+	// organization can access.
+	Organizations []string
+
+	// MinRateLimitRemaining is how many requests of the rate limit of an installation are left to other clients. The
+	// installation is not polled again until its rate limit resets once fewer are remaining. Defaults to 100.
+	MinRateLimitRemaining int
+}
+
+// Poller discovers queued workflow jobs by polling the GitHub API, for clusters that cannot receive webhooks. The
+// queued jobs are handed to the dispatcher as webhook.JobQueued events, so they take the same path as a delivery.
+//
+// Every list request is conditional on the ETag of the previous response, so a repository without changes does not
+// use up the rate limit.
+type Poller struct {
+	cfg Config
+
+	// gh is the GitHub client.
+	gh github.Client
+
+	// deliveries de-duplicates the jobs across polls.
+	deliveries webhook.DeliveryStore
+
+	// dispatcher is handed the queued jobs.
+	dispatcher webhook.Dispatcher
+
+	// installations are the app installation IDs of the polled scopes.
+	installations map[github.Scope]int64
+
+	// cache is the last response of each list request, by request.
+	cache map[string]*cached
+
+	// seen are the requests made during the current poll. The others are dropped from the cache after it.
+	seen map[string]struct{}
+
+	// limits are the last rate limits reported for each installation.
+	limits map[int64]github.RateLimit
+
+	// paused are the installations that are not polled until their rate limit resets.
+	paused map[int64]time.Time
+
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+}
+
+// cached is the last response of a list request.
+type cached struct {
+	etag  string
+	value any
+}
+
+// NewPoller creates a new Poller.
+func NewPoller(cfg Config, gh github.Client, deliveries webhook.DeliveryStore, dispatcher webhook.Dispatcher) *Poller {
+	if cfg.Interval == 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	if cfg.MinRateLimitRemaining == 0 {
+		cfg.MinRateLimitRemaining = 100
+	}
+
+	return &Poller{
+		cfg:           cfg,
+		gh:            gh,
+		deliveries:    deliveries,
+		dispatcher:    dispatcher,
+		installations: make(map[github.Scope]int64),
+		cache:         make(map[string]*cached),
+		seen:          make(map[string]struct{}),
+		limits:        make(map[int64]github.RateLimit),
+		paused:        make(map[int64]time.Time),
+		now:           time.Now,
+	}
+}
+
+// Run polls on the interval until the context is cancelled.
+func (p *Poller) Run(ctx context.Context) error {
+	slog.Info("polling for queued jobs",
+		slog.Duration("interval", p.cfg.Interval),
+		slog.Any("repositories", p.cfg.Repositories),
+		slog.Any("organizations", p.cfg.Organizations),
+	)
+
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll dispatches the queued jobs of every configured repository and organization.
+func (p *Poller) poll(ctx context.Context) {
+	clear(p.seen)
+
+	scopes := make([]github.Scope, 0, len(p.cfg.Repositories)+len(p.cfg.Organizations))
+	for _, repo := range p.cfg.Repositories {
+		scopes = append(scopes, github.Scope{Kind: github.ScopeRepository, Name: repo})
+	}
+	for _, org := range p.cfg.Organizations {
+		scopes = append(scopes, github.Scope{Kind: github.ScopeOrganization, Name: org})
+	}
+
+	dispatched := 0
+	for _, scope := range scopes {
+		n, err := p.pollScope(ctx, scope)
+		dispatched += n
+		if err != nil && ctx.Err() == nil {
+			slog.Error("unable to poll for queued jobs",
+				slog.String("scope", scope.String()),
+				slog.String(logging.KeyError, err.Error()),
+			)
+		}
+	}
+
+	// Drop the responses of the runs that are no longer queued or in progress.
+	for key := range p.cache {
+		if _, ok := p.seen[key]; !ok {
+			delete(p.cache, key)
+		}
+	}
+
+	slog.Debug("polled for queued jobs", slog.Int("dispatched", dispatched))
+}
+
+// pollScope dispatches the queued jobs of the repositories of the scope and returns how many were dispatched.
+func (p *Poller) pollScope(ctx context.Context, scope github.Scope) (int, error) {
+	installationID, err := p.installation(ctx, scope)
+	if err != nil {
+		return 0, err
+	}
+
+	repos := []string{scope.Name}
+	if scope.Kind == github.ScopeOrganization {
+		all, err := fetch(p, installationID, "repos:"+scope.Name, func(etag string) ([]string, *github.Response, error) {
+			return p.gh.ListInstallationRepositories(ctx, installationID, etag)
+		})
+		if errors.Is(err, errRateLimited) {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+
+		repos = make([]string, 0, len(all))
+		for _, repo := range all {
+			if strings.HasPrefix(repo, scope.Name+"/") {
+				repos = append(repos, repo)
+			}
+		}
+	}
+
+	dispatched := 0
+	for _, repo := range repos {
+		n, err := p.pollRepository(ctx, installationID, repo)
+		dispatched += n
+		if errors.Is(err, errRateLimited) {
+			return dispatched, nil
+		} else if err != nil {
+			return dispatched, err
+		}
+	}
+
+	return dispatched, nil
+}
+
+// pollRepository dispatches the queued jobs of the repository and returns how many were dispatched. A run that is in
+// progress can still have queued jobs, e.g. those of a matrix, so both queued and in progress runs are checked.
+func (p *Poller) pollRepository(ctx context.Context, installationID int64, repo string) (int, error) {
+	dispatched := 0
+	for _, status := range []string{github.RunStatusQueued, github.RunStatusInProgress} {
+		runs, err := fetch(p, installationID, fmt.Sprintf("runs:%s:%s", repo, status), func(etag string) ([]*github.WorkflowRun, *github.Response, error) {
+			return p.gh.ListWorkflowRuns(ctx, installationID, repo, status, etag)
+		})
+		if err != nil {
+			return dispatched, err
+		}
+
+		for _, run := range runs {
+			jobs, err := fetch(p, installationID, fmt.Sprintf("jobs:%s:%d", repo, run.ID), func(etag string) ([]*github.WorkflowJob, *github.Response, error) {
+				return p.gh.ListWorkflowRunJobs(ctx, installationID, repo, run.ID, etag)
+			})
+			if err != nil {
+				return dispatched, err
+			}
+
+			for _, job := range jobs {
+				if job.Status != github.JobStatusQueued {
+					continue
+				}
+
+				ok, err := p.dispatch(ctx, installationID, repo, job)
+				if err != nil {
+					return dispatched, err
+				} else if ok {
+					dispatched++
+				}
+			}
+		}
+	}
+
+	return dispatched, nil
+}
+
+// dispatch hands the queued job to the dispatcher, unless it already was. It returns true if the job was dispatched.
+func (p *Poller) dispatch(ctx context.Context, installationID int64, repo string, job *github.WorkflowJob) (bool, error) {
+	key := fmt.Sprintf("poll-%d-%d", job.ID, job.RunAttempt)
+
+	claimed, err := p.deliveries.Claim(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("claim job %d: %w", job.ID, err)
+	} else if !claimed {
+		return false, nil
+	}
+
+	event := webhook.NewJobQueued(key, installationID, repo, job)
+
+	if err := p.dispatcher.Dispatch(ctx, event); err != nil {
+		if err := p.deliveries.Release(ctx, key); err != nil {
+			slog.Error("unable to release job", slog.String(logging.KeyError, err.Error()))
+		}
+		return false, fmt.Errorf("dispatch job %d: %w", job.ID, err)
+	}
+
+	slog.Info("dispatched queued job",
+		slog.Int64(logging.KeyJobID, job.ID),
+		slog.String(logging.KeyRepository, repo),
+	)
+
+	return true, nil
+}
+
+// installation returns the app installation ID of the scope.
+func (p *Poller) installation(ctx context.Context, scope github.Scope) (int64, error) {
+	if id, ok := p.installations[scope]; ok {
+		return id, nil
+	}
+
+	id, err := p.gh.Installation(ctx, scope)
+	if err != nil {
+		return 0, err
+	}
+
+	p.installations[scope] = id
+	return id, nil
+}
+
+// rateLimited returns true if the installation must not be polled until its rate limit resets.
+func (p *Poller) rateLimited(installationID int64) bool {
+	limit, ok := p.limits[installationID]
+	if !ok || limit.Remaining >= p.cfg.MinRateLimitRemaining || !p.now().Before(limit.Reset) {
+		delete(p.paused, installationID)
+		return false
+	}
+
+	if p.paused[installationID] != limit.Reset {
+		p.paused[installationID] = limit.Reset
+		slog.Warn("pausing polling until the rate limit resets",
+			slog.Int64("installation_id", installationID),
+			slog.Int("remaining", limit.Remaining),
+			slog.Time("reset", limit.Reset),
+		)
+	}
+
+	return true
+}
+
+// fetch makes the list request conditional on the ETag of its last response, and returns the last response when
+// nothing has changed. errRateLimited is returned without making the request while the installation is rate limited.
+func fetch[T any](p *Poller, installationID int64, key string, get func(etag string) (T, *github.Response, error)) (T, error) {
+	p.seen[key] = struct{}{}
+
+	if p.rateLimited(installationID) {
+		var zero T
+		return zero, errRateLimited
+	}
+
+	var etag string
+	last, ok := p.cache[key]
+	if ok {
+		etag = last.etag
+	}
+
+	value, resp, err := get(etag)
+	if resp != nil && resp.RateLimit.Known() {
+		p.limits[installationID] = resp.RateLimit
+	}
+
+	switch {
+	case errors.Is(err, github.ErrNotModified) && ok:
+		return last.value.(T), nil
+	case err != nil:
+		var zero T
+		return zero, err
+	}
+
+	if resp != nil && resp.ETag != "" {
+		p.cache[key] = &cached{etag: resp.ETag, value: value}
+	}
+
+	return value, nil
+}
@@ -0,0 +1,173 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github/webhook"
+	"github.com/stretchr/testify/suite"
+)
+
+type fakeGitHub struct {
+	github.Client
+
+	runs      map[string][]*github.WorkflowRun
+	jobs      map[int64][]*github.WorkflowJob
+	repos     []string
+	remaining int
+	requests  int
+	modified  int
+}
+
+func (f *fakeGitHub) response(etag string) (*github.Response, error) {
+	f.requests++
+	resp := &github.Response{
+		ETag:      `"v1"`,
+		RateLimit: github.RateLimit{Limit: 5000, Remaining: f.remaining, Reset: time.Unix(2000, 0)},
+	}
+	if etag == resp.ETag {
+		return resp, github.ErrNotModified
+	}
+	f.modified++
+	return resp, nil
+}
+
+func (f *fakeGitHub) Installation(context.Context, github.Scope) (int64, error) {
+	return 7, nil
+}
+
+func (f *fakeGitHub) ListInstallationRepositories(_ context.Context, _ int64, etag string) ([]string, *github.Response, error) {
+	resp, err := f.response(etag)
+	if err != nil {
+		return nil, resp, err
+	}
+	return f.repos, resp, nil
+}
+
+func (f *fakeGitHub) ListWorkflowRuns(_ context.Context, _ int64, repo, status, etag string) ([]*github.WorkflowRun, *github.Response, error) {
+	resp, err := f.response(etag)
+	if err != nil {
+		return nil, resp, err
+	}
+	return f.runs[repo+":"+status], resp, nil
+}
+
+func (f *fakeGitHub) ListWorkflowRunJobs(_ context.Context, _ int64, _ string, runID int64, etag string) ([]*github.WorkflowJob, *github.Response, error) {
+	resp, err := f.response(etag)
+	if err != nil {
+		return nil, resp, err
+	}
+	return f.jobs[runID], resp, nil
+}
+
+type fakeDispatcher struct {
+	events []webhook.Event
+}
+
+func (f *fakeDispatcher) Dispatch(_ context.Context, event webhook.Event) error {
+	f.events = append(f.events, event)
+	return nil
+}
+
+type PollerSuite struct {
+	suite.Suite
+
+	gh         *fakeGitHub
+	dispatcher *fakeDispatcher
+	poller     *Poller
+}
+
+func TestPollerSuite(t *testing.T) {
+	suite.Run(t, new(PollerSuite))
+}
+
+func (s *PollerSuite) SetupTest() {
+	s.gh = &fakeGitHub{
+		runs: map[string][]*github.WorkflowRun{
+			"octo/repo:" + github.RunStatusQueued:     {{ID: 1}},
+			"octo/repo:" + github.RunStatusInProgress: {{ID: 2}},
+		},
+		jobs: map[int64][]*github.WorkflowJob{
+			1: {{ID: 10, RunID: 1, Status: github.JobStatusQueued, Labels: []string{"self-hosted"}}},
+			2: {
+				{ID: 20, RunID: 2, Status: "in_progress"},
+				{ID: 21, RunID: 2, Status: github.JobStatusQueued},
+			},
+		},
+		repos:     []string{"octo/repo", "other/repo"},
+		remaining: 4000,
+	}
+	s.dispatcher = new(fakeDispatcher)
+	s.poller = NewPoller(Config{Repositories: []string{"octo/repo"}}, s.gh, webhook.NewMemoryDeliveryStore(time.Hour), s.dispatcher)
+	s.poller.now = func() time.Time { return time.Unix(1000, 0) }
+}
+
+func (s *PollerSuite) dispatchedJobs() []int64 {
+	ids := make([]int64, 0, len(s.dispatcher.events))
+	for _, e := range s.dispatcher.events {
+		s.Require().IsType(&webhook.JobQueued{}, e)
+		ids = append(ids, e.Payload().WorkflowJob.ID)
+	}
+	return ids
+}
+
+func (s *PollerSuite) TestPollDispatchesQueuedJobs() {
+	s.poller.poll(context.Background())
+
+	s.ElementsMatch([]int64{10, 21}, s.dispatchedJobs())
+
+	event := s.dispatcher.events[0].Payload()
+	s.Equal("octo/repo", event.Repository.FullName)
+	s.Equal("octo", event.Repository.Owner.Login)
+	s.Equal(int64(7), event.Installation.ID)
+	s.Equal(fmt.Sprintf("poll-%d-0", event.WorkflowJob.ID), event.DeliveryID)
+}
+
+func (s *PollerSuite) TestPollIsConditional() {
+	s.poller.poll(context.Background())
+	s.Equal(4, s.gh.modified)
+
+	s.poller.poll(context.Background())
+
+	s.Equal(8, s.gh.requests)
+	s.Equal(4, s.gh.modified, "unchanged lists must be served from the cache")
+	s.Len(s.dispatcher.events, 2, "a job must only be dispatched once")
+}
+
+func (s *PollerSuite) TestPollDropsFinishedRuns() {
+	s.poller.poll(context.Background())
+	s.Contains(s.poller.cache, "jobs:octo/repo:1")
+
+	s.gh.runs["octo/repo:"+github.RunStatusQueued] = nil
+	s.poller.cache["runs:octo/repo:"+github.RunStatusQueued].etag = `"v0"`
+	s.poller.poll(context.Background())
+
+	s.NotContains(s.poller.cache, "jobs:octo/repo:1")
+}
+
+func (s *PollerSuite) TestPollPausesWhenRateLimited() {
+	s.gh.remaining = 10
+
+	s.poller.poll(context.Background())
+	s.Equal(1, s.gh.requests, "polling must stop once the rate limit is low")
+
+	s.poller.poll(context.Background())
+	s.Equal(1, s.gh.requests)
+
+	s.gh.remaining = 5000
+	s.poller.now = func() time.Time { return time.Unix(3000, 0) }
+	s.poller.poll(context.Background())
+	s.Equal(5, s.gh.requests, "polling must resume once the rate limit resets")
+}
+
+func (s *PollerSuite) TestPollOrganization() {
+	s.poller.cfg.Repositories = nil
+	s.poller.cfg.Organizations = []string{"octo"}
+
+	s.poller.poll(context.Background())
+
+	s.ElementsMatch([]int64{10, 21}, s.dispatchedJobs())
+}
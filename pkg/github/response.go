@@ -0,0 +1,58 @@
+package github
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrNotModified is returned by a conditional request when the resource has not changed since the given ETag. Such a
+// response does not count against the rate limit.
+var ErrNotModified = errors.New("not modified")
+
+// RateLimit is the state of the rate limit as reported with a response.
+type RateLimit struct {
+	// Limit is the number of requests allowed per window.
+	Limit int `json:"limit"`
+
+	// Remaining is the number of requests left in the current window.
+	Remaining int `json:"remaining"`
+
+	// Reset is when the current window ends.
+	Reset time.Time `json:"reset"`
+}
+
+// Known returns true if the response carried the rate limit headers.
+func (r RateLimit) Known() bool {
+	return !r.Reset.IsZero()
+}
+
+// Response is the metadata of a response.
+type Response struct {
+	// ETag identifies the version of the resource. Pass it to the next request to make it conditional.
+	ETag string
+
+	// RateLimit is the rate limit after the request.
+	RateLimit RateLimit
+}
+
+// newResponse reads the metadata from the response headers.
+func newResponse(resp *http.Response) *Response {
+	r := &Response{
+		ETag: resp.Header.Get("ETag"),
+	}
+
+	limit, errLimit := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	remaining, errRemaining := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	reset, errReset := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if errLimit == nil && errRemaining == nil && errReset == nil {
+		r.RateLimit = RateLimit{
+			Limit:     limit,
+			Remaining: remaining,
+			Reset:     time.Unix(reset, 0).UTC(),
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,132 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ScopeKind is the level a runner is registered at.
+type ScopeKind string
+
+const (
+	// ScopeOrganization registers runners with an organization.
+	ScopeOrganization ScopeKind = "organization"
+
+	// ScopeRepository registers runners with a single repository.
+	ScopeRepository ScopeKind = "repository"
+
+	// ScopeEnterprise registers runners with an enterprise.
+	ScopeEnterprise ScopeKind = "enterprise"
+)
+
+// Scope is where a runner is registered.
+type Scope struct {
+	// Kind is the level the runner is registered at.
+	Kind ScopeKind `json:"kind"`
+
+	// Name is the organization login, the owner/name of the repository or the enterprise slug.
+	Name string `json:"name"`
+}
+
+// String returns the string representation of the Scope.
+func (s Scope) String() string {
+	return fmt.Sprintf("%s:%s", s.Kind, s.Name)
+}
+
+// runnersPath returns the path of the self-hosted runners API for the scope.
+func (s Scope) runnersPath() (string, error) {
+	if s.Name == "" {
+		return "", fmt.Errorf("%s scope has no name", s.Kind)
+	}
+
+	switch s.Kind {
+	case ScopeOrganization:
+		return fmt.Sprintf("/orgs/%s/actions/runners", s.Name), nil
+	case ScopeRepository:
+		return fmt.Sprintf("/repos/%s/actions/runners", s.Name), nil
+	case ScopeEnterprise:
+		return fmt.Sprintf("/enterprises/%s/actions/runners", s.Name), nil
+	default:
+		return "", fmt.Errorf("unknown runner scope %q", s.Kind)
+	}
+}
+
+// installationPath returns the path of the app installation on the scope.
+func (s Scope) installationPath() (string, error) {
+	if s.Name == "" {
+		return "", fmt.Errorf("%s scope has no name", s.Kind)
+	}
+
+	switch s.Kind {
+	case ScopeOrganization:
+		return fmt.Sprintf("/orgs/%s/installation", s.Name), nil
+	case ScopeRepository:
+		return fmt.Sprintf("/repos/%s/installation", s.Name), nil
+	case ScopeEnterprise:
+		return fmt.Sprintf("/enterprises/%s/installation", s.Name), nil
+	default:
+		return "", fmt.Errorf("unknown runner scope %q", s.Kind)
+	}
+}
+
+// Label is a label of a self-hosted runner.
+type Label struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// RunnerStatusOffline is the status of a runner that is not connected to GitHub.
+const RunnerStatusOffline = "offline"
+
+// Runner is a self-hosted runner registered with GitHub.
+type Runner struct {
+	ID     int64   `json:"id"`
+	Name   string  `json:"name"`
+	OS     string  `json:"os"`
+	Status string  `json:"status"`
+	Busy   bool    `json:"busy"`
+	Labels []Label `json:"labels"`
+}
+
+// ListRunners returns every runner registered with the scope, following the pages of the API.
+func (c *appClient) ListRunners(ctx context.Context, installationID int64, scope Scope) ([]*Runner, error) {
+	base, err := scope.runnersPath()
+	if err != nil {
+		return nil, err
+	}
+
+	runners := make([]*Runner, 0)
+	for page := 1; ; page++ {
+		resp := new(struct {
+			TotalCount int       `json:"total_count"`
+			Runners    []*Runner `json:"runners"`
+		})
+
+		path := fmt.Sprintf("%s?per_page=%d&page=%d", base, listPageSize, page)
+		if err := c.installationJSON(ctx, installationID, http.MethodGet, path, nil, resp); err != nil {
+			return nil, fmt.Errorf("list runners of %s: %w", scope, err)
+		}
+
+		runners = append(runners, resp.Runners...)
+		if len(resp.Runners) < listPageSize || len(runners) >= resp.TotalCount {
+			return runners, nil
+		}
+	}
+}
+
+// DeleteRunner removes the runner registration from the scope.
+func (c *appClient) DeleteRunner(ctx context.Context, installationID int64, scope Scope, runnerID int64) error {
+	base, err := scope.runnersPath()
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("%s/%d", base, runnerID)
+	if err := c.installationJSON(ctx, installationID, http.MethodDelete, path, nil, nil); err != nil {
+		return fmt.Errorf("delete runner %d: %w", runnerID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListRunnersFollowsPages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/installations/1/access_tokens" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": "tok", "expires_at": time.Now().Add(time.Hour)})
+			return
+		}
+
+		require.Equal(t, "/orgs/octo/actions/runners", r.URL.Path)
+		require.Equal(t, "100", r.URL.Query().Get("per_page"))
+
+		runners := make([]map[string]any, 0)
+		count := 100
+		if r.URL.Query().Get("page") == "2" {
+			count = 5
+		}
+		for i := 0; i < count; i++ {
+			runners = append(runners, map[string]any{"id": i, "name": "runner", "status": "online"})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"total_count": 105, "runners": runners})
+	}))
+	defer srv.Close()
+
+	c := newAppClient(Config{AppID: 1, BaseURL: srv.URL}, newTestKey(t))
+
+	runners, err := c.ListRunners(context.Background(), 1, Scope{Kind: ScopeOrganization, Name: "octo"})
+	require.NoError(t, err)
+	require.Len(t, runners, 105)
+}
@@ -0,0 +1,125 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// RunStatusQueued is the status of a workflow run that is waiting to start.
+	RunStatusQueued = "queued"
+
+	// RunStatusInProgress is the status of a workflow run that has started. Some of its jobs may still be queued.
+	RunStatusInProgress = "in_progress"
+
+	// JobStatusQueued is the status of a workflow job that is waiting for a runner.
+	JobStatusQueued = "queued"
+)
+
+// listPageSize is the number of items requested per page, the maximum the API allows.
+const listPageSize = 100
+
+// WorkflowRun is a run of a workflow.
+type WorkflowRun struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	HeadBranch string    `json:"head_branch"`
+	Status     string    `json:"status"`
+	RunAttempt int       `json:"run_attempt"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ListInstallationRepositories returns the repositories the installation can access. Only the first page is
+// conditional, the others are fetched whenever it has changed.
+func (c *appClient) ListInstallationRepositories(ctx context.Context, installationID int64, etag string) ([]string, *Response, error) {
+	var first *Response
+	repos := make([]string, 0)
+	for page := 1; ; page++ {
+		resp := new(struct {
+			TotalCount   int `json:"total_count"`
+			Repositories []struct {
+				FullName string `json:"full_name"`
+			} `json:"repositories"`
+		})
+
+		path := fmt.Sprintf("/installation/repositories?per_page=%d&page=%d", listPageSize, page)
+		meta, err := c.installationConditional(ctx, installationID, path, etag, resp)
+		if first == nil {
+			first = meta
+		}
+		if err != nil {
+			return nil, first, fmt.Errorf("list repositories of installation %d: %w", installationID, err)
+		}
+		etag = ""
+
+		for _, r := range resp.Repositories {
+			repos = append(repos, r.FullName)
+		}
+		if len(resp.Repositories) < listPageSize || len(repos) >= resp.TotalCount {
+			return repos, first, nil
+		}
+	}
+}
+
+// ListWorkflowRuns returns the workflow runs with the given status, the most recent first, following the pages of
+// the API. Only the first page is conditional, the others are fetched whenever it has changed.
+func (c *appClient) ListWorkflowRuns(ctx context.Context, installationID int64, repository, status, etag string) ([]*WorkflowRun, *Response, error) {
+	var first *Response
+	runs := make([]*WorkflowRun, 0)
+	for page := 1; ; page++ {
+		resp := new(struct {
+			TotalCount   int            `json:"total_count"`
+			WorkflowRuns []*WorkflowRun `json:"workflow_runs"`
+		})
+
+		q := url.Values{}
+		q.Set("status", status)
+		q.Set("per_page", fmt.Sprint(listPageSize))
+		q.Set("page", fmt.Sprint(page))
+
+		path := fmt.Sprintf("/repos/%s/actions/runs?%s", repository, q.Encode())
+		meta, err := c.installationConditional(ctx, installationID, path, etag, resp)
+		if first == nil {
+			first = meta
+		}
+		if err != nil {
+			return nil, first, fmt.Errorf("list %s workflow runs of %s: %w", status, repository, err)
+		}
+		etag = ""
+
+		runs = append(runs, resp.WorkflowRuns...)
+		if len(resp.WorkflowRuns) < listPageSize || len(runs) >= resp.TotalCount {
+			return runs, first, nil
+		}
+	}
+}
+
+// ListWorkflowRunJobs returns the jobs of the latest attempt of the workflow run, following the pages of the API. Only
+// the first page is conditional, the others are fetched whenever it has changed.
+func (c *appClient) ListWorkflowRunJobs(ctx context.Context, installationID int64, repository string, runID int64, etag string) ([]*WorkflowJob, *Response, error) {
+	var first *Response
+	jobs := make([]*WorkflowJob, 0)
+	for page := 1; ; page++ {
+		resp := new(struct {
+			TotalCount int            `json:"total_count"`
+			Jobs       []*WorkflowJob `json:"jobs"`
+		})
+
+		path := fmt.Sprintf("/repos/%s/actions/runs/%d/jobs?filter=latest&per_page=%d&page=%d", repository, runID, listPageSize, page)
+		meta, err := c.installationConditional(ctx, installationID, path, etag, resp)
+		if first == nil {
+			first = meta
+		}
+		if err != nil {
+			return nil, first, fmt.Errorf("list jobs of workflow run %d: %w", runID, err)
+		}
+		etag = ""
+
+		jobs = append(jobs, resp.Jobs...)
+		if len(resp.Jobs) < listPageSize || len(jobs) >= resp.TotalCount {
+			return jobs, first, nil
+		}
+	}
+}
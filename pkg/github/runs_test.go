@@ -0,0 +1,151 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListWorkflowRunsIsConditional(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/installations/1/access_tokens" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": "tok", "expires_at": time.Now().Add(time.Hour)})
+			return
+		}
+
+		require.Equal(t, "/repos/octo/repo/actions/runs", r.URL.Path)
+		require.Equal(t, RunStatusQueued, r.URL.Query().Get("status"))
+
+		w.Header().Set("ETag", `W/"abc"`)
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		w.Header().Set("X-RateLimit-Reset", "1700000000")
+
+		if r.Header.Get("If-None-Match") == `W/"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte(`{"total_count":1,"workflow_runs":[{"id":7,"status":"queued"}]}`))
+	}))
+	defer srv.Close()
+
+	c := newAppClient(Config{AppID: 1, BaseURL: srv.URL}, newTestKey(t))
+
+	runs, resp, err := c.ListWorkflowRuns(context.Background(), 1, "octo/repo", RunStatusQueued, "")
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	require.Equal(t, int64(7), runs[0].ID)
+	require.Equal(t, `W/"abc"`, resp.ETag)
+	require.Equal(t, RateLimit{Limit: 5000, Remaining: 4999, Reset: time.Unix(1700000000, 0).UTC()}, resp.RateLimit)
+
+	runs, resp, err = c.ListWorkflowRuns(context.Background(), 1, "octo/repo", RunStatusQueued, resp.ETag)
+	require.ErrorIs(t, err, ErrNotModified)
+	require.Nil(t, runs)
+	require.Equal(t, 4999, resp.RateLimit.Remaining)
+}
+
+func TestListWorkflowRunJobs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/installations/1/access_tokens" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": "tok", "expires_at": time.Now().Add(time.Hour)})
+			return
+		}
+
+		require.Equal(t, "/repos/octo/repo/actions/runs/7/jobs", r.URL.Path)
+		require.Equal(t, "latest", r.URL.Query().Get("filter"))
+		_, _ = w.Write([]byte(`{"total_count":1,"jobs":[{"id":42,"run_id":7,"status":"queued","labels":["self-hosted"]}]}`))
+	}))
+	defer srv.Close()
+
+	c := newAppClient(Config{AppID: 1, BaseURL: srv.URL}, newTestKey(t))
+
+	jobs, resp, err := c.ListWorkflowRunJobs(context.Background(), 1, "octo/repo", 7, "")
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, []string{"self-hosted"}, jobs[0].Labels)
+	require.False(t, resp.RateLimit.Known())
+}
+
+func TestListWorkflowRunJobsFollowsPages(t *testing.T) {
+	pages := make([]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/installations/1/access_tokens" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": "tok", "expires_at": time.Now().Add(time.Hour)})
+			return
+		}
+
+		page := r.URL.Query().Get("page")
+		pages = append(pages, page)
+		if page != "1" {
+			require.Empty(t, r.Header.Get("If-None-Match"), "only the first page is conditional")
+		}
+
+		jobs := make([]map[string]any, 0, listPageSize)
+		for i := range listPageSize {
+			if page == "2" && i == 1 {
+				break
+			}
+			jobs = append(jobs, map[string]any{"id": len(pages)*1000 + i, "status": "queued"})
+		}
+		w.Header().Set("ETag", `W/"page-`+page+`"`)
+		_ = json.NewEncoder(w).Encode(map[string]any{"total_count": listPageSize + 1, "jobs": jobs})
+	}))
+	defer srv.Close()
+
+	c := newAppClient(Config{AppID: 1, BaseURL: srv.URL}, newTestKey(t))
+
+	jobs, resp, err := c.ListWorkflowRunJobs(context.Background(), 1, "octo/repo", 7, `W/"old"`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", "2"}, pages)
+	require.Len(t, jobs, listPageSize+1)
+	require.Equal(t, int64(2000), jobs[listPageSize].ID)
+	require.Equal(t, `W/"page-1"`, resp.ETag, "the etag is the one of the first page")
+}
+
+func TestListWorkflowRunsFollowsPages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/installations/1/access_tokens" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": "tok", "expires_at": time.Now().Add(time.Hour)})
+			return
+		}
+
+		runs := make([]map[string]any, 0, listPageSize)
+		if r.URL.Query().Get("page") == "1" {
+			for i := range listPageSize {
+				runs = append(runs, map[string]any{"id": i + 1, "status": "queued"})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"total_count": 2 * listPageSize, "workflow_runs": runs})
+	}))
+	defer srv.Close()
+
+	c := newAppClient(Config{AppID: 1, BaseURL: srv.URL}, newTestKey(t))
+
+	runs, _, err := c.ListWorkflowRuns(context.Background(), 1, "octo/repo", RunStatusQueued, "")
+	require.NoError(t, err)
+	require.Len(t, runs, listPageSize, "a short page ends the list")
+}
+
+func TestListInstallationRepositories(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/installations/1/access_tokens" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": "tok", "expires_at": time.Now().Add(time.Hour)})
+			return
+		}
+
+		require.Equal(t, "/installation/repositories", r.URL.Path)
+		_, _ = w.Write([]byte(`{"total_count":2,"repositories":[{"full_name":"octo/a"},{"full_name":"octo/b"}]}`))
+	}))
+	defer srv.Close()
+
+	c := newAppClient(Config{AppID: 1, BaseURL: srv.URL}, newTestKey(t))
+
+	repos, _, err := c.ListInstallationRepositories(context.Background(), 1, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"octo/a", "octo/b"}, repos)
+}
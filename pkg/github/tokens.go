@@ -0,0 +1,138 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// tokenRefreshWindow is how long before expiry a cached installation token is replaced. Installation tokens are
+	// valid for an hour, so a token is used for at most 55 minutes.
+	tokenRefreshWindow = 5 * time.Minute
+)
+
+// installationToken is an installation access token.
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// tokenMint is an installation token being minted. done is closed once tok or err is set.
+type tokenMint struct {
+	done chan struct{}
+	tok  *installationToken
+	err  error
+}
+
+func (c *appClient) InstallationToken(ctx context.Context, installationID int64) (string, error) {
+	for {
+		c.mu.Lock()
+		if tok, ok := c.tokens[installationID]; ok && c.now().Add(tokenRefreshWindow).Before(tok.ExpiresAt) {
+			c.mu.Unlock()
+			return tok.Token, nil
+		}
+
+		// Concurrent callers wait for the token being minted rather than all minting their own.
+		mint, ok := c.minting[installationID]
+		if !ok {
+			mint = &tokenMint{done: make(chan struct{})}
+			c.minting[installationID] = mint
+			c.mu.Unlock()
+
+			return c.mintToken(ctx, installationID, mint)
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-mint.done:
+		}
+
+		switch {
+		case mint.err == nil:
+			return mint.tok.Token, nil
+		case errors.Is(mint.err, context.Canceled), errors.Is(mint.err, context.DeadlineExceeded):
+			// The caller that minted gave up, which says nothing about this one. Try again.
+			continue
+		default:
+			return "", mint.err
+		}
+	}
+}
+
+// mintToken mints a token for the installation without holding mu, caches it and hands it to the callers waiting on
+// the mint.
+func (c *appClient) mintToken(ctx context.Context, installationID int64, mint *tokenMint) (string, error) {
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if mint.err == nil {
+			c.tokens[installationID] = mint.tok
+		}
+		delete(c.minting, installationID)
+		close(mint.done)
+	}()
+
+	jwt, err := c.AppJWT()
+	if err != nil {
+		mint.err = err
+		return "", err
+	}
+
+	tok := new(installationToken)
+	path := fmt.Sprintf("/app/installations/%d/access_tokens", installationID)
+	if err := c.doJSON(ctx, "Bearer "+jwt, http.MethodPost, path, nil, tok); err != nil {
+		mint.err = fmt.Errorf("create installation token: %w", err)
+		return "", mint.err
+	}
+
+	mint.tok = tok
+	return tok.Token, nil
+}
+
+func (c *appClient) Installation(ctx context.Context, scope Scope) (int64, error) {
+	path, err := scope.installationPath()
+	if err != nil {
+		return 0, err
+	}
+
+	jwt, err := c.AppJWT()
+	if err != nil {
+		return 0, err
+	}
+
+	installation := new(struct {
+		ID int64 `json:"id"`
+	})
+	if err := c.doJSON(ctx, "Bearer "+jwt, http.MethodGet, path, nil, installation); err != nil {
+		return 0, fmt.Errorf("get installation for %s: %w", scope, err)
+	}
+
+	return installation.ID, nil
+}
+
+// installationJSON sends a request authenticated as the installation and decodes the JSON response into out.
+func (c *appClient) installationJSON(ctx context.Context, installationID int64, method, path string, in, out any) error {
+	tok, err := c.InstallationToken(ctx, installationID)
+	if err != nil {
+		return err
+	}
+
+	return c.doJSON(ctx, "token "+tok, method, path, in, out)
+}
+
+// installationConditional sends a conditional GET authenticated as the installation and decodes the JSON response
+// into out.
+func (c *appClient) installationConditional(ctx context.Context, installationID int64, path, etag string, out any) (*Response, error) {
+	tok, err := c.InstallationToken(ctx, installationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, "token "+tok, http.MethodGet, path, etag, nil, out)
+}
@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DeliveryStore remembers which deliveries have already been handled so that redeliveries are not acted on twice.
+type DeliveryStore interface {
+	// Claim records the key. It returns false if the key was already claimed within the TTL.
+	Claim(ctx context.Context, key string) (bool, error)
+
+	// Release removes the claim on the key so that a redelivery is handled again.
+	Release(ctx context.Context, key string) error
+}
+
+type memoryDeliveryStore struct {
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// ttl is how long a claim is held for.
+	ttl time.Duration
+
+	// claims are the expiry times of the claimed keys.
+	claims map[string]time.Time
+
+	// lastPrune is when the expired claims were last removed.
+	lastPrune time.Time
+
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+}
+
+// NewMemoryDeliveryStore creates a DeliveryStore that holds the claims in memory for the given TTL.
+func NewMemoryDeliveryStore(ttl time.Duration) DeliveryStore {
+	return &memoryDeliveryStore{
+		ttl:    ttl,
+		claims: make(map[string]time.Time),
+		now:    time.Now,
+	}
+}
+
+func (m *memoryDeliveryStore) Claim(_ context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.prune(now)
+
+	if expiry, ok := m.claims[key]; ok && now.Before(expiry) {
+		return false, nil
+	}
+
+	m.claims[key] = now.Add(m.ttl)
+	return true, nil
+}
+
+func (m *memoryDeliveryStore) Release(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.claims, key)
+	return nil
+}
+
+// prune removes the expired claims. It runs at most once per TTL so that claiming stays cheap.
+func (m *memoryDeliveryStore) prune(now time.Time) {
+	if now.Sub(m.lastPrune) < m.ttl {
+		return
+	}
+
+	for key, expiry := range m.claims {
+		if !now.Before(expiry) {
+			delete(m.claims, key)
+		}
+	}
+	m.lastPrune = now
+}
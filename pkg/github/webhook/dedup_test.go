@@ -0,0 +1,35 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryDeliveryStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryDeliveryStore(time.Minute).(*memoryDeliveryStore)
+	store.now = func() time.Time { return now }
+
+	ctx := context.Background()
+
+	claimed, err := store.Claim(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, claimed, "first claim should succeed")
+
+	claimed, err = store.Claim(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, claimed, "second claim within the TTL should fail")
+
+	now = now.Add(2 * time.Minute)
+	claimed, err = store.Claim(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, claimed, "claim after the TTL should succeed")
+
+	require.NoError(t, store.Release(ctx, "a"))
+	claimed, err = store.Claim(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, claimed, "claim after release should succeed")
+}
@@ -0,0 +1,14 @@
+package webhook
+
+import "errors"
+
+var (
+	// ErrInvalidSignature is returned when the X-Hub-Signature-256 header does not match the body.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+
+	// ErrNoSecret is returned when there is no secret to validate the signature against.
+	ErrNoSecret = errors.New("no webhook secret configured")
+
+	// ErrUnsupportedAction is returned when the workflow_job action is not one the scaler acts on.
+	ErrUnsupportedAction = errors.New("unsupported workflow_job action")
+)
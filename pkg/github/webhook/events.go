@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+)
+
+// Action is the action of a workflow_job delivery.
+type Action string
+
+const (
+	// ActionQueued is sent when a job is queued and waiting for a runner.
+	ActionQueued Action = "queued"
+
+	// ActionInProgress is sent when a runner has picked up a job.
+	ActionInProgress Action = "in_progress"
+
+	// ActionCompleted is sent when a job has finished, whatever its conclusion.
+	ActionCompleted Action = "completed"
+)
+
+// String returns the string representation of the Action.
+func (a Action) String() string {
+	return string(a)
+}
+
+// WorkflowJob is the workflow_job object of a delivery.
+type WorkflowJob struct {
+	ID              int64      `json:"id"`
+	RunID           int64      `json:"run_id"`
+	RunAttempt      int        `json:"run_attempt"`
+	Name            string     `json:"name"`
+	WorkflowName    string     `json:"workflow_name"`
+	HeadBranch      string     `json:"head_branch"`
+	Status          string     `json:"status"`
+	Conclusion      string     `json:"conclusion"`
+	Labels          []string   `json:"labels"`
+	RunnerID        int64      `json:"runner_id"`
+	RunnerName      string     `json:"runner_name"`
+	RunnerGroupID   int64      `json:"runner_group_id"`
+	RunnerGroupName string     `json:"runner_group_name"`
+	CreatedAt       time.Time  `json:"created_at"`
+	StartedAt       time.Time  `json:"started_at"`
+	CompletedAt     *time.Time `json:"completed_at"`
+}
+
+// Repository is the repository a job belongs to.
+type Repository struct {
+	ID       int64   `json:"id"`
+	Name     string  `json:"name"`
+	FullName string  `json:"full_name"`
+	Private  bool    `json:"private"`
+	Owner    Account `json:"owner"`
+}
+
+// Account is a user, organization or enterprise.
+type Account struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+	Slug  string `json:"slug,omitempty"`
+}
+
+// Installation is the GitHub App installation the delivery was sent for.
+type Installation struct {
+	ID int64 `json:"id"`
+}
+
+// WorkflowJobEvent is the decoded body of a workflow_job delivery.
+type WorkflowJobEvent struct {
+	// DeliveryID is the value of the X-GitHub-Delivery header.
+	DeliveryID string `json:"-"`
+
+	Action       Action        `json:"action"`
+	WorkflowJob  WorkflowJob   `json:"workflow_job"`
+	Repository   Repository    `json:"repository"`
+	Organization *Account      `json:"organization,omitempty"`
+	Enterprise   *Account      `json:"enterprise,omitempty"`
+	Installation *Installation `json:"installation,omitempty"`
+}
+
+// Payload returns the decoded delivery. It lets the typed events satisfy Event.
+func (e *WorkflowJobEvent) Payload() *WorkflowJobEvent {
+	return e
+}
+
+// Event is a typed workflow_job event the scaler can act on.
+type Event interface {
+	// Payload returns the decoded delivery.
+	Payload() *WorkflowJobEvent
+}
+
+// JobQueued is sent when a job is waiting for a runner.
+type JobQueued struct {
+	*WorkflowJobEvent
+}
+
+// NewJobQueued returns the event of a queued job that was found through the API rather than delivered, so that it
+// takes the same path as a delivery.
+func NewJobQueued(deliveryID string, installationID int64, repository string, job *github.WorkflowJob) *JobQueued {
+	owner, name, _ := strings.Cut(repository, "/")
+	return &JobQueued{&WorkflowJobEvent{
+		DeliveryID: deliveryID,
+		Action:     ActionQueued,
+		WorkflowJob: WorkflowJob{
+			ID:           job.ID,
+			RunID:        job.RunID,
+			RunAttempt:   job.RunAttempt,
+			Name:         job.Name,
+			WorkflowName: job.WorkflowName,
+			HeadBranch:   job.HeadBranch,
+			Status:       job.Status,
+			Labels:       job.Labels,
+			CreatedAt:    job.CreatedAt,
+		},
+		Repository: Repository{
+			Name:     name,
+			FullName: repository,
+			Owner:    Account{Login: owner},
+		},
+		Installation: &Installation{ID: installationID},
+	}}
+}
+
+// JobInProgress is sent when a runner has picked up a job.
+type JobInProgress struct {
+	*WorkflowJobEvent
+}
+
+// JobCompleted is sent when a job has finished.
+type JobCompleted struct {
+	*WorkflowJobEvent
+}
+
+// ParseEvent decodes a workflow_job delivery body into its typed event. ErrUnsupportedAction is returned for actions
+// the scaler does not act on, e.g. waiting.
+func ParseEvent(deliveryID string, body []byte) (Event, error) {
+	e := new(WorkflowJobEvent)
+	if err := json.Unmarshal(body, e); err != nil {
+		return nil, fmt.Errorf("decode workflow_job payload: %w", err)
+	}
+	e.DeliveryID = deliveryID
+
+	switch e.Action {
+	case ActionQueued:
+		return &JobQueued{e}, nil
+	case ActionInProgress:
+		return &JobInProgress{e}, nil
+	case ActionCompleted:
+		return &JobCompleted{e}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAction, e.Action)
+	}
+}
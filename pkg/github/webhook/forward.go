@@ -0,0 +1,81 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+)
+
+// Forwarder hands the events to another replica of the scaler by delivering them to its webhook endpoint, signed with
+// the webhook secret as GitHub would. The replica deduplicates them like any other delivery.
+type Forwarder struct {
+	vc     vault.Client
+	secret SecretRef
+	client *http.Client
+
+	// target returns the base URL of the replica to forward to.
+	target func() (string, error)
+}
+
+// NewForwarder creates a new Forwarder that forwards to the replica whose base URL, e.g. http://10.0.0.2:8080, is
+// returned by target.
+func NewForwarder(vc vault.Client, secret SecretRef, client *http.Client, target func() (string, error)) *Forwarder {
+	return &Forwarder{
+		vc:     vc,
+		secret: secret,
+		client: client,
+		target: target,
+	}
+}
+
+// Dispatch implements Dispatcher.
+func (f *Forwarder) Dispatch(ctx context.Context, event Event) error {
+	base, err := f.target()
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := url.JoinPath(base, "webhooks", "github")
+	if err != nil {
+		return fmt.Errorf("build forward url: %w", err)
+	}
+
+	payload := event.Payload()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode event: %w", err)
+	}
+
+	secret, err := readSecret(ctx, f.vc, f.secret)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create forward request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerEvent, eventWorkflowJob)
+	req.Header.Set(headerDelivery, payload.DeliveryID)
+	req.Header.Set(headerSignature, Sign(secret, body))
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("forward event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("forward event: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwarderDeliversToAnotherReplica(t *testing.T) {
+	vc := vault.NewMockClient(t)
+	vc.On("GetKvSecretV2", mock.Anything, "secret", "github/webhook").
+		Return(&vaultapi.KVSecret{Data: map[string]any{"secret": "shh"}}, nil)
+	ref := SecretRef{Mount: "secret", Path: "github/webhook", Key: "secret"}
+
+	dispatcher := new(recordingDispatcher)
+	mux := http.NewServeMux()
+	mux.Handle("POST /webhooks/github", NewHandler(vc, ref, NewMemoryDeliveryStore(time.Hour), dispatcher))
+	leader := httptest.NewServer(mux)
+	defer leader.Close()
+
+	event, err := ParseEvent("delivery-1", []byte(testQueuedBody))
+	require.NoError(t, err)
+
+	forwarder := NewForwarder(vc, ref, leader.Client(), func() (string, error) { return leader.URL, nil })
+	require.NoError(t, forwarder.Dispatch(context.Background(), event))
+
+	// The leader acknowledges a second forward of the same delivery without dispatching it again.
+	require.NoError(t, forwarder.Dispatch(context.Background(), event))
+
+	require.Len(t, dispatcher.events, 1)
+	require.Equal(t, event, dispatcher.events[0])
+}
+
+func TestForwarderFailsWithoutTarget(t *testing.T) {
+	noLeader := errors.New("no leader")
+	forwarder := NewForwarder(nil, SecretRef{}, http.DefaultClient, func() (string, error) { return "", noLeader })
+
+	event, err := ParseEvent("delivery-1", []byte(testQueuedBody))
+	require.NoError(t, err)
+	require.ErrorIs(t, forwarder.Dispatch(context.Background(), event), noLeader)
+}
@@ -0,0 +1,187 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+	uhttp "github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils/http"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+)
+
+const (
+	// headerEvent is the header holding the name of the event that triggered the delivery.
+	headerEvent = "X-GitHub-Event"
+
+	// headerDelivery is the header holding the unique ID of the delivery.
+	headerDelivery = "X-GitHub-Delivery"
+
+	// headerSignature is the header holding the HMAC-SHA256 signature of the body.
+	headerSignature = "X-Hub-Signature-256"
+
+	// eventWorkflowJob is the event name of workflow_job deliveries.
+	eventWorkflowJob = "workflow_job"
+
+	// eventPing is the event GitHub sends when a webhook is first created.
+	eventPing = "ping"
+
+	// maxBodyBytes is the largest body GitHub will send in a delivery.
+	maxBodyBytes = 25 << 20
+)
+
+// Dispatcher acts on the typed workflow_job events.
+type Dispatcher interface {
+	// Dispatch handles the event. Returning an error makes GitHub see the delivery as failed.
+	Dispatch(ctx context.Context, event Event) error
+}
+
+// SecretRef is where the webhook secret is stored in Vault KV v2.
+type SecretRef struct {
+	// Mount is the KV v2 mount path.
+	Mount string
+
+	// Path is the path of the secret within the mount.
+	Path string
+
+	// Key is the key of the webhook secret within the secret data.
+	Key string
+}
+
+// Handler receives workflow_job deliveries from GitHub.
+type Handler struct {
+	vc         vault.Client
+	secret     SecretRef
+	deliveries DeliveryStore
+	dispatcher Dispatcher
+}
+
+// NewHandler creates a new Handler.
+func NewHandler(vc vault.Client, secret SecretRef, deliveries DeliveryStore, dispatcher Dispatcher) *Handler {
+	return &Handler{
+		vc:         vc,
+		secret:     secret,
+		deliveries: deliveries,
+		dispatcher: dispatcher,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	deliveryID := r.Header.Get(headerDelivery)
+	l := slog.With(slog.String(logging.KeyDeliveryID, deliveryID))
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		uhttp.SendErrorMessageWithStatus(w, http.StatusBadRequest, "Unable to read body", err)
+		return
+	}
+
+	secret, err := h.webhookSecret(r.Context())
+	if err != nil {
+		l.Error("unable to get webhook secret", slog.String(logging.KeyError, err.Error()))
+		uhttp.SendErrorMessage(w, "Unable to validate delivery", err)
+		return
+	}
+
+	if err := ValidateSignature(secret, body, r.Header.Get(headerSignature)); err != nil {
+		l.Warn("rejecting delivery", slog.String(logging.KeyError, err.Error()))
+		uhttp.UnauthorizedHandler()(w, r)
+		return
+	}
+
+	switch event := r.Header.Get(headerEvent); event {
+	case eventWorkflowJob:
+	case eventPing:
+		uhttp.SendMessage(w, "pong")
+		return
+	default:
+		uhttp.SendMessage(w, "Event %s ignored", event)
+		return
+	}
+
+	event, err := ParseEvent(deliveryID, body)
+	if errors.Is(err, ErrUnsupportedAction) {
+		uhttp.SendMessage(w, "Action ignored")
+		return
+	} else if err != nil {
+		uhttp.SendErrorMessageWithStatus(w, http.StatusBadRequest, uhttp.MsgBadRequest, err)
+		return
+	}
+
+	keys, duplicate, err := h.claim(r.Context(), deliveryID, body)
+	if err != nil {
+		l.Error("unable to claim delivery", slog.String(logging.KeyError, err.Error()))
+		uhttp.SendErrorMessage(w, "Unable to handle event", err)
+		return
+	} else if duplicate {
+		l.Info("duplicate delivery acknowledged")
+		uhttp.SendMessage(w, "Duplicate delivery ignored")
+		return
+	}
+
+	if err := h.dispatcher.Dispatch(r.Context(), event); err != nil {
+		l.Error("unable to dispatch event", slog.String(logging.KeyError, err.Error()))
+		h.release(r.Context(), keys)
+		uhttp.SendErrorMessage(w, "Unable to handle event", err)
+		return
+	}
+
+	uhttp.SendMessage(w, "Event accepted")
+}
+
+// webhookSecret reads the webhook secret from Vault.
+func (h *Handler) webhookSecret(ctx context.Context) ([]byte, error) {
+	return readSecret(ctx, h.vc, h.secret)
+}
+
+// readSecret reads the webhook secret at the reference from Vault.
+func readSecret(ctx context.Context, vc vault.Client, ref SecretRef) ([]byte, error) {
+	secret, err := vc.GetKvSecretV2(ctx, ref.Mount, ref.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read webhook secret: %w", err)
+	}
+
+	value, ok := secret.Data[ref.Key].(string)
+	if !ok || value == "" {
+		return nil, ErrNoSecret
+	}
+
+	return []byte(value), nil
+}
+
+// claim claims both the delivery ID and the fingerprint of the payload. The fingerprint catches the same event being
+// sent by more than one webhook, e.g. a repository and an organization hook, which have different delivery IDs.
+func (h *Handler) claim(ctx context.Context, deliveryID string, body []byte) (keys []string, duplicate bool, err error) {
+	candidates := []string{"payload:" + utils.GenerateShaToken(string(body))}
+	if deliveryID != "" {
+		candidates = append(candidates, "delivery:"+deliveryID)
+	}
+
+	for _, key := range candidates {
+		claimed, err := h.deliveries.Claim(ctx, key)
+		if err != nil {
+			h.release(ctx, keys)
+			return nil, false, fmt.Errorf("claim %s: %w", key, err)
+		} else if !claimed {
+			duplicate = true
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, duplicate, nil
+}
+
+// release releases the claims so that a redelivery of a failed event is handled again.
+func (h *Handler) release(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := h.deliveries.Release(ctx, key); err != nil {
+			slog.Error("unable to release delivery claim", slog.String("key", key), slog.String(logging.KeyError, err.Error()))
+		}
+	}
+}
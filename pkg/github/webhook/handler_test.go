@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+const testQueuedBody = `{
+  "action": "queued",
+  "workflow_job": {"id": 42, "run_id": 7, "labels": ["self-hosted", "proxmox"]},
+  "repository": {"id": 1, "name": "repo", "full_name": "octo/repo", "owner": {"id": 2, "login": "octo"}},
+  "installation": {"id": 99}
+}`
+
+type recordingDispatcher struct {
+	events []Event
+	err    error
+}
+
+func (d *recordingDispatcher) Dispatch(_ context.Context, event Event) error {
+	d.events = append(d.events, event)
+	return d.err
+}
+
+type HandlerSuite struct {
+	suite.Suite
+
+	vc         *vault.MockClient
+	dispatcher *recordingDispatcher
+	handler    *Handler
+}
+
+func TestHandlerSuite(t *testing.T) {
+	suite.Run(t, new(HandlerSuite))
+}
+
+func (s *HandlerSuite) SetupTest() {
+	s.vc = vault.NewMockClient(s.T())
+	s.dispatcher = new(recordingDispatcher)
+	s.handler = NewHandler(s.vc, SecretRef{Mount: "secret", Path: "github/webhook", Key: "secret"},
+		NewMemoryDeliveryStore(time.Hour), s.dispatcher)
+}
+
+func (s *HandlerSuite) expectSecret() {
+	s.vc.On("GetKvSecretV2", mock.Anything, "secret", "github/webhook").
+		Return(&vaultapi.KVSecret{Data: map[string]any{"secret": "shh"}}, nil)
+}
+
+func (s *HandlerSuite) request(event, body, signature string) *httptest.ResponseRecorder {
+	return s.requestWithDelivery("delivery-1", event, body, signature)
+}
+
+func (s *HandlerSuite) requestWithDelivery(deliveryID, event, body, signature string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewBufferString(body))
+	r.Header.Set(headerEvent, event)
+	r.Header.Set(headerDelivery, deliveryID)
+	r.Header.Set(headerSignature, signature)
+
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+func (s *HandlerSuite) TestQueuedIsDispatched() {
+	s.expectSecret()
+
+	w := s.request(eventWorkflowJob, testQueuedBody, Sign([]byte("shh"), []byte(testQueuedBody)))
+
+	s.Equal(http.StatusOK, w.Code)
+	s.Require().Len(s.dispatcher.events, 1)
+
+	queued, ok := s.dispatcher.events[0].(*JobQueued)
+	s.Require().True(ok)
+	s.Equal(int64(42), queued.WorkflowJob.ID)
+	s.Equal("octo/repo", queued.Repository.FullName)
+	s.Equal("delivery-1", queued.DeliveryID)
+}
+
+func (s *HandlerSuite) TestBadSignatureIsUnauthorized() {
+	s.expectSecret()
+
+	w := s.request(eventWorkflowJob, testQueuedBody, Sign([]byte("wrong"), []byte(testQueuedBody)))
+
+	s.Equal(http.StatusUnauthorized, w.Code)
+	s.Empty(s.dispatcher.events)
+}
+
+func (s *HandlerSuite) TestOtherEventsAreIgnored() {
+	s.expectSecret()
+
+	w := s.request("push", `{}`, Sign([]byte("shh"), []byte(`{}`)))
+
+	s.Equal(http.StatusOK, w.Code)
+	s.Empty(s.dispatcher.events)
+}
+
+func (s *HandlerSuite) TestUnsupportedActionIsIgnored() {
+	s.expectSecret()
+
+	body := `{"action": "waiting", "workflow_job": {"id": 1}}`
+	w := s.request(eventWorkflowJob, body, Sign([]byte("shh"), []byte(body)))
+
+	s.Equal(http.StatusOK, w.Code)
+	s.Empty(s.dispatcher.events)
+}
+
+func (s *HandlerSuite) TestDispatchErrorIsServerError() {
+	s.expectSecret()
+	s.dispatcher.err = errors.New("boom")
+
+	w := s.request(eventWorkflowJob, testQueuedBody, Sign([]byte("shh"), []byte(testQueuedBody)))
+
+	s.Equal(http.StatusInternalServerError, w.Code)
+}
+
+func (s *HandlerSuite) TestSecretErrorIsServerError() {
+	s.vc.On("GetKvSecretV2", mock.Anything, "secret", "github/webhook").
+		Return(nil, vault.ErrSecretNotFound)
+
+	w := s.request(eventWorkflowJob, testQueuedBody, Sign([]byte("shh"), []byte(testQueuedBody)))
+
+	s.Equal(http.StatusInternalServerError, w.Code)
+	s.Empty(s.dispatcher.events)
+}
+
+func (s *HandlerSuite) TestRedeliveryIsAcknowledgedOnce() {
+	s.expectSecret()
+	signature := Sign([]byte("shh"), []byte(testQueuedBody))
+
+	first := s.request(eventWorkflowJob, testQueuedBody, signature)
+	second := s.request(eventWorkflowJob, testQueuedBody, signature)
+
+	s.Equal(http.StatusOK, first.Code)
+	s.Equal(http.StatusOK, second.Code)
+	s.Len(s.dispatcher.events, 1)
+}
+
+func (s *HandlerSuite) TestSamePayloadFromAnotherHookIsIgnored() {
+	s.expectSecret()
+	signature := Sign([]byte("shh"), []byte(testQueuedBody))
+
+	s.requestWithDelivery("delivery-1", eventWorkflowJob, testQueuedBody, signature)
+	w := s.requestWithDelivery("delivery-2", eventWorkflowJob, testQueuedBody, signature)
+
+	s.Equal(http.StatusOK, w.Code)
+	s.Len(s.dispatcher.events, 1)
+}
+
+func (s *HandlerSuite) TestFailedDispatchIsRetriedOnRedelivery() {
+	s.expectSecret()
+	signature := Sign([]byte("shh"), []byte(testQueuedBody))
+
+	s.dispatcher.err = errors.New("boom")
+	first := s.request(eventWorkflowJob, testQueuedBody, signature)
+
+	s.dispatcher.err = nil
+	second := s.request(eventWorkflowJob, testQueuedBody, signature)
+
+	s.Equal(http.StatusInternalServerError, first.Code)
+	s.Equal(http.StatusOK, second.Code)
+	s.Len(s.dispatcher.events, 2)
+}
@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/queue"
+)
+
+// QueueDispatcher persists every event to the queue, so that a delivery is only acknowledged once it is on disk. The
+// events are acted on when the queue workers hand them to the dispatcher returned by MessageHandler.
+type QueueDispatcher struct {
+	q *queue.Queue
+}
+
+// NewQueueDispatcher creates a new QueueDispatcher.
+func NewQueueDispatcher(q *queue.Queue) *QueueDispatcher {
+	return &QueueDispatcher{
+		q: q,
+	}
+}
+
+// Dispatch implements Dispatcher.
+func (d *QueueDispatcher) Dispatch(ctx context.Context, event Event) error {
+	payload := event.Payload()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode event: %w", err)
+	}
+
+	if _, err := d.q.Enqueue(ctx, payload.DeliveryID, body); err != nil {
+		return fmt.Errorf("enqueue event: %w", err)
+	}
+
+	return nil
+}
+
+// MessageHandler returns a queue.Handler that decodes the queued events and hands them to the dispatcher.
+func MessageHandler(d Dispatcher) queue.Handler {
+	return func(ctx context.Context, msg *queue.Message) error {
+		event, err := ParseEvent(msg.Key, msg.Body)
+		if err != nil {
+			// The body was valid when it was enqueued, so it will never decode.
+			return queue.Permanent(err)
+		}
+
+		return d.Dispatch(ctx, event)
+	}
+}
@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/queue"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueDispatcherRoundTrip(t *testing.T) {
+	q, err := queue.Open(queue.Config{
+		Path:        filepath.Join(t.TempDir(), "events.wal"),
+		MaxAttempts: 1,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer q.Close()
+
+	event, err := ParseEvent("delivery-1", []byte(testQueuedBody))
+	require.NoError(t, err)
+
+	require.NoError(t, NewQueueDispatcher(q).Dispatch(context.Background(), event))
+	require.Equal(t, queue.Stats{Pending: 1}, q.Stats())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dispatcher := new(recordingDispatcher)
+	handler := MessageHandler(dispatcher)
+	require.NoError(t, q.Run(ctx, 1, func(ctx context.Context, msg *queue.Message) error {
+		defer cancel()
+		return handler(ctx, msg)
+	}))
+
+	require.Len(t, dispatcher.events, 1)
+	require.Equal(t, event, dispatcher.events[0])
+}
@@ -0,0 +1,46 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// signaturePrefix is the prefix GitHub puts before the hex encoded HMAC.
+	signaturePrefix = "sha256="
+)
+
+// Sign returns the X-Hub-Signature-256 value for the body signed with the secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateSignature checks the X-Hub-Signature-256 header against the body signed with the secret.
+func ValidateSignature(secret, body []byte, signature string) error {
+	if len(secret) == 0 {
+		return ErrNoSecret
+	}
+
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrInvalidSignature
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	// hmac.Equal is constant time, so the comparison does not leak how much of the signature matched.
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSignature(t *testing.T) {
+	secret := []byte("It's a Secret to Everybody")
+	body := []byte("Hello, World!")
+
+	tests := []struct {
+		name      string
+		secret    []byte
+		signature string
+		wantErr   error
+	}{
+		{
+			name:      "valid signature",
+			secret:    secret,
+			signature: "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+			wantErr:   nil,
+		},
+		{
+			name:      "wrong secret",
+			secret:    []byte("wrong"),
+			signature: "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+			wantErr:   ErrInvalidSignature,
+		},
+		{
+			name:      "missing prefix",
+			secret:    secret,
+			signature: "757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+			wantErr:   ErrInvalidSignature,
+		},
+		{
+			name:      "not hex",
+			secret:    secret,
+			signature: "sha256=zz",
+			wantErr:   ErrInvalidSignature,
+		},
+		{
+			name:      "no secret",
+			secret:    nil,
+			signature: "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+			wantErr:   ErrNoSecret,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSignature(tt.secret, body, tt.signature)
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	got := Sign([]byte("It's a Secret to Everybody"), []byte("Hello, World!"))
+	require.Equal(t, "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17", got)
+}
@@ -15,4 +15,40 @@ const (
 
 	// KeyHash represents the key for the hash.
 	KeyHash = `hash`
+
+	// KeyJobID represents the key for the workflow job ID.
+	KeyJobID = `job_id`
+
+	// KeyRepository represents the key for the repository.
+	KeyRepository = `repository`
+
+	// KeyRunner represents the key for the runner name.
+	KeyRunner = `runner`
+
+	// KeyDeliveryID represents the key for the GitHub webhook delivery ID.
+	KeyDeliveryID = `delivery_id`
+
+	// KeyMessageID represents the key for a queue message ID.
+	KeyMessageID = `message_id`
+
+	// KeyNode represents the key for a Proxmox node.
+	KeyNode = `node`
+
+	// KeyVMID represents the key for a Proxmox guest ID.
+	KeyVMID = `vmid`
+
+	// KeyJITConfig represents the key for a runner just-in-time config. Its value is always redacted.
+	KeyJITConfig = `jit_config`
+
+	// KeyUserData represents the key for rendered cloud-init user data. Its value is always redacted.
+	KeyUserData = `user_data`
 )
+
+// RedactedValue is the value logged in place of a secret.
+const RedactedValue = `[REDACTED]`
+
+// sensitiveKeys are the keys whose values are replaced with RedactedValue.
+var sensitiveKeys = map[string]struct{}{
+	KeyJITConfig: {},
+	KeyUserData:  {},
+}
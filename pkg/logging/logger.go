@@ -74,7 +74,8 @@ func CommonLoggerWithOptions(cfg *Config, w io.Writer, minLevel slog.Level, logT
 	return logger, nil
 }
 
-// replaceAttrs is a slog.HandlerOptions.ReplaceAttr function that replaces some attributes.
+// replaceAttrs is a slog.HandlerOptions.ReplaceAttr function that replaces some attributes, and redacts the values of
+// the sensitive keys.
 func replaceAttrs(_ []string, a slog.Attr) slog.Attr {
 	switch a.Key {
 	case slog.SourceKey:
@@ -89,6 +90,11 @@ func replaceAttrs(_ []string, a slog.Attr) slog.Attr {
 		// Remove any curly braces from the source file. This is needed for the logstash parser.
 		a.Value = slog.StringValue(strings.ReplaceAll(a.Value.String(), "{", ""))
 		a.Value = slog.StringValue(strings.ReplaceAll(a.Value.String(), "}", ""))
+	default:
+		// Secrets are redacted wherever they are logged from, including inside groups.
+		if _, ok := sensitiveKeys[a.Key]; ok {
+			a.Value = slog.StringValue(RedactedValue)
+		}
 	}
 	return a
 }
@@ -1,7 +1,9 @@
 package logging
 
 import (
+	"bytes"
 	"errors"
+	"log/slog"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -55,3 +57,22 @@ func TestCommonLogger(t *testing.T) {
 		})
 	}
 }
+
+func TestCommonLoggerWithOptionsRedactsSecrets(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	buf := new(bytes.Buffer)
+	logger, err := CommonLoggerWithOptions(NewConfig("TestRedact"), buf, slog.LevelDebug, true)
+	require.NoError(t, err)
+
+	logger.Info("rendered",
+		slog.String(KeyUserData, "#cloud-config\njitconfig: c2VjcmV0"),
+		slog.Group("guest", slog.String(KeyJITConfig, "c2VjcmV0")),
+		slog.String(KeyRunner, "pgr-1"),
+	)
+
+	require.NotContains(t, buf.String(), "c2VjcmV0")
+	require.Contains(t, buf.String(), `"user_data":"[REDACTED]"`)
+	require.Contains(t, buf.String(), `"guest":{"jit_config":"[REDACTED]"}`)
+	require.Contains(t, buf.String(), `"runner":"pgr-1"`)
+}
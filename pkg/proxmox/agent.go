@@ -0,0 +1,82 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func (c *client) AgentPing(ctx context.Context, node string, vmid int) error {
+	if err := c.post(ctx, guestPath(node, GuestTypeQemu, vmid)+"/agent/ping", nil, nil); err != nil {
+		return fmt.Errorf("ping guest agent of vm %d: %w", vmid, err)
+	}
+	return nil
+}
+
+func (c *client) AgentExec(ctx context.Context, node string, vmid int, command []string, input string) (int, error) {
+	if len(command) == 0 {
+		return 0, errors.New("command is required")
+	}
+
+	params := url.Values{"command": command}
+	if input != "" {
+		params.Set("input-data", input)
+	}
+
+	var started struct {
+		PID int `json:"pid"`
+	}
+	if err := c.post(ctx, guestPath(node, GuestTypeQemu, vmid)+"/agent/exec", params, &started); err != nil {
+		return 0, fmt.Errorf("exec %s in vm %d: %w", command[0], vmid, err)
+	}
+	return started.PID, nil
+}
+
+func (c *client) AgentExecStatus(ctx context.Context, node string, vmid, pid int) (*AgentExecStatus, error) {
+	params := url.Values{"pid": {strconv.Itoa(pid)}}
+
+	status := new(AgentExecStatus)
+	if err := c.get(ctx, guestPath(node, GuestTypeQemu, vmid)+"/agent/exec-status", params, status); err != nil {
+		return nil, fmt.Errorf("get status of pid %d in vm %d: %w", pid, vmid, err)
+	}
+	return status, nil
+}
+
+func (c *client) AgentRun(ctx context.Context, node string, vmid int, command []string, input string) (*AgentExecStatus, error) {
+	pid, err := c.AgentExec(ctx, node, vmid, command, input)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := c.AgentExecStatus(ctx, node, vmid, pid)
+		if err != nil && !isTransient(err) {
+			return nil, err
+		}
+		if status != nil && status.HasExited() {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for pid %d in vm %d: %w", pid, vmid, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *client) AgentNetworkInterfaces(ctx context.Context, node string, vmid int) ([]*AgentInterface, error) {
+	var resp struct {
+		Result []*AgentInterface `json:"result"`
+	}
+	if err := c.get(ctx, guestPath(node, GuestTypeQemu, vmid)+"/agent/network-get-interfaces", nil, &resp); err != nil {
+		return nil, fmt.Errorf("get network interfaces of vm %d: %w", vmid, err)
+	}
+	return resp.Result, nil
+}
@@ -0,0 +1,80 @@
+package proxmox
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+func (s *ClientSuite) TestAgentPing() {
+	var ready atomic.Bool
+	s.mux.HandleFunc("POST /api2/json/nodes/pve1/qemu/101/agent/ping", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"data":null}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"result":{}}}`))
+	})
+
+	s.ErrorContains(s.client.AgentPing(context.Background(), "pve1", 101), "ping guest agent of vm 101")
+
+	ready.Store(true)
+	s.NoError(s.client.AgentPing(context.Background(), "pve1", 101))
+}
+
+func (s *ClientSuite) TestAgentRun() {
+	var polls atomic.Int32
+	s.mux.HandleFunc("POST /api2/json/nodes/pve1/qemu/101/agent/exec", func(w http.ResponseWriter, r *http.Request) {
+		s.NoError(r.ParseForm())
+		s.Equal([]string{"systemctl", "is-active", "actions-runner.service"}, r.PostForm["command"])
+		s.Empty(r.PostForm.Get("input-data"))
+		_, _ = w.Write([]byte(`{"data":{"pid":4242}}`))
+	})
+	s.mux.HandleFunc("GET /api2/json/nodes/pve1/qemu/101/agent/exec-status", func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("4242", r.URL.Query().Get("pid"))
+		if polls.Add(1) < 3 {
+			_, _ = w.Write([]byte(`{"data":{"exited":0}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"exited":1,"exitcode":3,"out-data":"inactive\n"}}`))
+	})
+
+	status, err := s.client.AgentRun(context.Background(), "pve1", 101, []string{"systemctl", "is-active", "actions-runner.service"}, "")
+	s.Require().NoError(err)
+	s.Equal(int32(3), polls.Load())
+	s.True(status.HasExited())
+	s.False(status.OK())
+	s.Equal(3, status.ExitCode)
+	s.Equal("inactive\n", status.OutData)
+}
+
+func (s *ClientSuite) TestAgentExecSendsInput() {
+	s.mux.HandleFunc("POST /api2/json/nodes/pve1/qemu/101/agent/exec", func(w http.ResponseWriter, r *http.Request) {
+		s.NoError(r.ParseForm())
+		s.Equal("#!/bin/sh\necho hello\n", r.PostForm.Get("input-data"))
+		_, _ = w.Write([]byte(`{"data":{"pid":7}}`))
+	})
+
+	pid, err := s.client.AgentExec(context.Background(), "pve1", 101, []string{"/bin/sh"}, "#!/bin/sh\necho hello\n")
+	s.Require().NoError(err)
+	s.Equal(7, pid)
+
+	_, err = s.client.AgentExec(context.Background(), "pve1", 101, nil, "")
+	s.EqualError(err, "command is required")
+}
+
+func (s *ClientSuite) TestAgentNetworkInterfaces() {
+	s.mux.HandleFunc("GET /api2/json/nodes/pve1/qemu/101/agent/network-get-interfaces", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"result":[
+			{"name":"lo","hardware-address":"00:00:00:00:00:00","ip-addresses":[{"ip-address":"127.0.0.1","ip-address-type":"ipv4","prefix":8}]},
+			{"name":"eth0","hardware-address":"bc:24:11:00:00:01","ip-addresses":[{"ip-address":"10.0.0.5","ip-address-type":"ipv4","prefix":24}]}
+		]}}`))
+	})
+
+	ifaces, err := s.client.AgentNetworkInterfaces(context.Background(), "pve1", 101)
+	s.Require().NoError(err)
+	s.Require().Len(ifaces, 2)
+	s.Equal("eth0", ifaces[1].Name)
+	s.Equal(&AgentIPAddress{Address: "10.0.0.5", Type: "ipv4", Prefix: 24}, ifaces[1].IPAddresses[0])
+}
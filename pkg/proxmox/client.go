@@ -0,0 +1,339 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+)
+
+// Client is a typed client for the Proxmox VE REST API.
+type Client interface {
+	// ListNodes returns the nodes of the cluster.
+	ListNodes(ctx context.Context) ([]*Node, error)
+
+	// GetNodeStatus returns the status of the node.
+	GetNodeStatus(ctx context.Context, node string) (*NodeStatus, error)
+
+	// ClusterResources returns the resources of the cluster. An empty type returns every resource.
+	ClusterResources(ctx context.Context, resourceType ResourceType) ([]*Resource, error)
+
+	// ListVMs returns the QEMU virtual machines on the node.
+	ListVMs(ctx context.Context, node string) ([]*Guest, error)
+
+	// GetVMStatus returns the current status of the virtual machine.
+	GetVMStatus(ctx context.Context, node string, vmid int) (*Guest, error)
+
+	// GetVMConfig returns the current config of the virtual machine.
+	GetVMConfig(ctx context.Context, node string, vmid int) (GuestConfig, error)
+
+	// StartVM starts the virtual machine.
+	StartVM(ctx context.Context, node string, vmid int) (UPID, error)
+
+	// StopVM stops the virtual machine immediately, like pulling the power.
+	StopVM(ctx context.Context, node string, vmid int) (UPID, error)
+
+	// ShutdownVM asks the guest to shut down.
+	ShutdownVM(ctx context.Context, node string, vmid int, timeout time.Duration) (UPID, error)
+
+	// DeleteVM destroys the virtual machine and its disks.
+	DeleteVM(ctx context.Context, node string, vmid int) (UPID, error)
+
+	// ListContainers returns the LXC containers on the node.
+	ListContainers(ctx context.Context, node string) ([]*Guest, error)
+
+	// GetContainerStatus returns the current status of the container.
+	GetContainerStatus(ctx context.Context, node string, vmid int) (*Guest, error)
+
+	// GetContainerConfig returns the current config of the container.
+	GetContainerConfig(ctx context.Context, node string, vmid int) (GuestConfig, error)
+
+	// StartContainer starts the container.
+	StartContainer(ctx context.Context, node string, vmid int) (UPID, error)
+
+	// StopContainer stops the container immediately.
+	StopContainer(ctx context.Context, node string, vmid int) (UPID, error)
+
+	// DeleteContainer destroys the container and its volumes.
+	DeleteContainer(ctx context.Context, node string, vmid int) (UPID, error)
+
+	// UpdateContainerConfig sets the given config options of the container. Options are applied before it returns.
+	UpdateContainerConfig(ctx context.Context, node string, vmid int, params url.Values) error
+
+	// ResizeContainerDisk grows the volume of the container, e.g. rootfs, to the given size in bytes. Volumes cannot
+	// shrink.
+	ResizeContainerDisk(ctx context.Context, node string, vmid int, disk string, size int64) (UPID, error)
+
+	// CloneContainer clones the container template and waits for the clone task to finish.
+	CloneContainer(ctx context.Context, req *CloneRequest) (*TaskResult, error)
+
+	// GetTaskStatus returns the status of the task.
+	GetTaskStatus(ctx context.Context, node string, upid UPID) (*TaskStatus, error)
+
+	// GetTaskLog returns up to limit lines of the task log, starting at the given line.
+	GetTaskLog(ctx context.Context, node string, upid UPID, start, limit int) ([]string, error)
+
+	// ListStorage returns the storage available on the node.
+	ListStorage(ctx context.Context, node string) ([]*Storage, error)
+
+	// UpdateVMConfig sets the given config options of the virtual machine. Options are applied before it returns.
+	UpdateVMConfig(ctx context.Context, node string, vmid int, params url.Values) error
+
+	// ResizeVMDisk grows the disk of the virtual machine to the given size in bytes. Disks cannot shrink. The returned
+	// UPID is empty on versions of Proxmox that resize synchronously.
+	ResizeVMDisk(ctx context.Context, node string, vmid int, disk string, size int64) (UPID, error)
+
+	// ListVMSnapshots returns the snapshots of the virtual machine, followed by the pseudo snapshot current.
+	ListVMSnapshots(ctx context.Context, node string, vmid int) ([]*Snapshot, error)
+
+	// CreateVMSnapshot snapshots the disks and config of the virtual machine, without its memory, under the name.
+	CreateVMSnapshot(ctx context.Context, node string, vmid int, name, description string) (UPID, error)
+
+	// RollbackVMSnapshot rolls the disks and config of the virtual machine back to the snapshot with the name. A
+	// running virtual machine is stopped first.
+	RollbackVMSnapshot(ctx context.Context, node string, vmid int, name string) (UPID, error)
+
+	// SetCloudInit sets the cloud-init options of the virtual machine. They are applied on its next boot.
+	SetCloudInit(ctx context.Context, node string, vmid int, ci *CloudInit) error
+
+	// AgentPing returns nil once the guest agent of the virtual machine answers, which it only does once the guest
+	// has booted far enough to start it.
+	AgentPing(ctx context.Context, node string, vmid int) error
+
+	// AgentExec starts the command in the virtual machine through the guest agent, with input on its standard input,
+	// and returns its PID.
+	AgentExec(ctx context.Context, node string, vmid int, command []string, input string) (int, error)
+
+	// AgentExecStatus returns the status of the command with the PID started by AgentExec.
+	AgentExecStatus(ctx context.Context, node string, vmid, pid int) (*AgentExecStatus, error)
+
+	// AgentRun runs the command in the virtual machine through the guest agent and waits for it to exit. A command
+	// that exits unsuccessfully is not an error, its status says so.
+	AgentRun(ctx context.Context, node string, vmid int, command []string, input string) (*AgentExecStatus, error)
+
+	// AgentNetworkInterfaces returns the network interfaces of the virtual machine as the guest agent sees them.
+	AgentNetworkInterfaces(ctx context.Context, node string, vmid int) ([]*AgentInterface, error)
+
+	// NextID returns a VMID that is free in the cluster at the time of the call.
+	NextID(ctx context.Context) (int, error)
+
+	// IsIDFree returns true if no guest of the cluster has the VMID at the time of the call.
+	IsIDFree(ctx context.Context, vmid int) (bool, error)
+
+	// CloneVM clones the template and waits for the clone task to finish.
+	CloneVM(ctx context.Context, req *CloneRequest) (*TaskResult, error)
+
+	// WaitForTask polls the task until it finishes or the context is cancelled. A task that finishes with an exit
+	// status other than OK returns ErrTaskFailed along with its result.
+	WaitForTask(ctx context.Context, upid UPID) (*TaskResult, error)
+}
+
+// Config is the configuration for the client.
+type Config struct {
+	// URL is the URL of any node of the cluster, e.g. https://pve1.example.com:8006.
+	URL string
+
+	// TokenPath is the Vault path the API token is read from with vault.Client.GetSecret, e.g. secret/data/proxmox
+	// for a KV v2 mount. The secret must hold a token_id (user@realm!name) and a token_secret.
+	TokenPath string
+
+	// TLS is the TLS configuration.
+	TLS TLSConfig
+
+	// Timeout is the timeout of each request. Defaults to 30 seconds.
+	Timeout time.Duration
+
+	// TaskPollInterval is how often the status of a task is polled while waiting for it. Defaults to 1 second.
+	TaskPollInterval time.Duration
+
+	// TaskLogTail is how many of the last lines of the task log are kept in a TaskResult. Defaults to 20.
+	TaskLogTail int
+}
+
+type client struct {
+	baseURL      string
+	auth         string
+	hc           *http.Client
+	pollInterval time.Duration
+	logTail      int
+}
+
+// NewClient creates a client for the cluster, reading the API token from Vault.
+func NewClient(ctx context.Context, cfg Config, vc vault.Client) (Client, error) {
+	secret, err := vc.GetSecret(ctx, cfg.TokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("read api token: %w", err)
+	}
+
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		// Reading a KV v2 path through the logical API nests the secret under data.
+		data = nested
+	}
+
+	tokenID, _ := data["token_id"].(string)
+	tokenSecret, _ := data["token_secret"].(string)
+	if tokenID == "" || tokenSecret == "" {
+		return nil, fmt.Errorf("%w: token_id and token_secret must be set at %s", ErrNoToken, cfg.TokenPath)
+	}
+
+	return newClient(cfg, tokenID, tokenSecret)
+}
+
+func newClient(cfg Config, tokenID, tokenSecret string) (*client, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("proxmox url is required")
+	}
+
+	tlsCfg, err := cfg.TLS.build()
+	if err != nil {
+		return nil, fmt.Errorf("build tls config: %w", err)
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	pollInterval := cfg.TaskPollInterval
+	if pollInterval == 0 {
+		pollInterval = time.Second
+	}
+
+	logTail := cfg.TaskLogTail
+	if logTail == 0 {
+		logTail = 20
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+
+	return &client{
+		baseURL: strings.TrimSuffix(cfg.URL, "/") + "/api2/json",
+		auth:    fmt.Sprintf("PVEAPIToken=%s=%s", tokenID, tokenSecret),
+		hc: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		pollInterval: pollInterval,
+		logTail:      logTail,
+	}, nil
+}
+
+// get sends a GET request and decodes the data of the response into out.
+func (c *client) get(ctx context.Context, path string, params url.Values, out any) error {
+	return c.do(ctx, http.MethodGet, path, params, out)
+}
+
+// post sends a POST request and decodes the data of the response into out.
+func (c *client) post(ctx context.Context, path string, params url.Values, out any) error {
+	return c.do(ctx, http.MethodPost, path, params, out)
+}
+
+// put sends a PUT request and decodes the data of the response into out.
+func (c *client) put(ctx context.Context, path string, params url.Values, out any) error {
+	return c.do(ctx, http.MethodPut, path, params, out)
+}
+
+// delete sends a DELETE request and decodes the data of the response into out.
+func (c *client) delete(ctx context.Context, path string, params url.Values, out any) error {
+	return c.do(ctx, http.MethodDelete, path, params, out)
+}
+
+// envelope is the wrapper the API puts around every response.
+type envelope struct {
+	// Data is where the response data is decoded into.
+	Data any `json:"data"`
+
+	// Total is the total number of entries of paged responses, e.g. task logs.
+	Total int `json:"total,omitempty"`
+}
+
+// do sends the request and decodes the data of the response into out if it is not nil.
+func (c *client) do(ctx context.Context, method, path string, params url.Values, out any) error {
+	if out == nil {
+		return c.doEnvelope(ctx, method, path, params, nil)
+	}
+	return c.doEnvelope(ctx, method, path, params, &envelope{Data: out})
+}
+
+// doEnvelope sends the request and decodes the response into env if it is not nil. Parameters are sent in the query
+// for GET and DELETE requests and as a form otherwise, which is what the API expects.
+func (c *client) doEnvelope(ctx context.Context, method, path string, params url.Values, env *envelope) error {
+	target := c.baseURL + path
+
+	var body io.Reader
+	switch method {
+	case http.MethodGet, http.MethodDelete:
+		if len(params) > 0 {
+			target += "?" + params.Encode()
+		}
+	default:
+		body = strings.NewReader(params.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.auth)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%s %s: %w", method, path, responseError(resp))
+	}
+
+	if env == nil || env.Data == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(env); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+
+	return nil
+}
+
+// responseError builds the error for an unsuccessful response. The API puts the message in the status line, and the
+// parameter errors in the body.
+func responseError(resp *http.Response) error {
+	msg := strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)))
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+
+	details := new(struct {
+		Errors map[string]string `json:"errors"`
+	})
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	_ = json.Unmarshal(b, details)
+
+	return &APIError{
+		HttpError: utils.NewHttpError(resp.StatusCode, msg),
+		Errors:    details.Errors,
+	}
+}
+
+// guestPath returns the API path of a guest of the given type.
+func guestPath(node string, guestType GuestType, vmid int) string {
+	return fmt.Sprintf("/nodes/%s/%s/%d", url.PathEscape(node), guestType, vmid)
+}
@@ -0,0 +1,195 @@
+package proxmox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ClientSuite struct {
+	suite.Suite
+
+	mux    *http.ServeMux
+	srv    *httptest.Server
+	client *client
+}
+
+func TestClientSuite(t *testing.T) {
+	suite.Run(t, new(ClientSuite))
+}
+
+func (s *ClientSuite) SetupTest() {
+	s.mux = http.NewServeMux()
+	s.srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("PVEAPIToken=root@pam!scaler=secret", r.Header.Get("Authorization"))
+		s.mux.ServeHTTP(w, r)
+	}))
+
+	c, err := newClient(Config{
+		URL:              s.srv.URL,
+		TLS:              TLSConfig{CACert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.srv.Certificate().Raw}))},
+		TaskPollInterval: time.Millisecond,
+		TaskLogTail:      2,
+	}, "root@pam!scaler", "secret")
+	s.Require().NoError(err)
+	s.client = c
+}
+
+func (s *ClientSuite) TearDownTest() {
+	s.srv.Close()
+}
+
+func (s *ClientSuite) TestListNodes() {
+	s.mux.HandleFunc("GET /api2/json/nodes", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[{"node":"pve1","status":"online","maxmem":1024,"mem":512,"cpu":0.5}]}`))
+	})
+
+	nodes, err := s.client.ListNodes(context.Background())
+	s.Require().NoError(err)
+	s.Require().Len(nodes, 1)
+	s.Equal("pve1", nodes[0].Node)
+	s.Equal(int64(1024), nodes[0].MaxMem)
+}
+
+func (s *ClientSuite) TestPostSendsForm() {
+	s.mux.HandleFunc("POST /api2/json/nodes/pve1/qemu/100/status/shutdown", func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+		s.NoError(r.ParseForm())
+		s.Equal("1", r.PostForm.Get("forceStop"))
+		s.Equal("30", r.PostForm.Get("timeout"))
+		_, _ = w.Write([]byte(`{"data":"UPID:pve1:0001:0002:0003:qmshutdown:100:root@pam:"}`))
+	})
+
+	upid, err := s.client.ShutdownVM(context.Background(), "pve1", 100, 30e9)
+	s.Require().NoError(err)
+	s.Equal(UPID("UPID:pve1:0001:0002:0003:qmshutdown:100:root@pam:"), upid)
+	s.Equal("pve1", upid.Node())
+}
+
+func (s *ClientSuite) TestErrorWrapsHttpError() {
+	s.mux.HandleFunc("GET /api2/json/nodes/pve1/qemu/100/status/current", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"data":null}`, http.StatusInternalServerError)
+	})
+
+	_, err := s.client.GetVMStatus(context.Background(), "pve1", 100)
+	s.Require().Error(err)
+	s.ErrorIs(err, utils.NewHttpError(http.StatusInternalServerError, ""))
+}
+
+func (s *ClientSuite) TestParameterErrors() {
+	s.mux.HandleFunc("POST /api2/json/nodes/pve1/qemu/100/status/start", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"errors":{"vmid":"invalid format"},"data":null}`))
+	})
+
+	_, err := s.client.StartVM(context.Background(), "pve1", 100)
+
+	apiErr := new(APIError)
+	s.Require().ErrorAs(err, &apiErr)
+	s.Equal(map[string]string{"vmid": "invalid format"}, apiErr.Errors)
+}
+
+func TestIsNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "404",
+			err:  &APIError{HttpError: utils.NewHttpError(http.StatusNotFound, "Not Found")},
+			want: true,
+		},
+		{
+			name: "500 does not exist",
+			err:  &APIError{HttpError: utils.NewHttpError(http.StatusInternalServerError, "Configuration file 'nodes/pve1/qemu-server/100.conf' does not exist")},
+			want: true,
+		},
+		{
+			name: "500 other",
+			err:  &APIError{HttpError: utils.NewHttpError(http.StatusInternalServerError, "VM is locked")},
+			want: false,
+		},
+		{
+			name: "not an api error",
+			err:  utils.NewHttpError(http.StatusNotFound, "Not Found"),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, IsNotFound(tt.err))
+		})
+	}
+}
+
+func TestFingerprintPinning(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256(srv.Certificate().Raw)
+
+	tests := []struct {
+		name        string
+		fingerprint string
+		wantErr     bool
+	}{
+		{
+			name:        "matching",
+			fingerprint: hex.EncodeToString(sum[:]),
+		},
+		{
+			name:        "wrong",
+			fingerprint: hex.EncodeToString(make([]byte, sha256.Size)),
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newClient(Config{URL: srv.URL, TLS: TLSConfig{Fingerprint: tt.fingerprint}}, "id", "secret")
+			require.NoError(t, err)
+
+			_, err = c.ListNodes(context.Background())
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNewClientReadsTokenFromVault(t *testing.T) {
+	vc := vault.NewMockClient(t)
+	vc.On("GetSecret", mock.Anything, "secret/data/proxmox").Return(&vault.Secrets{Secret: &vaultapi.Secret{
+		Data: map[string]any{"data": map[string]any{"token_id": "root@pam!scaler", "token_secret": "secret"}},
+	}}, nil)
+
+	c, err := NewClient(context.Background(), Config{URL: "https://pve1:8006", TokenPath: "secret/data/proxmox"}, vc)
+	require.NoError(t, err)
+	require.Equal(t, "PVEAPIToken=root@pam!scaler=secret", c.(*client).auth)
+}
+
+func TestNewClientWithoutToken(t *testing.T) {
+	vc := vault.NewMockClient(t)
+	vc.On("GetSecret", mock.Anything, "secret/data/proxmox").Return(vault.CreateMockSecret("token_id", "root@pam!scaler"), nil)
+
+	_, err := NewClient(context.Background(), Config{URL: "https://pve1:8006", TokenPath: "secret/data/proxmox"}, vc)
+	require.ErrorIs(t, err, ErrNoToken)
+}
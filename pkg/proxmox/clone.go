@@ -0,0 +1,97 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// CloneRequest is a request to clone a VM or container template.
+type CloneRequest struct {
+	// Node is the node the template is on.
+	Node string
+
+	// TemplateID is the VMID of the template.
+	TemplateID int
+
+	// NewID is the VMID of the clone.
+	NewID int
+
+	// Name is the name of the clone, the hostname of a container.
+	Name string
+
+	// Target is the node to create the clone on. Defaults to the template's node. Linked clones can only target
+	// another node when the template is on shared storage.
+	Target string
+
+	// Full creates a full copy of the disks rather than a linked clone. Full clones are slower to create but do not
+	// depend on the template.
+	Full bool
+
+	// Storage is the storage the disks of a full clone are written to. Defaults to the template's storage.
+	Storage string
+
+	// Pool is the resource pool the clone is added to.
+	Pool string
+
+	// Description is the description of the clone.
+	Description string
+}
+
+func (c *client) CloneVM(ctx context.Context, req *CloneRequest) (*TaskResult, error) {
+	return c.cloneGuest(ctx, GuestTypeQemu, req)
+}
+
+func (c *client) CloneContainer(ctx context.Context, req *CloneRequest) (*TaskResult, error) {
+	return c.cloneGuest(ctx, GuestTypeLXC, req)
+}
+
+// cloneGuest clones the template of the given type and waits for the clone task to finish.
+func (c *client) cloneGuest(ctx context.Context, guestType GuestType, req *CloneRequest) (*TaskResult, error) {
+	if req.Node == "" || req.TemplateID == 0 || req.NewID == 0 {
+		return nil, errors.New("node, template id and new id are required to clone")
+	} else if req.Storage != "" && !req.Full {
+		return nil, errors.New("storage can only be set for full clones")
+	}
+
+	params := url.Values{}
+	params.Set("newid", strconv.Itoa(req.NewID))
+	params.Set("full", boolParam(req.Full))
+	if req.Name != "" {
+		// Containers are named by their hostname.
+		if guestType == GuestTypeLXC {
+			params.Set("hostname", req.Name)
+		} else {
+			params.Set("name", req.Name)
+		}
+	}
+	if req.Target != "" {
+		params.Set("target", req.Target)
+	}
+	if req.Storage != "" {
+		params.Set("storage", req.Storage)
+	}
+	if req.Pool != "" {
+		params.Set("pool", req.Pool)
+	}
+	if req.Description != "" {
+		params.Set("description", req.Description)
+	}
+
+	var upid UPID
+	if err := c.post(ctx, guestPath(req.Node, guestType, req.TemplateID)+"/clone", params, &upid); err != nil {
+		return nil, fmt.Errorf("clone %s %d to %d: %w", guestType, req.TemplateID, req.NewID, err)
+	}
+
+	return c.WaitForTask(ctx, upid)
+}
+
+// boolParam returns the API representation of a boolean.
+func boolParam(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,129 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const testCloneUPID = "UPID:pve1:0001:0002:0003:qmclone:9000:root@pam:"
+
+// handleTask serves the status of the clone task, reporting it as running for the given number of polls.
+func (s *ClientSuite) handleTask(runningPolls int32, exitStatus string, logLines int) {
+	polls := new(atomic.Int32)
+
+	s.mux.HandleFunc("GET /api2/json/nodes/pve1/tasks/{upid}/status", func(w http.ResponseWriter, r *http.Request) {
+		s.Equal(testCloneUPID, r.PathValue("upid"))
+
+		if polls.Add(1) <= runningPolls {
+			_, _ = w.Write([]byte(`{"data":{"status":"running","type":"qmclone"}}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"data":{"status":"stopped","type":"qmclone","exitstatus":%q,"starttime":1700000000}}`, exitStatus)
+	})
+
+	s.mux.HandleFunc("GET /api2/json/nodes/pve1/tasks/{upid}/log", func(w http.ResponseWriter, r *http.Request) {
+		start := 0
+		_, _ = fmt.Sscan(r.URL.Query().Get("start"), &start)
+
+		_, _ = fmt.Fprint(w, `{"data":[`)
+		for i := start; i < logLines && i < start+2; i++ {
+			if i > start {
+				_, _ = fmt.Fprint(w, ",")
+			}
+			_, _ = fmt.Fprintf(w, `{"n":%d,"t":"line %d"}`, i+1, i)
+		}
+		_, _ = fmt.Fprintf(w, `],"total":%d}`, logLines)
+	})
+}
+
+func (s *ClientSuite) TestCloneVMLinked() {
+	s.mux.HandleFunc("POST /api2/json/nodes/pve1/qemu/9000/clone", func(w http.ResponseWriter, r *http.Request) {
+		s.NoError(r.ParseForm())
+		s.Equal("101", r.PostForm.Get("newid"))
+		s.Equal("0", r.PostForm.Get("full"))
+		s.Equal("runner-1", r.PostForm.Get("name"))
+		s.Equal("pve2", r.PostForm.Get("target"))
+		_, _ = fmt.Fprintf(w, `{"data":%q}`, testCloneUPID)
+	})
+	s.handleTask(3, "OK", 5)
+
+	result, err := s.client.CloneVM(context.Background(), &CloneRequest{
+		Node:       "pve1",
+		TemplateID: 9000,
+		NewID:      101,
+		Name:       "runner-1",
+		Target:     "pve2",
+	})
+	s.Require().NoError(err)
+
+	s.True(result.OK())
+	s.Equal("qmclone", result.Type)
+	s.Equal([]string{"line 3", "line 4"}, result.LogTail)
+}
+
+func (s *ClientSuite) TestCloneVMFullRequiresFullForStorage() {
+	_, err := s.client.CloneVM(context.Background(), &CloneRequest{
+		Node:       "pve1",
+		TemplateID: 9000,
+		NewID:      101,
+		Storage:    "ceph",
+	})
+	s.Error(err)
+}
+
+func (s *ClientSuite) TestCloneVMTaskFailure() {
+	s.mux.HandleFunc("POST /api2/json/nodes/pve1/qemu/9000/clone", func(w http.ResponseWriter, r *http.Request) {
+		s.NoError(r.ParseForm())
+		s.Equal("1", r.PostForm.Get("full"))
+		s.Equal("ceph", r.PostForm.Get("storage"))
+		_, _ = fmt.Fprintf(w, `{"data":%q}`, testCloneUPID)
+	})
+	s.handleTask(0, "clone failed: storage full", 1)
+
+	result, err := s.client.CloneVM(context.Background(), &CloneRequest{
+		Node:       "pve1",
+		TemplateID: 9000,
+		NewID:      101,
+		Full:       true,
+		Storage:    "ceph",
+	})
+
+	s.ErrorIs(err, ErrTaskFailed)
+	s.Require().NotNil(result)
+	s.Equal("clone failed: storage full", result.ExitStatus)
+	s.Equal([]string{"line 0"}, result.LogTail)
+}
+
+func (s *ClientSuite) TestWaitForTaskCancelled() {
+	s.handleTask(1<<30, "OK", 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := s.client.WaitForTask(ctx, testCloneUPID)
+	s.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func (s *ClientSuite) TestCloneContainer() {
+	s.mux.HandleFunc("POST /api2/json/nodes/pve1/lxc/9000/clone", func(w http.ResponseWriter, r *http.Request) {
+		s.NoError(r.ParseForm())
+		s.Equal("101", r.PostForm.Get("newid"))
+		s.Equal("1", r.PostForm.Get("full"))
+		s.Equal("runner-1", r.PostForm.Get("hostname"))
+		s.Empty(r.PostForm.Get("name"))
+		_, _ = fmt.Fprintf(w, `{"data":%q}`, testCloneUPID)
+	})
+	s.handleTask(0, "OK", 1)
+
+	_, err := s.client.CloneContainer(context.Background(), &CloneRequest{
+		Node:       "pve1",
+		TemplateID: 9000,
+		NewID:      101,
+		Name:       "runner-1",
+		Full:       true,
+	})
+	s.Require().NoError(err)
+}
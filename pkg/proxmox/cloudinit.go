@@ -0,0 +1,47 @@
+package proxmox
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// CloudInit is the cloud-init config of a virtual machine. Empty fields are left unchanged.
+type CloudInit struct {
+	// User is the default user, set as ciuser.
+	User string
+
+	// SSHKeys are the public keys authorized for the default user.
+	SSHKeys []string
+
+	// IPConfig0 is the config of the first network interface, e.g. ip=dhcp or ip=10.0.0.5/24,gw=10.0.0.1.
+	IPConfig0 string
+
+	// UserData is the volume of a snippet that replaces the generated user data, e.g. local:snippets/runner.yaml.
+	// Cloud-init then ignores User and SSHKeys, so the snippet has to set them itself.
+	UserData string
+}
+
+// params returns the config options of the cloud-init config.
+func (ci *CloudInit) params() url.Values {
+	params := url.Values{}
+	if ci.User != "" {
+		params.Set("ciuser", ci.User)
+	}
+	if len(ci.SSHKeys) > 0 {
+		// The API expects the keys URL encoded a second time, with spaces as %20 rather than +.
+		keys := strings.Join(ci.SSHKeys, "\n")
+		params.Set("sshkeys", strings.ReplaceAll(url.QueryEscape(keys), "+", "%20"))
+	}
+	if ci.IPConfig0 != "" {
+		params.Set("ipconfig0", ci.IPConfig0)
+	}
+	if ci.UserData != "" {
+		params.Set("cicustom", "user="+ci.UserData)
+	}
+	return params
+}
+
+func (c *client) SetCloudInit(ctx context.Context, node string, vmid int, ci *CloudInit) error {
+	return c.UpdateVMConfig(ctx, node, vmid, ci.params())
+}
@@ -0,0 +1,54 @@
+package proxmox
+
+import (
+	"context"
+	"net/http"
+)
+
+func (s *ClientSuite) TestSetCloudInit() {
+	s.mux.HandleFunc("PUT /api2/json/nodes/pve1/qemu/101/config", func(w http.ResponseWriter, r *http.Request) {
+		s.NoError(r.ParseForm())
+		s.Equal("runner", r.PostForm.Get("ciuser"))
+		s.Equal("ssh-ed25519%20AAAA%20ops%40example.com%0Assh-ed25519%20BBBB", r.PostForm.Get("sshkeys"))
+		s.Equal("ip=dhcp", r.PostForm.Get("ipconfig0"))
+		s.Equal("user=local:snippets/pgr-1.yaml", r.PostForm.Get("cicustom"))
+		_, _ = w.Write([]byte(`{"data":null}`))
+	})
+
+	err := s.client.SetCloudInit(context.Background(), "pve1", 101, &CloudInit{
+		User:      "runner",
+		SSHKeys:   []string{"ssh-ed25519 AAAA ops@example.com", "ssh-ed25519 BBBB"},
+		IPConfig0: "ip=dhcp",
+		UserData:  "local:snippets/pgr-1.yaml",
+	})
+	s.NoError(err)
+}
+
+func (s *ClientSuite) TestNextID() {
+	s.mux.HandleFunc("GET /api2/json/cluster/nextid", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":"105"}`))
+	})
+
+	vmid, err := s.client.NextID(context.Background())
+	s.Require().NoError(err)
+	s.Equal(105, vmid)
+}
+
+func (s *ClientSuite) TestIsIDFree() {
+	s.mux.HandleFunc("GET /api2/json/cluster/nextid", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("vmid") == "9000" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":{"vmid":"VM 9000 already exists"},"data":null}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":"` + r.URL.Query().Get("vmid") + `"}`))
+	})
+
+	free, err := s.client.IsIDFree(context.Background(), 9000)
+	s.Require().NoError(err)
+	s.False(free)
+
+	free, err = s.client.IsIDFree(context.Background(), 9001)
+	s.Require().NoError(err)
+	s.True(free)
+}
@@ -0,0 +1,52 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+func (c *client) ClusterResources(ctx context.Context, resourceType ResourceType) ([]*Resource, error) {
+	params := url.Values{}
+	if resourceType != "" {
+		params.Set("type", string(resourceType))
+	}
+
+	resources := make([]*Resource, 0)
+	if err := c.get(ctx, "/cluster/resources", params, &resources); err != nil {
+		return nil, fmt.Errorf("list cluster resources: %w", err)
+	}
+	return resources, nil
+}
+
+func (c *client) NextID(ctx context.Context) (int, error) {
+	// The API returns the ID as a string.
+	var id string
+	if err := c.get(ctx, "/cluster/nextid", nil, &id); err != nil {
+		return 0, fmt.Errorf("get next vmid: %w", err)
+	}
+
+	vmid, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, fmt.Errorf("parse next vmid %q: %w", id, err)
+	}
+	return vmid, nil
+}
+
+func (c *client) IsIDFree(ctx context.Context, vmid int) (bool, error) {
+	params := url.Values{"vmid": {strconv.Itoa(vmid)}}
+
+	var id string
+	err := c.get(ctx, "/cluster/nextid", params, &id)
+
+	// The API echoes a free ID and rejects the parameter when the ID is taken.
+	apiErr := new(APIError)
+	if errors.As(err, &apiErr) && apiErr.Errors["vmid"] != "" {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("check vmid %d: %w", vmid, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,62 @@
+package proxmox
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+)
+
+var (
+	// ErrTaskFailed is returned when a task finished with an exit status other than OK.
+	ErrTaskFailed = errors.New("task failed")
+
+	// ErrNoToken is returned when the API token could not be read from Vault.
+	ErrNoToken = errors.New("no proxmox api token")
+)
+
+// APIError is an unsuccessful response from the Proxmox VE API.
+type APIError struct {
+	*utils.HttpError
+
+	// Errors are the per parameter errors, keyed by parameter name.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("proxmox: %d %s", e.Code, e.Message)
+	}
+
+	params := make([]string, 0, len(e.Errors))
+	for k, v := range e.Errors {
+		params = append(params, fmt.Sprintf("%s: %s", k, strings.TrimSpace(v)))
+	}
+	sort.Strings(params)
+
+	return fmt.Sprintf("proxmox: %d %s (%s)", e.Code, e.Message, strings.Join(params, ", "))
+}
+
+// Unwrap returns the wrapped utils.HttpError, so errors.Is matches on the status code.
+func (e *APIError) Unwrap() error {
+	return e.HttpError
+}
+
+// IsNotFound returns true if the error says the resource does not exist. Proxmox VE answers most lookups of a missing
+// guest with a 500 rather than a 404, so the message is checked as well as the status code.
+func IsNotFound(err error) bool {
+	apiErr := new(APIError)
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	if apiErr.Code == http.StatusNotFound {
+		return true
+	}
+
+	return apiErr.Code == http.StatusInternalServerError && strings.Contains(apiErr.Message, "does not exist")
+}
@@ -0,0 +1,56 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// listGuests returns the guests of the given type on the node.
+func (c *client) listGuests(ctx context.Context, node string, guestType GuestType) ([]*Guest, error) {
+	guests := make([]*Guest, 0)
+	if err := c.get(ctx, fmt.Sprintf("/nodes/%s/%s", url.PathEscape(node), guestType), nil, &guests); err != nil {
+		return nil, fmt.Errorf("list %s guests on %s: %w", guestType, node, err)
+	}
+	return guests, nil
+}
+
+// guestStatus returns the current status of the guest.
+func (c *client) guestStatus(ctx context.Context, node string, guestType GuestType, vmid int) (*Guest, error) {
+	guest := new(Guest)
+	if err := c.get(ctx, guestPath(node, guestType, vmid)+"/status/current", nil, guest); err != nil {
+		return nil, fmt.Errorf("get %s %d status: %w", guestType, vmid, err)
+	}
+	return guest, nil
+}
+
+// guestConfig returns the current config of the guest.
+func (c *client) guestConfig(ctx context.Context, node string, guestType GuestType, vmid int) (GuestConfig, error) {
+	cfg := make(GuestConfig)
+	if err := c.get(ctx, guestPath(node, guestType, vmid)+"/config", nil, &cfg); err != nil {
+		return nil, fmt.Errorf("get %s %d config: %w", guestType, vmid, err)
+	}
+	return cfg, nil
+}
+
+// guestAction runs a status action, e.g. start or stop, on the guest and returns the task it started.
+func (c *client) guestAction(ctx context.Context, node string, guestType GuestType, vmid int, action string, params url.Values) (UPID, error) {
+	var upid UPID
+	if err := c.post(ctx, guestPath(node, guestType, vmid)+"/status/"+action, params, &upid); err != nil {
+		return "", fmt.Errorf("%s %s %d: %w", action, guestType, vmid, err)
+	}
+	return upid, nil
+}
+
+// deleteGuest destroys the guest, its disks and every reference to it, e.g. in backup jobs and HA.
+func (c *client) deleteGuest(ctx context.Context, node string, guestType GuestType, vmid int) (UPID, error) {
+	params := url.Values{}
+	params.Set("purge", "1")
+	params.Set("destroy-unreferenced-disks", "1")
+
+	var upid UPID
+	if err := c.delete(ctx, guestPath(node, guestType, vmid), params, &upid); err != nil {
+		return "", fmt.Errorf("delete %s %d: %w", guestType, vmid, err)
+	}
+	return upid, nil
+}
@@ -0,0 +1,50 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+func (c *client) ListContainers(ctx context.Context, node string) ([]*Guest, error) {
+	return c.listGuests(ctx, node, GuestTypeLXC)
+}
+
+func (c *client) GetContainerStatus(ctx context.Context, node string, vmid int) (*Guest, error) {
+	return c.guestStatus(ctx, node, GuestTypeLXC, vmid)
+}
+
+func (c *client) GetContainerConfig(ctx context.Context, node string, vmid int) (GuestConfig, error) {
+	return c.guestConfig(ctx, node, GuestTypeLXC, vmid)
+}
+
+func (c *client) StartContainer(ctx context.Context, node string, vmid int) (UPID, error) {
+	return c.guestAction(ctx, node, GuestTypeLXC, vmid, "start", nil)
+}
+
+func (c *client) StopContainer(ctx context.Context, node string, vmid int) (UPID, error) {
+	return c.guestAction(ctx, node, GuestTypeLXC, vmid, "stop", nil)
+}
+
+func (c *client) DeleteContainer(ctx context.Context, node string, vmid int) (UPID, error) {
+	return c.deleteGuest(ctx, node, GuestTypeLXC, vmid)
+}
+
+func (c *client) UpdateContainerConfig(ctx context.Context, node string, vmid int, params url.Values) error {
+	if err := c.put(ctx, guestPath(node, GuestTypeLXC, vmid)+"/config", params, nil); err != nil {
+		return fmt.Errorf("update lxc %d config: %w", vmid, err)
+	}
+	return nil
+}
+
+func (c *client) ResizeContainerDisk(ctx context.Context, node string, vmid int, disk string, size int64) (UPID, error) {
+	params := url.Values{}
+	params.Set("disk", disk)
+	params.Set("size", fmt.Sprintf("%dK", size>>10))
+
+	var upid UPID
+	if err := c.put(ctx, guestPath(node, GuestTypeLXC, vmid)+"/resize", params, &upid); err != nil {
+		return "", fmt.Errorf("resize lxc %d disk %s: %w", vmid, disk, err)
+	}
+	return upid, nil
+}
@@ -0,0 +1,23 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+func (c *client) ListNodes(ctx context.Context) ([]*Node, error) {
+	nodes := make([]*Node, 0)
+	if err := c.get(ctx, "/nodes", nil, &nodes); err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	return nodes, nil
+}
+
+func (c *client) GetNodeStatus(ctx context.Context, node string) (*NodeStatus, error) {
+	status := new(NodeStatus)
+	if err := c.get(ctx, fmt.Sprintf("/nodes/%s/status", url.PathEscape(node)), nil, status); err != nil {
+		return nil, fmt.Errorf("get node %s status: %w", node, err)
+	}
+	return status, nil
+}
@@ -0,0 +1,92 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func (c *client) ListVMs(ctx context.Context, node string) ([]*Guest, error) {
+	return c.listGuests(ctx, node, GuestTypeQemu)
+}
+
+func (c *client) GetVMStatus(ctx context.Context, node string, vmid int) (*Guest, error) {
+	return c.guestStatus(ctx, node, GuestTypeQemu, vmid)
+}
+
+func (c *client) GetVMConfig(ctx context.Context, node string, vmid int) (GuestConfig, error) {
+	return c.guestConfig(ctx, node, GuestTypeQemu, vmid)
+}
+
+func (c *client) StartVM(ctx context.Context, node string, vmid int) (UPID, error) {
+	return c.guestAction(ctx, node, GuestTypeQemu, vmid, "start", nil)
+}
+
+func (c *client) StopVM(ctx context.Context, node string, vmid int) (UPID, error) {
+	return c.guestAction(ctx, node, GuestTypeQemu, vmid, "stop", nil)
+}
+
+func (c *client) ShutdownVM(ctx context.Context, node string, vmid int, timeout time.Duration) (UPID, error) {
+	params := url.Values{}
+	params.Set("forceStop", "1")
+	if timeout > 0 {
+		params.Set("timeout", strconv.Itoa(int(timeout.Seconds())))
+	}
+	return c.guestAction(ctx, node, GuestTypeQemu, vmid, "shutdown", params)
+}
+
+func (c *client) DeleteVM(ctx context.Context, node string, vmid int) (UPID, error) {
+	return c.deleteGuest(ctx, node, GuestTypeQemu, vmid)
+}
+
+func (c *client) UpdateVMConfig(ctx context.Context, node string, vmid int, params url.Values) error {
+	// A PUT applies the config synchronously, where a POST would start a task.
+	if err := c.put(ctx, guestPath(node, GuestTypeQemu, vmid)+"/config", params, nil); err != nil {
+		return fmt.Errorf("update qemu %d config: %w", vmid, err)
+	}
+	return nil
+}
+
+func (c *client) ResizeVMDisk(ctx context.Context, node string, vmid int, disk string, size int64) (UPID, error) {
+	params := url.Values{}
+	params.Set("disk", disk)
+	params.Set("size", fmt.Sprintf("%dK", size>>10))
+
+	var upid UPID
+	if err := c.put(ctx, guestPath(node, GuestTypeQemu, vmid)+"/resize", params, &upid); err != nil {
+		return "", fmt.Errorf("resize qemu %d disk %s: %w", vmid, disk, err)
+	}
+	return upid, nil
+}
+
+func (c *client) ListVMSnapshots(ctx context.Context, node string, vmid int) ([]*Snapshot, error) {
+	snapshots := make([]*Snapshot, 0)
+	if err := c.get(ctx, guestPath(node, GuestTypeQemu, vmid)+"/snapshot", nil, &snapshots); err != nil {
+		return nil, fmt.Errorf("list qemu %d snapshots: %w", vmid, err)
+	}
+	return snapshots, nil
+}
+
+func (c *client) CreateVMSnapshot(ctx context.Context, node string, vmid int, name, description string) (UPID, error) {
+	params := url.Values{}
+	params.Set("snapname", name)
+	if description != "" {
+		params.Set("description", description)
+	}
+
+	var upid UPID
+	if err := c.post(ctx, guestPath(node, GuestTypeQemu, vmid)+"/snapshot", params, &upid); err != nil {
+		return "", fmt.Errorf("snapshot qemu %d as %s: %w", vmid, name, err)
+	}
+	return upid, nil
+}
+
+func (c *client) RollbackVMSnapshot(ctx context.Context, node string, vmid int, name string) (UPID, error) {
+	var upid UPID
+	if err := c.post(ctx, guestPath(node, GuestTypeQemu, vmid)+"/snapshot/"+url.PathEscape(name)+"/rollback", nil, &upid); err != nil {
+		return "", fmt.Errorf("roll back qemu %d to snapshot %s: %w", vmid, name, err)
+	}
+	return upid, nil
+}
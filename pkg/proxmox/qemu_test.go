@@ -0,0 +1,49 @@
+package proxmox
+
+import (
+	"context"
+	"net/http"
+)
+
+func (s *ClientSuite) TestResizeVMDisk() {
+	s.mux.HandleFunc("PUT /api2/json/nodes/pve1/qemu/101/resize", func(w http.ResponseWriter, r *http.Request) {
+		s.NoError(r.ParseForm())
+		s.Equal("scsi0", r.PostForm.Get("disk"))
+		s.Equal("52428800K", r.PostForm.Get("size"))
+		_, _ = w.Write([]byte(`{"data":"UPID:pve1:00001234:00005678:65000000:resize:101:root@pam:"}`))
+	})
+
+	upid, err := s.client.ResizeVMDisk(context.Background(), "pve1", 101, "scsi0", 50<<30)
+	s.Require().NoError(err)
+	s.Equal("pve1", upid.Node())
+}
+
+func (s *ClientSuite) TestVMSnapshots() {
+	s.mux.HandleFunc("GET /api2/json/nodes/pve1/qemu/101/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[{"name":"base","description":"clean","snaptime":1700000000},{"name":"current","parent":"base","running":1}]}`))
+	})
+	s.mux.HandleFunc("POST /api2/json/nodes/pve1/qemu/101/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		s.NoError(r.ParseForm())
+		s.Equal("base", r.PostForm.Get("snapname"))
+		s.Equal("clean", r.PostForm.Get("description"))
+		_, _ = w.Write([]byte(`{"data":"UPID:pve1:00001234:00005678:65000000:qmsnapshot:101:root@pam:"}`))
+	})
+	s.mux.HandleFunc("POST /api2/json/nodes/pve1/qemu/101/snapshot/base/rollback", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":"UPID:pve1:00001234:00005678:65000000:qmrollback:101:root@pam:"}`))
+	})
+
+	snapshots, err := s.client.ListVMSnapshots(context.Background(), "pve1", 101)
+	s.Require().NoError(err)
+	s.Equal([]*Snapshot{
+		{Name: "base", Description: "clean", SnapTime: 1700000000},
+		{Name: "current", Parent: "base"},
+	}, snapshots)
+
+	upid, err := s.client.CreateVMSnapshot(context.Background(), "pve1", 101, "base", "clean")
+	s.Require().NoError(err)
+	s.Equal(UPID("UPID:pve1:00001234:00005678:65000000:qmsnapshot:101:root@pam:"), upid)
+
+	upid, err = s.client.RollbackVMSnapshot(context.Background(), "pve1", 101, "base")
+	s.Require().NoError(err)
+	s.Equal(UPID("UPID:pve1:00001234:00005678:65000000:qmrollback:101:root@pam:"), upid)
+}
@@ -0,0 +1,15 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+func (c *client) ListStorage(ctx context.Context, node string) ([]*Storage, error) {
+	storage := make([]*Storage, 0)
+	if err := c.get(ctx, fmt.Sprintf("/nodes/%s/storage", url.PathEscape(node)), nil, &storage); err != nil {
+		return nil, fmt.Errorf("list storage on %s: %w", node, err)
+	}
+	return storage, nil
+}
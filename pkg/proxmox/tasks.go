@@ -0,0 +1,148 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// TaskResult is the outcome of a finished task.
+type TaskResult struct {
+	// UPID is the ID of the task.
+	UPID UPID
+
+	// Node is the node the task ran on.
+	Node string
+
+	// Type is the type of the task, e.g. qmclone.
+	Type string
+
+	// ExitStatus is OK for a successful task, otherwise the error the task ended with.
+	ExitStatus string
+
+	// StartedAt is when the task started.
+	StartedAt time.Time
+
+	// Duration is how long the task was waited for.
+	Duration time.Duration
+
+	// LogTail is the last lines of the task log.
+	LogTail []string
+}
+
+// OK returns true if the task finished successfully.
+func (r *TaskResult) OK() bool {
+	return r.ExitStatus == "OK"
+}
+
+func (c *client) GetTaskStatus(ctx context.Context, node string, upid UPID) (*TaskStatus, error) {
+	status := new(TaskStatus)
+	path := fmt.Sprintf("/nodes/%s/tasks/%s/status", url.PathEscape(node), url.PathEscape(upid.String()))
+	if err := c.get(ctx, path, nil, status); err != nil {
+		return nil, fmt.Errorf("get task status: %w", err)
+	}
+	return status, nil
+}
+
+func (c *client) GetTaskLog(ctx context.Context, node string, upid UPID, start, limit int) ([]string, error) {
+	lines, _, err := c.taskLog(ctx, node, upid, start, limit)
+	return lines, err
+}
+
+func (c *client) WaitForTask(ctx context.Context, upid UPID) (*TaskResult, error) {
+	node := upid.Node()
+	if node == "" {
+		return nil, fmt.Errorf("invalid upid %q", upid)
+	}
+
+	begin := time.Now()
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := c.GetTaskStatus(ctx, node, upid)
+		if err != nil && !isTransient(err) {
+			return nil, err
+		}
+
+		if status != nil && !status.IsRunning() {
+			result := &TaskResult{
+				UPID:       upid,
+				Node:       node,
+				Type:       status.Type,
+				ExitStatus: status.ExitStatus,
+				StartedAt:  time.Unix(status.StartTime, 0).UTC(),
+				Duration:   time.Since(begin),
+			}
+
+			// The log is only detail for the caller, so failing to read it does not fail the task.
+			result.LogTail, _ = c.taskLogTail(ctx, node, upid)
+
+			if !result.OK() {
+				return result, fmt.Errorf("%w: %s %s: %s", ErrTaskFailed, result.Type, upid, result.ExitStatus)
+			}
+			return result, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for task %s: %w", upid, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// taskLog returns the lines of the task log and the total number of lines.
+func (c *client) taskLog(ctx context.Context, node string, upid UPID, start, limit int) ([]string, int, error) {
+	params := url.Values{}
+	params.Set("start", strconv.Itoa(start))
+	params.Set("limit", strconv.Itoa(limit))
+
+	lines := make([]struct {
+		N int    `json:"n"`
+		T string `json:"t"`
+	}, 0)
+	env := &envelope{Data: &lines}
+
+	path := fmt.Sprintf("/nodes/%s/tasks/%s/log", url.PathEscape(node), url.PathEscape(upid.String()))
+	if err := c.doEnvelope(ctx, http.MethodGet, path, params, env); err != nil {
+		return nil, 0, fmt.Errorf("get task log: %w", err)
+	}
+
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = l.T
+	}
+	return out, env.Total, nil
+}
+
+// taskLogTail returns the last lines of the task log. Full clones log a progress line for every chunk they copy, so
+// only the tail is fetched.
+func (c *client) taskLogTail(ctx context.Context, node string, upid UPID) ([]string, error) {
+	lines, total, err := c.taskLog(ctx, node, upid, 0, c.logTail)
+	if err != nil || total <= c.logTail {
+		return lines, err
+	}
+
+	lines, _, err = c.taskLog(ctx, node, upid, total-c.logTail, c.logTail)
+	return lines, err
+}
+
+// isTransient returns true for errors that are worth polling through, e.g. the node proxy restarting.
+func isTransient(err error) bool {
+	apiErr := new(APIError)
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.Code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,81 @@
+package proxmox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSConfig is the TLS configuration for talking to a cluster. Clusters with self-signed certificates can either be
+// trusted through their CA bundle, or by pinning the fingerprint of the node certificate.
+type TLSConfig struct {
+	// CAFile is the path of a PEM encoded CA bundle to trust.
+	CAFile string
+
+	// CACert is a PEM encoded CA bundle to trust.
+	CACert string
+
+	// Fingerprint is the SHA-256 fingerprint of the certificate the cluster presents, as shown by `pvenode cert info`.
+	// Colons are optional. When it is set the certificate chain is not verified, only the fingerprint.
+	Fingerprint string
+
+	// InsecureSkipVerify disables certificate verification. It must only be used for testing.
+	InsecureSkipVerify bool
+}
+
+// build returns the tls.Config for the configuration.
+func (c *TLSConfig) build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify, // nolint:gosec // Opt in for testing only.
+	}
+
+	if c.CAFile != "" || c.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pemData := []byte(c.CACert)
+		if c.CAFile != "" {
+			pemData, err = os.ReadFile(c.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("read ca file: %w", err)
+			}
+		}
+
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, errors.New("no certificates found in ca bundle")
+		}
+		cfg.RootCAs = pool
+	}
+
+	if c.Fingerprint != "" {
+		want, err := hex.DecodeString(strings.ReplaceAll(c.Fingerprint, ":", ""))
+		if err != nil || len(want) != sha256.Size {
+			return nil, fmt.Errorf("invalid sha256 fingerprint %q", c.Fingerprint)
+		}
+
+		// The chain is replaced by the pin, so normal verification is turned off and VerifyConnection does the check.
+		cfg.InsecureSkipVerify = true // nolint:gosec // Verified by the fingerprint below.
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no peer certificate presented")
+			}
+
+			got := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if !bytes.Equal(got[:], want) {
+				return fmt.Errorf("certificate fingerprint %x does not match the pinned fingerprint", got)
+			}
+			return nil
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,261 @@
+package proxmox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UPID is the unique ID of an asynchronous task, e.g. UPID:pve1:000A1B2C:0B3C4D5E:65A1B2C3:qmclone:100:root@pam:.
+type UPID string
+
+// String returns the string representation of the UPID.
+func (u UPID) String() string {
+	return string(u)
+}
+
+// Node returns the node the task runs on, which is the node its status must be read from.
+func (u UPID) Node() string {
+	parts := strings.Split(string(u), ":")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// GuestType is the type of a guest.
+type GuestType string
+
+const (
+	// GuestTypeQemu is a QEMU virtual machine.
+	GuestTypeQemu GuestType = "qemu"
+
+	// GuestTypeLXC is an LXC container.
+	GuestTypeLXC GuestType = "lxc"
+)
+
+// ResourceType is the type of a cluster resource.
+type ResourceType string
+
+const (
+	// ResourceTypeVM are the guests of the cluster, both QEMU and LXC.
+	ResourceTypeVM ResourceType = "vm"
+
+	// ResourceTypeNode are the nodes of the cluster.
+	ResourceTypeNode ResourceType = "node"
+
+	// ResourceTypeStorage are the storages of every node of the cluster.
+	ResourceTypeStorage ResourceType = "storage"
+)
+
+// Node is a node of the cluster.
+type Node struct {
+	Node    string  `json:"node"`
+	Status  string  `json:"status"`
+	CPU     float64 `json:"cpu"`
+	MaxCPU  int     `json:"maxcpu"`
+	Mem     int64   `json:"mem"`
+	MaxMem  int64   `json:"maxmem"`
+	Disk    int64   `json:"disk"`
+	MaxDisk int64   `json:"maxdisk"`
+	Uptime  int64   `json:"uptime"`
+}
+
+// NodeStatus is the detailed status of a node.
+type NodeStatus struct {
+	CPU     float64  `json:"cpu"`
+	LoadAvg []string `json:"loadavg"`
+	Uptime  int64    `json:"uptime"`
+	Memory  struct {
+		Free  int64 `json:"free"`
+		Total int64 `json:"total"`
+		Used  int64 `json:"used"`
+	} `json:"memory"`
+	CPUInfo struct {
+		CPUs    int `json:"cpus"`
+		Cores   int `json:"cores"`
+		Sockets int `json:"sockets"`
+	} `json:"cpuinfo"`
+}
+
+// Resource is an entry of /cluster/resources. Which fields are set depends on the type of the resource.
+type Resource struct {
+	ID       string  `json:"id"`
+	Type     string  `json:"type"`
+	Node     string  `json:"node"`
+	Status   string  `json:"status"`
+	Name     string  `json:"name,omitempty"`
+	VMID     int     `json:"vmid,omitempty"`
+	Storage  string  `json:"storage,omitempty"`
+	Pool     string  `json:"pool,omitempty"`
+	Tags     string  `json:"tags,omitempty"`
+	Template int     `json:"template,omitempty"`
+	CPU      float64 `json:"cpu"`
+	MaxCPU   float64 `json:"maxcpu"`
+	Mem      int64   `json:"mem"`
+	MaxMem   int64   `json:"maxmem"`
+	Disk     int64   `json:"disk"`
+	MaxDisk  int64   `json:"maxdisk"`
+	Content  string  `json:"content,omitempty"`
+	Shared   int     `json:"shared,omitempty"`
+}
+
+// HasTag returns true if the resource carries the tag.
+func (r *Resource) HasTag(tag string) bool {
+	return hasTag(r.Tags, tag)
+}
+
+// Guest is a QEMU virtual machine or an LXC container.
+type Guest struct {
+	VMID     int     `json:"vmid"`
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Tags     string  `json:"tags,omitempty"`
+	Template int     `json:"template,omitempty"`
+	Lock     string  `json:"lock,omitempty"`
+	CPU      float64 `json:"cpu"`
+	CPUs     float64 `json:"cpus"`
+	Mem      int64   `json:"mem"`
+	MaxMem   int64   `json:"maxmem"`
+	MaxDisk  int64   `json:"maxdisk"`
+	Uptime   int64   `json:"uptime"`
+}
+
+// IsRunning returns true if the guest is running.
+func (g *Guest) IsRunning() bool {
+	return g.Status == "running"
+}
+
+// HasTag returns true if the guest carries the tag.
+func (g *Guest) HasTag(tag string) bool {
+	return hasTag(g.Tags, tag)
+}
+
+// hasTag returns true if the tag is in the tag list. The API separates tags with semicolons, but accepts commas and
+// spaces too.
+func hasTag(tags, tag string) bool {
+	for _, t := range strings.FieldsFunc(tags, func(r rune) bool { return r == ';' || r == ',' || r == ' ' }) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// GuestConfig is the config of a guest. The keys depend on the guest type and its hardware, so the raw values are
+// kept and read with the typed accessors.
+type GuestConfig map[string]any
+
+// String returns the value of the key as a string, or an empty string if it is not set.
+func (c GuestConfig) String(key string) string {
+	v, ok := c[key]
+	if !ok || v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// Int returns the value of the key as an int, or zero if it is not set or not a number.
+func (c GuestConfig) Int(key string) int {
+	i, err := strconv.Atoi(c.String(key))
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// HasTag returns true if the tags of the config hold the tag.
+func (c GuestConfig) HasTag(tag string) bool {
+	return hasTag(c.String("tags"), tag)
+}
+
+// TaskStatus is the status of an asynchronous task.
+type TaskStatus struct {
+	UPID       UPID   `json:"upid"`
+	Node       string `json:"node"`
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	User       string `json:"user"`
+	Status     string `json:"status"`
+	ExitStatus string `json:"exitstatus,omitempty"`
+	StartTime  int64  `json:"starttime"`
+	PID        int    `json:"pid"`
+}
+
+// IsRunning returns true if the task has not finished.
+func (t *TaskStatus) IsRunning() bool {
+	return t.Status == "running"
+}
+
+// IsOK returns true if the task finished successfully.
+func (t *TaskStatus) IsOK() bool {
+	return t.Status == "stopped" && t.ExitStatus == "OK"
+}
+
+// Storage is a storage as seen from a node.
+type Storage struct {
+	Storage string `json:"storage"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Active  int    `json:"active"`
+	Enabled int    `json:"enabled"`
+	Shared  int    `json:"shared"`
+	Avail   int64  `json:"avail"`
+	Used    int64  `json:"used"`
+	Total   int64  `json:"total"`
+}
+
+// Supports returns true if the storage can hold the given content type, e.g. images or snippets.
+func (s *Storage) Supports(content string) bool {
+	for _, c := range strings.Split(s.Content, ",") {
+		if c == content {
+			return true
+		}
+	}
+	return false
+}
+
+// AgentExecStatus is the status of a command the guest agent runs in a virtual machine.
+type AgentExecStatus struct {
+	Exited       int    `json:"exited"`
+	ExitCode     int    `json:"exitcode,omitempty"`
+	Signal       int    `json:"signal,omitempty"`
+	OutData      string `json:"out-data,omitempty"`
+	ErrData      string `json:"err-data,omitempty"`
+	OutTruncated int    `json:"out-truncated,omitempty"`
+	ErrTruncated int    `json:"err-truncated,omitempty"`
+}
+
+// HasExited returns true if the command has finished.
+func (s *AgentExecStatus) HasExited() bool {
+	return s.Exited == 1
+}
+
+// OK returns true if the command has finished with exit code zero.
+func (s *AgentExecStatus) OK() bool {
+	return s.HasExited() && s.ExitCode == 0 && s.Signal == 0
+}
+
+// AgentInterface is a network interface of a virtual machine as reported by the guest agent.
+type AgentInterface struct {
+	Name            string            `json:"name"`
+	HardwareAddress string            `json:"hardware-address,omitempty"`
+	IPAddresses     []*AgentIPAddress `json:"ip-addresses,omitempty"`
+}
+
+// AgentIPAddress is an address of a network interface.
+type AgentIPAddress struct {
+	Address string `json:"ip-address"`
+	Type    string `json:"ip-address-type"`
+	Prefix  int    `json:"prefix"`
+}
+
+// Snapshot is a snapshot of a guest. The list of the snapshots of a guest ends with the pseudo snapshot current, which
+// is the running state of the guest and whose parent is the latest snapshot.
+type Snapshot struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parent      string `json:"parent,omitempty"`
+	SnapTime    int64  `json:"snaptime,omitempty"`
+	VMState     int    `json:"vmstate,omitempty"`
+}
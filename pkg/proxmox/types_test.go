@@ -0,0 +1,26 @@
+package proxmox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasTag(t *testing.T) {
+	tests := []struct {
+		name string
+		tags string
+		want bool
+	}{
+		{name: "semicolons", tags: "prod;gh-runner", want: true},
+		{name: "commas", tags: "gh-runner,prod", want: true},
+		{name: "prefix only", tags: "gh-runner-old", want: false},
+		{name: "empty", tags: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, (&Resource{Tags: tt.tags}).HasTag("gh-runner"))
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// State is the state of a message in the queue.
+type State string
+
+const (
+	// StatePending is a message that is waiting to be handled, or to be retried.
+	StatePending State = "pending"
+
+	// StateDead is a message that has run out of attempts, or failed permanently. Dead messages are kept so that they
+	// can be inspected, but they are never handled again.
+	StateDead State = "dead"
+)
+
+// Message is an entry in the queue.
+type Message struct {
+	// ID is the unique ID of the message.
+	ID string `json:"id"`
+
+	// Key is a caller supplied key, e.g. the webhook delivery ID.
+	Key string `json:"key"`
+
+	// Body is the payload of the message.
+	Body []byte `json:"body"`
+
+	// State is the state of the message.
+	State State `json:"state"`
+
+	// Attempts is how many times handling the message has failed.
+	Attempts int `json:"attempts"`
+
+	// LastError is the error of the last failed attempt.
+	LastError string `json:"last_error,omitempty"`
+
+	// EnqueuedAt is when the message was added to the queue.
+	EnqueuedAt time.Time `json:"enqueued_at"`
+
+	// NextAttemptAt is the earliest time the message will next be handled.
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// Handler handles a message. Returning nil removes the message from the queue, returning an error schedules a retry.
+type Handler func(ctx context.Context, msg *Message) error
+
+// Stats are the number of messages in each state.
+type Stats struct {
+	Pending  int `json:"pending"`
+	InFlight int `json:"in_flight"`
+	Dead     int `json:"dead"`
+}
+
+// permanentError marks an error that should not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps the error so that the message is moved straight to the dead-letter state instead of being retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent returns true if the error was wrapped with Permanent.
+func IsPermanent(err error) bool {
+	pErr := new(permanentError)
+	return errors.As(err, &pErr)
+}
+
+// newID returns a random message ID.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate message id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
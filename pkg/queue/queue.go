@@ -0,0 +1,413 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+)
+
+const (
+	// compactAfter is how many records the write-ahead log can hold beyond the live messages before it is compacted.
+	compactAfter = 1000
+
+	// idleWait is the longest a worker waits before checking for due retries.
+	idleWait = time.Second
+)
+
+// Config is the configuration for a Queue.
+type Config struct {
+	// Path is the path of the write-ahead log file.
+	Path string
+
+	// MaxAttempts is how many times a message is attempted before it is moved to the dead-letter state.
+	MaxAttempts int
+
+	// MinBackoff is the delay before the first retry. The delay doubles with every attempt.
+	MinBackoff time.Duration
+
+	// MaxBackoff caps the delay between retries.
+	MaxBackoff time.Duration
+}
+
+// Queue is a durable FIFO queue backed by a write-ahead log file. A message is on disk before Enqueue returns, and it
+// stays there until a handler succeeds, so a crash never loses an accepted message. Messages may be handled more than
+// once if the process crashes mid-way through handling one.
+type Queue struct {
+	cfg Config
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// f is the write-ahead log.
+	f *os.File
+
+	// messages are the messages in the queue keyed by ID.
+	messages map[string]*Message
+
+	// order is the IDs of the messages in the order they were enqueued.
+	order []string
+
+	// inFlight are the IDs of the messages that are being handled.
+	inFlight map[string]bool
+
+	// records is how many records are in the write-ahead log.
+	records int
+
+	// unsynced is set when the directory could not be synced after the write-ahead log was compacted, so that the
+	// compacted log may not survive a crash. Nothing is appended to it until the directory is synced.
+	unsynced bool
+
+	// wake is closed when a message is enqueued to wake up the idle workers.
+	wake chan struct{}
+
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+
+	// syncDir syncs the directory of the write-ahead log to disk. It is replaced in tests.
+	syncDir func(path string) error
+}
+
+// Open opens the queue, replaying the write-ahead log at the configured path if it exists.
+func Open(cfg Config) (*Queue, error) {
+	if cfg.MaxAttempts <= 0 {
+		return nil, errors.New("max attempts must be greater than zero")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o700); err != nil {
+		return nil, fmt.Errorf("create queue directory: %w", err)
+	}
+
+	messages, records, err := replay(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		cfg:      cfg,
+		messages: make(map[string]*Message, len(messages)),
+		order:    make([]string, 0, len(messages)),
+		inFlight: make(map[string]bool),
+		records:  records,
+		wake:     make(chan struct{}),
+		now:      time.Now,
+		syncDir:  syncDir,
+	}
+
+	for _, msg := range messages {
+		q.messages[msg.ID] = msg
+		q.order = append(q.order, msg.ID)
+	}
+
+	// Compact on open so the log does not grow across restarts.
+	f, err := writeSnapshot(cfg.Path, messages)
+	if err != nil {
+		return nil, err
+	}
+	if err := replaceLog(f, cfg.Path); err != nil {
+		return nil, err
+	}
+	if err := q.syncDir(filepath.Dir(cfg.Path)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	q.f = f
+	q.records = len(messages)
+
+	if len(messages) > 0 {
+		slog.Info("replayed queue", slog.String("path", cfg.Path), slog.Int("messages", len(messages)))
+	}
+
+	return q, nil
+}
+
+// Close closes the write-ahead log.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.f.Close()
+}
+
+// Enqueue durably adds a message to the queue.
+func (q *Queue) Enqueue(_ context.Context, key string, body []byte) (*Message, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := q.now()
+	msg := &Message{
+		ID:            id,
+		Key:           key,
+		Body:          body,
+		State:         StatePending,
+		EnqueuedAt:    now,
+		NextAttemptAt: now,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.append(&record{Op: opPut, Message: msg}); err != nil {
+		return nil, err
+	}
+
+	q.messages[msg.ID] = msg
+	q.order = append(q.order, msg.ID)
+	q.compactIfDue()
+
+	close(q.wake)
+	q.wake = make(chan struct{})
+
+	return copyMessage(msg), nil
+}
+
+// Run drains the queue with the given number of workers until the context is cancelled.
+func (q *Queue) Run(ctx context.Context, workers int, h Handler) error {
+	if workers <= 0 {
+		return errors.New("workers must be greater than zero")
+	}
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, h)
+		}()
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// Stats returns the number of messages in each state.
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := Stats{}
+	for id, msg := range q.messages {
+		switch {
+		case msg.State == StateDead:
+			stats.Dead++
+		case q.inFlight[id]:
+			stats.InFlight++
+		default:
+			stats.Pending++
+		}
+	}
+
+	return stats
+}
+
+// DeadLetters returns the messages in the dead-letter state.
+func (q *Queue) DeadLetters() []*Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	out := make([]*Message, 0)
+	for _, id := range q.order {
+		if msg := q.messages[id]; msg.State == StateDead {
+			out = append(out, copyMessage(msg))
+		}
+	}
+
+	return out
+}
+
+// work handles messages until the context is cancelled.
+func (q *Queue) work(ctx context.Context, h Handler) {
+	for {
+		msg, wake, wait := q.next()
+		if msg == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		q.handle(ctx, msg, h)
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// next claims the oldest message that is due. If there is none it returns the channel that is closed on the next
+// enqueue and how long to wait before the next retry is due.
+func (q *Queue) next() (*Message, <-chan struct{}, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	wait := idleWait
+	for _, id := range q.order {
+		msg := q.messages[id]
+		if msg.State != StatePending || q.inFlight[id] {
+			continue
+		}
+
+		if until := msg.NextAttemptAt.Sub(now); until > 0 {
+			wait = min(wait, until)
+			continue
+		}
+
+		q.inFlight[id] = true
+		return copyMessage(msg), nil, 0
+	}
+
+	return nil, q.wake, wait
+}
+
+// handle runs the handler for the message and records the outcome.
+func (q *Queue) handle(ctx context.Context, msg *Message, h Handler) {
+	l := slog.With(slog.String(logging.KeyMessageID, msg.ID), slog.String("key", msg.Key))
+
+	err := h(ctx, msg)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.inFlight, msg.ID)
+
+	if err == nil {
+		if err := q.append(&record{Op: opAck, ID: msg.ID}); err != nil {
+			// The message stays on disk, so it is handled again after a restart.
+			l.Error("unable to acknowledge message", slog.String(logging.KeyError, err.Error()))
+		}
+		q.remove(msg.ID)
+		q.compactIfDue()
+		return
+	}
+
+	if ctx.Err() != nil {
+		// The handler was interrupted by shutdown. Leave the message as it is so that it is handled on the next start.
+		return
+	}
+
+	stored := q.messages[msg.ID]
+	stored.Attempts++
+	stored.LastError = err.Error()
+
+	if IsPermanent(err) || stored.Attempts >= q.cfg.MaxAttempts {
+		stored.State = StateDead
+		l.Error("message moved to dead-letter state",
+			slog.Int("attempts", stored.Attempts),
+			slog.String(logging.KeyError, err.Error()),
+		)
+	} else {
+		stored.NextAttemptAt = q.now().Add(q.backoff(stored.Attempts))
+		l.Warn("message handling failed, will retry",
+			slog.Int("attempts", stored.Attempts),
+			slog.Time("next_attempt_at", stored.NextAttemptAt),
+			slog.String(logging.KeyError, err.Error()),
+		)
+	}
+
+	if err := q.append(&record{Op: opPut, Message: stored}); err != nil {
+		l.Error("unable to record message attempt", slog.String(logging.KeyError, err.Error()))
+	}
+	q.compactIfDue()
+}
+
+// backoff returns the delay before the given retry attempt.
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.cfg.MinBackoff
+	for i := 1; i < attempts && d < q.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, q.cfg.MaxBackoff)
+}
+
+// remove removes the message from memory. The caller must hold the lock.
+func (q *Queue) remove(id string) {
+	delete(q.messages, id)
+	for i, oid := range q.order {
+		if oid == id {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// append writes the record to the write-ahead log and syncs it to disk. The caller must hold the lock.
+func (q *Queue) append(rec *record) error {
+	if q.unsynced {
+		if err := q.syncDir(filepath.Dir(q.cfg.Path)); err != nil {
+			return fmt.Errorf("compacted write-ahead log not synced: %w", err)
+		}
+		q.unsynced = false
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode record: %w", err)
+	}
+
+	if _, err := q.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write record: %w", err)
+	}
+	if err := q.f.Sync(); err != nil {
+		return fmt.Errorf("sync write-ahead log: %w", err)
+	}
+	q.records++
+
+	return nil
+}
+
+// compactIfDue compacts the write-ahead log once it holds compactAfter records beyond the live messages. It must only
+// be called once the record just appended is applied in memory, or the snapshot misses it. The caller must hold the
+// lock.
+func (q *Queue) compactIfDue() {
+	if q.records > len(q.messages)+compactAfter {
+		q.compact()
+	}
+}
+
+// compact rewrites the write-ahead log with only the live messages. The new log is open before it replaces the old
+// one, so that records are never appended to a log that is no longer on disk. The caller must hold the lock.
+func (q *Queue) compact() {
+	messages := make([]*Message, 0, len(q.order))
+	for _, id := range q.order {
+		messages = append(messages, q.messages[id])
+	}
+
+	f, err := writeSnapshot(q.cfg.Path, messages)
+	if err == nil {
+		err = replaceLog(f, q.cfg.Path)
+	}
+	if err != nil {
+		slog.Error("unable to compact write-ahead log", slog.String(logging.KeyError, err.Error()))
+		return
+	}
+
+	q.f.Close()
+	q.f = f
+	q.records = len(messages)
+
+	if err := q.syncDir(filepath.Dir(q.cfg.Path)); err != nil {
+		// A crash could bring the old log back without the records appended from now on, so appending waits for the
+		// directory to be synced.
+		q.unsynced = true
+		slog.Error("unable to sync compacted write-ahead log", slog.String(logging.KeyError, err.Error()))
+	}
+}
+
+// copyMessage returns a copy of the message so callers cannot change the queue's copy.
+func copyMessage(msg *Message) *Message {
+	c := *msg
+	return &c
+}
@@ -0,0 +1,201 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type QueueSuite struct {
+	suite.Suite
+
+	cfg Config
+	q   *Queue
+}
+
+func TestQueueSuite(t *testing.T) {
+	suite.Run(t, new(QueueSuite))
+}
+
+func (s *QueueSuite) SetupTest() {
+	s.cfg = Config{
+		Path:        filepath.Join(s.T().TempDir(), "events.wal"),
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	}
+
+	q, err := Open(s.cfg)
+	s.Require().NoError(err)
+	s.q = q
+}
+
+func (s *QueueSuite) TearDownTest() {
+	s.NoError(s.q.Close())
+}
+
+func (s *QueueSuite) reopen() {
+	s.Require().NoError(s.q.Close())
+
+	q, err := Open(s.cfg)
+	s.Require().NoError(err)
+	s.q = q
+}
+
+// drain runs the handler over the queue until the condition is met.
+func (s *QueueSuite) drain(h Handler, done func() bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		for ctx.Err() == nil {
+			if done() {
+				cancel()
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	s.NoError(s.q.Run(ctx, 2, h))
+}
+
+func (s *QueueSuite) TestEnqueueSurvivesReopen() {
+	_, err := s.q.Enqueue(context.Background(), "a", []byte("body-a"))
+	s.Require().NoError(err)
+	_, err = s.q.Enqueue(context.Background(), "b", []byte("body-b"))
+	s.Require().NoError(err)
+
+	s.reopen()
+
+	s.Equal(Stats{Pending: 2}, s.q.Stats())
+}
+
+func (s *QueueSuite) TestHandledMessagesAreRemoved() {
+	_, err := s.q.Enqueue(context.Background(), "a", []byte("body-a"))
+	s.Require().NoError(err)
+
+	mu := new(sync.Mutex)
+	handled := make([]string, 0)
+	s.drain(func(_ context.Context, msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, string(msg.Body))
+		return nil
+	}, func() bool {
+		return s.q.Stats() == Stats{}
+	})
+
+	s.Equal([]string{"body-a"}, handled)
+
+	s.reopen()
+	s.Equal(Stats{}, s.q.Stats())
+}
+
+// cycle enqueues n messages, fails each the given number of times and then acknowledges it.
+func (s *QueueSuite) cycle(n, failures int) {
+	for range n {
+		msg, err := s.q.Enqueue(context.Background(), "a", []byte("body-a"))
+		s.Require().NoError(err)
+		for range failures {
+			s.q.handle(context.Background(), msg, func(context.Context, *Message) error { return errors.New("boom") })
+		}
+		s.q.handle(context.Background(), msg, func(context.Context, *Message) error { return nil })
+	}
+}
+
+func (s *QueueSuite) TestCompactionKeepsEnqueuedMessages() {
+	s.cycle(compactAfter/2, 0)
+	_, err := s.q.Enqueue(context.Background(), "b", []byte("body-b"))
+	s.Require().NoError(err)
+	s.cycle(1, 0)
+	s.Require().Less(s.q.records, compactAfter, "the log must have been compacted")
+
+	s.reopen()
+	s.Equal(Stats{Pending: 1}, s.q.Stats())
+}
+
+func (s *QueueSuite) TestCompactionDropsAcknowledgedMessages() {
+	// The log is left one record short of compaction, so that the acknowledgement of the last message compacts it.
+	s.cycle(1, 1)
+	s.cycle(compactAfter/2-2, 0)
+	s.cycle(1, 1)
+	s.Require().Less(s.q.records, compactAfter, "the log must have been compacted")
+
+	s.reopen()
+	s.Equal(Stats{}, s.q.Stats())
+}
+
+func (s *QueueSuite) TestEnqueueFailsUntilCompactionIsSynced() {
+	syncErr := errors.New("sync failed")
+	s.q.syncDir = func(string) error { return syncErr }
+	s.cycle(compactAfter/2+1, 0)
+	s.Require().Less(s.q.records, compactAfter, "the log must have been compacted")
+
+	_, err := s.q.Enqueue(context.Background(), "b", []byte("body-b"))
+	s.ErrorIs(err, syncErr, "a message must not be accepted while the compacted log may not survive a crash")
+	s.Equal(Stats{}, s.q.Stats())
+
+	s.q.syncDir = syncDir
+	_, err = s.q.Enqueue(context.Background(), "b", []byte("body-b"))
+	s.Require().NoError(err)
+
+	s.reopen()
+	s.Equal(Stats{Pending: 1}, s.q.Stats(), "the message must be in the log on disk")
+}
+
+func (s *QueueSuite) TestFailingMessagesAreDeadLettered() {
+	_, err := s.q.Enqueue(context.Background(), "a", []byte("body-a"))
+	s.Require().NoError(err)
+
+	s.drain(func(_ context.Context, _ *Message) error {
+		return errors.New("boom")
+	}, func() bool {
+		return s.q.Stats().Dead == 1
+	})
+
+	dead := s.q.DeadLetters()
+	s.Require().Len(dead, 1)
+	s.Equal(3, dead[0].Attempts)
+	s.Equal("boom", dead[0].LastError)
+
+	s.reopen()
+	s.Equal(Stats{Dead: 1}, s.q.Stats())
+}
+
+func (s *QueueSuite) TestPermanentErrorsAreNotRetried() {
+	_, err := s.q.Enqueue(context.Background(), "a", []byte("body-a"))
+	s.Require().NoError(err)
+
+	s.drain(func(_ context.Context, _ *Message) error {
+		return Permanent(errors.New("bad body"))
+	}, func() bool {
+		return s.q.Stats().Dead == 1
+	})
+
+	s.Equal(1, s.q.DeadLetters()[0].Attempts)
+}
+
+func (s *QueueSuite) TestPartialRecordIsDropped() {
+	_, err := s.q.Enqueue(context.Background(), "a", []byte("body-a"))
+	s.Require().NoError(err)
+	s.Require().NoError(s.q.Close())
+
+	f, err := os.OpenFile(s.cfg.Path, os.O_APPEND|os.O_WRONLY, 0o600)
+	s.Require().NoError(err)
+	_, err = f.WriteString(`{"op":"put","message":{"id":"tor`)
+	s.Require().NoError(err)
+	s.Require().NoError(f.Close())
+
+	q, err := Open(s.cfg)
+	s.Require().NoError(err)
+	s.q = q
+
+	s.Equal(Stats{Pending: 1}, s.q.Stats())
+}
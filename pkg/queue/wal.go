@@ -0,0 +1,151 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+)
+
+// op is the operation a write-ahead log record applies.
+type op string
+
+const (
+	// opPut adds or replaces a message.
+	opPut op = "put"
+
+	// opAck removes a message.
+	opAck op = "ack"
+)
+
+// record is a single line of the write-ahead log.
+type record struct {
+	Op      op       `json:"op"`
+	ID      string   `json:"id,omitempty"`
+	Message *Message `json:"message,omitempty"`
+}
+
+// replay reads the write-ahead log and returns the messages it holds in the order they were enqueued, along with the
+// number of records read.
+func replay(path string) ([]*Message, int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("open write-ahead log: %w", err)
+	}
+	defer f.Close()
+
+	messages := make(map[string]*Message)
+	order := make([]string, 0)
+	records := 0
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				// A partial last line is a write that was interrupted by a crash. The write was never acknowledged, so it
+				// is safe to drop.
+				slog.Warn("dropping partial write-ahead log record", slog.String("path", path))
+			}
+			break
+		} else if err != nil {
+			return nil, 0, fmt.Errorf("read write-ahead log: %w", err)
+		}
+
+		rec := new(record)
+		if err := json.Unmarshal(line, rec); err != nil {
+			slog.Warn("skipping corrupt write-ahead log record",
+				slog.String("path", path),
+				slog.String(logging.KeyError, err.Error()),
+			)
+			continue
+		}
+		records++
+
+		switch rec.Op {
+		case opPut:
+			if rec.Message == nil {
+				continue
+			}
+			if _, ok := messages[rec.Message.ID]; !ok {
+				order = append(order, rec.Message.ID)
+			}
+			messages[rec.Message.ID] = rec.Message
+		case opAck:
+			delete(messages, rec.ID)
+		}
+	}
+
+	out := make([]*Message, 0, len(messages))
+	for _, id := range order {
+		if msg, ok := messages[id]; ok {
+			out = append(out, msg)
+		}
+	}
+
+	return out, records, nil
+}
+
+// writeSnapshot writes a new write-ahead log with one put record per message next to the one at path and syncs it to
+// disk. It returns the new log open for appending, for the caller to rename over the one at path.
+func writeSnapshot(path string, messages []*Message) (*os.File, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, msg := range messages {
+		if err = enc.Encode(&record{Op: opPut, Message: msg}); err != nil {
+			err = fmt.Errorf("encode snapshot record: %w", err)
+			break
+		}
+	}
+	if err == nil {
+		if err = w.Flush(); err != nil {
+			err = fmt.Errorf("flush snapshot: %w", err)
+		} else if err = tmp.Sync(); err != nil {
+			err = fmt.Errorf("sync snapshot: %w", err)
+		}
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return tmp, nil
+}
+
+// replaceLog renames the snapshot over the write-ahead log at path. The snapshot is removed if it cannot be.
+func replaceLog(snapshot *os.File, path string) error {
+	if err := os.Rename(snapshot.Name(), path); err != nil {
+		snapshot.Close()
+		os.Remove(snapshot.Name())
+		return fmt.Errorf("replace write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// syncDir syncs the directory to disk, so that a rename in it survives a crash.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open queue directory: %w", err)
+	}
+	defer dir.Close()
+
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("sync queue directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+package election
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+)
+
+// ErrConflict is returned by Store.Put when the lease was changed since it was read.
+var ErrConflict = errors.New("lease was changed by another replica")
+
+// Lease is the record of the replica that leads.
+type Lease struct {
+	// Holder is the ID of the replica holding the lease. The lease is free when it is empty.
+	Holder string `json:"holder"`
+
+	// Address is the URL the holder is reached at by the other replicas, e.g. http://10.0.0.2:8080.
+	Address string `json:"address"`
+
+	// AcquiredAt is when the holder took the lease.
+	AcquiredAt time.Time `json:"acquired_at"`
+
+	// RenewedAt is when the holder last renewed the lease.
+	RenewedAt time.Time `json:"renewed_at"`
+
+	// Version is the version of the lease in the store, which changes with every write.
+	Version int `json:"version"`
+}
+
+// Store keeps the lease where every replica can read and write it.
+type Store interface {
+	// Get returns the lease, or nil if there has never been one.
+	Get(ctx context.Context) (*Lease, error)
+
+	// Put writes the lease if the stored lease is still at the given version, zero meaning there is none yet. It
+	// returns the new version, or ErrConflict.
+	Put(ctx context.Context, lease *Lease, version int) (int, error)
+}
+
+// Config is the configuration for the Elector.
+type Config struct {
+	// ID identifies the replica. It must be unique among the replicas, and defaults to the host name with a random
+	// suffix.
+	ID string
+
+	// Address is the URL the replica is reached at by the other replicas.
+	Address string
+
+	// LeaseDuration is how long the lease must go unrenewed before another replica takes it. Defaults to 10 seconds.
+	LeaseDuration time.Duration
+
+	// RenewInterval is how often the leader renews the lease and the other replicas check it. It must be at most a
+	// third of LeaseDuration. Defaults to 2 seconds.
+	RenewInterval time.Duration
+}
+
+// Elector elects one of the replicas of the scaler as the leader by taking a lease in a Store. The leader renews the
+// lease every RenewInterval. The other replicas take the lease once they have seen it unchanged for LeaseDuration, by
+// their own clock so that the clocks of the replicas need not agree. The leader steps down when it has been unable to
+// renew the lease for long enough that another replica could take it, and frees the lease when it stops so that
+// another replica takes over straight away.
+type Elector struct {
+	cfg   Config
+	store Store
+	now   func() time.Time
+
+	// mut guards the fields below.
+	mut sync.Mutex
+
+	// lease is the lease as last read or written.
+	lease *Lease
+
+	// observedAt is when the version of lease was first seen.
+	observedAt time.Time
+
+	// leading is true while the replica holds the lease.
+	leading bool
+
+	// renewedAt is when the last successful renewal started.
+	renewedAt time.Time
+}
+
+// NewElector creates a new Elector.
+func NewElector(cfg Config, store Store) (*Elector, error) {
+	if cfg.ID == "" {
+		id, err := defaultID()
+		if err != nil {
+			return nil, err
+		}
+		cfg.ID = id
+	}
+	if cfg.LeaseDuration == 0 {
+		cfg.LeaseDuration = 10 * time.Second
+	}
+	if cfg.RenewInterval == 0 {
+		cfg.RenewInterval = 2 * time.Second
+	}
+
+	switch {
+	case cfg.LeaseDuration < 0 || cfg.RenewInterval < 0:
+		return nil, errors.New("lease duration and renew interval must be positive")
+	case cfg.RenewInterval*3 > cfg.LeaseDuration:
+		return nil, fmt.Errorf("renew interval %s must be at most a third of the lease duration %s", cfg.RenewInterval, cfg.LeaseDuration)
+	}
+
+	return &Elector{
+		cfg:   cfg,
+		store: store,
+		now:   time.Now,
+	}, nil
+}
+
+// ID returns the ID of the replica.
+func (e *Elector) ID() string {
+	return e.cfg.ID
+}
+
+// IsLeader returns true while the replica holds the lease. It returns false as soon as the replica would step down
+// for failing to renew the lease, even before the renewal that notices it, so that what the replica writes while it
+// returns true is written before another replica could take over.
+func (e *Elector) IsLeader() bool {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	return e.leading && e.holds(e.now())
+}
+
+// Leader returns the lease of the leader, or nil if no replica is known to lead.
+func (e *Elector) Leader() *Lease {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if e.lease == nil || e.lease.Holder == "" || (!e.leading && e.expired(e.now())) {
+		return nil
+	}
+
+	lease := *e.lease
+	return &lease
+}
+
+// Run takes part in the election until the context is cancelled. While the replica leads, lead runs with a context
+// that is cancelled when it stops leading. Run returns the error of lead if it returns while the replica still leads.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context) error) error {
+	ticker := time.NewTicker(e.cfg.RenewInterval)
+	defer ticker.Stop()
+
+	var current *term
+	stop := func() error {
+		if current == nil {
+			return nil
+		}
+		err := current.stop()
+		current = nil
+		return err
+	}
+	defer e.release()
+
+	for {
+		leading := e.tick(ctx)
+		switch {
+		case leading && current == nil:
+			current = startTerm(ctx, lead)
+		case !leading && current != nil:
+			if err := stop(); err != nil {
+				return err
+			}
+		}
+
+		var done <-chan error
+		if current != nil {
+			done = current.done
+		}
+
+		select {
+		case <-ctx.Done():
+			return stop()
+		case err := <-done:
+			current.cancel()
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// term is the time the replica leads, during which lead runs.
+type term struct {
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// startTerm runs lead until the term is stopped.
+func startTerm(ctx context.Context, lead func(ctx context.Context) error) *term {
+	ctx, cancel := context.WithCancel(ctx)
+	t := &term{
+		cancel: cancel,
+		done:   make(chan error, 1),
+	}
+
+	go func() {
+		t.done <- lead(ctx)
+	}()
+
+	return t
+}
+
+// stop cancels the context of lead and waits for it to return.
+func (t *term) stop() error {
+	t.cancel()
+	return <-t.done
+}
+
+// tick takes or renews the lease if it can, and returns true if the replica holds it.
+func (e *Elector) tick(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.RenewInterval)
+	defer cancel()
+
+	now := e.now()
+
+	lease, err := e.store.Get(ctx)
+	if err != nil {
+		slog.Warn("unable to read leader lease", slog.String(logging.KeyError, err.Error()))
+		return e.keep(now)
+	}
+
+	e.mut.Lock()
+	e.observe(lease, now)
+	free := lease == nil || lease.Holder == "" || lease.Holder == e.cfg.ID || e.expired(now)
+	e.mut.Unlock()
+
+	if !free {
+		return e.lose(lease.Holder)
+	}
+
+	next := &Lease{
+		Holder:     e.cfg.ID,
+		Address:    e.cfg.Address,
+		AcquiredAt: now,
+		RenewedAt:  now,
+	}
+	version := 0
+	if lease != nil {
+		version = lease.Version
+		if lease.Holder == e.cfg.ID {
+			next.AcquiredAt = lease.AcquiredAt
+		}
+	}
+
+	next.Version, err = e.store.Put(ctx, next, version)
+	if errors.Is(err, ErrConflict) {
+		// Another replica wrote the lease since it was read, it is seen on the next tick.
+		return e.lose("")
+	} else if err != nil {
+		slog.Warn("unable to write leader lease", slog.String(logging.KeyError, err.Error()))
+		return e.keep(now)
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	e.observe(next, now)
+	e.renewedAt = now
+	if !e.leading {
+		e.leading = true
+
+		previous := ""
+		if lease != nil {
+			previous = lease.Holder
+		}
+		slog.Info("acquired leadership",
+			slog.String("replica", e.cfg.ID),
+			slog.String("previous_leader", previous),
+		)
+	}
+
+	return true
+}
+
+// keep returns whether the leader still holds the lease it was unable to renew. It steps down once another replica
+// could have taken the lease by the time the next renewal gives up: the other replicas wait LeaseDuration after seeing
+// the last renewal, and the next renewal may take up to two RenewIntervals.
+func (e *Elector) keep(now time.Time) bool {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if !e.leading {
+		return false
+	}
+	if e.holds(now) {
+		return true
+	}
+
+	e.leading = false
+	slog.Warn("lost leadership",
+		slog.String("replica", e.cfg.ID),
+		slog.String("reason", "unable to renew the lease"),
+		slog.Time("renewed_at", e.renewedAt),
+	)
+
+	return false
+}
+
+// holds returns true if no other replica could take the lease by the time the next renewal gives up. The caller must
+// hold mut.
+func (e *Elector) holds(now time.Time) bool {
+	return now.Sub(e.renewedAt) < e.cfg.LeaseDuration-2*e.cfg.RenewInterval
+}
+
+// lose records that the lease is held by another replica.
+func (e *Elector) lose(holder string) bool {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if e.leading {
+		e.leading = false
+		slog.Warn("lost leadership",
+			slog.String("replica", e.cfg.ID),
+			slog.String("reason", "the lease was taken by another replica"),
+			slog.String("leader", holder),
+		)
+	}
+
+	return false
+}
+
+// release frees the lease if the replica holds it, so that another replica takes over without waiting for it to
+// expire.
+func (e *Elector) release() {
+	e.mut.Lock()
+	lease := e.lease
+	leading := e.leading
+	e.leading = false
+	e.mut.Unlock()
+
+	if !leading || lease == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.RenewInterval)
+	defer cancel()
+
+	if _, err := e.store.Put(ctx, &Lease{RenewedAt: e.now()}, lease.Version); err != nil {
+		slog.Warn("unable to release leader lease", slog.String(logging.KeyError, err.Error()))
+		return
+	}
+
+	slog.Info("released leadership", slog.String("replica", e.cfg.ID))
+}
+
+// observe records the lease as read or written at now. The caller must hold mut.
+func (e *Elector) observe(lease *Lease, now time.Time) {
+	version := 0
+	if lease != nil {
+		version = lease.Version
+	}
+	if e.lease == nil || e.lease.Version != version || e.observedAt.IsZero() {
+		e.observedAt = now
+	}
+
+	if lease == nil {
+		lease = &Lease{}
+	}
+	e.lease = lease
+}
+
+// expired returns true if the lease has gone unrenewed for LeaseDuration. The caller must hold mut.
+func (e *Elector) expired(now time.Time) bool {
+	return now.Sub(e.observedAt) >= e.cfg.LeaseDuration
+}
+
+// defaultID returns the host name with a random suffix, so that replicas sharing a host name do not share an ID.
+func defaultID() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("get host name: %w", err)
+	}
+
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate replica id: %w", err)
+	}
+
+	return host + "-" + hex.EncodeToString(b), nil
+}
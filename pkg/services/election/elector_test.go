@@ -0,0 +1,195 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStore struct {
+	mut   sync.Mutex
+	lease *Lease
+
+	// down fails every call made with it set in the context.
+	down map[string]bool
+}
+
+type replicaKey struct{}
+
+func (m *memoryStore) Get(ctx context.Context) (*Lease, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.down[replica(ctx)] {
+		return nil, errors.New("connection refused")
+	}
+	if m.lease == nil {
+		return nil, nil
+	}
+	lease := *m.lease
+	return &lease, nil
+}
+
+func (m *memoryStore) Put(ctx context.Context, lease *Lease, version int) (int, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.down[replica(ctx)] {
+		return 0, errors.New("connection refused")
+	}
+
+	current := 0
+	if m.lease != nil {
+		current = m.lease.Version
+	}
+	if current != version {
+		return 0, ErrConflict
+	}
+
+	stored := *lease
+	stored.Version = current + 1
+	m.lease = &stored
+	return stored.Version, nil
+}
+
+func (m *memoryStore) setDown(id string, down bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.down[id] = down
+}
+
+func replica(ctx context.Context) string {
+	id, _ := ctx.Value(replicaKey{}).(string)
+	return id
+}
+
+// runReplica runs an elector for the replica until the returned function is called. leading reports whether its lead
+// function is running.
+func runReplica(t *testing.T, store Store, id string) (e *Elector, leading *atomic.Bool, stop func()) {
+	e, err := NewElector(Config{
+		ID:            id,
+		Address:       "http://" + id + ":8080",
+		LeaseDuration: 150 * time.Millisecond,
+		RenewInterval: 10 * time.Millisecond,
+	}, store)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), replicaKey{}, id))
+	leading = new(atomic.Bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Run(ctx, func(ctx context.Context) error {
+			leading.Store(true)
+			defer leading.Store(false)
+			<-ctx.Done()
+			return nil
+		})
+	}()
+
+	stop = func() {
+		cancel()
+		require.NoError(t, <-done)
+	}
+	return e, leading, stop
+}
+
+func TestElectsOneLeader(t *testing.T) {
+	store := &memoryStore{down: make(map[string]bool)}
+
+	a, aLeading, stopA := runReplica(t, store, "a")
+	defer stopA()
+	require.Eventually(t, aLeading.Load, time.Second, 5*time.Millisecond)
+
+	b, bLeading, stopB := runReplica(t, store, "b")
+	defer stopB()
+
+	// b keeps seeing the lease renewed, so it never takes it.
+	time.Sleep(300 * time.Millisecond)
+	require.True(t, a.IsLeader())
+	require.False(t, b.IsLeader())
+	require.False(t, bLeading.Load())
+
+	leader := b.Leader()
+	require.NotNil(t, leader)
+	require.Equal(t, "a", leader.Holder)
+	require.Equal(t, "http://a:8080", leader.Address)
+}
+
+func TestReleasedLeaseIsTakenStraightAway(t *testing.T) {
+	store := &memoryStore{down: make(map[string]bool)}
+
+	a, aLeading, stopA := runReplica(t, store, "a")
+	require.Eventually(t, aLeading.Load, time.Second, 5*time.Millisecond)
+
+	b, bLeading, stopB := runReplica(t, store, "b")
+	defer stopB()
+	require.Eventually(t, func() bool { return b.Leader() != nil }, time.Second, 5*time.Millisecond)
+
+	stopA()
+	require.False(t, a.IsLeader())
+	require.False(t, aLeading.Load(), "lead must have returned when Run returned")
+
+	// Well within the lease duration, as the lease was freed.
+	require.Eventually(t, bLeading.Load, 100*time.Millisecond, 5*time.Millisecond)
+	require.Equal(t, "b", b.Leader().Holder)
+}
+
+func TestLeaderStepsDownWhenUnableToRenew(t *testing.T) {
+	store := &memoryStore{down: make(map[string]bool)}
+
+	a, aLeading, stopA := runReplica(t, store, "a")
+	defer stopA()
+	require.Eventually(t, aLeading.Load, time.Second, 5*time.Millisecond)
+
+	b, bLeading, stopB := runReplica(t, store, "b")
+	defer stopB()
+	require.Eventually(t, func() bool { return b.Leader() != nil }, time.Second, 5*time.Millisecond)
+
+	store.setDown("a", true)
+
+	// a must step down before b takes over, so that they never lead together.
+	require.Eventually(t, func() bool { return !aLeading.Load() }, time.Second, time.Millisecond)
+	require.False(t, b.IsLeader())
+	require.Eventually(t, bLeading.Load, time.Second, 5*time.Millisecond)
+	require.False(t, a.IsLeader())
+
+	// a follows once it can reach the store again.
+	store.setDown("a", false)
+	require.Eventually(t, func() bool {
+		leader := a.Leader()
+		return leader != nil && leader.Holder == "b"
+	}, time.Second, 5*time.Millisecond)
+	require.False(t, aLeading.Load())
+}
+
+func TestIsLeaderStopsBeforeTheLeaseCanBeTaken(t *testing.T) {
+	store := &memoryStore{down: make(map[string]bool)}
+	e, err := NewElector(Config{ID: "a", LeaseDuration: 10 * time.Second, RenewInterval: 2 * time.Second}, store)
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+	require.True(t, e.tick(context.Background()))
+	require.True(t, e.IsLeader())
+
+	// No renewal has failed yet, but one started now could give up after another replica took the lease.
+	now = now.Add(6 * time.Second)
+	require.False(t, e.IsLeader())
+}
+
+func TestNewElectorValidatesIntervals(t *testing.T) {
+	_, err := NewElector(Config{ID: "a", LeaseDuration: 5 * time.Second, RenewInterval: 2 * time.Second}, nil)
+	require.EqualError(t, err, "renew interval 2s must be at most a third of the lease duration 5s")
+
+	e, err := NewElector(Config{}, nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, e.ID())
+	require.Equal(t, 10*time.Second, e.cfg.LeaseDuration)
+	require.Equal(t, 2*time.Second, e.cfg.RenewInterval)
+}
@@ -0,0 +1,96 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// vaultStore keeps the lease in a KV v2 secret, using check-and-set writes so that only one replica wins a race.
+type vaultStore struct {
+	vc    vault.Client
+	mount string
+	path  string
+}
+
+// NewVaultStore creates a Store that keeps the lease at the path of the KV v2 mount. The path must not be used for
+// anything else.
+func NewVaultStore(vc vault.Client, mount, path string) Store {
+	return &vaultStore{
+		vc:    vc,
+		mount: mount,
+		path:  path,
+	}
+}
+
+func (s *vaultStore) Get(ctx context.Context) (*Lease, error) {
+	secret, err := s.vc.Client().KVv2(s.mount).Get(ctx, s.path)
+	if errors.Is(err, vaultapi.ErrSecretNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read lease: %w", err)
+	}
+
+	lease := new(Lease)
+	if secret.VersionMetadata != nil {
+		lease.Version = secret.VersionMetadata.Version
+	}
+
+	// The data of a deleted version is nil, which leaves the lease free.
+	lease.Holder, _ = secret.Data["holder"].(string)
+	lease.Address, _ = secret.Data["address"].(string)
+	if lease.AcquiredAt, err = parseTime(secret.Data["acquired_at"]); err != nil {
+		return nil, fmt.Errorf("decode lease acquired_at: %w", err)
+	}
+	if lease.RenewedAt, err = parseTime(secret.Data["renewed_at"]); err != nil {
+		return nil, fmt.Errorf("decode lease renewed_at: %w", err)
+	}
+
+	return lease, nil
+}
+
+func (s *vaultStore) Put(ctx context.Context, lease *Lease, version int) (int, error) {
+	secret, err := s.vc.Client().KVv2(s.mount).Put(ctx, s.path, map[string]any{
+		"holder":      lease.Holder,
+		"address":     lease.Address,
+		"acquired_at": lease.AcquiredAt.Format(time.RFC3339Nano),
+		"renewed_at":  lease.RenewedAt.Format(time.RFC3339Nano),
+	}, vaultapi.WithCheckAndSet(version))
+	if isCheckAndSetMismatch(err) {
+		return 0, ErrConflict
+	} else if err != nil {
+		return 0, fmt.Errorf("write lease: %w", err)
+	}
+
+	return secret.VersionMetadata.Version, nil
+}
+
+// isCheckAndSetMismatch returns true if Vault refused the write because the secret is no longer at the given version.
+func isCheckAndSetMismatch(err error) bool {
+	respErr := new(vaultapi.ResponseError)
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+
+	for _, msg := range respErr.Errors {
+		if strings.Contains(msg, "check-and-set") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTime parses an RFC 3339 time stored in the lease, which is zero if it is missing.
+func parseTime(v any) (time.Time, error) {
+	s, _ := v.(string)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
@@ -0,0 +1,95 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKV serves a single KV v2 secret with check-and-set writes.
+type fakeKV struct {
+	mut     sync.Mutex
+	data    map[string]any
+	version int
+}
+
+func (f *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		if f.version == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"data":     f.data,
+				"metadata": map[string]any{"version": f.version, "created_time": time.Now().Format(time.RFC3339Nano)},
+			},
+		})
+	case http.MethodPut:
+		var body struct {
+			Data    map[string]any `json:"data"`
+			Options struct {
+				CAS int `json:"cas"`
+			} `json:"options"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if body.Options.CAS != f.version {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["check-and-set parameter did not match the current version"]}`))
+			return
+		}
+
+		f.data = body.Data
+		f.version++
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{"version": f.version, "created_time": time.Now().Format(time.RFC3339Nano)},
+		})
+	}
+}
+
+func TestVaultStore(t *testing.T) {
+	kv := new(fakeKV)
+	srv := httptest.NewServer(http.StripPrefix("/v1/secret/data/scaler/leader", kv))
+	defer srv.Close()
+
+	client, err := vaultapi.NewClient(&vaultapi.Config{Address: srv.URL})
+	require.NoError(t, err)
+	vc := vault.NewMockClient(t)
+	vc.On("Client").Return(client)
+
+	store := NewVaultStore(vc, "secret", "scaler/leader")
+	ctx := context.Background()
+
+	lease, err := store.Get(ctx)
+	require.NoError(t, err)
+	require.Nil(t, lease)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	version, err := store.Put(ctx, &Lease{Holder: "a", Address: "http://a:8080", AcquiredAt: now, RenewedAt: now}, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+
+	_, err = store.Put(ctx, &Lease{Holder: "b"}, 0)
+	require.ErrorIs(t, err, ErrConflict)
+
+	lease, err = store.Get(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &Lease{Holder: "a", Address: "http://a:8080", AcquiredAt: now, RenewedAt: now, Version: 1}, lease)
+}
@@ -0,0 +1,166 @@
+package placement
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+)
+
+// BreakerState is the state of the circuit breaker of a node.
+type BreakerState string
+
+const (
+	// BreakerClosed is a node that takes guests.
+	BreakerClosed BreakerState = "closed"
+
+	// BreakerOpen is a node that failed too often and is out of placement until its cool-down ends.
+	BreakerOpen BreakerState = "open"
+
+	// BreakerHalfOpen is a node whose cool-down ended. It takes a single trial guest, which closes the breaker if it
+	// succeeds and opens it again if it fails.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerConfig is the configuration of the circuit breakers of the nodes.
+type BreakerConfig struct {
+	// Failures is the number of failures in a row that open the breaker of a node. Defaults to 3.
+	Failures int
+
+	// CoolDown is how long an open breaker keeps its node out of placement. Defaults to 5 minutes.
+	CoolDown time.Duration
+}
+
+// BreakerStatus is the state of the circuit breaker of a node.
+type BreakerStatus struct {
+	// Node is the name of the node.
+	Node string `json:"node"`
+
+	// State is the state of the breaker.
+	State BreakerState `json:"state"`
+
+	// Failures is the number of failures in a row on the node.
+	Failures int `json:"failures"`
+
+	// OpenUntil is when the cool-down of an open breaker ends.
+	OpenUntil time.Time `json:"open_until"`
+
+	// LastError is the last failure on the node.
+	LastError string `json:"last_error"`
+}
+
+// breaker counts the failures on a node.
+type breaker struct {
+	state     BreakerState
+	failures  int
+	openUntil time.Time
+	lastError string
+
+	// trialUntil is when the trial guest of a half-open breaker is given up on, so that a trial whose outcome is never
+	// reported does not keep the node out for good.
+	trialUntil time.Time
+}
+
+// Failed records that creating or starting a guest failed on the node. The breaker of the node opens after
+// BreakerConfig.Failures failures in a row, or straight away if its trial guest failed.
+func (p *Placer) Failed(node string, err error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	b, ok := p.breakers[node]
+	if !ok {
+		b = &breaker{state: BreakerClosed}
+		p.breakers[node] = b
+	}
+
+	b.failures++
+	b.lastError = err.Error()
+	b.trialUntil = time.Time{}
+
+	if b.state == BreakerOpen || (b.state == BreakerClosed && b.failures < p.cfg.Breaker.Failures) {
+		return
+	}
+
+	b.state = BreakerOpen
+	b.openUntil = p.now().Add(p.cfg.Breaker.CoolDown)
+
+	slog.Warn("node circuit breaker opened",
+		slog.String(logging.KeyNode, node),
+		slog.Int("failures", b.failures),
+		slog.Time("open_until", b.openUntil),
+		slog.String(logging.KeyError, b.lastError),
+	)
+}
+
+// Succeeded records that a guest was created and started on the node, which closes its breaker.
+func (p *Placer) Succeeded(node string) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	b, ok := p.breakers[node]
+	if !ok {
+		return
+	}
+	delete(p.breakers, node)
+
+	if b.state != BreakerClosed {
+		slog.Info("node circuit breaker closed", slog.String(logging.KeyNode, node))
+	}
+}
+
+// Breakers returns the breakers of the nodes that failed since their last success, sorted by node.
+func (p *Placer) Breakers() []BreakerStatus {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(p.breakers))
+	for node, b := range p.breakers {
+		statuses = append(statuses, BreakerStatus{
+			Node:      node,
+			State:     b.state,
+			Failures:  b.failures,
+			OpenUntil: b.openUntil,
+			LastError: b.lastError,
+		})
+	}
+
+	slices.SortFunc(statuses, func(a, b BreakerStatus) int { return strings.Compare(a.Node, b.Node) })
+
+	return statuses
+}
+
+// tripped returns why the breaker of the node keeps it out of placement, or an empty string if it does not. An open
+// breaker whose cool-down ended turns half-open. The caller must hold mut.
+func (p *Placer) tripped(node string) string {
+	b, ok := p.breakers[node]
+	if !ok {
+		return ""
+	}
+
+	now := p.now()
+	if b.state == BreakerOpen {
+		if now.Before(b.openUntil) {
+			return fmt.Sprintf("circuit breaker open until %s", b.openUntil.Format(time.RFC3339))
+		}
+
+		b.state = BreakerHalfOpen
+		slog.Info("node circuit breaker half-open", slog.String(logging.KeyNode, node))
+	}
+
+	if b.state == BreakerHalfOpen && now.Before(b.trialUntil) {
+		return "circuit breaker half-open with a trial guest"
+	}
+
+	return ""
+}
+
+// trial makes the guest placed on the node the trial guest if the node's breaker is half-open. The caller must hold
+// mut.
+func (p *Placer) trial(node string) {
+	if b, ok := p.breakers[node]; ok && b.state == BreakerHalfOpen {
+		b.trialUntil = p.now().Add(p.cfg.PendingTTL)
+	}
+}
@@ -0,0 +1,55 @@
+package placement
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func (s *PlacerSuite) TestBreakerTakesFailingNodeOut() {
+	p := s.placer(Config{Breaker: BreakerConfig{Failures: 2, CoolDown: time.Minute}}, Spread{})
+	boom := errors.New("clone failed: storage full")
+
+	p.Failed("pve2", boom)
+	nodes, err := p.Rank(context.Background(), &Request{})
+	s.Require().NoError(err)
+	s.Contains(nodes, "pve2", "a single failure must not open the breaker")
+
+	p.Failed("pve2", boom)
+	nodes, err = p.Rank(context.Background(), &Request{})
+	s.Require().NoError(err)
+	s.Equal([]string{"pve3", "pve1"}, nodes)
+	s.Equal([]BreakerStatus{{
+		Node:      "pve2",
+		State:     BreakerOpen,
+		Failures:  2,
+		OpenUntil: s.now.Add(time.Minute),
+		LastError: "clone failed: storage full",
+	}}, p.Breakers())
+
+	// After the cool-down a single trial guest goes to the node.
+	s.now = s.now.Add(time.Minute)
+	node, err := p.Place(context.Background(), &Request{Name: "trial"})
+	s.Require().NoError(err)
+	s.Equal("pve2", node)
+	s.Equal(BreakerHalfOpen, p.Breakers()[0].State)
+
+	nodes, err = p.Rank(context.Background(), &Request{})
+	s.Require().NoError(err)
+	s.NotContains(nodes, "pve2", "only one trial guest must go to a half-open node")
+
+	p.Failed("pve2", boom)
+	s.Equal(BreakerOpen, p.Breakers()[0].State, "a failed trial must open the breaker again")
+
+	s.now = s.now.Add(time.Minute)
+	_, err = p.Place(context.Background(), &Request{Name: "trial"})
+	s.Require().NoError(err)
+	p.Succeeded("pve2")
+	s.Empty(p.Breakers())
+}
+
+func (s *PlacerSuite) TestRankExcludesNodes() {
+	nodes, err := s.placer(Config{}, Spread{}).Rank(context.Background(), &Request{Exclude: []string{"pve2"}})
+	s.Require().NoError(err)
+	s.Equal([]string{"pve3", "pve1"}, nodes)
+}
@@ -0,0 +1,373 @@
+package placement
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+)
+
+// ErrNoCapacity is returned when no node can take the guest.
+var ErrNoCapacity = errors.New("no node has capacity")
+
+// Config is the configuration for the Placer.
+type Config struct {
+	// MaxLoad is the CPU load above which a node takes no new guests, from 0 to 1. Defaults to 0.9.
+	MaxLoad float64
+
+	// MemoryReserve is the memory in bytes that is kept free on every node for the host itself.
+	MemoryReserve int64
+
+	// Storage is the storage whose headroom is checked, e.g. the storage the clones are written to. Nothing is
+	// checked when it is empty.
+	Storage string
+
+	// StorageHeadroom is the share of the storage that must stay free after the guest is placed, from 0 to 1.
+	StorageHeadroom float64
+
+	// MaxVMs is the maximum number of guests on every node. Zero is unlimited.
+	MaxVMs int
+
+	// MaxCores is the maximum number of vCPUs allocated to the guests of every node. Zero is unlimited.
+	MaxCores int
+
+	// MaxMemory is the maximum memory in bytes allocated to the guests of every node. Zero is unlimited.
+	MaxMemory int64
+
+	// Nodes is the config of the individual nodes, by node name.
+	Nodes map[string]NodeConfig
+
+	// PendingTTL is how long a placed guest is counted against its node at most while it is not running yet.
+	// Defaults to 5 minutes.
+	PendingTTL time.Duration
+
+	// Breaker is the configuration of the circuit breakers that take failing nodes out of placement.
+	Breaker BreakerConfig
+}
+
+// Placer picks the node a new guest is created on.
+type Placer struct {
+	cfg Config
+
+	// px is the Proxmox client the cluster resources are read with.
+	px proxmox.Client
+
+	// strategy orders the nodes the guest fits on.
+	strategy Strategy
+
+	// now returns the current time.
+	now func() time.Time
+
+	// mut guards pending, placed and breakers.
+	mut sync.Mutex
+
+	// pending are the guests that were placed but may not be in the cluster resources yet, by node.
+	pending map[string][]pending
+
+	// placed is the sequence number of the last guest that was placed.
+	placed uint64
+
+	// breakers are the circuit breakers of the nodes that failed since their last success, by node.
+	breakers map[string]*breaker
+}
+
+// pending is a placed guest that is counted against its node until it is running or expires.
+type pending struct {
+	name    string
+	mem     int64
+	cores   int
+	expires time.Time
+
+	// seq is the sequence number of the placement.
+	seq uint64
+}
+
+// NewPlacer creates a new Placer.
+func NewPlacer(cfg Config, px proxmox.Client, strategy Strategy) *Placer {
+	if cfg.MaxLoad == 0 {
+		cfg.MaxLoad = 0.9
+	}
+	if cfg.PendingTTL == 0 {
+		cfg.PendingTTL = 5 * time.Minute
+	}
+	if cfg.Breaker.Failures == 0 {
+		cfg.Breaker.Failures = 3
+	}
+	if cfg.Breaker.CoolDown == 0 {
+		cfg.Breaker.CoolDown = 5 * time.Minute
+	}
+
+	return &Placer{
+		cfg:      cfg,
+		px:       px,
+		strategy: strategy,
+		now:      time.Now,
+		pending:  make(map[string][]pending),
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// Place picks the node for the guest and counts the guest against it until it shows up in the cluster resources.
+// Guests placed at the same time may have taken the node since the nodes were ranked, so the node is checked again
+// with them counted before the guest is counted against it, and the nodes are ranked again if it no longer fits. A
+// guest that is counted against a node already, e.g. because it was reserved when its runner was admitted, keeps it.
+func (p *Placer) Place(ctx context.Context, req *Request) (string, error) {
+	if node, ok := p.reserved(req); ok {
+		return node, nil
+	}
+
+	for {
+		nodes, seq, err := p.rank(ctx, req)
+		if err != nil {
+			return "", err
+		}
+
+		if node, ok := p.reserve(nodes[0], seq, req); ok {
+			return node, nil
+		}
+	}
+}
+
+// reserve counts the guest against the node, unless the guests placed after the node was read leave no room for it.
+func (p *Placer) reserve(n *Node, seq uint64, req *Request) (string, bool) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	now := *n
+	for _, g := range p.pending[n.Name] {
+		if g.seq > seq {
+			now.VMs++
+			now.Cores += g.cores
+			now.Allocated += g.mem
+			now.Mem += g.mem
+		}
+	}
+	if reason := p.reject(&now, req); reason != "" {
+		slog.Debug("node taken by a concurrent placement, ranking again", slog.String("node", n.Name), slog.String("reason", reason))
+		return "", false
+	}
+
+	p.placed++
+	p.pending[n.Name] = append(p.pending[n.Name], pending{
+		name:    req.Name,
+		mem:     req.Memory,
+		cores:   req.Cores,
+		expires: p.now().Add(p.cfg.PendingTTL),
+		seq:     p.placed,
+	})
+	p.trial(n.Name)
+
+	return n.Name, true
+}
+
+// reserved returns the node the guest is counted against, unless it is one of the nodes the guest is kept off.
+func (p *Placer) reserved(req *Request) (string, bool) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	now := p.now()
+	for node, placed := range p.pending {
+		if slices.Contains(req.Exclude, node) {
+			continue
+		}
+		for _, g := range placed {
+			if g.name == req.Name && !now.After(g.expires) {
+				return node, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Rank returns the nodes the guest fits on, the most preferred first. It returns ErrNoCapacity when the guest fits on
+// none.
+func (p *Placer) Rank(ctx context.Context, req *Request) ([]string, error) {
+	nodes, _, err := p.rank(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = n.Name
+	}
+	return names, nil
+}
+
+// rank returns the nodes the guest fits on, the most preferred first, and the sequence number of the last placed
+// guest they count.
+func (p *Placer) rank(ctx context.Context, req *Request) ([]*Node, uint64, error) {
+	nodes, seq, err := p.nodes(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fits := make([]*Node, 0, len(nodes))
+	reasons := make([]string, 0)
+	for _, n := range nodes {
+		reason := p.reject(n, req)
+		if reason == "" {
+			p.mut.Lock()
+			reason = p.tripped(n.Name)
+			p.mut.Unlock()
+		}
+		if reason != "" {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", n.Name, reason))
+			continue
+		}
+		fits = append(fits, n)
+	}
+
+	if len(fits) == 0 {
+		return nil, 0, fmt.Errorf("%w: %s", ErrNoCapacity, strings.Join(reasons, "; "))
+	}
+
+	p.strategy.Rank(fits, req)
+
+	names := make([]string, len(fits))
+	for i, n := range fits {
+		names[i] = n.Name
+	}
+	slog.Debug("ranked nodes", slog.Any("nodes", names), slog.Any("rejected", reasons))
+
+	return fits, seq, nil
+}
+
+// reject returns why the guest cannot be placed on the node, or an empty string if it can.
+func (p *Placer) reject(n *Node, req *Request) string {
+	switch {
+	case slices.Contains(req.Exclude, n.Name):
+		return "excluded after a failed attempt"
+	case !n.HasLabels(req.NodeSelector):
+		return "does not match the node selector"
+	case n.MaxVMs > 0 && n.VMs >= n.MaxVMs:
+		return fmt.Sprintf("has %d of %d guests", n.VMs, n.MaxVMs)
+	case n.MaxCores > 0 && n.Cores+req.Cores > n.MaxCores:
+		return fmt.Sprintf("has %d of %d vcpus allocated", n.Cores, n.MaxCores)
+	case n.MaxAllocated > 0 && n.Allocated+req.Memory > n.MaxAllocated:
+		return fmt.Sprintf("has %d of %d bytes of memory allocated", n.Allocated, n.MaxAllocated)
+	case n.CPU >= p.cfg.MaxLoad:
+		return fmt.Sprintf("cpu load %.2f is above %.2f", n.CPU, p.cfg.MaxLoad)
+	case n.FreeMem()-p.cfg.MemoryReserve < req.Memory:
+		return fmt.Sprintf("%d bytes of memory free", n.FreeMem()-p.cfg.MemoryReserve)
+	case req.Cores > 0 && float64(req.Cores) > n.MaxCPU:
+		return fmt.Sprintf("has %.0f cpus", n.MaxCPU)
+	case p.cfg.Storage != "" && n.StorageTotal == 0:
+		return fmt.Sprintf("storage %s is not available", p.cfg.Storage)
+	case p.cfg.Storage != "" && float64(n.StorageFree-req.Disk) < p.cfg.StorageHeadroom*float64(n.StorageTotal):
+		return fmt.Sprintf("%d bytes free on %s", n.StorageFree, p.cfg.Storage)
+	default:
+		return ""
+	}
+}
+
+// nodes returns the online nodes of the cluster from the cluster resources, with the guests that were placed but are
+// not running yet counted against them, and the sequence number of the last placed guest counted.
+func (p *Placer) nodes(ctx context.Context) ([]*Node, uint64, error) {
+	resources, err := p.px.ClusterResources(ctx, "")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	byName := make(map[string]*Node)
+	order := make([]*Node, 0)
+	for _, r := range resources {
+		if r.Type != string(proxmox.ResourceTypeNode) || r.Status != "online" {
+			continue
+		}
+
+		cfg := p.cfg.Nodes[r.Node]
+		n := &Node{
+			Name:         r.Node,
+			Labels:       cfg.Labels,
+			CPU:          r.CPU,
+			MaxCPU:       r.MaxCPU,
+			Mem:          r.Mem,
+			MaxMem:       r.MaxMem,
+			MaxVMs:       cmp.Or(cfg.MaxVMs, p.cfg.MaxVMs),
+			MaxCores:     cmp.Or(cfg.MaxCores, p.cfg.MaxCores),
+			MaxAllocated: cmp.Or(int64(cfg.MaxMemoryMB)<<20, p.cfg.MaxMemory),
+		}
+		byName[r.Node] = n
+		order = append(order, n)
+	}
+
+	// A guest only uses memory once it is running, so placed guests are counted until they are.
+	guests := make(map[string]*proxmox.Resource)
+	for _, r := range resources {
+		n, ok := byName[r.Node]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case (r.Type == string(proxmox.GuestTypeQemu) || r.Type == string(proxmox.GuestTypeLXC)) && r.Template == 0:
+			n.VMs++
+			n.Cores += int(r.MaxCPU)
+			n.Allocated += r.MaxMem
+			guests[r.Node+"/"+r.Name] = r
+		case r.Type == string(proxmox.ResourceTypeStorage) && r.Storage == p.cfg.Storage:
+			n.StorageFree = r.MaxDisk - r.Disk
+			n.StorageTotal = r.MaxDisk
+		}
+	}
+
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	now := p.now()
+	for name, placed := range p.pending {
+		live := placed[:0]
+		for _, g := range placed {
+			guest, exists := guests[name+"/"+g.name]
+			if now.After(g.expires) || (exists && guest.Status == "running") {
+				continue
+			}
+			live = append(live, g)
+
+			if n, ok := byName[name]; ok {
+				n.Mem += g.mem
+				if !exists {
+					n.VMs++
+					n.Cores += g.cores
+					n.Allocated += g.mem
+				}
+			}
+		}
+
+		if len(live) == 0 {
+			delete(p.pending, name)
+		} else {
+			p.pending[name] = live
+		}
+	}
+
+	if len(order) == 0 {
+		slog.Warn("no online nodes in the cluster resources", slog.Int("resources", len(resources)))
+	}
+
+	return order, p.placed, nil
+}
+
+// Forget stops counting a placed guest against the node, or against any node if node is empty, e.g. because creating
+// it failed.
+func (p *Placer) Forget(node, name string) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	for n, placed := range p.pending {
+		if node != "" && n != node {
+			continue
+		}
+		p.pending[n] = slices.DeleteFunc(placed, func(g pending) bool {
+			return g.name == name
+		})
+	}
+}
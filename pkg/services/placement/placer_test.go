@@ -0,0 +1,294 @@
+package placement
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const gib = int64(1 << 30)
+
+type fakeProxmox struct {
+	proxmox.Client
+
+	resources []*proxmox.Resource
+}
+
+func (f *fakeProxmox) ClusterResources(context.Context, proxmox.ResourceType) ([]*proxmox.Resource, error) {
+	return f.resources, nil
+}
+
+func node(name string, cpu float64, mem, maxMem int64) *proxmox.Resource {
+	return &proxmox.Resource{ID: "node/" + name, Type: "node", Node: name, Status: "online", CPU: cpu, MaxCPU: 16, Mem: mem, MaxMem: maxMem}
+}
+
+func storage(nodeName, name string, used, total int64) *proxmox.Resource {
+	return &proxmox.Resource{ID: "storage/" + nodeName + "/" + name, Type: "storage", Node: nodeName, Storage: name, Disk: used, MaxDisk: total}
+}
+
+func guest(nodeName, name string, status string) *proxmox.Resource {
+	return &proxmox.Resource{Type: "qemu", Node: nodeName, Name: name, Status: status}
+}
+
+type PlacerSuite struct {
+	suite.Suite
+
+	px  *fakeProxmox
+	now time.Time
+}
+
+func TestPlacerSuite(t *testing.T) {
+	suite.Run(t, new(PlacerSuite))
+}
+
+func (s *PlacerSuite) SetupTest() {
+	s.now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.px = &fakeProxmox{resources: []*proxmox.Resource{
+		node("pve1", 0.2, 48*gib, 64*gib),
+		node("pve2", 0.1, 16*gib, 64*gib),
+		node("pve3", 0.5, 32*gib, 64*gib),
+		storage("pve1", "ceph", 100*gib, 1000*gib),
+		storage("pve2", "ceph", 100*gib, 1000*gib),
+		storage("pve3", "ceph", 100*gib, 1000*gib),
+	}}
+}
+
+func (s *PlacerSuite) placer(cfg Config, strategy Strategy) *Placer {
+	p := NewPlacer(cfg, s.px, strategy)
+	p.now = func() time.Time { return s.now }
+	return p
+}
+
+func (s *PlacerSuite) TestSpread() {
+	nodes, err := s.placer(Config{}, Spread{}).Rank(context.Background(), &Request{Memory: 4 * gib})
+	s.Require().NoError(err)
+	s.Equal([]string{"pve2", "pve3", "pve1"}, nodes)
+}
+
+func (s *PlacerSuite) TestBinPack() {
+	nodes, err := s.placer(Config{}, BinPack{}).Rank(context.Background(), &Request{Memory: 4 * gib})
+	s.Require().NoError(err)
+	s.Equal([]string{"pve1", "pve3", "pve2"}, nodes)
+}
+
+func (s *PlacerSuite) TestAffinity() {
+	p := s.placer(Config{Nodes: map[string]NodeConfig{
+		"pve1": {Labels: []string{"ssd", "gpu"}},
+		"pve3": {Labels: []string{"ssd"}},
+	}}, Affinity{Then: Spread{}})
+
+	nodes, err := p.Rank(context.Background(), &Request{PreferredLabels: []string{"ssd", "gpu"}})
+	s.Require().NoError(err)
+	s.Equal([]string{"pve1", "pve3", "pve2"}, nodes)
+
+	nodes, err = p.Rank(context.Background(), &Request{NodeSelector: []string{"ssd"}})
+	s.Require().NoError(err)
+	s.Equal([]string{"pve3", "pve1"}, nodes, "nodes without the selected labels must be filtered out")
+}
+
+func (s *PlacerSuite) TestRejects() {
+	s.px.resources = append(s.px.resources,
+		guest("pve2", "a", "running"),
+		guest("pve2", "b", "running"),
+		&proxmox.Resource{Type: "qemu", Node: "pve2", Name: "template", Template: 1},
+	)
+	s.px.resources[2].CPU = 0.95
+
+	p := s.placer(Config{
+		MaxLoad:         0.9,
+		MemoryReserve:   4 * gib,
+		Storage:         "ceph",
+		StorageHeadroom: 0.1,
+		Nodes:           map[string]NodeConfig{"pve2": {MaxVMs: 2}},
+	}, Spread{})
+
+	_, err := p.Rank(context.Background(), &Request{Memory: 16 * gib})
+	s.ErrorIs(err, ErrNoCapacity)
+	s.ErrorContains(err, "pve1: 12884901888 bytes of memory free")
+	s.ErrorContains(err, "pve2: has 2 of 2 guests")
+	s.ErrorContains(err, "pve3: cpu load 0.95 is above 0.90")
+
+	nodes, err := p.Rank(context.Background(), &Request{Memory: 8 * gib})
+	s.Require().NoError(err)
+	s.Equal([]string{"pve1"}, nodes)
+}
+
+func (s *PlacerSuite) TestAllocationLimits() {
+	a := guest("pve1", "a", "running")
+	a.MaxCPU, a.MaxMem = 6, 12*gib
+	b := guest("pve2", "b", "stopped")
+	b.MaxCPU, b.MaxMem = 2, 30*gib
+	s.px.resources = append(s.px.resources, a, b)
+
+	p := s.placer(Config{
+		MaxCores:  8,
+		MaxMemory: 32 * gib,
+		Nodes:     map[string]NodeConfig{"pve3": {MaxCores: 2}},
+	}, BinPack{})
+
+	_, err := p.Rank(context.Background(), &Request{Cores: 4, Memory: 4 * gib})
+	s.ErrorIs(err, ErrNoCapacity)
+	s.ErrorContains(err, "pve1: has 6 of 8 vcpus allocated")
+	s.ErrorContains(err, "pve2: has 32212254720 of 34359738368 bytes of memory allocated",
+		"stopped guests must count against the limits")
+	s.ErrorContains(err, "pve3: has 0 of 2 vcpus allocated")
+
+	node, err := p.Place(context.Background(), &Request{Name: "pgr-1", Cores: 2, Memory: 2 * gib})
+	s.Require().NoError(err)
+	s.Equal("pve1", node)
+
+	nodes, err := p.Rank(context.Background(), &Request{Cores: 2, Memory: 2 * gib})
+	s.Require().NoError(err)
+	s.Equal([]string{"pve3", "pve2"}, nodes, "a placed guest must count against its node's limits")
+}
+
+func (s *PlacerSuite) TestStorageHeadroom() {
+	s.px.resources[3].Disk = 880 * gib
+	s.px.resources = s.px.resources[:5]
+
+	nodes, err := s.placer(Config{Storage: "ceph", StorageHeadroom: 0.1}, Spread{}).Rank(context.Background(), &Request{Disk: 50 * gib})
+	s.Require().NoError(err)
+	s.Equal([]string{"pve2"}, nodes, "pve1 would drop below the headroom and pve3 does not have the storage")
+}
+
+func (s *PlacerSuite) TestPlaceCountsPendingGuests() {
+	p := s.placer(Config{MaxVMs: 1}, BinPack{})
+
+	node, err := p.Place(context.Background(), &Request{Name: "pgr-1", Memory: 8 * gib})
+	s.Require().NoError(err)
+	s.Equal("pve1", node)
+
+	node, err = p.Place(context.Background(), &Request{Name: "pgr-2", Memory: 8 * gib})
+	s.Require().NoError(err)
+	s.Equal("pve3", node, "the first guest must count against its node before it shows up")
+
+	// The first guest shows up but is not running yet, so its memory still counts.
+	s.px.resources = append(s.px.resources, guest("pve1", "pgr-1", "stopped"))
+	p.cfg.MaxVMs = 0
+	nodes, err := p.Rank(context.Background(), &Request{Memory: 10 * gib})
+	s.Require().NoError(err)
+	s.NotContains(nodes, "pve1")
+
+	p.Forget("pve1", "pgr-1")
+	nodes, err = p.Rank(context.Background(), &Request{Memory: 10 * gib})
+	s.Require().NoError(err)
+	s.Contains(nodes, "pve1")
+}
+
+// barrierStrategy holds the first callers until all of them have ranked the nodes, so that they all rank them before
+// any guest is counted against its node.
+type barrierStrategy struct {
+	Strategy
+
+	callers int32
+	calls   atomic.Int32
+	arrived sync.WaitGroup
+}
+
+func (b *barrierStrategy) Rank(nodes []*Node, req *Request) {
+	b.Strategy.Rank(nodes, req)
+	if b.calls.Add(1) <= b.callers {
+		b.arrived.Done()
+		b.arrived.Wait()
+	}
+}
+
+func (s *PlacerSuite) TestConcurrentPlacesRespectNodeLimits() {
+	strategy := &barrierStrategy{Strategy: Spread{}, callers: 5}
+	strategy.arrived.Add(int(strategy.callers))
+	p := s.placer(Config{MaxVMs: 1}, strategy)
+
+	nodes := make(chan string, strategy.callers)
+	errs := make(chan error, strategy.callers)
+	for i := range strategy.callers {
+		go func() {
+			node, err := p.Place(context.Background(), &Request{Name: fmt.Sprintf("pgr-%d", i), Memory: gib})
+			if err != nil {
+				errs <- err
+				return
+			}
+			nodes <- node
+		}()
+	}
+
+	placed := make([]string, 0)
+	for range strategy.callers {
+		select {
+		case node := <-nodes:
+			placed = append(placed, node)
+		case err := <-errs:
+			s.ErrorIs(err, ErrNoCapacity)
+		}
+	}
+	s.ElementsMatch([]string{"pve1", "pve2", "pve3"}, placed, "every node takes a single guest")
+}
+
+func (s *PlacerSuite) TestPendingGuestsExpire() {
+	p := s.placer(Config{MaxVMs: 1}, Spread{})
+
+	for i := range 3 {
+		_, err := p.Place(context.Background(), &Request{Name: fmt.Sprintf("pgr-%d", i), Memory: gib})
+		s.Require().NoError(err)
+	}
+
+	_, err := p.Place(context.Background(), &Request{Name: "pgr-3", Memory: gib})
+	s.ErrorIs(err, ErrNoCapacity)
+
+	s.now = s.now.Add(6 * time.Minute)
+	_, err = p.Place(context.Background(), &Request{Name: "pgr-3", Memory: gib})
+	s.NoError(err)
+}
+
+func (s *PlacerSuite) TestPlaceKeepsReservedNode() {
+	p := s.placer(Config{MaxVMs: 1}, Spread{})
+
+	node, err := p.Place(context.Background(), &Request{Name: "pgr-1", Memory: gib})
+	s.Require().NoError(err)
+
+	again, err := p.Place(context.Background(), &Request{Name: "pgr-1", Memory: gib})
+	s.Require().NoError(err)
+	s.Equal(node, again, "the guest keeps the node it was reserved")
+
+	again, err = p.Place(context.Background(), &Request{Name: "pgr-1", Memory: gib, Exclude: []string{node}})
+	s.Require().NoError(err)
+	s.NotEqual(node, again, "the guest is kept off the failed node")
+
+	p.Forget("", "pgr-1")
+	for i := 2; i <= 4; i++ {
+		_, err = p.Place(context.Background(), &Request{Name: fmt.Sprintf("pgr-%d", i), Memory: gib})
+		s.NoError(err, "the guest is no longer counted against any node")
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Strategy
+		wantErr bool
+	}{
+		{name: "", want: Spread{}},
+		{name: StrategySpread, want: Spread{}},
+		{name: StrategyBinPack, want: BinPack{}},
+		{name: StrategyAffinity, want: Affinity{Then: Spread{}}},
+		{name: "random", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewStrategy(tt.name)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
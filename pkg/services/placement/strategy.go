@@ -0,0 +1,95 @@
+package placement
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Strategy orders the nodes a guest fits on by preference.
+type Strategy interface {
+	// Rank sorts the nodes, the most preferred first.
+	Rank(nodes []*Node, req *Request)
+}
+
+const (
+	// StrategySpread spreads guests over the nodes, preferring the node with the most free memory.
+	StrategySpread = "spread"
+
+	// StrategyBinPack packs guests onto as few nodes as possible, preferring the node with the least free memory.
+	StrategyBinPack = "bin-pack"
+
+	// StrategyAffinity prefers the nodes that carry the most of the requested labels, and spreads between equals.
+	StrategyAffinity = "affinity"
+)
+
+// NewStrategy returns the strategy with the given name.
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case StrategySpread, "":
+		return Spread{}, nil
+	case StrategyBinPack:
+		return BinPack{}, nil
+	case StrategyAffinity:
+		return Affinity{Then: Spread{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown placement strategy %q", name)
+	}
+}
+
+// Spread prefers the node with the largest share of its memory free, then the node with the lowest CPU load.
+type Spread struct{}
+
+func (Spread) Rank(nodes []*Node, _ *Request) {
+	slices.SortStableFunc(nodes, func(a, b *Node) int {
+		if c := cmp.Compare(freeShare(b), freeShare(a)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.CPU, b.CPU)
+	})
+}
+
+// BinPack prefers the node with the least free memory, so that the other nodes stay free for large guests or
+// maintenance.
+type BinPack struct{}
+
+func (BinPack) Rank(nodes []*Node, _ *Request) {
+	slices.SortStableFunc(nodes, func(a, b *Node) int {
+		if c := cmp.Compare(a.FreeMem(), b.FreeMem()); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.CPU, b.CPU)
+	})
+}
+
+// Affinity prefers the nodes that carry the most of the preferred labels of the request, and ranks nodes with the
+// same number of matches with Then.
+type Affinity struct {
+	Then Strategy
+}
+
+func (s Affinity) Rank(nodes []*Node, req *Request) {
+	s.Then.Rank(nodes, req)
+	slices.SortStableFunc(nodes, func(a, b *Node) int {
+		return cmp.Compare(matches(b, req.PreferredLabels), matches(a, req.PreferredLabels))
+	})
+}
+
+// freeShare returns the share of the memory of the node that is free.
+func freeShare(n *Node) float64 {
+	if n.MaxMem == 0 {
+		return 0
+	}
+	return float64(n.FreeMem()) / float64(n.MaxMem)
+}
+
+// matches returns how many of the labels the node carries.
+func matches(n *Node, labels []string) int {
+	count := 0
+	for _, l := range labels {
+		if slices.Contains(n.Labels, l) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,104 @@
+package placement
+
+import (
+	"slices"
+)
+
+// Request is what a new guest needs from the node it is placed on.
+type Request struct {
+	// Name is the name of the guest. It is how a placed guest is recognised in the cluster resources.
+	Name string
+
+	// Memory is the memory of the guest in bytes.
+	Memory int64
+
+	// Cores is the number of vCPUs of the guest.
+	Cores int
+
+	// Disk is the disk space the guest needs on the placement storage, in bytes.
+	Disk int64
+
+	// NodeSelector are labels a node must carry to be considered.
+	NodeSelector []string
+
+	// PreferredLabels are labels that make a node more attractive to the affinity strategy.
+	PreferredLabels []string
+
+	// Exclude are nodes the guest must not be placed on, e.g. because creating it failed there.
+	Exclude []string
+}
+
+// Node is the state of a node as used for placement.
+type Node struct {
+	// Name is the name of the node.
+	Name string
+
+	// Labels are the labels the node carries in the config.
+	Labels []string
+
+	// CPU is the CPU load of the node, from 0 to 1.
+	CPU float64
+
+	// MaxCPU is the number of CPUs of the node.
+	MaxCPU float64
+
+	// Mem is the memory in use on the node in bytes, including memory reserved for guests still starting.
+	Mem int64
+
+	// MaxMem is the memory of the node in bytes.
+	MaxMem int64
+
+	// VMs is the number of guests on the node, including guests still being created.
+	VMs int
+
+	// MaxVMs is the maximum number of guests on the node. Zero is unlimited.
+	MaxVMs int
+
+	// Cores is the number of vCPUs allocated to the guests of the node, including guests still being created.
+	Cores int
+
+	// MaxCores is the maximum number of vCPUs allocated to the guests of the node. Zero is unlimited.
+	MaxCores int
+
+	// Allocated is the memory allocated to the guests of the node in bytes, including guests still being created.
+	Allocated int64
+
+	// MaxAllocated is the maximum memory allocated to the guests of the node in bytes. Zero is unlimited.
+	MaxAllocated int64
+
+	// StorageFree is the free space of the placement storage on the node in bytes.
+	StorageFree int64
+
+	// StorageTotal is the size of the placement storage on the node in bytes. Zero when no storage is checked.
+	StorageTotal int64
+}
+
+// FreeMem returns the memory that is not in use on the node.
+func (n *Node) FreeMem() int64 {
+	return n.MaxMem - n.Mem
+}
+
+// HasLabels returns true if the node carries every one of the labels.
+func (n *Node) HasLabels(labels []string) bool {
+	for _, l := range labels {
+		if !slices.Contains(n.Labels, l) {
+			return false
+		}
+	}
+	return true
+}
+
+// NodeConfig is the placement config of a single node.
+type NodeConfig struct {
+	// MaxVMs is the maximum number of guests on the node. Overrides Config.MaxVMs.
+	MaxVMs int `mapstructure:"max_vms"`
+
+	// MaxCores is the maximum number of vCPUs allocated to the guests of the node. Overrides Config.MaxCores.
+	MaxCores int `mapstructure:"max_cores"`
+
+	// MaxMemoryMB is the maximum memory in MiB allocated to the guests of the node. Overrides Config.MaxMemory.
+	MaxMemoryMB int `mapstructure:"max_memory_mb"`
+
+	// Labels are the labels the node carries, e.g. ssd or gpu.
+	Labels []string `mapstructure:"labels"`
+}
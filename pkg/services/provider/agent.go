@@ -0,0 +1,127 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+)
+
+const (
+	// BootstrapCloudInit installs the runner in a VM with cloud-init user data.
+	BootstrapCloudInit = "cloud-init"
+
+	// BootstrapAgent installs the runner in a VM by running the bootstrap script through the QEMU guest agent, for
+	// templates without cloud-init.
+	BootstrapAgent = "agent"
+)
+
+// agentBootstrapCommand writes the bootstrap script from its standard input and starts it in the background. A VM that
+// already has the script is left alone, so that a retry never starts the runner twice with its single-use config.
+var agentBootstrapCommand = []string{"/bin/sh", "-c", `set -eu
+[ ! -e /root/bootstrap.sh ] || exit 0
+umask 077
+cat > /root/bootstrap.sh.tmp
+chmod 0700 /root/bootstrap.sh.tmp
+mv /root/bootstrap.sh.tmp /root/bootstrap.sh
+nohup /root/bootstrap.sh >/var/log/runner-bootstrap.log 2>&1 &`}
+
+// waitForAgent waits until the guest agent of the runner's VM answers and reports an address, which it records on
+// the runner. A VM that is running is not necessarily booted, the agent only answers once it is.
+func (q *Qemu) waitForAgent(ctx context.Context, runner *scaler.Runner) error {
+	ctx, cancel := context.WithTimeout(ctx, q.cfg.AgentTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(q.cfg.AgentPollInterval)
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		address, err := q.agentAddress(ctx, runner)
+		if err == nil {
+			runner.Address = address
+			return nil
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("vm %d is not ready after %s: %w", runner.VMID, q.cfg.AgentTimeout, lastErr)
+		case <-ticker.C:
+		}
+	}
+}
+
+// agentAddress pings the guest agent of the runner's VM and returns the address of the VM.
+func (q *Qemu) agentAddress(ctx context.Context, runner *scaler.Runner) (string, error) {
+	if err := q.px.AgentPing(ctx, runner.Node, runner.VMID); err != nil {
+		return "", err
+	}
+
+	ifaces, err := q.px.AgentNetworkInterfaces(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return "", err
+	}
+
+	address, ok := primaryAddress(ifaces)
+	if !ok {
+		return "", fmt.Errorf("vm %d has no address yet", runner.VMID)
+	}
+	return address, nil
+}
+
+// bootstrapWithAgent runs the bootstrap script in the runner's VM through the guest agent.
+func (q *Qemu) bootstrapWithAgent(ctx context.Context, runner *scaler.Runner, script []byte) error {
+	status, err := q.px.AgentRun(ctx, runner.Node, runner.VMID, agentBootstrapCommand, string(script))
+	if err != nil {
+		return fmt.Errorf("bootstrap vm %d: %w", runner.VMID, err)
+	} else if !status.OK() {
+		return fmt.Errorf("bootstrap vm %d: exited with %d: %s", runner.VMID, status.ExitCode, status.ErrData)
+	}
+
+	slog.Debug("runner bootstrapped through the guest agent",
+		slog.String(logging.KeyRunner, runner.Name),
+		slog.Int(logging.KeyVMID, runner.VMID),
+	)
+	return nil
+}
+
+// RunCommand implements scaler.CommandRunner through the guest agent of the runner's VM.
+func (q *Qemu) RunCommand(ctx context.Context, runner *scaler.Runner, command []string) (*scaler.CommandResult, error) {
+	status, err := q.px.AgentRun(ctx, runner.Node, runner.VMID, command, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &scaler.CommandResult{
+		ExitCode: status.ExitCode,
+		Stdout:   status.OutData,
+		Stderr:   status.ErrData,
+	}, nil
+}
+
+// primaryAddress returns the address the VM is reached at: the first global IPv4 address of its interfaces, or the
+// first global IPv6 address if it has none.
+func primaryAddress(ifaces []*proxmox.AgentInterface) (string, bool) {
+	var v6 string
+	for _, iface := range ifaces {
+		for _, ip := range iface.IPAddresses {
+			addr, err := netip.ParseAddr(ip.Address)
+			if err != nil || !addr.IsGlobalUnicast() {
+				continue
+			}
+
+			if addr.Is4() {
+				return addr.String(), true
+			} else if v6 == "" {
+				v6 = addr.String()
+			}
+		}
+	}
+	return v6, v6 != ""
+}
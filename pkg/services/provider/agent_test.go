@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeAgent is an in-memory cluster whose VMs run the guest agent.
+type fakeAgent struct {
+	*fakeProxmox
+
+	down   map[string]bool
+	ifaces []*proxmox.AgentInterface
+	inputs map[int][]string
+	status *proxmox.AgentExecStatus
+}
+
+func newFakeAgent() *fakeAgent {
+	return &fakeAgent{
+		fakeProxmox: newFakeProxmox(),
+		down:        make(map[string]bool),
+		ifaces: []*proxmox.AgentInterface{
+			{Name: "lo", IPAddresses: []*proxmox.AgentIPAddress{{Address: "127.0.0.1", Type: "ipv4", Prefix: 8}}},
+			{Name: "eth0", IPAddresses: []*proxmox.AgentIPAddress{{Address: "10.0.0.12", Type: "ipv4", Prefix: 24}}},
+		},
+		inputs: make(map[int][]string),
+		status: &proxmox.AgentExecStatus{Exited: 1},
+	}
+}
+
+func (f *fakeAgent) AgentPing(_ context.Context, node string, vmid int) error {
+	if f.down[node] || !f.vms[vmid].IsRunning() {
+		return errors.New("QEMU guest agent is not running")
+	}
+	return nil
+}
+
+func (f *fakeAgent) AgentNetworkInterfaces(context.Context, string, int) ([]*proxmox.AgentInterface, error) {
+	return f.ifaces, nil
+}
+
+func (f *fakeAgent) AgentRun(_ context.Context, _ string, vmid int, _ []string, input string) (*proxmox.AgentExecStatus, error) {
+	f.inputs[vmid] = append(f.inputs[vmid], input)
+	return f.status, nil
+}
+
+type AgentSuite struct {
+	suite.Suite
+
+	px       *fakeAgent
+	provider *Qemu
+}
+
+func TestAgentSuite(t *testing.T) {
+	suite.Run(t, new(AgentSuite))
+}
+
+func (s *AgentSuite) SetupTest() {
+	snippets, err := NewDirSnippetStore(s.T().TempDir(), "shared")
+	s.Require().NoError(err)
+
+	s.px = newFakeAgent()
+	s.provider, err = NewQemu(QemuConfig{
+		Node:              "pve1",
+		TemplateID:        9000,
+		User:              "runner",
+		Bootstrap:         BootstrapAgent,
+		AgentTimeout:      50 * time.Millisecond,
+		AgentPollInterval: time.Millisecond,
+	}, s.px, snippets, nil, newVMIDAllocator(s.T(), VMIDConfig{}, s.px))
+	s.Require().NoError(err)
+}
+
+func (s *AgentSuite) TestProvisionBootstrapsThroughAgent() {
+	runner := &scaler.Runner{Name: "pgr-1"}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Equal("10.0.0.12", runner.Address)
+	s.Empty(s.px.cloudInit, "the template has no cloud-init")
+	s.Require().Len(s.px.inputs[100], 1)
+	s.Contains(s.px.inputs[100][0], "c2VjcmV0")
+}
+
+func (s *AgentSuite) TestProvisionFailsWhenBootstrapFails() {
+	s.px.status = &proxmox.AgentExecStatus{Exited: 1, ExitCode: 1, ErrData: "cat: write error: No space left on device"}
+
+	err := s.provider.Provision(context.Background(), &scaler.Runner{Name: "pgr-1"}, "c2VjcmV0")
+	s.EqualError(err, "bootstrap vm 100: exited with 1: cat: write error: No space left on device")
+}
+
+func (s *AgentSuite) TestProvisionRetriesWhenAgentNeverAnswers() {
+	s.px.vms[9000] = &proxmox.Guest{VMID: 9000, Name: "template", MaxMem: 4 << 30, CPUs: 2}
+	placer := &fakePlacer{nodes: []string{"pve2", "pve3"}}
+	s.provider.placer = placer
+	s.provider.cfg.MinBackoff = time.Millisecond
+	s.px.down["pve2"] = true
+
+	runner := &scaler.Runner{Name: "pgr-1"}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Equal("pve3", runner.Node)
+	s.Equal([]string{"pve2"}, placer.failed, "a vm whose agent never answers is tried on another node")
+	s.Equal([]int{100}, s.px.deleted)
+	s.Len(s.px.inputs[runner.VMID], 1, "only the vm that booted is bootstrapped")
+}
+
+func (s *AgentSuite) TestRunCommand() {
+	s.px.status = &proxmox.AgentExecStatus{Exited: 1, ExitCode: 3, OutData: "inactive\n"}
+	runner := &scaler.Runner{Name: "pgr-1", Node: "pve1", VMID: 100}
+
+	res, err := s.provider.RunCommand(context.Background(), runner, []string{"systemctl", "is-active", scaler.RunnerServiceName})
+	s.Require().NoError(err)
+	s.Equal(&scaler.CommandResult{ExitCode: 3, Stdout: "inactive\n"}, res)
+}
+
+func TestBackendsRunCommandUnsupported(t *testing.T) {
+	backends := Backends{"lxc": new(Lxc)}
+
+	_, err := backends.RunCommand(context.Background(), &scaler.Runner{Spec: &scaler.MachineSpec{Backend: "lxc"}}, []string{"true"})
+	require.ErrorIs(t, err, scaler.ErrCommandsUnsupported)
+}
+
+func TestPrimaryAddress(t *testing.T) {
+	address, ok := primaryAddress([]*proxmox.AgentInterface{
+		{Name: "lo", IPAddresses: []*proxmox.AgentIPAddress{{Address: "127.0.0.1"}, {Address: "::1"}}},
+		{Name: "eth0", IPAddresses: []*proxmox.AgentIPAddress{{Address: "fe80::1"}, {Address: "2001:db8::12"}}},
+		{Name: "eth1", IPAddresses: []*proxmox.AgentIPAddress{{Address: "192.168.1.12"}}},
+	})
+	require.True(t, ok)
+	require.Equal(t, "192.168.1.12", address, "an ipv4 address wins")
+
+	address, ok = primaryAddress([]*proxmox.AgentInterface{
+		{Name: "eth0", IPAddresses: []*proxmox.AgentIPAddress{{Address: "fe80::1"}, {Address: "2001:db8::12"}}},
+	})
+	require.True(t, ok)
+	require.Equal(t, "2001:db8::12", address)
+
+	_, ok = primaryAddress([]*proxmox.AgentInterface{
+		{Name: "lo", IPAddresses: []*proxmox.AgentIPAddress{{Address: "127.0.0.1"}}},
+	})
+	require.False(t, ok, "a vm still waiting for dhcp has no address")
+}
@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+)
+
+// Backends hands every runner to the provider of the backend its pool asks for, e.g. qemu or lxc. Runners without a
+// backend go to the qemu backend.
+type Backends map[string]scaler.Provider
+
+// Provision implements scaler.Provider.
+func (b Backends) Provision(ctx context.Context, runner *scaler.Runner, jitConfig string) error {
+	p, err := b.provider(runner.Spec)
+	if err != nil {
+		return err
+	}
+	return p.Provision(ctx, runner, jitConfig)
+}
+
+// Destroy implements scaler.Provider.
+func (b Backends) Destroy(ctx context.Context, runner *scaler.Runner) error {
+	p, err := b.provider(runner.Spec)
+	if err != nil {
+		return err
+	}
+	return p.Destroy(ctx, runner)
+}
+
+// Stopped implements scaler.Provider.
+func (b Backends) Stopped(ctx context.Context, runner *scaler.Runner) (bool, error) {
+	p, err := b.provider(runner.Spec)
+	if err != nil {
+		return false, err
+	}
+	return p.Stopped(ctx, runner)
+}
+
+// List implements scaler.Provider. It returns the machines of every backend, each marked with its backend.
+func (b Backends) List(ctx context.Context) ([]*scaler.Machine, error) {
+	machines := make([]*scaler.Machine, 0)
+	for backend, p := range b {
+		list, err := p.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list %s machines: %w", backend, err)
+		}
+		for _, m := range list {
+			m.Backend = backend
+		}
+		machines = append(machines, list...)
+	}
+	return machines, nil
+}
+
+// RunCommand implements scaler.CommandRunner for the backends whose provider can run commands.
+func (b Backends) RunCommand(ctx context.Context, runner *scaler.Runner, command []string) (*scaler.CommandResult, error) {
+	p, err := b.provider(runner.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	commands, ok := p.(scaler.CommandRunner)
+	if !ok {
+		return nil, scaler.ErrCommandsUnsupported
+	}
+	return commands.RunCommand(ctx, runner, command)
+}
+
+// Recycle implements scaler.Recycler for the backends whose provider can recycle machines.
+func (b Backends) Recycle(ctx context.Context, runner *scaler.Runner, jitConfig string) error {
+	p, err := b.provider(runner.Spec)
+	if err != nil {
+		return err
+	}
+
+	recycler, ok := p.(scaler.Recycler)
+	if !ok {
+		return scaler.ErrRecycleUnsupported
+	}
+	return recycler.Recycle(ctx, runner, jitConfig)
+}
+
+// Reserve implements scaler.Reserver for the backends whose provider places machines on nodes.
+func (b Backends) Reserve(ctx context.Context, runner *scaler.Runner) error {
+	p, err := b.provider(runner.Spec)
+	if err != nil {
+		return err
+	}
+
+	reserver, ok := p.(scaler.Reserver)
+	if !ok {
+		return nil
+	}
+	return reserver.Reserve(ctx, runner)
+}
+
+// Release implements scaler.Reserver.
+func (b Backends) Release(runner *scaler.Runner) {
+	p, err := b.provider(runner.Spec)
+	if err != nil {
+		return
+	}
+
+	if reserver, ok := p.(scaler.Reserver); ok {
+		reserver.Release(runner)
+	}
+}
+
+// provider returns the provider of the backend of the spec.
+func (b Backends) provider(spec *scaler.MachineSpec) (scaler.Provider, error) {
+	backend := routing.BackendQemu
+	if spec != nil && spec.Backend != "" {
+		backend = spec.Backend
+	}
+
+	p, ok := b[backend]
+	if !ok {
+		return nil, fmt.Errorf("no provider for backend %q", backend)
+	}
+	return p, nil
+}
@@ -0,0 +1,83 @@
+package provider
+
+// DefaultHookScriptName is the name of the hook script snippet the Lxc provider writes.
+const DefaultHookScriptName = "gh-runner-hook.sh"
+
+// HookScript is the hook script that bootstraps the runner in a container. Proxmox runs it on the node with the VMID
+// and the phase of the container. The API has no equivalent of pct push and pct exec, so once the container has
+// started the script copies the runner's bootstrap script, which the scaler writes next to it, into the container and
+// runs it there.
+const HookScript = `#!/bin/sh
+# Bootstraps the GitHub Actions runner in the containers of proxmox-github-runners.
+set -eu
+
+vmid="$1"
+phase="$2"
+
+[ "$phase" = "post-start" ] || exit 0
+
+name=$(pct config "$vmid" | sed -n 's/^hostname: //p')
+script="$(dirname "$0")/${name}.sh"
+
+# A container without a bootstrap script is not a runner.
+[ -f "$script" ] || exit 0
+
+pct push "$vmid" "$script" /root/bootstrap.sh --perms 0700
+pct exec "$vmid" -- /bin/sh -c 'nohup /root/bootstrap.sh >/var/log/runner-bootstrap.log 2>&1 &'
+`
+
+// DefaultBootstrapTemplate is the script that installs the runner in a container and starts it with its just-in-time
+// config. It is rendered with UserData. The runner exits after its job, which reports the exit to the scaler and
+// powers the container off.
+const DefaultBootstrapTemplate = `#!/bin/sh
+set -eu
+
+id -u {{ .User }} >/dev/null 2>&1 || useradd -m -s /bin/bash {{ .User }}
+{{- if .SSHKeys }}
+install -d -m 0700 -o {{ .User }} -g {{ .User }} /home/{{ .User }}/.ssh
+cat > /home/{{ .User }}/.ssh/authorized_keys <<'EOF'
+{{- range .SSHKeys }}
+{{ . }}
+{{- end }}
+EOF
+chown {{ .User }}:{{ .User }} /home/{{ .User }}/.ssh/authorized_keys
+chmod 0600 /home/{{ .User }}/.ssh/authorized_keys
+{{- end }}
+
+install -d -m 0755 /etc/actions-runner
+(umask 077 && printf '%s' '{{ .JITConfig }}' > /etc/actions-runner/jitconfig)
+chown {{ .User }}:{{ .User }} /etc/actions-runner/jitconfig
+
+cat > /etc/systemd/system/actions-runner.service <<'EOF'
+[Unit]
+Description=GitHub Actions runner
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+User={{ .User }}
+WorkingDirectory=/opt/actions-runner
+ExecStart=/bin/sh -c 'exec ./run.sh --jitconfig "$$(cat /etc/actions-runner/jitconfig)"'
+{{- if .CallbackURL }}
+ExecStopPost=-/usr/bin/curl -fsS -m 10 -X POST -H "Authorization: Bearer {{ .CallbackToken }}" {{ .CallbackURL }}
+{{- end }}
+ExecStopPost=+/usr/bin/systemctl poweroff
+
+[Install]
+WantedBy=multi-user.target
+EOF
+
+# The network may still be coming up right after the container started.
+for attempt in 1 2 3 4 5 6 7 8 9 10; do
+  curl -fsSL -o /tmp/actions-runner.tar.gz {{ printf "%q" .RunnerURL }} && break
+  sleep 5
+done
+
+install -d /opt/actions-runner
+tar -xzf /tmp/actions-runner.tar.gz -C /opt/actions-runner
+rm -f /tmp/actions-runner.tar.gz
+/opt/actions-runner/bin/installdependencies.sh
+chown -R {{ .User }}:{{ .User }} /opt/actions-runner
+systemctl daemon-reload
+systemctl enable --now actions-runner.service
+`
@@ -0,0 +1,467 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+)
+
+// LxcConfig is the configuration for the Lxc provider.
+type LxcConfig struct {
+	// Node is the node the template is on. The clones are created on it unless a Placer is set.
+	Node string
+
+	// TemplateID is the VMID of the container template the runners are cloned from. The template must run systemd
+	// and have curl installed.
+	TemplateID int
+
+	// FullClone creates full clones rather than linked clones.
+	FullClone bool
+
+	// Storage is the storage the volumes of full clones are written to.
+	Storage string
+
+	// Pool is the resource pool the clones are added to.
+	Pool string
+
+	// Disk is the volume that is grown when a runner asks for a disk size. Defaults to rootfs.
+	Disk string
+
+	// Net0 is the config of the first network interface. Defaults to name=eth0,bridge=vmbr0,ip=dhcp.
+	Net0 string
+
+	// User is the user the runner runs as.
+	User string
+
+	// SSHKeys are the public keys authorized for the user.
+	SSHKeys []string
+
+	// BootstrapTemplate is the bootstrap script template. Defaults to DefaultBootstrapTemplate.
+	BootstrapTemplate string
+
+	// HookScript is the name of the snippet the hook script is written to. Defaults to DefaultHookScriptName.
+	HookScript string
+
+	// RunnerURL is where the runner release is downloaded from. Defaults to DefaultRunnerURL.
+	RunnerURL string
+
+	// CallbackURL is the URL of the scaler the runners report their exit to, e.g. https://scaler.example.com. The
+	// runners do not call back when it is empty, and are only torn down once their machine is seen stopped.
+	CallbackURL string
+
+	// Attempts is how often a container whose clone or start fails is tried on another node. Defaults to 3.
+	Attempts int
+
+	// MinBackoff is the delay before the first retry on another node. It doubles with every retry, with jitter.
+	// Defaults to 2 seconds.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the longest delay between retries. Defaults to 30 seconds.
+	MaxBackoff time.Duration
+}
+
+// Lxc provisions runners as LXC containers cloned from a container template. Containers have no cloud-init, so the
+// runner is bootstrapped by HookScript, which Proxmox runs on the node once the container has started.
+type Lxc struct {
+	cfg LxcConfig
+
+	// px is the Proxmox client.
+	px proxmox.Client
+
+	// snippets stores the hook script and the bootstrap scripts of the runners.
+	snippets SnippetStore
+
+	// placer picks the node of every clone. The clones are created on the template's node when it is nil.
+	placer Placer
+
+	// ids hands out the VMIDs of the clones.
+	ids *VMIDAllocator
+
+	// bootstrap is the parsed bootstrap script template.
+	bootstrap *template.Template
+}
+
+// NewLxc creates a new Lxc provider.
+func NewLxc(cfg LxcConfig, px proxmox.Client, snippets SnippetStore, placer Placer, ids *VMIDAllocator) (*Lxc, error) {
+	if cfg.Node == "" || cfg.TemplateID == 0 {
+		return nil, errors.New("node and template id are required")
+	} else if cfg.User == "" {
+		return nil, errors.New("runner user is required")
+	}
+
+	if cfg.Disk == "" {
+		cfg.Disk = "rootfs"
+	}
+	if cfg.Net0 == "" {
+		cfg.Net0 = "name=eth0,bridge=vmbr0,ip=dhcp"
+	}
+	if cfg.HookScript == "" {
+		cfg.HookScript = DefaultHookScriptName
+	}
+	if cfg.RunnerURL == "" {
+		cfg.RunnerURL = DefaultRunnerURL
+	}
+	if cfg.Attempts == 0 {
+		cfg.Attempts = 3
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = 2 * time.Second
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+
+	tmpl, err := parseBootstrapTemplate(cfg.BootstrapTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lxc{
+		cfg:       cfg,
+		px:        px,
+		snippets:  snippets,
+		placer:    placer,
+		ids:       ids,
+		bootstrap: tmpl,
+	}, nil
+}
+
+// Provision clones the container, writes the bootstrap script with the just-in-time config and starts it. A retry
+// picks up the container an earlier attempt cloned. A container whose clone or start fails is destroyed and tried
+// again on another node, after a backoff, until LxcConfig.Attempts attempts failed.
+func (x *Lxc) Provision(ctx context.Context, runner *scaler.Runner, jitConfig string) error {
+	return provisionWithRetries(ctx, runner, x.placer, retryConfig{
+		attempts:   x.cfg.Attempts,
+		minBackoff: x.cfg.MinBackoff,
+		maxBackoff: x.cfg.MaxBackoff,
+	}, func(ctx context.Context, failed []string) error {
+		return x.provision(ctx, runner, jitConfig, failed)
+	}, func(ctx context.Context) error {
+		return x.Destroy(ctx, runner)
+	})
+}
+
+// provision makes a single attempt at provisioning the container, keeping it off the failed nodes. A failure to clone
+// or start the container is returned as a nodeError.
+func (x *Lxc) provision(ctx context.Context, runner *scaler.Runner, jitConfig string, failed []string) error {
+	if err := x.ensureClone(ctx, runner, failed); err != nil {
+		return err
+	}
+
+	l := slog.With(slog.String(logging.KeyRunner, runner.Name), slog.Int(logging.KeyVMID, runner.VMID))
+
+	hookScript, err := x.snippets.PutScript(ctx, x.cfg.HookScript, []byte(HookScript))
+	if err != nil {
+		return fmt.Errorf("store hook script: %w", err)
+	}
+
+	current, err := x.px.GetContainerConfig(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return err
+	}
+	if err := x.px.UpdateContainerConfig(ctx, runner.Node, runner.VMID, x.containerConfig(runner, current, hookScript)); err != nil {
+		return fmt.Errorf("configure container: %w", err)
+	}
+
+	if err := x.resizeDisk(ctx, runner); err != nil {
+		return err
+	}
+
+	data := &UserData{
+		Hostname:  runner.Name,
+		User:      x.cfg.User,
+		SSHKeys:   x.cfg.SSHKeys,
+		JITConfig: jitConfig,
+		RunnerURL: x.cfg.RunnerURL,
+	}
+	if x.cfg.CallbackURL != "" {
+		data.CallbackURL = fmt.Sprintf("%s/api/runners/%s/exited", strings.TrimSuffix(x.cfg.CallbackURL, "/"), runner.Name)
+		data.CallbackToken = scaler.CallbackToken(jitConfig, runner.Name)
+	}
+
+	script, err := renderUserData(x.bootstrap, data)
+	if err != nil {
+		return err
+	}
+	l.Debug("rendered bootstrap script", slog.String(logging.KeyUserData, string(script)))
+
+	if _, err := x.snippets.Put(ctx, bootstrapName(runner), script); err != nil {
+		return fmt.Errorf("store bootstrap script: %w", err)
+	}
+
+	guest, err := x.px.GetContainerStatus(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return err
+	} else if guest.IsRunning() {
+		return nil
+	}
+
+	upid, err := x.px.StartContainer(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return &nodeError{node: runner.Node, err: err}
+	}
+	if _, err := x.px.WaitForTask(ctx, upid); err != nil {
+		return &nodeError{node: runner.Node, err: fmt.Errorf("start container %d: %w", runner.VMID, err)}
+	}
+
+	l.Info("runner container started", slog.String(logging.KeyNode, runner.Node))
+
+	return nil
+}
+
+// Destroy stops and deletes the container of the runner and its bootstrap script. A container that does not carry the
+// runner's name is never touched, as its ID may have been reused.
+func (x *Lxc) Destroy(ctx context.Context, runner *scaler.Runner) error {
+	if runner.VMID != 0 {
+		guest, err := x.px.GetContainerStatus(ctx, runner.Node, runner.VMID)
+		switch {
+		case proxmox.IsNotFound(err):
+		case err != nil:
+			return err
+		case guest.Name != runner.Name:
+			slog.Warn("container no longer belongs to the runner, leaving it alone",
+				slog.String(logging.KeyRunner, runner.Name),
+				slog.Int(logging.KeyVMID, runner.VMID),
+				slog.String("container_name", guest.Name),
+			)
+		default:
+			config, err := x.px.GetContainerConfig(ctx, runner.Node, runner.VMID)
+			if err != nil {
+				return err
+			}
+			if !owns(config, runner.Name) {
+				slog.Warn("container does not carry the ownership marker of the runner, leaving it alone",
+					slog.String(logging.KeyRunner, runner.Name),
+					slog.Int(logging.KeyVMID, runner.VMID),
+				)
+				break
+			}
+			if err := x.deleteContainer(ctx, runner, guest); err != nil {
+				return err
+			}
+		}
+	}
+
+	return x.snippets.Delete(ctx, bootstrapName(runner))
+}
+
+// Stopped returns true if the container of the runner is not running, or no longer exists.
+func (x *Lxc) Stopped(ctx context.Context, runner *scaler.Runner) (bool, error) {
+	if runner.VMID == 0 {
+		return true, nil
+	}
+
+	guest, err := x.px.GetContainerStatus(ctx, runner.Node, runner.VMID)
+	switch {
+	case proxmox.IsNotFound(err):
+		return true, nil
+	case err != nil:
+		return false, err
+	default:
+		return guest.Name != runner.Name || !guest.IsRunning(), nil
+	}
+}
+
+// List returns the containers of the cluster that carry ManagedTag.
+func (x *Lxc) List(ctx context.Context) ([]*scaler.Machine, error) {
+	resources, err := x.px.ClusterResources(ctx, proxmox.ResourceTypeVM)
+	if err != nil {
+		return nil, err
+	}
+
+	machines := make([]*scaler.Machine, 0)
+	for _, r := range resources {
+		if r.Type != string(proxmox.GuestTypeLXC) || r.Template != 0 || !r.HasTag(ManagedTag) {
+			continue
+		}
+
+		machines = append(machines, &scaler.Machine{
+			Name:    r.Name,
+			Node:    r.Node,
+			VMID:    r.VMID,
+			Running: r.Status == "running",
+		})
+	}
+
+	return machines, nil
+}
+
+// ensureClone clones the template for the runner unless an earlier attempt already has. The location of the clone is
+// recorded on the runner before it is created.
+func (x *Lxc) ensureClone(ctx context.Context, runner *scaler.Runner, failed []string) error {
+	if runner.VMID != 0 {
+		guest, err := x.px.GetContainerStatus(ctx, runner.Node, runner.VMID)
+		switch {
+		case err == nil && guest.Name == runner.Name:
+			if guest.Lock != "" {
+				return fmt.Errorf("container %d is still locked for %s", runner.VMID, guest.Lock)
+			}
+			return nil
+		case err == nil:
+			// Someone else took the ID before the earlier attempt cloned into it.
+		case !proxmox.IsNotFound(err):
+			return err
+		}
+	}
+
+	node, err := x.place(ctx, runner, failed)
+	if err != nil {
+		return err
+	}
+
+	vmid, err := x.ids.Allocate(ctx, runner)
+	if err != nil {
+		if x.placer != nil {
+			x.placer.Forget(node, runner.Name)
+		}
+		return err
+	}
+	// The clone holds the ID once it exists, and a failed clone frees it.
+	defer x.ids.Release(ctx, runner, vmid)
+
+	runner.Node = node
+	runner.VMID = vmid
+
+	if _, err := x.px.CloneContainer(ctx, &proxmox.CloneRequest{
+		Node:        x.cfg.Node,
+		TemplateID:  x.templateID(runner),
+		NewID:       vmid,
+		Name:        runner.Name,
+		Target:      node,
+		Full:        x.cfg.FullClone,
+		Storage:     x.cfg.Storage,
+		Pool:        x.cfg.Pool,
+		Description: newOwnership(runner).Notes(),
+	}); err != nil {
+		if x.placer != nil {
+			x.placer.Forget(node, runner.Name)
+		}
+		return &nodeError{node: node, err: err}
+	}
+
+	return nil
+}
+
+// place picks the node the runner's container is cloned to, sized after its spec and the template.
+func (x *Lxc) place(ctx context.Context, runner *scaler.Runner, failed []string) (string, error) {
+	if x.placer == nil {
+		return x.cfg.Node, nil
+	}
+
+	template, err := x.px.GetContainerStatus(ctx, x.cfg.Node, x.templateID(runner))
+	if err != nil {
+		return "", fmt.Errorf("get template: %w", err)
+	}
+
+	return placeGuest(ctx, x.placer, runner, template, x.cfg.FullClone, failed)
+}
+
+// Reserve implements scaler.Reserver. It counts the runner's container against the node it is to be cloned to.
+func (x *Lxc) Reserve(ctx context.Context, runner *scaler.Runner) error {
+	_, err := x.place(ctx, runner, nil)
+	return err
+}
+
+// Release implements scaler.Reserver.
+func (x *Lxc) Release(runner *scaler.Runner) {
+	if x.placer != nil {
+		x.placer.Forget("", runner.Name)
+	}
+}
+
+// templateID returns the template the runner's container is cloned from.
+func (x *Lxc) templateID(runner *scaler.Runner) int {
+	if runner.Spec != nil && runner.Spec.TemplateID != 0 {
+		return runner.Spec.TemplateID
+	}
+	return x.cfg.TemplateID
+}
+
+// containerConfig returns the config set on the runner's container after it is cloned: its tags, network, hook script
+// and the resources of its spec. The hook script is usually inherited from the template, as only root@pam may set it;
+// it is only set when the clone does not have it already.
+func (x *Lxc) containerConfig(runner *scaler.Runner, current proxmox.GuestConfig, hookScript string) url.Values {
+	params := url.Values{
+		"tags": {guestTags(runner)},
+		"net0": {x.cfg.Net0},
+	}
+	if current.String("hookscript") != hookScript {
+		params.Set("hookscript", hookScript)
+	}
+	if spec := runner.Spec; spec != nil {
+		if spec.Cores > 0 {
+			params.Set("cores", strconv.Itoa(spec.Cores))
+		}
+		if spec.MemoryMB > 0 {
+			params.Set("memory", strconv.Itoa(spec.MemoryMB))
+		}
+	}
+	return params
+}
+
+// resizeDisk grows the volume of the runner's container to the size of its spec. A volume that is already as large is
+// left alone, so a retry does not resize it again.
+func (x *Lxc) resizeDisk(ctx context.Context, runner *scaler.Runner) error {
+	if runner.Spec == nil || runner.Spec.DiskGB == 0 {
+		return nil
+	}
+
+	size := int64(runner.Spec.DiskGB) << 30
+	guest, err := x.px.GetContainerStatus(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return err
+	} else if guest.MaxDisk >= size {
+		return nil
+	}
+
+	upid, err := x.px.ResizeContainerDisk(ctx, runner.Node, runner.VMID, x.cfg.Disk, size)
+	if err != nil {
+		return err
+	}
+	if upid != "" {
+		if _, err := x.px.WaitForTask(ctx, upid); err != nil {
+			return fmt.Errorf("resize disk of container %d: %w", runner.VMID, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteContainer stops the container if it is running and deletes it.
+func (x *Lxc) deleteContainer(ctx context.Context, runner *scaler.Runner, guest *proxmox.Guest) error {
+	if guest.IsRunning() {
+		upid, err := x.px.StopContainer(ctx, runner.Node, runner.VMID)
+		if err != nil {
+			return err
+		}
+		if _, err := x.px.WaitForTask(ctx, upid); err != nil {
+			return fmt.Errorf("stop container %d: %w", runner.VMID, err)
+		}
+	}
+
+	upid, err := x.px.DeleteContainer(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return err
+	}
+	if _, err := x.px.WaitForTask(ctx, upid); err != nil {
+		return fmt.Errorf("delete container %d: %w", runner.VMID, err)
+	}
+
+	return nil
+}
+
+// bootstrapName returns the name of the bootstrap script snippet of the runner, which HookScript looks up by the
+// hostname of the container.
+func bootstrapName(runner *scaler.Runner) string {
+	return runner.Name + ".sh"
+}
@@ -0,0 +1,259 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeLxc is an in-memory cluster of containers with a single node. It keeps its containers in the VMs of the
+// embedded fakeProxmox.
+type fakeLxc struct {
+	*fakeProxmox
+
+	hookScripts map[int]string
+}
+
+func newFakeLxc() *fakeLxc {
+	return &fakeLxc{
+		fakeProxmox: newFakeProxmox(),
+		hookScripts: make(map[int]string),
+	}
+}
+
+func (f *fakeLxc) CloneContainer(_ context.Context, req *proxmox.CloneRequest) (*proxmox.TaskResult, error) {
+	f.clones = append(f.clones, req)
+	f.vms[req.NewID] = &proxmox.Guest{VMID: req.NewID, Name: req.Name, Status: "stopped", MaxDisk: 8 << 30}
+	f.notes[req.NewID] = req.Description
+	return &proxmox.TaskResult{ExitStatus: "OK"}, nil
+}
+
+func (f *fakeLxc) GetContainerStatus(_ context.Context, _ string, vmid int) (*proxmox.Guest, error) {
+	ct, ok := f.vms[vmid]
+	if !ok {
+		return nil, &proxmox.APIError{HttpError: utils.NewHttpError(http.StatusInternalServerError, fmt.Sprintf("Configuration file 'nodes/pve1/lxc/%d.conf' does not exist", vmid))}
+	}
+	return ct, nil
+}
+
+func (f *fakeLxc) GetContainerConfig(_ context.Context, _ string, vmid int) (proxmox.GuestConfig, error) {
+	config := proxmox.GuestConfig{"hostname": f.vms[vmid].Name, "tags": f.vms[vmid].Tags, "description": f.notes[vmid]}
+	if hookScript, ok := f.hookScripts[vmid]; ok {
+		config["hookscript"] = hookScript
+	}
+	return config, nil
+}
+
+func (f *fakeLxc) UpdateContainerConfig(_ context.Context, _ string, vmid int, params url.Values) error {
+	f.configs[vmid] = params
+	if tags := params.Get("tags"); tags != "" {
+		f.vms[vmid].Tags = tags
+	}
+	if hookScript := params.Get("hookscript"); hookScript != "" {
+		f.hookScripts[vmid] = hookScript
+	}
+	return nil
+}
+
+func (f *fakeLxc) ResizeContainerDisk(_ context.Context, _ string, vmid int, disk string, size int64) (proxmox.UPID, error) {
+	f.resized = append(f.resized, fmt.Sprintf("%d/%s/%d", vmid, disk, size>>30))
+	f.vms[vmid].MaxDisk = size
+	return proxmox.UPID(fmt.Sprintf("UPID:pve1:0:0:0:resize:%d:root@pam:", vmid)), nil
+}
+
+func (f *fakeLxc) StartContainer(_ context.Context, node string, vmid int) (proxmox.UPID, error) {
+	if f.failStart[node] {
+		return "", fmt.Errorf("start container %d on %s: no space left on device", vmid, node)
+	}
+	f.vms[vmid].Status = "running"
+	return proxmox.UPID(fmt.Sprintf("UPID:pve1:0:0:0:vzstart:%d:root@pam:", vmid)), nil
+}
+
+func (f *fakeLxc) StopContainer(_ context.Context, _ string, vmid int) (proxmox.UPID, error) {
+	f.vms[vmid].Status = "stopped"
+	return proxmox.UPID(fmt.Sprintf("UPID:pve1:0:0:0:vzstop:%d:root@pam:", vmid)), nil
+}
+
+func (f *fakeLxc) DeleteContainer(_ context.Context, _ string, vmid int) (proxmox.UPID, error) {
+	delete(f.vms, vmid)
+	f.deleted = append(f.deleted, vmid)
+	return proxmox.UPID(fmt.Sprintf("UPID:pve1:0:0:0:vzdestroy:%d:root@pam:", vmid)), nil
+}
+
+func (f *fakeLxc) ClusterResources(ctx context.Context, t proxmox.ResourceType) ([]*proxmox.Resource, error) {
+	resources, err := f.fakeProxmox.ClusterResources(ctx, t)
+	for _, r := range resources {
+		r.Type = string(proxmox.GuestTypeLXC)
+	}
+	return resources, err
+}
+
+type LxcSuite struct {
+	suite.Suite
+
+	dir      string
+	px       *fakeLxc
+	provider *Lxc
+}
+
+func TestLxcSuite(t *testing.T) {
+	suite.Run(t, new(LxcSuite))
+}
+
+func (s *LxcSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+	snippets, err := NewDirSnippetStore(s.dir, "shared")
+	s.Require().NoError(err)
+
+	s.px = newFakeLxc()
+	s.provider, err = NewLxc(LxcConfig{
+		Node:       "pve1",
+		TemplateID: 8000,
+		User:       "runner",
+		SSHKeys:    []string{"ssh-ed25519 AAAA ops@example.com"},
+	}, s.px, snippets, nil, newVMIDAllocator(s.T(), VMIDConfig{}, s.px))
+	s.Require().NoError(err)
+}
+
+func (s *LxcSuite) TestProvision() {
+	runner := &scaler.Runner{Name: "pgr-1"}
+
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Equal("pve1", runner.Node)
+	s.Equal(100, runner.VMID)
+	s.Require().Len(s.px.clones, 1)
+	s.Equal(8000, s.px.clones[0].TemplateID)
+	s.True(s.px.vms[100].IsRunning())
+	s.Equal(url.Values{
+		"tags":       {ManagedTag},
+		"net0":       {"name=eth0,bridge=vmbr0,ip=dhcp"},
+		"hookscript": {"shared:snippets/" + DefaultHookScriptName},
+	}, s.px.configs[100])
+
+	hookScript, err := os.Stat(filepath.Join(s.dir, "snippets", DefaultHookScriptName))
+	s.Require().NoError(err)
+	s.Equal(os.FileMode(0o700), hookScript.Mode().Perm(), "proxmox only runs an executable hook script")
+
+	bootstrap, err := os.ReadFile(filepath.Join(s.dir, "snippets", "pgr-1.sh"))
+	s.Require().NoError(err)
+	s.Contains(string(bootstrap), "useradd -m -s /bin/bash runner")
+	s.Contains(string(bootstrap), "printf '%s' 'c2VjcmV0' > /etc/actions-runner/jitconfig")
+	s.Contains(string(bootstrap), "ssh-ed25519 AAAA ops@example.com")
+	s.NotContains(string(bootstrap), "ExecStopPost=-/usr/bin/curl", "no callback url is configured")
+}
+
+func (s *LxcSuite) TestProvisionKeepsInheritedHookScript() {
+	s.px.hookScripts[100] = "shared:snippets/" + DefaultHookScriptName
+
+	s.Require().NoError(s.provider.Provision(context.Background(), &scaler.Runner{Name: "pgr-1"}, "c2VjcmV0"))
+
+	s.NotContains(s.px.configs[100], "hookscript", "only root@pam may set the hook script")
+}
+
+func (s *LxcSuite) TestProvisionAppliesSpec() {
+	runner := &scaler.Runner{Name: "pgr-1", Spec: &scaler.MachineSpec{
+		Pool:       "containers",
+		Backend:    "lxc",
+		TemplateID: 8100,
+		Cores:      4,
+		MemoryMB:   8192,
+		DiskGB:     30,
+	}}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Len(s.px.clones, 1, "a retry must reuse the clone")
+	s.Equal(8100, s.px.clones[0].TemplateID)
+	s.Equal("4", s.px.configs[100].Get("cores"))
+	s.Equal("8192", s.px.configs[100].Get("memory"))
+	s.Equal([]string{"100/rootfs/30"}, s.px.resized, "a grown volume must not be resized again")
+}
+
+func (s *LxcSuite) TestProvisionRetriesOnAnotherNode() {
+	s.px.vms[8000] = &proxmox.Guest{VMID: 8000, Name: "template", MaxMem: 2 << 30, CPUs: 2}
+	placer := &fakePlacer{nodes: []string{"pve2", "pve3"}}
+	s.provider.placer = placer
+	s.provider.cfg.MinBackoff = time.Millisecond
+	s.px.failStart["pve2"] = true
+
+	runner := &scaler.Runner{Name: "pgr-1"}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Equal("pve3", runner.Node)
+	s.Equal([]string{"pve2"}, placer.failed)
+	s.Equal([]int{100}, s.px.deleted, "the container on the failed node must be destroyed")
+	s.True(s.px.vms[runner.VMID].IsRunning())
+}
+
+func (s *LxcSuite) TestDestroy() {
+	runner := &scaler.Runner{Name: "pgr-1"}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Require().NoError(s.provider.Destroy(context.Background(), runner))
+
+	s.Equal([]int{100}, s.px.deleted)
+	s.NoFileExists(filepath.Join(s.dir, "snippets", "pgr-1.sh"))
+	s.FileExists(filepath.Join(s.dir, "snippets", DefaultHookScriptName), "the hook script is shared by every runner")
+
+	s.NoError(s.provider.Destroy(context.Background(), runner), "destroying twice must succeed")
+}
+
+func (s *LxcSuite) TestDestroyLeavesForeignContainer() {
+	s.px.vms[100] = &proxmox.Guest{VMID: 100, Name: "hand-built", Status: "running"}
+
+	s.Require().NoError(s.provider.Destroy(context.Background(), &scaler.Runner{Name: "pgr-1", Node: "pve1", VMID: 100}))
+
+	s.Empty(s.px.deleted)
+	s.True(s.px.vms[100].IsRunning())
+}
+
+func (s *LxcSuite) TestListOnlyReturnsManagedContainers() {
+	s.px.vms[50] = &proxmox.Guest{VMID: 50, Name: "hand-built", Status: "running"}
+	s.px.vms[8000] = &proxmox.Guest{VMID: 8000, Name: "template", Tags: ManagedTag, Template: 1}
+
+	runner := &scaler.Runner{Name: "pgr-1"}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	machines, err := s.provider.List(context.Background())
+	s.Require().NoError(err)
+	s.Equal([]*scaler.Machine{{Name: "pgr-1", Node: "pve1", VMID: 100, Running: true}}, machines)
+}
+
+func (s *LxcSuite) TestBackendsRouteBySpec() {
+	qemu := newFakeProxmox()
+	snippets, err := NewDirSnippetStore(s.T().TempDir(), "shared")
+	s.Require().NoError(err)
+	vms, err := NewQemu(QemuConfig{Node: "pve1", TemplateID: 9000, User: "runner"}, qemu, snippets, nil, newVMIDAllocator(s.T(), VMIDConfig{}, qemu))
+	s.Require().NoError(err)
+
+	backends := Backends{"qemu": vms, "lxc": s.provider}
+	ctx := context.Background()
+
+	s.Require().NoError(backends.Provision(ctx, &scaler.Runner{Name: "pgr-1"}, "c2VjcmV0"))
+	s.Require().NoError(backends.Provision(ctx, &scaler.Runner{Name: "pgr-2", Spec: &scaler.MachineSpec{Backend: "lxc"}}, "c2VjcmV0"))
+	s.Require().Len(qemu.clones, 1)
+	s.Equal("pgr-1", qemu.clones[0].Name)
+	s.Require().Len(s.px.clones, 1)
+	s.Equal("pgr-2", s.px.clones[0].Name)
+
+	machines, err := backends.List(ctx)
+	s.Require().NoError(err)
+	s.ElementsMatch([]*scaler.Machine{
+		{Name: "pgr-1", Node: "pve1", VMID: 100, Running: true, Backend: "qemu"},
+		{Name: "pgr-2", Node: "pve1", VMID: 100, Running: true, Backend: "lxc"},
+	}, machines)
+
+	err = backends.Provision(ctx, &scaler.Runner{Name: "pgr-3", Spec: &scaler.MachineSpec{Backend: "docker"}}, "c2VjcmV0")
+	s.EqualError(err, `no provider for backend "docker"`)
+}
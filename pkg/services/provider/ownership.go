@@ -0,0 +1,108 @@
+package provider
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+)
+
+const (
+	// poolTagPrefix prefixes the tag naming the pool of a guest.
+	poolTagPrefix = "pool-"
+
+	// ownershipFence opens the block of the notes of a guest that holds its Ownership. The block is closed by a line
+	// of three backticks, so the notes render it as code.
+	ownershipFence = "```" + ManagedTag + "\n"
+)
+
+// Ownership is the machine-readable block written to the notes of every guest the providers create. Only a guest
+// whose block names the runner is ever deleted for it, so that a hand-built guest sharing the cluster is never touched.
+type Ownership struct {
+	// Runner is the name of the runner the guest was created for.
+	Runner string `json:"runner"`
+
+	// Pool is the pool of the runner. It is empty when the jobs are not routed to pools.
+	Pool string `json:"pool,omitempty"`
+
+	// JobID is the workflow job the runner was created for. It is zero for a warm pool runner, which is created before
+	// its job is known.
+	JobID int64 `json:"job_id,omitempty"`
+
+	// Repository is the full name of the repository of the job.
+	Repository string `json:"repository,omitempty"`
+
+	// CreatedAt is when the guest was created.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newOwnership returns the ownership of a guest created for the runner now.
+func newOwnership(runner *scaler.Runner) *Ownership {
+	o := &Ownership{
+		Runner:     runner.Name,
+		JobID:      runner.JobID,
+		Repository: runner.Repository,
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	if runner.Spec != nil {
+		o.Pool = runner.Spec.Pool
+	}
+	return o
+}
+
+// Notes returns the notes of the guest, a short note for people followed by the machine-readable block.
+func (o *Ownership) Notes() string {
+	b, _ := json.Marshal(o) // The fields are all plain values, which always encode.
+	return "Created by proxmox-github-runners for a single job, it is destroyed once the job is done. Do not edit.\n\n" +
+		ownershipFence + string(b) + "\n```\n"
+}
+
+// ParseOwnership returns the ownership in the notes of a guest, or false if the notes hold none.
+func ParseOwnership(notes string) (*Ownership, bool) {
+	_, block, ok := strings.Cut(notes, ownershipFence)
+	if !ok {
+		return nil, false
+	}
+	block, _, ok = strings.Cut(block, "\n```")
+	if !ok {
+		return nil, false
+	}
+
+	o := new(Ownership)
+	if err := json.Unmarshal([]byte(block), o); err != nil || o.Runner == "" {
+		return nil, false
+	}
+	return o, true
+}
+
+// guestTags returns the tags of the runner's guest: ManagedTag and, for a runner of a pool, the tag of its pool.
+func guestTags(runner *scaler.Runner) string {
+	if runner.Spec == nil || runner.Spec.Pool == "" {
+		return ManagedTag
+	}
+	return ManagedTag + ";" + poolTagPrefix + tagValue(runner.Spec.Pool)
+}
+
+// tagValue returns the value with the characters a Proxmox tag cannot hold replaced by dashes. Tags are compared
+// case-insensitively, so it is lowercased.
+func tagValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-', r == '+', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return '-'
+		}
+	}, value)
+}
+
+// owns returns true if the guest with the config was created for the runner, i.e. its notes hold an ownership block
+// naming the runner. ManagedTag alone is not enough, as anyone can tag a guest.
+func owns(config proxmox.GuestConfig, runner string) bool {
+	o, ok := ParseOwnership(config.String("description"))
+	return ok && o.Runner == runner
+}
@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOwnershipRoundTrip(t *testing.T) {
+	o := &Ownership{
+		Runner:     "pgr-1",
+		Pool:       "large",
+		JobID:      42,
+		Repository: "octo-org/monorepo",
+		CreatedAt:  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	notes := o.Notes()
+	require.Contains(t, notes, "```gh-runner\n{\"runner\":\"pgr-1\",\"pool\":\"large\",\"job_id\":42,")
+
+	parsed, ok := ParseOwnership("Edited by hand.\n\n" + notes + "\nMore notes.")
+	require.True(t, ok, "text around the block must not matter")
+	require.Equal(t, o, parsed)
+
+	for _, notes := range []string{"", "Build box, do not delete.", "```gh-runner\n{\"runner\":\"pgr-1\"}", "```gh-runner\nnot json\n```"} {
+		_, ok := ParseOwnership(notes)
+		require.False(t, ok, notes)
+	}
+}
+
+func TestGuestTags(t *testing.T) {
+	require.Equal(t, "gh-runner", guestTags(&scaler.Runner{Name: "pgr-1"}))
+	require.Equal(t, "gh-runner;pool-large", guestTags(&scaler.Runner{Name: "pgr-1", Spec: &scaler.MachineSpec{Pool: "large"}}))
+	require.Equal(t, "gh-runner;pool-gpu-a100_x2", guestTags(&scaler.Runner{Name: "pgr-1", Spec: &scaler.MachineSpec{Pool: "GPU:A100_x2"}}))
+}
@@ -0,0 +1,556 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/placement"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+)
+
+// ManagedTag is the tag of every VM the provider creates. Only VMs carrying it are ever listed as managed.
+const ManagedTag = "gh-runner"
+
+// Placer picks the node a new guest is created on.
+type Placer interface {
+	// Place picks the node for the guest. A guest that was placed already keeps its node.
+	Place(ctx context.Context, req *placement.Request) (string, error)
+
+	// Forget stops counting a placed guest against the node, or against any node if node is empty.
+	Forget(node, name string)
+
+	// Failed records that creating or starting a guest failed on the node.
+	Failed(node string, err error)
+
+	// Succeeded records that a guest was created and started on the node.
+	Succeeded(node string)
+}
+
+// QemuConfig is the configuration for the Qemu provider.
+type QemuConfig struct {
+	// Node is the node the template is on. The clones are created on it unless a Placer is set.
+	Node string
+
+	// TemplateID is the VMID of the template the runners are cloned from. The template must have a cloud-init drive,
+	// or the guest agent enabled for BootstrapAgent.
+	TemplateID int
+
+	// FullClone creates full clones rather than linked clones.
+	FullClone bool
+
+	// Storage is the storage the disks of full clones are written to.
+	Storage string
+
+	// Pool is the resource pool the clones are added to.
+	Pool string
+
+	// Disk is the boot disk of the template, the disk that is grown when a runner asks for a disk size. Defaults to
+	// scsi0.
+	Disk string
+
+	// User is the user the runner runs as.
+	User string
+
+	// SSHKeys are the public keys authorized for the user.
+	SSHKeys []string
+
+	// IPConfig0 is the config of the first network interface. Defaults to ip=dhcp.
+	IPConfig0 string
+
+	// UserDataTemplate is the user data template. Defaults to DefaultUserDataTemplate.
+	UserDataTemplate string
+
+	// Bootstrap is how the runner is installed in the VM, BootstrapCloudInit or BootstrapAgent. Defaults to
+	// BootstrapCloudInit.
+	Bootstrap string
+
+	// BootstrapTemplate is the bootstrap script template run through the guest agent with BootstrapAgent. Defaults to
+	// DefaultBootstrapTemplate.
+	BootstrapTemplate string
+
+	// WaitForAgent waits for the guest agent of every started VM to answer and report an address, which is recorded
+	// on the runner, before the VM counts as provisioned. It is implied by BootstrapAgent.
+	WaitForAgent bool
+
+	// AgentTimeout is how long a started VM has for its guest agent to answer. A VM whose agent does not is tried
+	// again on another node. Defaults to 5 minutes.
+	AgentTimeout time.Duration
+
+	// AgentPollInterval is how often the guest agent is pinged while waiting for it. Defaults to 2 seconds.
+	AgentPollInterval time.Duration
+
+	// RunnerURL is where the runner release is downloaded from. Defaults to DefaultRunnerURL.
+	RunnerURL string
+
+	// CallbackURL is the URL of the scaler the runners report their exit to, e.g. https://scaler.example.com. The
+	// runners do not call back when it is empty, and are only torn down once their machine is seen stopped.
+	CallbackURL string
+
+	// Attempts is how often a VM whose clone or start fails is tried on another node. Defaults to 3.
+	Attempts int
+
+	// MinBackoff is the delay before the first retry on another node. It doubles with every retry, with jitter.
+	// Defaults to 2 seconds.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the longest delay between retries. Defaults to 30 seconds.
+	MaxBackoff time.Duration
+}
+
+// Qemu provisions runners as QEMU virtual machines cloned from a template, bootstrapped with cloud-init or through the
+// guest agent.
+type Qemu struct {
+	cfg QemuConfig
+
+	// px is the Proxmox client.
+	px proxmox.Client
+
+	// snippets stores the user data of the runners.
+	snippets SnippetStore
+
+	// placer picks the node of every clone. The clones are created on the template's node when it is nil.
+	placer Placer
+
+	// ids hands out the VMIDs of the clones.
+	ids *VMIDAllocator
+
+	// userData is the parsed user data template.
+	userData *template.Template
+
+	// bootstrap is the parsed bootstrap script template of BootstrapAgent.
+	bootstrap *template.Template
+}
+
+// NewQemu creates a new Qemu provider.
+func NewQemu(cfg QemuConfig, px proxmox.Client, snippets SnippetStore, placer Placer, ids *VMIDAllocator) (*Qemu, error) {
+	if cfg.Node == "" || cfg.TemplateID == 0 {
+		return nil, errors.New("node and template id are required")
+	} else if cfg.User == "" {
+		return nil, errors.New("runner user is required")
+	}
+
+	if cfg.IPConfig0 == "" {
+		cfg.IPConfig0 = "ip=dhcp"
+	}
+	if cfg.Disk == "" {
+		cfg.Disk = "scsi0"
+	}
+	if cfg.RunnerURL == "" {
+		cfg.RunnerURL = DefaultRunnerURL
+	}
+	if cfg.Attempts == 0 {
+		cfg.Attempts = 3
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = 2 * time.Second
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	switch cfg.Bootstrap {
+	case "":
+		cfg.Bootstrap = BootstrapCloudInit
+	case BootstrapCloudInit:
+	case BootstrapAgent:
+		cfg.WaitForAgent = true
+	default:
+		return nil, fmt.Errorf("unknown bootstrap %q", cfg.Bootstrap)
+	}
+	if cfg.AgentTimeout == 0 {
+		cfg.AgentTimeout = 5 * time.Minute
+	}
+	if cfg.AgentPollInterval == 0 {
+		cfg.AgentPollInterval = 2 * time.Second
+	}
+
+	tmpl, err := parseUserDataTemplate(cfg.UserDataTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	bootstrap, err := parseBootstrapTemplate(cfg.BootstrapTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Qemu{
+		cfg:       cfg,
+		px:        px,
+		snippets:  snippets,
+		placer:    placer,
+		ids:       ids,
+		userData:  tmpl,
+		bootstrap: bootstrap,
+	}, nil
+}
+
+// Provision clones the VM, injects the user data with the just-in-time config and starts it, or with BootstrapAgent
+// starts it and runs the bootstrap script through the guest agent. A retry picks up the VM an earlier attempt cloned.
+// A VM whose clone, start or boot fails is destroyed and tried again on another node, after a backoff, until
+// QemuConfig.Attempts attempts failed.
+func (q *Qemu) Provision(ctx context.Context, runner *scaler.Runner, jitConfig string) error {
+	return provisionWithRetries(ctx, runner, q.placer, retryConfig{
+		attempts:   q.cfg.Attempts,
+		minBackoff: q.cfg.MinBackoff,
+		maxBackoff: q.cfg.MaxBackoff,
+	}, func(ctx context.Context, failed []string) error {
+		return q.provision(ctx, runner, jitConfig, failed)
+	}, func(ctx context.Context) error {
+		return q.Destroy(ctx, runner)
+	})
+}
+
+// provision makes a single attempt at provisioning the VM, keeping it off the failed nodes. The VM of a recyclable
+// runner is snapshotted before its first boot. A failure to clone, start or boot the VM is returned as a nodeError.
+func (q *Qemu) provision(ctx context.Context, runner *scaler.Runner, jitConfig string, failed []string) error {
+	if err := q.ensureClone(ctx, runner, failed); err != nil {
+		return err
+	}
+
+	if err := q.px.UpdateVMConfig(ctx, runner.Node, runner.VMID, q.vmConfig(runner)); err != nil {
+		return fmt.Errorf("configure vm: %w", err)
+	}
+
+	if err := q.resizeDisk(ctx, runner); err != nil {
+		return err
+	}
+
+	if runner.Recyclable {
+		if err := q.ensureSnapshot(ctx, runner); err != nil {
+			return err
+		}
+	}
+
+	return q.boot(ctx, runner, jitConfig)
+}
+
+// boot hands the just-in-time config to the VM of the runner, starts it and, with BootstrapAgent, runs the bootstrap
+// script through the guest agent. A failure to start or boot the VM is returned as a nodeError.
+func (q *Qemu) boot(ctx context.Context, runner *scaler.Runner, jitConfig string) error {
+	l := slog.With(slog.String(logging.KeyRunner, runner.Name), slog.Int(logging.KeyVMID, runner.VMID))
+
+	data := &UserData{
+		Hostname:  runner.Name,
+		User:      q.cfg.User,
+		SSHKeys:   q.cfg.SSHKeys,
+		JITConfig: jitConfig,
+		RunnerURL: q.cfg.RunnerURL,
+	}
+	if q.cfg.CallbackURL != "" {
+		data.CallbackURL = fmt.Sprintf("%s/api/runners/%s/exited", strings.TrimSuffix(q.cfg.CallbackURL, "/"), runner.Name)
+		data.CallbackToken = scaler.CallbackToken(jitConfig, runner.Name)
+	}
+
+	var script []byte
+	if q.cfg.Bootstrap == BootstrapAgent {
+		rendered, err := renderUserData(q.bootstrap, data)
+		if err != nil {
+			return err
+		}
+		script = rendered
+		l.Debug("rendered bootstrap script", slog.String(logging.KeyUserData, string(script)))
+	} else if err := q.setUserData(ctx, runner, data); err != nil {
+		return err
+	}
+
+	guest, err := q.px.GetVMStatus(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return err
+	}
+
+	if !guest.IsRunning() {
+		upid, err := q.px.StartVM(ctx, runner.Node, runner.VMID)
+		if err != nil {
+			return &nodeError{node: runner.Node, err: err}
+		}
+		if _, err := q.px.WaitForTask(ctx, upid); err != nil {
+			return &nodeError{node: runner.Node, err: fmt.Errorf("start vm %d: %w", runner.VMID, err)}
+		}
+
+		l.Info("runner vm started", slog.String(logging.KeyNode, runner.Node))
+	}
+
+	if q.cfg.WaitForAgent {
+		if err := q.waitForAgent(ctx, runner); err != nil {
+			return &nodeError{node: runner.Node, err: err}
+		}
+		l.Info("runner vm ready", slog.String("address", runner.Address))
+	}
+
+	if script != nil {
+		return q.bootstrapWithAgent(ctx, runner, script)
+	}
+
+	return nil
+}
+
+// setUserData renders the cloud-init user data of the runner to a snippet and points the VM's cloud-init at it.
+func (q *Qemu) setUserData(ctx context.Context, runner *scaler.Runner, data *UserData) error {
+	userData, err := renderUserData(q.userData, data)
+	if err != nil {
+		return err
+	}
+	slog.Debug("rendered user data",
+		slog.String(logging.KeyRunner, runner.Name),
+		slog.String(logging.KeyUserData, string(userData)),
+	)
+
+	volume, err := q.snippets.Put(ctx, snippetName(runner), userData)
+	if err != nil {
+		return fmt.Errorf("store user data: %w", err)
+	}
+
+	return q.px.SetCloudInit(ctx, runner.Node, runner.VMID, &proxmox.CloudInit{
+		User:      q.cfg.User,
+		SSHKeys:   q.cfg.SSHKeys,
+		IPConfig0: q.cfg.IPConfig0,
+		UserData:  volume,
+	})
+}
+
+// Destroy stops and deletes the VM of the runner and its user data. A VM that does not carry the runner's name is
+// never touched, as its ID may have been reused.
+func (q *Qemu) Destroy(ctx context.Context, runner *scaler.Runner) error {
+	if runner.VMID != 0 {
+		guest, err := q.px.GetVMStatus(ctx, runner.Node, runner.VMID)
+		switch {
+		case proxmox.IsNotFound(err):
+		case err != nil:
+			return err
+		case guest.Name != runner.Name:
+			slog.Warn("vm no longer belongs to the runner, leaving it alone",
+				slog.String(logging.KeyRunner, runner.Name),
+				slog.Int(logging.KeyVMID, runner.VMID),
+				slog.String("vm_name", guest.Name),
+			)
+		default:
+			config, err := q.px.GetVMConfig(ctx, runner.Node, runner.VMID)
+			if err != nil {
+				return err
+			}
+			if !owns(config, runner.Name) {
+				slog.Warn("vm does not carry the ownership marker of the runner, leaving it alone",
+					slog.String(logging.KeyRunner, runner.Name),
+					slog.Int(logging.KeyVMID, runner.VMID),
+				)
+				break
+			}
+			if err := q.deleteVM(ctx, runner, guest); err != nil {
+				return err
+			}
+		}
+	}
+
+	return q.snippets.Delete(ctx, snippetName(runner))
+}
+
+// Stopped returns true if the VM of the runner is not running, or no longer exists.
+func (q *Qemu) Stopped(ctx context.Context, runner *scaler.Runner) (bool, error) {
+	if runner.VMID == 0 {
+		return true, nil
+	}
+
+	guest, err := q.px.GetVMStatus(ctx, runner.Node, runner.VMID)
+	switch {
+	case proxmox.IsNotFound(err):
+		return true, nil
+	case err != nil:
+		return false, err
+	default:
+		return guest.Name != runner.Name || !guest.IsRunning(), nil
+	}
+}
+
+// List returns the VMs of the cluster that carry ManagedTag.
+func (q *Qemu) List(ctx context.Context) ([]*scaler.Machine, error) {
+	resources, err := q.px.ClusterResources(ctx, proxmox.ResourceTypeVM)
+	if err != nil {
+		return nil, err
+	}
+
+	machines := make([]*scaler.Machine, 0)
+	for _, r := range resources {
+		if r.Type != string(proxmox.GuestTypeQemu) || r.Template != 0 || !r.HasTag(ManagedTag) {
+			continue
+		}
+
+		machines = append(machines, &scaler.Machine{
+			Name:    r.Name,
+			Node:    r.Node,
+			VMID:    r.VMID,
+			Running: r.Status == "running",
+		})
+	}
+
+	return machines, nil
+}
+
+// ensureClone clones the template for the runner unless an earlier attempt already has. The location of the clone is
+// recorded on the runner before it is created.
+func (q *Qemu) ensureClone(ctx context.Context, runner *scaler.Runner, failed []string) error {
+	if runner.VMID != 0 {
+		guest, err := q.px.GetVMStatus(ctx, runner.Node, runner.VMID)
+		switch {
+		case err == nil && guest.Name == runner.Name:
+			if guest.Lock == "clone" {
+				return fmt.Errorf("clone of vm %d is still in progress", runner.VMID)
+			}
+			return nil
+		case err == nil:
+			// Someone else took the ID before the earlier attempt cloned into it.
+		case !proxmox.IsNotFound(err):
+			return err
+		}
+	}
+
+	node, err := q.place(ctx, runner, failed)
+	if err != nil {
+		return err
+	}
+
+	vmid, err := q.ids.Allocate(ctx, runner)
+	if err != nil {
+		if q.placer != nil {
+			q.placer.Forget(node, runner.Name)
+		}
+		return err
+	}
+	// The clone holds the ID once it exists, and a failed clone frees it.
+	defer q.ids.Release(ctx, runner, vmid)
+
+	runner.Node = node
+	runner.VMID = vmid
+
+	if _, err := q.px.CloneVM(ctx, &proxmox.CloneRequest{
+		Node:        q.cfg.Node,
+		TemplateID:  q.templateID(runner),
+		NewID:       vmid,
+		Name:        runner.Name,
+		Target:      node,
+		Full:        q.cfg.FullClone,
+		Storage:     q.cfg.Storage,
+		Pool:        q.cfg.Pool,
+		Description: newOwnership(runner).Notes(),
+	}); err != nil {
+		if q.placer != nil {
+			q.placer.Forget(node, runner.Name)
+		}
+		return &nodeError{node: node, err: err}
+	}
+
+	return nil
+}
+
+// place picks the node the runner's VM is cloned to, sized after its spec and the template.
+func (q *Qemu) place(ctx context.Context, runner *scaler.Runner, failed []string) (string, error) {
+	if q.placer == nil {
+		return q.cfg.Node, nil
+	}
+
+	template, err := q.px.GetVMStatus(ctx, q.cfg.Node, q.templateID(runner))
+	if err != nil {
+		return "", fmt.Errorf("get template: %w", err)
+	}
+
+	return placeGuest(ctx, q.placer, runner, template, q.cfg.FullClone, failed)
+}
+
+// Reserve implements scaler.Reserver. It counts the runner's VM against the node it is to be cloned to.
+func (q *Qemu) Reserve(ctx context.Context, runner *scaler.Runner) error {
+	_, err := q.place(ctx, runner, nil)
+	return err
+}
+
+// Release implements scaler.Reserver.
+func (q *Qemu) Release(runner *scaler.Runner) {
+	if q.placer != nil {
+		q.placer.Forget("", runner.Name)
+	}
+}
+
+// templateID returns the template the runner's VM is cloned from.
+func (q *Qemu) templateID(runner *scaler.Runner) int {
+	if runner.Spec != nil && runner.Spec.TemplateID != 0 {
+		return runner.Spec.TemplateID
+	}
+	return q.cfg.TemplateID
+}
+
+// vmConfig returns the config set on the runner's VM after it is cloned: its tags and the resources of its spec.
+func (q *Qemu) vmConfig(runner *scaler.Runner) url.Values {
+	params := url.Values{"tags": {guestTags(runner)}}
+	if spec := runner.Spec; spec != nil {
+		if spec.Cores > 0 {
+			params.Set("cores", strconv.Itoa(spec.Cores))
+		}
+		if spec.MemoryMB > 0 {
+			params.Set("memory", strconv.Itoa(spec.MemoryMB))
+		}
+	}
+	return params
+}
+
+// resizeDisk grows the boot disk of the runner's VM to the size of its spec. A disk that is already as large is left
+// alone, so a retry does not resize it again.
+func (q *Qemu) resizeDisk(ctx context.Context, runner *scaler.Runner) error {
+	if runner.Spec == nil || runner.Spec.DiskGB == 0 {
+		return nil
+	}
+
+	size := int64(runner.Spec.DiskGB) << 30
+	guest, err := q.px.GetVMStatus(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return err
+	} else if guest.MaxDisk >= size {
+		return nil
+	}
+
+	upid, err := q.px.ResizeVMDisk(ctx, runner.Node, runner.VMID, q.cfg.Disk, size)
+	if err != nil {
+		return err
+	}
+	if upid != "" {
+		if _, err := q.px.WaitForTask(ctx, upid); err != nil {
+			return fmt.Errorf("resize disk of vm %d: %w", runner.VMID, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteVM stops the VM if it is running and deletes it.
+func (q *Qemu) deleteVM(ctx context.Context, runner *scaler.Runner, guest *proxmox.Guest) error {
+	if guest.IsRunning() {
+		upid, err := q.px.StopVM(ctx, runner.Node, runner.VMID)
+		if err != nil {
+			return err
+		}
+		if _, err := q.px.WaitForTask(ctx, upid); err != nil {
+			return fmt.Errorf("stop vm %d: %w", runner.VMID, err)
+		}
+	}
+
+	upid, err := q.px.DeleteVM(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return err
+	}
+	if _, err := q.px.WaitForTask(ctx, upid); err != nil {
+		return fmt.Errorf("delete vm %d: %w", runner.VMID, err)
+	}
+
+	return nil
+}
+
+// snippetName returns the name of the user data snippet of the runner.
+func snippetName(runner *scaler.Runner) string {
+	return runner.Name + ".yaml"
+}
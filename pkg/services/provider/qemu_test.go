@@ -0,0 +1,432 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/placement"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeProxmox is an in-memory cluster with a single node.
+type fakeProxmox struct {
+	proxmox.Client
+
+	nextID    int
+	vms       map[int]*proxmox.Guest
+	clones    []*proxmox.CloneRequest
+	cloudInit map[int]*proxmox.CloudInit
+	configs   map[int]url.Values
+	notes     map[int]string
+	snapshots map[int][]string
+	rollbacks []int
+	resized   []string
+	deleted   []int
+	failStart map[string]bool
+}
+
+func (f *fakeProxmox) UpdateVMConfig(_ context.Context, _ string, vmid int, params url.Values) error {
+	f.configs[vmid] = params
+	if tags := params.Get("tags"); tags != "" {
+		f.vms[vmid].Tags = tags
+	}
+	return nil
+}
+
+func (f *fakeProxmox) ResizeVMDisk(_ context.Context, _ string, vmid int, disk string, size int64) (proxmox.UPID, error) {
+	f.resized = append(f.resized, fmt.Sprintf("%d/%s/%d", vmid, disk, size>>30))
+	f.vms[vmid].MaxDisk = size
+	return proxmox.UPID(fmt.Sprintf("UPID:pve1:0:0:0:resize:%d:root@pam:", vmid)), nil
+}
+
+func (f *fakeProxmox) ClusterResources(context.Context, proxmox.ResourceType) ([]*proxmox.Resource, error) {
+	resources := make([]*proxmox.Resource, 0, len(f.vms))
+	for _, vm := range f.vms {
+		resources = append(resources, &proxmox.Resource{
+			Type:     "qemu",
+			Node:     "pve1",
+			Name:     vm.Name,
+			VMID:     vm.VMID,
+			Status:   vm.Status,
+			Tags:     vm.Tags,
+			Template: vm.Template,
+		})
+	}
+	return resources, nil
+}
+
+func newFakeProxmox() *fakeProxmox {
+	return &fakeProxmox{
+		nextID:    100,
+		vms:       make(map[int]*proxmox.Guest),
+		cloudInit: make(map[int]*proxmox.CloudInit),
+		configs:   make(map[int]url.Values),
+		notes:     make(map[int]string),
+		snapshots: make(map[int][]string),
+		failStart: make(map[string]bool),
+	}
+}
+
+func (f *fakeProxmox) NextID(context.Context) (int, error) {
+	for f.vms[f.nextID] != nil {
+		f.nextID++
+	}
+	return f.nextID, nil
+}
+
+func (f *fakeProxmox) IsIDFree(_ context.Context, vmid int) (bool, error) {
+	return f.vms[vmid] == nil, nil
+}
+
+func (f *fakeProxmox) CloneVM(_ context.Context, req *proxmox.CloneRequest) (*proxmox.TaskResult, error) {
+	f.clones = append(f.clones, req)
+	f.vms[req.NewID] = &proxmox.Guest{VMID: req.NewID, Name: req.Name, Status: "stopped", MaxDisk: 20 << 30}
+	f.notes[req.NewID] = req.Description
+	return &proxmox.TaskResult{ExitStatus: "OK"}, nil
+}
+
+func (f *fakeProxmox) GetVMConfig(_ context.Context, _ string, vmid int) (proxmox.GuestConfig, error) {
+	return proxmox.GuestConfig{"name": f.vms[vmid].Name, "tags": f.vms[vmid].Tags, "description": f.notes[vmid]}, nil
+}
+
+func (f *fakeProxmox) GetVMStatus(_ context.Context, _ string, vmid int) (*proxmox.Guest, error) {
+	vm, ok := f.vms[vmid]
+	if !ok {
+		return nil, &proxmox.APIError{HttpError: utils.NewHttpError(http.StatusInternalServerError, fmt.Sprintf("Configuration file 'nodes/pve1/qemu-server/%d.conf' does not exist", vmid))}
+	}
+	return vm, nil
+}
+
+func (f *fakeProxmox) SetCloudInit(_ context.Context, _ string, vmid int, ci *proxmox.CloudInit) error {
+	f.cloudInit[vmid] = ci
+	return nil
+}
+
+func (f *fakeProxmox) StartVM(_ context.Context, node string, vmid int) (proxmox.UPID, error) {
+	if f.failStart[node] {
+		return "", fmt.Errorf("start vm %d on %s: no space left on device", vmid, node)
+	}
+	f.vms[vmid].Status = "running"
+	return proxmox.UPID(fmt.Sprintf("UPID:pve1:0:0:0:qmstart:%d:root@pam:", vmid)), nil
+}
+
+func (f *fakeProxmox) StopVM(_ context.Context, _ string, vmid int) (proxmox.UPID, error) {
+	f.vms[vmid].Status = "stopped"
+	return proxmox.UPID(fmt.Sprintf("UPID:pve1:0:0:0:qmstop:%d:root@pam:", vmid)), nil
+}
+
+func (f *fakeProxmox) DeleteVM(_ context.Context, _ string, vmid int) (proxmox.UPID, error) {
+	delete(f.vms, vmid)
+	f.deleted = append(f.deleted, vmid)
+	return proxmox.UPID(fmt.Sprintf("UPID:pve1:0:0:0:qmdestroy:%d:root@pam:", vmid)), nil
+}
+
+func (f *fakeProxmox) WaitForTask(_ context.Context, upid proxmox.UPID) (*proxmox.TaskResult, error) {
+	return &proxmox.TaskResult{UPID: upid, ExitStatus: "OK"}, nil
+}
+
+type fakePlacer struct {
+	nodes     []string
+	err       error
+	requests  []*placement.Request
+	failed    []string
+	succeeded []string
+}
+
+func (f *fakePlacer) Place(_ context.Context, req *placement.Request) (string, error) {
+	f.requests = append(f.requests, req)
+	if f.err != nil {
+		return "", f.err
+	}
+	for _, node := range f.nodes {
+		if !slices.Contains(req.Exclude, node) {
+			return node, nil
+		}
+	}
+	return "", placement.ErrNoCapacity
+}
+
+func (f *fakePlacer) Forget(string, string) {}
+
+func (f *fakePlacer) Failed(node string, _ error) {
+	f.failed = append(f.failed, node)
+}
+
+func (f *fakePlacer) Succeeded(node string) {
+	f.succeeded = append(f.succeeded, node)
+}
+
+// newVMIDAllocator returns an allocator that reserves the VMIDs in a state file of its own.
+func newVMIDAllocator(t *testing.T, cfg VMIDConfig, px proxmox.Client) *VMIDAllocator {
+	store, err := scaler.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, err)
+	ids, err := NewVMIDAllocator(cfg, px, store)
+	require.NoError(t, err)
+	return ids
+}
+
+type QemuSuite struct {
+	suite.Suite
+
+	dir      string
+	px       *fakeProxmox
+	provider *Qemu
+}
+
+func TestQemuSuite(t *testing.T) {
+	suite.Run(t, new(QemuSuite))
+}
+
+func (s *QemuSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+	snippets, err := NewDirSnippetStore(s.dir, "shared")
+	s.Require().NoError(err)
+
+	s.px = newFakeProxmox()
+	s.provider, err = NewQemu(QemuConfig{
+		Node:       "pve1",
+		TemplateID: 9000,
+		User:       "runner",
+		SSHKeys:    []string{"ssh-ed25519 AAAA ops@example.com"},
+	}, s.px, snippets, nil, newVMIDAllocator(s.T(), VMIDConfig{}, s.px))
+	s.Require().NoError(err)
+}
+
+func (s *QemuSuite) TestProvision() {
+	runner := &scaler.Runner{Name: "pgr-1"}
+
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Equal("pve1", runner.Node)
+	s.Equal(100, runner.VMID)
+	s.Require().Len(s.px.clones, 1)
+	s.False(s.px.clones[0].Full)
+	s.True(s.px.vms[100].IsRunning())
+
+	s.Equal(&proxmox.CloudInit{
+		User:      "runner",
+		SSHKeys:   []string{"ssh-ed25519 AAAA ops@example.com"},
+		IPConfig0: "ip=dhcp",
+		UserData:  "shared:snippets/pgr-1.yaml",
+	}, s.px.cloudInit[100])
+
+	userData, err := os.ReadFile(filepath.Join(s.dir, "snippets", "pgr-1.yaml"))
+	s.Require().NoError(err)
+	s.Contains(string(userData), "#cloud-config\nhostname: pgr-1\n")
+	s.Contains(string(userData), "content: c2VjcmV0\n")
+	s.Contains(string(userData), `- "ssh-ed25519 AAAA ops@example.com"`)
+}
+
+func (s *QemuSuite) TestProvisionPlacesClone() {
+	s.px.vms[9000] = &proxmox.Guest{VMID: 9000, Name: "template", MaxMem: 4 << 30, CPUs: 2, MaxDisk: 20 << 30}
+	placer := &fakePlacer{nodes: []string{"pve3"}}
+	s.provider.placer = placer
+
+	runner := &scaler.Runner{Name: "pgr-1"}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Equal("pve3", runner.Node)
+	s.Equal("pve3", s.px.clones[0].Target)
+	s.Equal(&placement.Request{Name: "pgr-1", Memory: 4 << 30, Cores: 2}, placer.requests[0], "a linked clone needs no disk up front")
+}
+
+func (s *QemuSuite) TestProvisionWithoutCapacity() {
+	s.px.vms[9000] = &proxmox.Guest{VMID: 9000, Name: "template", MaxMem: 4 << 30, CPUs: 2}
+	s.provider.placer = &fakePlacer{err: fmt.Errorf("%w: pve1: has 8 of 8 vcpus allocated", placement.ErrNoCapacity)}
+
+	err := s.provider.Provision(context.Background(), &scaler.Runner{Name: "pgr-1"}, "c2VjcmV0")
+	s.ErrorIs(err, scaler.ErrNoCapacity, "the scaler must be told to queue the runner")
+	s.Empty(s.px.clones)
+}
+
+func (s *QemuSuite) TestProvisionRetriesOnAnotherNode() {
+	s.px.vms[9000] = &proxmox.Guest{VMID: 9000, Name: "template", MaxMem: 4 << 30, CPUs: 2}
+	placer := &fakePlacer{nodes: []string{"pve2", "pve3"}}
+	s.provider.placer = placer
+	s.provider.cfg.MinBackoff = time.Millisecond
+	s.px.failStart["pve2"] = true
+
+	runner := &scaler.Runner{Name: "pgr-1"}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Equal("pve3", runner.Node)
+	s.Equal([]string{"pve2"}, placer.failed)
+	s.Equal([]string{"pve3"}, placer.succeeded)
+	s.Equal([]string{"pve2"}, placer.requests[1].Exclude, "the retry must avoid the failed node")
+	s.Equal([]int{100}, s.px.deleted, "the vm on the failed node must be destroyed")
+	s.True(s.px.vms[runner.VMID].IsRunning())
+}
+
+func (s *QemuSuite) TestProvisionGivesUpAfterAttempts() {
+	s.px.vms[9000] = &proxmox.Guest{VMID: 9000, Name: "template", MaxMem: 4 << 30, CPUs: 2}
+	placer := &fakePlacer{nodes: []string{"pve1", "pve2", "pve3", "pve4"}}
+	s.provider.placer = placer
+	s.provider.cfg.MinBackoff = time.Millisecond
+	for _, node := range placer.nodes {
+		s.px.failStart[node] = true
+	}
+
+	err := s.provider.Provision(context.Background(), &scaler.Runner{Name: "pgr-1"}, "c2VjcmV0")
+	s.ErrorContains(err, "no space left on device")
+	s.Equal([]string{"pve1", "pve2", "pve3"}, placer.failed)
+	s.Empty(placer.succeeded)
+}
+
+func (s *QemuSuite) TestProvisionAppliesSpec() {
+	s.px.vms[9100] = &proxmox.Guest{VMID: 9100, Name: "large-template", MaxMem: 4 << 30, CPUs: 2}
+	placer := &fakePlacer{nodes: []string{"pve2"}}
+	s.provider.placer = placer
+
+	runner := &scaler.Runner{Name: "pgr-1", Spec: &scaler.MachineSpec{
+		Pool:         "large",
+		TemplateID:   9100,
+		Cores:        8,
+		MemoryMB:     16384,
+		NodeSelector: []string{"ssd"},
+	}}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Equal(9100, s.px.clones[0].TemplateID)
+	s.Equal(&placement.Request{Name: "pgr-1", Memory: 16 << 30, Cores: 8, NodeSelector: []string{"ssd"}}, placer.requests[0])
+	s.Equal(url.Values{"tags": {"gh-runner;pool-large"}, "cores": {"8"}, "memory": {"16384"}}, s.px.configs[100])
+	s.Empty(s.px.resized, "no disk size was asked for")
+}
+
+func (s *QemuSuite) TestProvisionGrowsDisk() {
+	runner := &scaler.Runner{Name: "pgr-1", Spec: &scaler.MachineSpec{Pool: "sized", DiskGB: 50}}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Equal([]string{"100/scsi0/50"}, s.px.resized, "a grown disk must not be resized again")
+}
+
+func (s *QemuSuite) TestProvisionRetryReusesClone() {
+	runner := &scaler.Runner{Name: "pgr-1"}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Len(s.px.clones, 1)
+	s.Equal(100, runner.VMID)
+}
+
+func (s *QemuSuite) TestProvisionReplacesReusedID() {
+	s.px.vms[100] = &proxmox.Guest{VMID: 100, Name: "someone-else"}
+	runner := &scaler.Runner{Name: "pgr-1", Node: "pve1", VMID: 100}
+
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Equal(101, runner.VMID)
+	s.Equal("someone-else", s.px.vms[100].Name)
+}
+
+func (s *QemuSuite) TestDestroy() {
+	runner := &scaler.Runner{Name: "pgr-1"}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Require().NoError(s.provider.Destroy(context.Background(), runner))
+
+	s.Equal([]int{100}, s.px.deleted)
+	s.NoFileExists(filepath.Join(s.dir, "snippets", "pgr-1.yaml"))
+
+	s.NoError(s.provider.Destroy(context.Background(), runner), "destroying twice must succeed")
+}
+
+func (s *QemuSuite) TestProvisionRendersCallback() {
+	s.provider.cfg.CallbackURL = "https://scaler.example.com/"
+	runner := &scaler.Runner{Name: "pgr-1"}
+
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	userData, err := os.ReadFile(filepath.Join(s.dir, "snippets", "pgr-1.yaml"))
+	s.Require().NoError(err)
+	s.Contains(string(userData), "Authorization: Bearer "+scaler.CallbackToken("c2VjcmV0", "pgr-1"))
+	s.Contains(string(userData), "https://scaler.example.com/api/runners/pgr-1/exited")
+}
+
+func (s *QemuSuite) TestListOnlyReturnsManagedVMs() {
+	s.px.vms[50] = &proxmox.Guest{VMID: 50, Name: "hand-built", Status: "running"}
+	s.px.vms[9000] = &proxmox.Guest{VMID: 9000, Name: "template", Tags: ManagedTag, Template: 1}
+
+	runner := &scaler.Runner{Name: "pgr-1"}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	machines, err := s.provider.List(context.Background())
+	s.Require().NoError(err)
+	s.Equal([]*scaler.Machine{{Name: "pgr-1", Node: "pve1", VMID: 100, Running: true}}, machines)
+}
+
+func (s *QemuSuite) TestStopped() {
+	runner := &scaler.Runner{Name: "pgr-1"}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	stopped, err := s.provider.Stopped(context.Background(), runner)
+	s.Require().NoError(err)
+	s.False(stopped)
+
+	s.px.vms[runner.VMID].Status = "stopped"
+	stopped, err = s.provider.Stopped(context.Background(), runner)
+	s.Require().NoError(err)
+	s.True(stopped, "the runner powers the vm off when it exits")
+
+	delete(s.px.vms, runner.VMID)
+	stopped, err = s.provider.Stopped(context.Background(), runner)
+	s.Require().NoError(err)
+	s.True(stopped)
+}
+
+func (s *QemuSuite) TestDestroyLeavesForeignVM() {
+	s.px.vms[100] = &proxmox.Guest{VMID: 100, Name: "hand-built", Status: "running"}
+
+	s.Require().NoError(s.provider.Destroy(context.Background(), &scaler.Runner{Name: "pgr-1", Node: "pve1", VMID: 100}))
+
+	s.Empty(s.px.deleted)
+	s.True(s.px.vms[100].IsRunning())
+}
+
+func (s *QemuSuite) TestProvisionRecordsOwnership() {
+	runner := &scaler.Runner{Name: "pgr-1", JobID: 42, Repository: "octo-org/monorepo", Spec: &scaler.MachineSpec{Pool: "large"}}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	owner, ok := ParseOwnership(s.px.clones[0].Description)
+	s.Require().True(ok, "the notes must be set when the vm is cloned")
+	s.Equal("pgr-1", owner.Runner)
+	s.Equal("large", owner.Pool)
+	s.Equal(int64(42), owner.JobID)
+	s.Equal("octo-org/monorepo", owner.Repository)
+	s.WithinDuration(time.Now(), owner.CreatedAt, time.Minute)
+}
+
+func (s *QemuSuite) TestDestroyLeavesVMOfAnotherOwner() {
+	s.px.vms[100] = &proxmox.Guest{VMID: 100, Name: "pgr-1", Status: "running"}
+	s.px.notes[100] = (&Ownership{Runner: "pgr-1-of-another-scaler"}).Notes()
+
+	s.Require().NoError(s.provider.Destroy(context.Background(), &scaler.Runner{Name: "pgr-1", Node: "pve1", VMID: 100}))
+	s.Empty(s.px.deleted)
+
+	delete(s.px.notes, 100)
+	s.Require().NoError(s.provider.Destroy(context.Background(), &scaler.Runner{Name: "pgr-1", Node: "pve1", VMID: 100}))
+	s.Empty(s.px.deleted, "a hand-built vm of the same name does not carry the notes")
+
+	s.px.vms[100].Tags = ManagedTag
+	s.Require().NoError(s.provider.Destroy(context.Background(), &scaler.Runner{Name: "pgr-1", Node: "pve1", VMID: 100}))
+	s.Empty(s.px.deleted, "the tag alone does not make the vm the runner's")
+}
+
+func TestDirSnippetStoreRejectsPaths(t *testing.T) {
+	snippets, err := NewDirSnippetStore(t.TempDir(), "shared")
+	require.NoError(t, err)
+
+	_, err = snippets.Put(context.Background(), "../escape.yaml", nil)
+	require.Error(t, err, "a name outside the snippets directory must be rejected")
+}
@@ -0,0 +1,139 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/placement"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+)
+
+// retryConfig is how often, and how long apart, the guest of a runner is tried on another node.
+type retryConfig struct {
+	attempts   int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// provisionWithRetries provisions the guest of the runner with attempt, which is given the nodes that failed so far. A
+// guest whose clone or start failed, which attempt returns as a nodeError, is destroyed and tried again on another
+// node after a backoff, until cfg.attempts attempts failed. The placer, if any, learns of every failure and success.
+func provisionWithRetries(
+	ctx context.Context,
+	runner *scaler.Runner,
+	placer Placer,
+	cfg retryConfig,
+	attempt func(ctx context.Context, failed []string) error,
+	destroy func(ctx context.Context) error,
+) error {
+	var failed []string
+	for n := 1; ; n++ {
+		err := attempt(ctx, failed)
+
+		var nodeErr *nodeError
+		if !errors.As(err, &nodeErr) {
+			if err == nil && placer != nil {
+				placer.Succeeded(runner.Node)
+			}
+			return err
+		}
+
+		if placer != nil {
+			placer.Failed(nodeErr.node, nodeErr.err)
+		}
+		if n >= cfg.attempts {
+			return err
+		}
+
+		// The guest is destroyed so that the next attempt clones it on another node.
+		if err := destroy(ctx); err != nil {
+			return fmt.Errorf("destroy guest after failed attempt: %w", err)
+		}
+		runner.Node = ""
+		runner.VMID = 0
+		runner.Address = ""
+		failed = append(failed, nodeErr.node)
+
+		delay := backoff(cfg, n)
+		slog.Warn("unable to provision runner machine, retrying on another node",
+			slog.String(logging.KeyRunner, runner.Name),
+			slog.String(logging.KeyNode, nodeErr.node),
+			slog.Int("attempt", n),
+			slog.Duration("backoff", delay),
+			slog.String(logging.KeyError, nodeErr.err.Error()),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff returns the delay before the retry after the given attempt: the doubled minimum backoff up to the maximum,
+// of which a random half is taken off, so that runners failing together do not retry together.
+func backoff(cfg retryConfig, attempt int) time.Duration {
+	d := cfg.minBackoff
+	for i := 1; i < attempt && d < cfg.maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, cfg.maxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// nodeError is a failure to clone or start a guest on a node, after which the guest is tried on another node.
+type nodeError struct {
+	node string
+	err  error
+}
+
+func (e *nodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nodeError) Unwrap() error {
+	return e.err
+}
+
+// placeGuest picks the node the runner's guest is cloned to, sized after its spec and the template, keeping it off
+// the failed nodes.
+func placeGuest(ctx context.Context, placer Placer, runner *scaler.Runner, template *proxmox.Guest, fullClone bool, failed []string) (string, error) {
+	req := &placement.Request{
+		Name:    runner.Name,
+		Memory:  template.MaxMem,
+		Cores:   int(template.CPUs),
+		Exclude: failed,
+	}
+	if fullClone {
+		// A linked clone only writes its changes, so only full clones need the space of the template up front.
+		req.Disk = template.MaxDisk
+	}
+	if spec := runner.Spec; spec != nil {
+		if spec.MemoryMB > 0 {
+			req.Memory = int64(spec.MemoryMB) << 20
+		}
+		if spec.Cores > 0 {
+			req.Cores = spec.Cores
+		}
+		if fullClone {
+			req.Disk = max(req.Disk, int64(spec.DiskGB)<<30)
+		}
+		req.NodeSelector = spec.NodeSelector
+	}
+
+	node, err := placer.Place(ctx, req)
+	if errors.Is(err, placement.ErrNoCapacity) {
+		// The scaler queues the runner until a node has room for it.
+		return "", fmt.Errorf("place runner %s: %w: %w", runner.Name, scaler.ErrNoCapacity, err)
+	} else if err != nil {
+		return "", fmt.Errorf("place runner %s: %w", runner.Name, err)
+	}
+	return node, nil
+}
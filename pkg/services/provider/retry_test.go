@@ -0,0 +1,18 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	cfg := retryConfig{minBackoff: 2 * time.Second, maxBackoff: 5 * time.Second}
+
+	for attempt, want := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		d := backoff(cfg, attempt+1)
+		require.GreaterOrEqual(t, d, want/2)
+		require.LessOrEqual(t, d, want)
+	}
+}
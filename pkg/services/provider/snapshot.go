@@ -0,0 +1,79 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+)
+
+// BaseSnapshot is the snapshot the VM of a recyclable runner is rolled back to after every job. It is taken after the
+// VM is cloned and configured, before it first boots.
+const BaseSnapshot = "base"
+
+// ensureSnapshot takes the base snapshot of the runner's VM, unless an earlier attempt did.
+func (q *Qemu) ensureSnapshot(ctx context.Context, runner *scaler.Runner) error {
+	snapshots, err := q.px.ListVMSnapshots(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return err
+	} else if slices.ContainsFunc(snapshots, func(s *proxmox.Snapshot) bool { return s.Name == BaseSnapshot }) {
+		return nil
+	}
+
+	upid, err := q.px.CreateVMSnapshot(ctx, runner.Node, runner.VMID, BaseSnapshot, "The clean disk every job of the runner starts from.")
+	if err != nil {
+		return err
+	}
+	if _, err := q.px.WaitForTask(ctx, upid); err != nil {
+		return fmt.Errorf("snapshot vm %d: %w", runner.VMID, err)
+	}
+
+	slog.Debug("base snapshot taken",
+		slog.String(logging.KeyRunner, runner.Name),
+		slog.Int(logging.KeyVMID, runner.VMID),
+	)
+	return nil
+}
+
+// Recycle implements scaler.Recycler. It rolls the VM of the runner back to its base snapshot, which stops it, and
+// boots it again with the new just-in-time config, so that the next job gets the disk of a fresh clone without the
+// time a clone takes. A VM that no longer belongs to the runner is never rolled back.
+func (q *Qemu) Recycle(ctx context.Context, runner *scaler.Runner, jitConfig string) error {
+	if runner.VMID == 0 {
+		return scaler.ErrNoMachine
+	}
+
+	guest, err := q.px.GetVMStatus(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return err
+	} else if guest.Name != runner.Name {
+		return fmt.Errorf("vm %d no longer belongs to the runner", runner.VMID)
+	}
+
+	config, err := q.px.GetVMConfig(ctx, runner.Node, runner.VMID)
+	if err != nil {
+		return err
+	} else if !owns(config, runner.Name) {
+		return fmt.Errorf("vm %d does not carry the ownership marker of the runner", runner.VMID)
+	}
+
+	upid, err := q.px.RollbackVMSnapshot(ctx, runner.Node, runner.VMID, BaseSnapshot)
+	if err != nil {
+		return err
+	}
+	if _, err := q.px.WaitForTask(ctx, upid); err != nil {
+		return fmt.Errorf("roll back vm %d: %w", runner.VMID, err)
+	}
+	runner.Address = ""
+
+	slog.Debug("vm rolled back to its base snapshot",
+		slog.String(logging.KeyRunner, runner.Name),
+		slog.Int(logging.KeyVMID, runner.VMID),
+	)
+
+	return q.boot(ctx, runner, jitConfig)
+}
@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+)
+
+func (f *fakeProxmox) ListVMSnapshots(_ context.Context, _ string, vmid int) ([]*proxmox.Snapshot, error) {
+	snapshots := make([]*proxmox.Snapshot, 0, len(f.snapshots[vmid])+1)
+	for _, name := range f.snapshots[vmid] {
+		snapshots = append(snapshots, &proxmox.Snapshot{Name: name})
+	}
+	return append(snapshots, &proxmox.Snapshot{Name: "current"}), nil
+}
+
+func (f *fakeProxmox) CreateVMSnapshot(_ context.Context, _ string, vmid int, name, _ string) (proxmox.UPID, error) {
+	if f.vms[vmid].IsRunning() {
+		return "", fmt.Errorf("vm %d must not have booted before it is snapshotted", vmid)
+	}
+	f.snapshots[vmid] = append(f.snapshots[vmid], name)
+	return proxmox.UPID(fmt.Sprintf("UPID:pve1:0:0:0:qmsnapshot:%d:root@pam:", vmid)), nil
+}
+
+func (f *fakeProxmox) RollbackVMSnapshot(_ context.Context, _ string, vmid int, name string) (proxmox.UPID, error) {
+	if len(f.snapshots[vmid]) == 0 || f.snapshots[vmid][0] != name {
+		return "", fmt.Errorf("vm %d has no snapshot %s", vmid, name)
+	}
+	f.vms[vmid].Status = "stopped"
+	f.rollbacks = append(f.rollbacks, vmid)
+	return proxmox.UPID(fmt.Sprintf("UPID:pve1:0:0:0:qmrollback:%d:root@pam:", vmid)), nil
+}
+
+func (s *QemuSuite) TestProvisionSnapshotsRecyclableVM() {
+	runner := &scaler.Runner{Name: "pgr-small-1", Recyclable: true}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "c2VjcmV0"))
+
+	s.Equal([]string{BaseSnapshot}, s.px.snapshots[100], "a retry must not snapshot the vm again")
+	s.True(s.px.vms[100].IsRunning())
+
+	s.Require().NoError(s.provider.Provision(context.Background(), &scaler.Runner{Name: "pgr-1"}, "c2VjcmV0"))
+	s.Empty(s.px.snapshots[101], "only recyclable runners are snapshotted")
+}
+
+func (s *QemuSuite) TestRecycle() {
+	runner := &scaler.Runner{Name: "pgr-small-1", Recyclable: true}
+	s.Require().NoError(s.provider.Provision(context.Background(), runner, "Zmlyc3Q="))
+
+	s.Require().NoError(s.provider.Recycle(context.Background(), runner, "c2Vjb25k"))
+
+	s.Equal([]int{100}, s.px.rollbacks)
+	s.True(s.px.vms[100].IsRunning())
+	s.Len(s.px.clones, 1, "a recycled vm must not be cloned again")
+	s.Empty(s.px.deleted)
+
+	userData, err := os.ReadFile(filepath.Join(s.dir, "snippets", "pgr-small-1.yaml"))
+	s.Require().NoError(err)
+	s.Contains(string(userData), "c2Vjb25k")
+	s.NotContains(string(userData), "Zmlyc3Q=")
+}
+
+func (s *QemuSuite) TestRecycleLeavesVMOfAnotherOwner() {
+	s.px.vms[100] = &proxmox.Guest{VMID: 100, Name: "pgr-small-1", Status: "running"}
+	s.px.snapshots[100] = []string{BaseSnapshot}
+	s.px.notes[100] = (&Ownership{Runner: "pgr-small-1-of-another-scaler"}).Notes()
+
+	err := s.provider.Recycle(context.Background(), &scaler.Runner{Name: "pgr-small-1", Node: "pve1", VMID: 100}, "c2VjcmV0")
+	s.EqualError(err, "vm 100 does not carry the ownership marker of the runner")
+	s.Empty(s.px.rollbacks)
+}
+
+func (s *QemuSuite) TestBackendsRecycleUnsupported() {
+	backends := Backends{"qemu": s.provider, "lxc": new(Lxc)}
+
+	err := backends.Recycle(context.Background(), &scaler.Runner{Spec: &scaler.MachineSpec{Backend: "lxc"}}, "c2VjcmV0")
+	s.ErrorIs(err, scaler.ErrRecycleUnsupported)
+}
@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SnippetStore stores snippets, e.g. cloud-init user data, on a storage with the snippets content type.
+type SnippetStore interface {
+	// Put writes the snippet and returns the volume ID the VM config refers to it by, e.g. local:snippets/name.yaml.
+	Put(ctx context.Context, name string, data []byte) (string, error)
+
+	// PutScript writes the snippet as an executable, e.g. a hook script, and returns its volume ID.
+	PutScript(ctx context.Context, name string, data []byte) (string, error)
+
+	// Delete removes the snippet. Deleting a snippet that does not exist is not an error.
+	Delete(ctx context.Context, name string) error
+}
+
+// DirSnippetStore writes snippets to the directory a directory-type Proxmox storage is mounted at. The Proxmox API
+// cannot upload snippets, so the storage must be shared with the scaler, e.g. over NFS or CephFS.
+type DirSnippetStore struct {
+	// dir is where the storage is mounted. Snippets are written to its snippets directory.
+	dir string
+
+	// storage is the ID of the storage in Proxmox.
+	storage string
+}
+
+// NewDirSnippetStore creates a new DirSnippetStore.
+func NewDirSnippetStore(dir, storage string) (*DirSnippetStore, error) {
+	if dir == "" || storage == "" {
+		return nil, errors.New("snippet directory and storage are required")
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "snippets"), 0o755); err != nil {
+		return nil, fmt.Errorf("create snippets directory: %w", err)
+	}
+
+	return &DirSnippetStore{
+		dir:     dir,
+		storage: storage,
+	}, nil
+}
+
+func (s *DirSnippetStore) Put(_ context.Context, name string, data []byte) (string, error) {
+	return s.put(name, data, 0o600)
+}
+
+func (s *DirSnippetStore) PutScript(_ context.Context, name string, data []byte) (string, error) {
+	return s.put(name, data, 0o700)
+}
+
+// put writes the snippet with the given permissions and returns its volume ID.
+func (s *DirSnippetStore) put(name string, data []byte, perm os.FileMode) (string, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file first so that a VM never boots with half a snippet.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return "", fmt.Errorf("write snippet: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("rename snippet: %w", err)
+	}
+
+	return fmt.Sprintf("%s:snippets/%s", s.storage, name), nil
+}
+
+func (s *DirSnippetStore) Delete(_ context.Context, name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete snippet: %w", err)
+	}
+	return nil
+}
+
+// path returns the path of the snippet, refusing names that would escape the snippets directory.
+func (s *DirSnippetStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid snippet name %q", name)
+	}
+	return filepath.Join(s.dir, "snippets", name), nil
+}
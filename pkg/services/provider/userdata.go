@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// DefaultRunnerURL is the runner release the default user data installs.
+const DefaultRunnerURL = "https://github.com/actions/runner/releases/download/v2.321.0/actions-runner-linux-x64-2.321.0.tar.gz"
+
+// DefaultUserDataTemplate is the cloud-init user data that installs the runner and starts it with its just-in-time
+// config. The runner exits after its job, which reports the exit to the scaler and powers the VM off.
+const DefaultUserDataTemplate = `#cloud-config
+hostname: {{ .Hostname }}
+users:
+  - name: {{ .User }}
+    shell: /bin/bash
+    sudo: ALL=(ALL) NOPASSWD:ALL
+{{- if .SSHKeys }}
+    ssh_authorized_keys:
+{{- range .SSHKeys }}
+      - {{ printf "%q" . }}
+{{- end }}
+{{- end }}
+write_files:
+  - path: /etc/actions-runner/jitconfig
+    owner: {{ .User }}:{{ .User }}
+    permissions: "0600"
+    defer: true
+    content: {{ .JITConfig }}
+  - path: /etc/systemd/system/actions-runner.service
+    content: |
+      [Unit]
+      Description=GitHub Actions runner
+      Wants=network-online.target
+      After=network-online.target
+
+      [Service]
+      User={{ .User }}
+      WorkingDirectory=/opt/actions-runner
+      ExecStart=/bin/sh -c 'exec ./run.sh --jitconfig "$$(cat /etc/actions-runner/jitconfig)"'
+{{- if .CallbackURL }}
+      ExecStopPost=-/usr/bin/curl -fsS -m 10 -X POST -H "Authorization: Bearer {{ .CallbackToken }}" {{ .CallbackURL }}
+{{- end }}
+      ExecStopPost=+/usr/bin/systemctl poweroff
+
+      [Install]
+      WantedBy=multi-user.target
+runcmd:
+  - mkdir -p /opt/actions-runner
+  - curl -fsSL {{ printf "%q" .RunnerURL }} | tar -xz -C /opt/actions-runner
+  - /opt/actions-runner/bin/installdependencies.sh
+  - chown -R {{ .User }}:{{ .User }} /opt/actions-runner
+  - systemctl daemon-reload
+  - systemctl enable --now actions-runner.service
+`
+
+// UserData is the data the user data template is rendered with.
+type UserData struct {
+	// Hostname is the hostname of the machine, which is the runner name.
+	Hostname string
+
+	// User is the user the runner runs as.
+	User string
+
+	// SSHKeys are the public keys authorized for the user.
+	SSHKeys []string
+
+	// JITConfig is the encoded just-in-time config of the runner.
+	JITConfig string
+
+	// RunnerURL is where the runner release is downloaded from.
+	RunnerURL string
+
+	// CallbackURL is where the runner reports its exit to. It is empty when the runner does not call back.
+	CallbackURL string
+
+	// CallbackToken authenticates the exit callback.
+	CallbackToken string
+}
+
+// parseUserDataTemplate parses the user data template, falling back to DefaultUserDataTemplate.
+func parseUserDataTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultUserDataTemplate
+	}
+
+	tmpl, err := template.New("user-data").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse user data template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// parseBootstrapTemplate parses the bootstrap script template of containers, falling back to DefaultBootstrapTemplate.
+func parseBootstrapTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultBootstrapTemplate
+	}
+
+	tmpl, err := template.New("bootstrap").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse bootstrap template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// renderUserData renders the user data. The result holds the just-in-time config, so it must only be logged under
+// logging.KeyUserData.
+func renderUserData(tmpl *template.Template, data *UserData) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return nil, fmt.Errorf("render user data: %w", err)
+	}
+	return buf.Bytes(), nil
+}
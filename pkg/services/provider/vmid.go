@@ -0,0 +1,132 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+)
+
+// maxVMID is the largest VMID Proxmox VE allows.
+const maxVMID = 999999999
+
+// VMIDReservations holds the VMIDs reserved for the runners whose machines are being cloned.
+type VMIDReservations interface {
+	// ReserveVMID reserves the VMID for the owner until the given time. It returns false if another owner holds it.
+	ReserveVMID(ctx context.Context, vmid int, owner string, until time.Time) (bool, error)
+
+	// ReleaseVMID drops the owner's reservation of the VMID.
+	ReleaseVMID(ctx context.Context, vmid int, owner string) error
+}
+
+// VMIDConfig is the configuration for the VMIDAllocator.
+type VMIDConfig struct {
+	// Range is the range the VMIDs of the runners whose spec has no range are taken from. They take any free VMID
+	// from /cluster/nextid on when it is nil.
+	Range *routing.VMIDRange
+
+	// ReservationTTL is how long a VMID stays reserved for a runner if it is not released, e.g. because the scaler
+	// crashed mid-clone. It must be longer than a clone takes. Defaults to 10 minutes.
+	ReservationTTL time.Duration
+}
+
+// VMIDAllocator hands out the VMIDs of new clones. /cluster/nextid alone returns the same ID to clones started at the
+// same time, so every ID handed out is reserved in the state store until its clone exists or has failed.
+type VMIDAllocator struct {
+	cfg VMIDConfig
+
+	// px is the Proxmox client.
+	px proxmox.Client
+
+	// reservations holds the reserved VMIDs.
+	reservations VMIDReservations
+}
+
+// NewVMIDAllocator creates a new VMIDAllocator.
+func NewVMIDAllocator(cfg VMIDConfig, px proxmox.Client, reservations VMIDReservations) (*VMIDAllocator, error) {
+	if cfg.Range != nil {
+		if err := cfg.Range.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ReservationTTL == 0 {
+		cfg.ReservationTTL = 10 * time.Minute
+	}
+
+	return &VMIDAllocator{
+		cfg:          cfg,
+		px:           px,
+		reservations: reservations,
+	}, nil
+}
+
+// Allocate reserves a free VMID in the range of the runner's spec, or the default range, for the runner. The lowest
+// free ID is taken. It returns scaler.ErrNoCapacity when every ID of the range is taken, so that the runner is queued
+// until one is freed.
+func (a *VMIDAllocator) Allocate(ctx context.Context, runner *scaler.Runner) (int, error) {
+	r := a.cfg.Range
+	if runner.Spec != nil && runner.Spec.VMIDRange != nil {
+		r = runner.Spec.VMIDRange
+	}
+	if r == nil {
+		first, err := a.px.NextID(ctx)
+		if err != nil {
+			return 0, err
+		}
+		r = &routing.VMIDRange{Min: first, Max: maxVMID}
+	}
+
+	resources, err := a.px.ClusterResources(ctx, proxmox.ResourceTypeVM)
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[int]struct{}, len(resources))
+	for _, res := range resources {
+		used[res.VMID] = struct{}{}
+	}
+
+	until := time.Now().Add(a.cfg.ReservationTTL)
+	for vmid := r.Min; vmid <= r.Max; vmid++ {
+		if _, ok := used[vmid]; ok {
+			continue
+		}
+
+		ok, err := a.reservations.ReserveVMID(ctx, vmid, runner.Name, until)
+		if err != nil {
+			return 0, fmt.Errorf("reserve vmid %d: %w", vmid, err)
+		} else if !ok {
+			continue
+		}
+
+		// The resources may be stale, or a guest may have been created outside of the scaler since.
+		free, err := a.px.IsIDFree(ctx, vmid)
+		if err != nil || !free {
+			a.Release(ctx, runner, vmid)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		return vmid, nil
+	}
+
+	return 0, fmt.Errorf("%w: every vmid in %s is taken", scaler.ErrNoCapacity, r)
+}
+
+// Release drops the reservation of the runner's VMID, once its clone exists or has failed. A failure is only logged,
+// the reservation then expires on its own.
+func (a *VMIDAllocator) Release(ctx context.Context, runner *scaler.Runner, vmid int) {
+	if err := a.reservations.ReleaseVMID(ctx, vmid, runner.Name); err != nil {
+		slog.Warn("unable to release vmid reservation",
+			slog.String(logging.KeyRunner, runner.Name),
+			slog.Int(logging.KeyVMID, vmid),
+			slog.String(logging.KeyError, err.Error()),
+		)
+	}
+}
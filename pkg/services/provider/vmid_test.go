@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/proxmox"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scaler"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedProxmox serialises the calls to the fake cluster, which the allocator makes from several goroutines.
+type lockedProxmox struct {
+	*fakeProxmox
+
+	mut sync.Mutex
+}
+
+func (l *lockedProxmox) NextID(ctx context.Context) (int, error) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.fakeProxmox.NextID(ctx)
+}
+
+func (l *lockedProxmox) IsIDFree(ctx context.Context, vmid int) (bool, error) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.fakeProxmox.IsIDFree(ctx, vmid)
+}
+
+func (l *lockedProxmox) ClusterResources(ctx context.Context, t proxmox.ResourceType) ([]*proxmox.Resource, error) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.fakeProxmox.ClusterResources(ctx, t)
+}
+
+func TestVMIDAllocatorTakesLowestFreeIDOfRange(t *testing.T) {
+	px := newFakeProxmox()
+	px.vms[9000] = &proxmox.Guest{VMID: 9000, Name: "template", Template: 1}
+	ids := newVMIDAllocator(t, VMIDConfig{Range: &routing.VMIDRange{Min: 9000, Max: 9499}}, px)
+	ctx := context.Background()
+
+	vmid, err := ids.Allocate(ctx, &scaler.Runner{Name: "pgr-1"})
+	require.NoError(t, err)
+	require.Equal(t, 9001, vmid)
+
+	vmid, err = ids.Allocate(ctx, &scaler.Runner{Name: "pgr-2"})
+	require.NoError(t, err)
+	require.Equal(t, 9002, vmid, "9001 is reserved for pgr-1 until it is cloned")
+
+	vmid, err = ids.Allocate(ctx, &scaler.Runner{Name: "pgr-3", Spec: &scaler.MachineSpec{
+		VMIDRange: &routing.VMIDRange{Min: 9500, Max: 9999},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, 9500, vmid, "the range of the spec wins")
+
+	ids.Release(ctx, &scaler.Runner{Name: "pgr-1"}, 9001)
+	vmid, err = ids.Allocate(ctx, &scaler.Runner{Name: "pgr-4"})
+	require.NoError(t, err)
+	require.Equal(t, 9001, vmid)
+}
+
+func TestVMIDAllocatorStartsAtNextID(t *testing.T) {
+	px := newFakeProxmox()
+	px.vms[100] = &proxmox.Guest{VMID: 100, Name: "hand-built"}
+	ids := newVMIDAllocator(t, VMIDConfig{}, px)
+
+	vmid, err := ids.Allocate(context.Background(), &scaler.Runner{Name: "pgr-1"})
+	require.NoError(t, err)
+	require.Equal(t, 101, vmid)
+
+	vmid, err = ids.Allocate(context.Background(), &scaler.Runner{Name: "pgr-2"})
+	require.NoError(t, err)
+	require.Equal(t, 102, vmid, "nextid returns 101 again until pgr-1 is cloned")
+}
+
+func TestVMIDAllocatorNeverHandsOutAnIDTwice(t *testing.T) {
+	px := &lockedProxmox{fakeProxmox: newFakeProxmox()}
+	ids := newVMIDAllocator(t, VMIDConfig{Range: &routing.VMIDRange{Min: 9000, Max: 9499}}, px)
+
+	var (
+		wg   sync.WaitGroup
+		mut  sync.Mutex
+		seen = make(map[int]string)
+	)
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runner := &scaler.Runner{Name: "pgr-" + string(rune('a'+i))}
+			vmid, err := ids.Allocate(context.Background(), runner)
+			require.NoError(t, err)
+
+			mut.Lock()
+			defer mut.Unlock()
+			require.NotContains(t, seen, vmid, "vmid %d handed out twice", vmid)
+			seen[vmid] = runner.Name
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, seen, 20)
+}
+
+func TestVMIDAllocatorQueuesWhenRangeIsFull(t *testing.T) {
+	px := newFakeProxmox()
+	px.vms[9000] = &proxmox.Guest{VMID: 9000, Name: "pgr-1"}
+	ids := newVMIDAllocator(t, VMIDConfig{Range: &routing.VMIDRange{Min: 9000, Max: 9001}}, px)
+
+	_, err := ids.Allocate(context.Background(), &scaler.Runner{Name: "pgr-2"})
+	require.NoError(t, err)
+
+	_, err = ids.Allocate(context.Background(), &scaler.Runner{Name: "pgr-3"})
+	require.ErrorIs(t, err, scaler.ErrNoCapacity)
+	require.EqualError(t, err, "no capacity for the machine: every vmid in 9000-9001 is taken")
+}
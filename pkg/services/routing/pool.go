@@ -0,0 +1,126 @@
+package routing
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// BackendQemu runs the runners of a pool in QEMU virtual machines. It is the default.
+	BackendQemu = "qemu"
+
+	// BackendLXC runs the runners of a pool in LXC containers.
+	BackendLXC = "lxc"
+)
+
+// Pool is a kind of runner. The jobs routed to it get a machine of its shape.
+type Pool struct {
+	// Name is the name of the pool.
+	Name string `mapstructure:"name"`
+
+	// Labels are the runs-on labels the pool serves. A label can be a wildcard pattern as understood by path.Match,
+	// e.g. proxmox-* or linux-?64.
+	Labels []string `mapstructure:"labels"`
+
+	// Backend is what the machines of the pool are, BackendQemu or BackendLXC. Defaults to BackendQemu.
+	Backend string `mapstructure:"backend"`
+
+	// TemplateID is the VMID of the template the machines of the pool are cloned from, a container template for
+	// BackendLXC. Defaults to the template of the backend.
+	TemplateID int `mapstructure:"template_id"`
+
+	// Cores is the number of vCPUs of the machines. The template's are kept when it is zero.
+	Cores int `mapstructure:"cores"`
+
+	// MemoryMB is the memory of the machines in MiB. The template's is kept when it is zero.
+	MemoryMB int `mapstructure:"memory_mb"`
+
+	// VMIDRange is the range the VMIDs of the machines of the pool are taken from. The machines take any free VMID
+	// when it is nil.
+	VMIDRange *VMIDRange `mapstructure:"vmid_range"`
+
+	// NodeSelector are the placement labels a node must carry to take a machine of the pool.
+	NodeSelector []string `mapstructure:"node_selector"`
+
+	// MaxSize is the maximum number of runners of the pool at once. Zero is unlimited.
+	MaxSize int `mapstructure:"max_size"`
+
+	// MaxCores is the maximum number of vCPUs of the runners of the pool at once. Zero is unlimited.
+	MaxCores int `mapstructure:"max_cores"`
+
+	// MaxMemoryMB is the maximum memory in MiB of the runners of the pool at once. Zero is unlimited.
+	MaxMemoryMB int `mapstructure:"max_memory_mb"`
+
+	// Sizes are the machine shapes the jobs of the pool can ask for with a size label. The pool serves no size labels
+	// when it is nil.
+	Sizes *SizeLimits `mapstructure:"sizes"`
+}
+
+// VMIDRange is a range of VMIDs, both ends included.
+type VMIDRange struct {
+	// Min is the first VMID of the range.
+	Min int `mapstructure:"min" json:"min"`
+
+	// Max is the last VMID of the range.
+	Max int `mapstructure:"max" json:"max"`
+}
+
+// Validate returns an error if the range is empty or holds IDs Proxmox VE does not allow, which are 100 to 999999999.
+func (r *VMIDRange) Validate() error {
+	if r.Min < 100 || r.Max > 999999999 {
+		return fmt.Errorf("vmid range %s must be within 100-999999999", r)
+	} else if r.Min > r.Max {
+		return fmt.Errorf("vmid range %s is empty", r)
+	}
+	return nil
+}
+
+// String returns the range as min-max.
+func (r *VMIDRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// Serves returns true if the runners of the pool satisfy every one of the labels. Labels in implicit are satisfied
+// by every pool, e.g. self-hosted, and size labels by every pool that allows sizes, whatever the size. Labels are
+// compared case-insensitively, as GitHub does.
+func (p *Pool) Serves(labels, implicit []string) bool {
+	for _, label := range labels {
+		if _, ok := ParseSizeLabel(label); ok && p.Sizes != nil {
+			continue
+		}
+		if !matchAny(implicit, label) && !matchAny(p.Labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// Size returns the size the labels ask for, or nil if they ask for none or the pool allows no sizes.
+func (p *Pool) Size(labels []string) (*Size, error) {
+	if p.Sizes == nil {
+		return nil, nil
+	}
+
+	size, err := SizeFromLabels(labels)
+	if err != nil || size == nil {
+		return nil, err
+	}
+
+	if err := p.Sizes.Check(*size); err != nil {
+		return nil, fmt.Errorf("pool %s: %w", p.Name, err)
+	}
+
+	return size, nil
+}
+
+// matchAny returns true if the label matches one of the patterns.
+func matchAny(patterns []string, label string) bool {
+	label = strings.ToLower(label)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), label); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrNoPool is returned when no pool serves the labels of a job.
+var ErrNoPool = errors.New("no pool matches the labels")
+
+// Router routes jobs to pools by their runs-on labels.
+type Router struct {
+	// pools are the pools in the order they are tried.
+	pools []*Pool
+
+	// implicit are the labels every runner carries whatever its pool.
+	implicit []string
+}
+
+// NewRouter creates a Router for the pools. The pools are tried in order, so a catch-all pool goes last. Labels in
+// implicit are carried by the runners of every pool, e.g. self-hosted and linux.
+func NewRouter(pools []Pool, implicit []string) (*Router, error) {
+	names := make(map[string]struct{}, len(pools))
+	r := &Router{
+		pools:    make([]*Pool, 0, len(pools)),
+		implicit: implicit,
+	}
+
+	for i := range pools {
+		p := &pools[i]
+
+		if p.Name == "" {
+			return nil, fmt.Errorf("pool %d has no name", i)
+		} else if strings.ContainsAny(p.Name, "/ ") {
+			return nil, fmt.Errorf("pool name %q must not contain slashes or spaces", p.Name)
+		}
+		if _, ok := names[p.Name]; ok {
+			return nil, fmt.Errorf("pool %q is defined twice", p.Name)
+		}
+		names[p.Name] = struct{}{}
+
+		for _, pattern := range p.Labels {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("pool %q: label %q: %w", p.Name, pattern, err)
+			}
+		}
+		switch p.Backend {
+		case "", BackendQemu, BackendLXC:
+		default:
+			return nil, fmt.Errorf("pool %q: unknown backend %q", p.Name, p.Backend)
+		}
+		if p.MaxSize < 0 || p.MaxCores < 0 || p.MaxMemoryMB < 0 || p.Cores < 0 || p.MemoryMB < 0 {
+			return nil, fmt.Errorf("pool %q: limits and resources must not be negative", p.Name)
+		}
+		// The template's resources are not known up front, so they cannot be counted against the pool's limits.
+		if (p.MaxCores > 0 && p.Cores == 0) || (p.MaxMemoryMB > 0 && p.MemoryMB == 0) {
+			return nil, fmt.Errorf("pool %q: max cores and max memory need the pool's cores and memory set", p.Name)
+		}
+		if p.VMIDRange != nil {
+			if err := p.VMIDRange.Validate(); err != nil {
+				return nil, fmt.Errorf("pool %q: %w", p.Name, err)
+			}
+		}
+		if l := p.Sizes; l != nil && (l.Min.Cores > l.Max.Cores || l.Min.MemoryMB > l.Max.MemoryMB || l.Min.DiskGB > l.Max.DiskGB) {
+			return nil, fmt.Errorf("pool %q: minimum size %s is above the maximum %s", p.Name, l.Min, l.Max)
+		}
+
+		r.pools = append(r.pools, p)
+	}
+
+	return r, nil
+}
+
+// Route returns the first pool that serves every one of the labels and allows the size they ask for. It returns
+// ErrSizeOutOfRange if the pools that serve the labels do not allow the size, and ErrNoPool if no pool serves them.
+func (r *Router) Route(labels []string) (*Pool, error) {
+	var sizeErr error
+	for _, p := range r.pools {
+		if !p.Serves(labels, r.implicit) {
+			continue
+		}
+
+		if _, err := p.Size(labels); err != nil {
+			sizeErr = errors.Join(sizeErr, err)
+			continue
+		}
+
+		return p, nil
+	}
+
+	if sizeErr != nil {
+		return nil, sizeErr
+	}
+
+	return nil, fmt.Errorf("%w %s", ErrNoPool, strings.Join(labels, ", "))
+}
+
+// Rejected returns true if the error means that no pool can ever take the job.
+func Rejected(err error) bool {
+	return errors.Is(err, ErrNoPool) || errors.Is(err, ErrSizeOutOfRange)
+}
+
+// Pool returns the pool with the given name.
+func (r *Router) Pool(name string) (*Pool, bool) {
+	for _, p := range r.pools {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Pools returns the pools in the order they are tried.
+func (r *Router) Pools() []*Pool {
+	return r.pools
+}
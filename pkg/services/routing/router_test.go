@@ -0,0 +1,68 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoute(t *testing.T) {
+	r, err := NewRouter([]Pool{
+		{Name: "gpu", Labels: []string{"proxmox", "gpu"}},
+		{Name: "large", Labels: []string{"proxmox", "large", "arch-*"}},
+		{Name: "default", Labels: []string{"proxmox"}},
+	}, []string{"self-hosted", "linux", "x64"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		labels  []string
+		want    string
+		wantErr bool
+	}{
+		{name: "exact", labels: []string{"self-hosted", "gpu"}, want: "gpu"},
+		{name: "case insensitive", labels: []string{"Self-Hosted", "GPU"}, want: "gpu"},
+		{name: "wildcard", labels: []string{"proxmox", "large", "arch-amd64"}, want: "large"},
+		{name: "all labels must match", labels: []string{"proxmox", "gpu", "large"}, wantErr: true},
+		{name: "first match wins", labels: []string{"proxmox"}, want: "gpu"},
+		{name: "implicit only", labels: []string{"self-hosted", "linux"}, want: "gpu"},
+		{name: "no match", labels: []string{"ubuntu-latest"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Route(tt.labels)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrNoPool)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got.Name)
+		})
+	}
+}
+
+func TestNewRouterValidates(t *testing.T) {
+	tests := []struct {
+		name  string
+		pools []Pool
+	}{
+		{name: "no name", pools: []Pool{{Labels: []string{"a"}}}},
+		{name: "duplicate", pools: []Pool{{Name: "a"}, {Name: "a"}}},
+		{name: "bad pattern", pools: []Pool{{Name: "a", Labels: []string{"[a"}}}},
+		{name: "negative size", pools: []Pool{{Name: "a", MaxSize: -1}}},
+		{name: "max cores without cores", pools: []Pool{{Name: "a", MaxCores: 8}}},
+		{name: "max memory without memory", pools: []Pool{{Name: "a", Cores: 2, MaxMemoryMB: 8192}}},
+		{name: "min above max", pools: []Pool{{Name: "a", Sizes: &SizeLimits{Min: Size{Cores: 8}, Max: Size{Cores: 4}}}}},
+		{name: "unknown backend", pools: []Pool{{Name: "a", Backend: "docker"}}},
+		{name: "empty vmid range", pools: []Pool{{Name: "a", VMIDRange: &VMIDRange{Min: 9500, Max: 9000}}}},
+		{name: "reserved vmids", pools: []Pool{{Name: "a", VMIDRange: &VMIDRange{Min: 0, Max: 99}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRouter(tt.pools, nil)
+			require.Error(t, err)
+		})
+	}
+}
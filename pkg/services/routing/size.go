@@ -0,0 +1,132 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SizeLabelPrefix is the prefix of the labels a job asks for the shape of its machine with, e.g.
+// proxmox-cpu4-mem8g-disk50g.
+const SizeLabelPrefix = "proxmox-"
+
+// ErrSizeOutOfRange is returned when a job asks for a machine shape the pool it was routed to does not allow.
+var ErrSizeOutOfRange = errors.New("size is out of range")
+
+// Size is a machine shape. A zero field is not set.
+type Size struct {
+	// Cores is the number of vCPUs.
+	Cores int `mapstructure:"cores"`
+
+	// MemoryMB is the memory in MiB.
+	MemoryMB int `mapstructure:"memory_mb"`
+
+	// DiskGB is the size of the boot disk in GiB.
+	DiskGB int `mapstructure:"disk_gb"`
+}
+
+// String returns the string representation of the Size, as it is written in a size label.
+func (s Size) String() string {
+	parts := make([]string, 0, 3)
+	if s.Cores > 0 {
+		parts = append(parts, fmt.Sprintf("cpu%d", s.Cores))
+	}
+	if s.MemoryMB > 0 {
+		if s.MemoryMB%1024 == 0 {
+			parts = append(parts, fmt.Sprintf("mem%dg", s.MemoryMB/1024))
+		} else {
+			parts = append(parts, fmt.Sprintf("mem%dm", s.MemoryMB))
+		}
+	}
+	if s.DiskGB > 0 {
+		parts = append(parts, fmt.Sprintf("disk%dg", s.DiskGB))
+	}
+	return SizeLabelPrefix + strings.Join(parts, "-")
+}
+
+// SizeLimits are the machine shapes a pool allows its jobs to ask for.
+type SizeLimits struct {
+	// Min is the smallest shape. A zero field has no minimum.
+	Min Size `mapstructure:"min"`
+
+	// Max is the largest shape. A zero field cannot be asked for.
+	Max Size `mapstructure:"max"`
+}
+
+// Check returns an ErrSizeOutOfRange error if the size is not within the limits.
+func (l *SizeLimits) Check(size Size) error {
+	check := func(name string, v, minV, maxV int) error {
+		switch {
+		case v == 0:
+			return nil
+		case maxV == 0:
+			return fmt.Errorf("%w: %s cannot be set", ErrSizeOutOfRange, name)
+		case v < minV || v > maxV:
+			return fmt.Errorf("%w: %s %d is not between %d and %d", ErrSizeOutOfRange, name, v, minV, maxV)
+		default:
+			return nil
+		}
+	}
+
+	return errors.Join(
+		check("cores", size.Cores, l.Min.Cores, l.Max.Cores),
+		check("memory_mb", size.MemoryMB, l.Min.MemoryMB, l.Max.MemoryMB),
+		check("disk_gb", size.DiskGB, l.Min.DiskGB, l.Max.DiskGB),
+	)
+}
+
+// ParseSizeLabel parses a size label such as proxmox-cpu4-mem8g-disk50g. Every part is optional but at least one is
+// required, memory is given in g or m and disk in g. It returns false if the label is not a size label.
+func ParseSizeLabel(label string) (Size, bool) {
+	rest, ok := strings.CutPrefix(strings.ToLower(label), SizeLabelPrefix)
+	if !ok || rest == "" {
+		return Size{}, false
+	}
+
+	size := Size{}
+	for _, part := range strings.Split(rest, "-") {
+		var (
+			field *int
+			unit  = 1
+			value string
+		)
+
+		switch {
+		case strings.HasPrefix(part, "cpu"):
+			field, value = &size.Cores, strings.TrimPrefix(part, "cpu")
+		case strings.HasPrefix(part, "mem") && strings.HasSuffix(part, "g"):
+			field, value, unit = &size.MemoryMB, strings.TrimSuffix(strings.TrimPrefix(part, "mem"), "g"), 1024
+		case strings.HasPrefix(part, "mem") && strings.HasSuffix(part, "m"):
+			field, value = &size.MemoryMB, strings.TrimSuffix(strings.TrimPrefix(part, "mem"), "m")
+		case strings.HasPrefix(part, "disk") && strings.HasSuffix(part, "g"):
+			field, value = &size.DiskGB, strings.TrimSuffix(strings.TrimPrefix(part, "disk"), "g")
+		default:
+			return Size{}, false
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || *field != 0 {
+			return Size{}, false
+		}
+		*field = n * unit
+	}
+
+	return size, true
+}
+
+// SizeFromLabels returns the size the labels ask for, or nil if none of them is a size label. Only one size label is
+// allowed.
+func SizeFromLabels(labels []string) (*Size, error) {
+	var found *Size
+	for _, label := range labels {
+		size, ok := ParseSizeLabel(label)
+		if !ok {
+			continue
+		} else if found != nil {
+			return nil, fmt.Errorf("%w: more than one size label", ErrSizeOutOfRange)
+		}
+		found = &size
+	}
+	return found, nil
+}
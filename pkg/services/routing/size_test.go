@@ -0,0 +1,86 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSizeLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		want  Size
+		ok    bool
+	}{
+		{label: "proxmox-cpu4-mem8g-disk50g", want: Size{Cores: 4, MemoryMB: 8192, DiskGB: 50}, ok: true},
+		{label: "Proxmox-CPU2", want: Size{Cores: 2}, ok: true},
+		{label: "proxmox-disk100g-mem512m", want: Size{MemoryMB: 512, DiskGB: 100}, ok: true},
+		{label: "proxmox-cpu4-cpu8"},
+		{label: "proxmox-cpu0"},
+		{label: "proxmox-disk50m"},
+		{label: "proxmox-large"},
+		{label: "proxmox-"},
+		{label: "cpu4-mem8g"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			got, ok := ParseSizeLabel(tt.label)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.want, got)
+			if ok {
+				require.Equal(t, tt.want, mustParse(t, got.String()), "the string form must parse back")
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, label string) Size {
+	size, ok := ParseSizeLabel(label)
+	require.True(t, ok)
+	return size
+}
+
+func TestRouteWithSizes(t *testing.T) {
+	r, err := NewRouter([]Pool{
+		{Name: "fixed", Labels: []string{"proxmox-cpu2"}},
+		{Name: "small", Labels: []string{"proxmox"}, Sizes: &SizeLimits{
+			Min: Size{Cores: 1, MemoryMB: 1024},
+			Max: Size{Cores: 4, MemoryMB: 8192},
+		}},
+		{Name: "large", Labels: []string{"proxmox"}, Sizes: &SizeLimits{
+			Min: Size{Cores: 4, MemoryMB: 8192, DiskGB: 20},
+			Max: Size{Cores: 32, MemoryMB: 131072, DiskGB: 500},
+		}},
+	}, []string{"self-hosted"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		labels  []string
+		want    string
+		wantErr error
+	}{
+		{name: "plain label of a fixed pool", labels: []string{"proxmox-cpu2"}, want: "fixed"},
+		{name: "within the first pool", labels: []string{"proxmox", "proxmox-cpu2-mem4g"}, want: "small"},
+		{name: "falls through to a larger pool", labels: []string{"proxmox", "proxmox-cpu16-mem64g-disk100g"}, want: "large"},
+		{name: "no size", labels: []string{"proxmox"}, want: "small"},
+		{name: "disk not allowed in first pool", labels: []string{"proxmox-cpu4-mem8g-disk50g"}, want: "large"},
+		{name: "too large for every pool", labels: []string{"proxmox-cpu64"}, wantErr: ErrSizeOutOfRange},
+		{name: "two size labels", labels: []string{"proxmox-cpu2", "proxmox-mem2g"}, wantErr: ErrSizeOutOfRange},
+		{name: "unknown label", labels: []string{"proxmox-cpu2", "gpu"}, wantErr: ErrNoPool},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Route(tt.labels)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				require.True(t, Rejected(err))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got.Name)
+		})
+	}
+}
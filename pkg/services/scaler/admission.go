@@ -0,0 +1,611 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scheduling"
+)
+
+// PoolStatus is how much of its limits a pool uses and how many jobs are waiting for it.
+type PoolStatus struct {
+	// Name is the name of the pool. It is empty when the jobs are not routed to pools.
+	Name string `json:"name"`
+
+	// Runners is the number of admitted runners of the pool.
+	Runners int `json:"runners"`
+
+	// Cores is the number of vCPUs of the admitted runners.
+	Cores int `json:"cores"`
+
+	// MemoryMB is the memory of the admitted runners in MiB.
+	MemoryMB int `json:"memory_mb"`
+
+	// MaxSize is the maximum number of runners of the pool. Zero is unlimited.
+	MaxSize int `json:"max_size"`
+
+	// MaxCores is the maximum number of vCPUs of the pool. Zero is unlimited.
+	MaxCores int `json:"max_cores"`
+
+	// MaxMemoryMB is the maximum memory of the pool in MiB. Zero is unlimited.
+	MaxMemoryMB int `json:"max_memory_mb"`
+
+	// Queued is the number of jobs waiting for room in the pool or on a node.
+	Queued int `json:"queued"`
+}
+
+// QueuedJob is a job waiting to be admitted.
+type QueuedJob struct {
+	// JobID is the workflow job ID.
+	JobID int64 `json:"job_id"`
+
+	// Runner is the name of the runner of the job.
+	Runner string `json:"runner"`
+
+	// Repository is the full name of the repository of the job.
+	Repository string `json:"repository"`
+
+	// Pool is the pool the job waits for.
+	Pool string `json:"pool"`
+
+	// Class is the priority class of the job.
+	Class string `json:"class,omitempty"`
+
+	// Priority is the priority of the class of the job.
+	Priority int `json:"priority"`
+
+	// QueuedAt is when the job was queued.
+	QueuedAt time.Time `json:"queued_at"`
+
+	// Position is the position of the job in the queue of its pool, starting at one.
+	Position int `json:"position"`
+
+	// Reason is why the job is waiting.
+	Reason string `json:"reason"`
+}
+
+// Reserver is implemented by the providers that place machines on the nodes of a cluster, so that a queued runner is
+// only admitted, and registered, once a node has room for its machine.
+type Reserver interface {
+	// Reserve counts the machine of the runner against the node it is to be provisioned on, until it is. It returns
+	// ErrNoCapacity if no node has room for it.
+	Reserve(ctx context.Context, runner *Runner) error
+
+	// Release stops counting the machine of the runner against the node reserved for it, e.g. because the runner
+	// could not be registered.
+	Release(runner *Runner)
+}
+
+// usage is what the admitted runners of a pool take of its limits.
+type usage struct {
+	runners  int
+	cores    int
+	memoryMB int
+}
+
+// add counts a runner with the spec.
+func (u *usage) add(spec *MachineSpec) {
+	u.runners++
+	if spec != nil {
+		u.cores += spec.Cores
+		u.memoryMB += spec.MemoryMB
+	}
+}
+
+// admits returns ErrPoolFull if one more runner with the spec would take the pool over one of its limits.
+func (u usage) admits(pool *routing.Pool, spec *MachineSpec) error {
+	next := u
+	next.add(spec)
+
+	switch {
+	case pool.MaxSize > 0 && next.runners > pool.MaxSize:
+		return fmt.Errorf("%w: %s has %d of %d runners", ErrPoolFull, pool.Name, u.runners, pool.MaxSize)
+	case pool.MaxCores > 0 && next.cores > pool.MaxCores:
+		return fmt.Errorf("%w: %s has %d of %d vcpus", ErrPoolFull, pool.Name, u.cores, pool.MaxCores)
+	case pool.MaxMemoryMB > 0 && next.memoryMB > pool.MaxMemoryMB:
+		return fmt.Errorf("%w: %s has %d of %d MiB of memory", ErrPoolFull, pool.Name, u.memoryMB, pool.MaxMemoryMB)
+	default:
+		return nil
+	}
+}
+
+// RunQueue starts the queued runners as their pools and the nodes get room for them, until the context is
+// cancelled. The queue is checked on an interval and whenever a runner is torn down.
+func (s *Service) RunQueue(ctx context.Context) error {
+	interval := s.cfg.QueueInterval
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	wg := new(sync.WaitGroup)
+	defer wg.Wait()
+
+	for {
+		if err := s.drainQueue(ctx, wg); err != nil {
+			slog.Error("unable to drain the queue", slog.String(logging.KeyError, err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-s.drain:
+		}
+	}
+}
+
+// drainQueue starts every queued runner that can be admitted, in the order the scheduler puts the jobs of its pool in.
+// A node is reserved for the machine of every runner before it is started.
+func (s *Service) drainQueue(ctx context.Context, wg *sync.WaitGroup) error {
+	if s.provider == nil {
+		return errors.New("no runner provider configured")
+	}
+
+	s.admitMut.Lock()
+	defer s.admitMut.Unlock()
+
+	runners, err := s.store.ListRunners(ctx)
+	if err != nil {
+		return fmt.Errorf("list runners: %w", err)
+	}
+
+	admitted := make([]*Runner, 0)
+	for _, pool := range queuedPools(runners) {
+		for {
+			r := s.admitNext(runners, pool)
+			if r == nil {
+				break
+			}
+
+			// The first job that does not fit on a node holds up the jobs behind it, as with the limits of its pool.
+			if err := s.reserve(ctx, r); err != nil {
+				s.unadmit(r.Name)
+				if err := s.holdBack(ctx, r, err); err != nil {
+					slog.Error("unable to hold back queued runner",
+						slog.String(logging.KeyRunner, r.Name),
+						slog.String(logging.KeyError, err.Error()),
+					)
+				}
+				break
+			}
+			admitted = append(admitted, r)
+		}
+	}
+
+	for _, r := range admitted {
+		wg.Add(1)
+		go s.startAdmitted(ctx, wg, r)
+	}
+
+	return nil
+}
+
+// admitNext marks the first queued runner of the pool as being started and returns it. It returns nil if the pool
+// has no queued runners or the first one has to wait, so that the jobs behind it cannot overtake it.
+func (s *Service) admitNext(runners []*Runner, pool string) *Runner {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	queue := s.queue(runners, pool)
+	if len(queue) == 0 || s.waitReason(runners, queue[0]) != nil {
+		return nil
+	}
+
+	s.admitting[queue[0].Name] = struct{}{}
+	return queue[0]
+}
+
+// startAdmitted starts a runner the queue admitted. A runner that fails to start goes back to the queue, to be tried
+// again on the next check.
+func (s *Service) startAdmitted(ctx context.Context, wg *sync.WaitGroup, runner *Runner) {
+	defer wg.Done()
+	defer s.unadmit(runner.Name)
+
+	if err := s.start(ctx, runner); err != nil {
+		slog.Error("unable to start queued runner",
+			slog.String(logging.KeyRunner, runner.Name),
+			slog.String(logging.KeyError, err.Error()),
+		)
+
+		if err := s.requeue(ctx, runner, err); err != nil {
+			slog.Error("unable to requeue runner",
+				slog.String(logging.KeyRunner, runner.Name),
+				slog.String(logging.KeyError, err.Error()),
+			)
+		}
+	}
+}
+
+// enqueue saves a queued runner for the job. It returns false if the job asks for more than its pool allows even
+// when the pool is empty, so that it would never be admitted.
+func (s *Service) enqueue(ctx context.Context, pool *routing.Pool, runner *Runner) (bool, error) {
+	if pool != nil {
+		if err := (usage{}).admits(pool, runner.Spec); err != nil {
+			slog.Warn("job rejected",
+				slog.Int64(logging.KeyJobID, runner.JobID),
+				slog.String(logging.KeyRepository, runner.Repository),
+				slog.String("reason", err.Error()),
+			)
+			return false, nil
+		}
+	}
+
+	runner.State = RunnerStateQueued
+	runner.UpdatedAt = time.Now().UTC()
+	if err := s.store.SaveRunner(ctx, runner); err != nil {
+		return false, fmt.Errorf("save runner: %w", err)
+	}
+
+	return true, nil
+}
+
+// startQueued starts the queued runner if it can be admitted. Otherwise it is left in the queue, to be started once
+// there is room for it.
+func (s *Service) startQueued(ctx context.Context, runner *Runner) error {
+	admitted, err := s.admit(ctx, runner)
+	if err != nil || !admitted {
+		return err
+	}
+	defer s.unadmit(runner.Name)
+
+	return s.start(ctx, runner)
+}
+
+// admit reserves room for the queued runner in its pool and on a node. It returns false if the runner has to wait.
+func (s *Service) admit(ctx context.Context, runner *Runner) (bool, error) {
+	s.admitMut.Lock()
+	defer s.admitMut.Unlock()
+
+	runners, err := s.store.ListRunners(ctx)
+	if err != nil {
+		return false, fmt.Errorf("list runners: %w", err)
+	}
+
+	s.mut.Lock()
+	_, admitting := s.admitting[runner.Name]
+	reason := s.waitReason(runners, runner)
+	if !admitting && reason == nil {
+		s.admitting[runner.Name] = struct{}{}
+	}
+	s.mut.Unlock()
+
+	if admitting {
+		return false, nil
+	} else if reason != nil {
+		slog.Info("job queued",
+			slog.Int64(logging.KeyJobID, runner.JobID),
+			slog.String(logging.KeyRunner, runner.Name),
+			slog.String("reason", reason.Error()),
+		)
+		return false, nil
+	}
+
+	if err := s.reserve(ctx, runner); err != nil {
+		s.unadmit(runner.Name)
+		return false, s.holdBack(ctx, runner, err)
+	}
+
+	return true, nil
+}
+
+// reserve reserves a node for the machine of the runner, if the provider places machines on nodes. It returns
+// ErrNoCapacity if no node has room for it.
+func (s *Service) reserve(ctx context.Context, runner *Runner) error {
+	reserver, ok := s.provider.(Reserver)
+	if !ok {
+		return nil
+	}
+	return reserver.Reserve(ctx, runner)
+}
+
+// unreserve gives up the node reserved for the machine of the runner.
+func (s *Service) unreserve(runner *Runner) {
+	if reserver, ok := s.provider.(Reserver); ok {
+		reserver.Release(runner)
+	}
+}
+
+// holdBack leaves the queued runner in the queue because no node could be reserved for its machine, recording why
+// for the queue. It returns the error if it is not ErrNoCapacity.
+func (s *Service) holdBack(ctx context.Context, runner *Runner, reason error) error {
+	if !errors.Is(reason, ErrNoCapacity) {
+		return fmt.Errorf("reserve node: %w", reason)
+	}
+
+	slog.Info("job queued",
+		slog.Int64(logging.KeyJobID, runner.JobID),
+		slog.String(logging.KeyRunner, runner.Name),
+		slog.String("reason", reason.Error()),
+	)
+
+	if runner.WaitReason == reason.Error() {
+		return nil
+	}
+	runner.WaitReason = reason.Error()
+	runner.UpdatedAt = time.Now().UTC()
+	if err := s.store.SaveRunner(ctx, runner); err != nil {
+		return fmt.Errorf("save runner: %w", err)
+	}
+	return nil
+}
+
+// claimStart records that the runner is being started outside the queue. It returns false if the runner is being
+// started or warmed already, so that its machine is not provisioned twice with the same just-in-time config.
+func (s *Service) claimStart(name string) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if _, ok := s.admitting[name]; ok {
+		return false
+	} else if _, ok := s.warming[name]; ok {
+		return false
+	}
+
+	s.admitting[name] = struct{}{}
+	return true
+}
+
+// unadmit forgets that the runner is being started.
+func (s *Service) unadmit(name string) {
+	s.mut.Lock()
+	delete(s.admitting, name)
+	s.mut.Unlock()
+}
+
+// start provisions the machine of an admitted runner, registering the runner first unless an earlier attempt has. A
+// runner whose machine fits on no node goes back to the queue.
+func (s *Service) start(ctx context.Context, runner *Runner) error {
+	runner.WaitReason = ""
+
+	var jitConfig string
+	var err error
+	if runner.JITConfig == "" {
+		jitConfig, err = s.register(ctx, runner)
+	} else if jitConfig, err = s.vc.TransitDecrypt(ctx, runner.JITConfig); err != nil {
+		err = fmt.Errorf("decrypt jit config: %w", err)
+	}
+	if err != nil {
+		s.unreserve(runner)
+		return err
+	}
+
+	err = s.provision(ctx, runner, jitConfig, RunnerStateRunning)
+	if errors.Is(err, ErrNoCapacity) {
+		return s.requeue(ctx, runner, err)
+	} else if err != nil {
+		return err
+	}
+
+	slog.Info("runner provisioned",
+		slog.Int64(logging.KeyJobID, runner.JobID),
+		slog.String(logging.KeyRunner, runner.Name),
+		slog.String(logging.KeyRepository, runner.Repository),
+	)
+
+	return nil
+}
+
+// requeue puts the runner back in the queue. It keeps its registration and machine, if it has them, for when it is
+// admitted again.
+func (s *Service) requeue(ctx context.Context, runner *Runner, reason error) error {
+	runner.State = RunnerStateQueued
+	runner.WaitReason = reason.Error()
+	runner.UpdatedAt = time.Now().UTC()
+	if err := s.store.SaveRunner(ctx, runner); err != nil {
+		return fmt.Errorf("save runner: %w", err)
+	}
+
+	slog.Info("runner queued again",
+		slog.Int64(logging.KeyJobID, runner.JobID),
+		slog.String(logging.KeyRunner, runner.Name),
+		slog.String("reason", reason.Error()),
+	)
+
+	return nil
+}
+
+// waitReason returns why the queued runner cannot be admitted yet, or nil if it can. A runner also waits for the jobs
+// the scheduler puts ahead of it in its pool. The caller must hold mut.
+func (s *Service) waitReason(runners []*Runner, runner *Runner) error {
+	pool := poolOf(runner)
+
+	queue := s.queue(runners, pool)
+	if ahead := slices.IndexFunc(queue, func(r *Runner) bool { return r.Name == runner.Name }); ahead > 0 {
+		return fmt.Errorf("behind %d of the pool's jobs", ahead)
+	}
+
+	if s.cfg.Router == nil {
+		return nil
+	}
+	p, ok := s.cfg.Router.Pool(pool)
+	if !ok {
+		return nil
+	}
+
+	return s.usage(runners, pool).admits(p, runner.Spec)
+}
+
+// queue returns the queued runners of the pool that are not being started, in the order the scheduler admits them.
+// The caller must hold mut.
+func (s *Service) queue(runners []*Runner, pool string) []*Runner {
+	byName := make(map[string]*Runner)
+	queued := make([]scheduling.Job, 0)
+	admitted := make([]scheduling.Job, 0)
+	for _, r := range runners {
+		if poolOf(r) != pool {
+			continue
+		}
+
+		job := scheduling.Job{Name: r.Name, Repository: r.Repository, Priority: r.Priority, QueuedAt: r.QueuedAt}
+		if _, ok := s.admitting[r.Name]; !ok && r.State == RunnerStateQueued {
+			byName[r.Name] = r
+			queued = append(queued, job)
+		} else if r.Repository != "" {
+			admitted = append(admitted, job)
+		}
+	}
+
+	order := s.cfg.Scheduler.Order(queued, admitted)
+	queue := make([]*Runner, len(order))
+	for i, job := range order {
+		queue[i] = byName[job.Name]
+	}
+
+	return queue
+}
+
+// usage returns what the admitted runners of the pool take of its limits. The warm runners that are being registered
+// are counted with the resources of the pool. The caller must hold mut.
+func (s *Service) usage(runners []*Runner, pool string) usage {
+	var u usage
+	stored := make(map[string]struct{}, len(runners))
+	for _, r := range runners {
+		stored[r.Name] = struct{}{}
+		if poolOf(r) != pool {
+			continue
+		} else if _, ok := s.admitting[r.Name]; !ok && r.State == RunnerStateQueued {
+			continue
+		}
+		u.add(r.Spec)
+	}
+
+	for name, warmPool := range s.warming {
+		if _, ok := stored[name]; ok {
+			continue
+		}
+		wp := s.warmPool(warmPool)
+		if wp == nil || wp.Pool != pool {
+			continue
+		}
+
+		var spec *MachineSpec
+		if s.cfg.Router != nil {
+			if p, ok := s.cfg.Router.Pool(pool); ok {
+				spec = &MachineSpec{Cores: p.Cores, MemoryMB: p.MemoryMB}
+			}
+		}
+		u.add(spec)
+	}
+
+	return u
+}
+
+// Pools returns the usage and queue of every pool. There is a single unnamed pool when the jobs are not routed to
+// pools.
+func (s *Service) Pools(ctx context.Context) ([]PoolStatus, error) {
+	runners, err := s.store.ListRunners(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list runners: %w", err)
+	}
+
+	pools := []*routing.Pool{{}}
+	if s.cfg.Router != nil {
+		pools = s.cfg.Router.Pools()
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	statuses := make([]PoolStatus, 0, len(pools))
+	for _, p := range pools {
+		u := s.usage(runners, p.Name)
+		status := PoolStatus{
+			Name:        p.Name,
+			Runners:     u.runners,
+			Cores:       u.cores,
+			MemoryMB:    u.memoryMB,
+			MaxSize:     p.MaxSize,
+			MaxCores:    p.MaxCores,
+			MaxMemoryMB: p.MaxMemoryMB,
+		}
+		status.Queued = len(s.queue(runners, p.Name))
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// QueuedJobs returns the jobs waiting to be admitted, by pool in the order they are admitted, with why each waits.
+func (s *Service) QueuedJobs(ctx context.Context) ([]QueuedJob, error) {
+	runners, err := s.store.ListRunners(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list runners: %w", err)
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	jobs := make([]QueuedJob, 0)
+	for _, pool := range queuedPools(runners) {
+		queue := s.queue(runners, pool)
+		if len(queue) == 0 {
+			continue
+		}
+
+		// Every job of the pool waits for the first one, which waits for room in the pool or on a node.
+		reason := "waiting for the queue to be drained"
+		if err := s.waitReason(runners, queue[0]); err != nil {
+			reason = err.Error()
+		} else if queue[0].WaitReason != "" {
+			reason = queue[0].WaitReason
+		}
+
+		for i, r := range queue {
+			job := QueuedJob{
+				JobID:      r.JobID,
+				Runner:     r.Name,
+				Repository: r.Repository,
+				Pool:       pool,
+				Class:      r.Class,
+				Priority:   r.Priority,
+				QueuedAt:   r.QueuedAt,
+				Position:   i + 1,
+				Reason:     reason,
+			}
+			if i > 0 {
+				job.Reason = fmt.Sprintf("behind %d of the pool's jobs; first in line: %s", i, reason)
+			}
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs, nil
+}
+
+// triggerDrain wakes up the queue without blocking.
+func (s *Service) triggerDrain() {
+	select {
+	case s.drain <- struct{}{}:
+	default:
+	}
+}
+
+// poolOf returns the name of the pool the runner belongs to, or an empty string if the jobs are not routed to pools.
+func poolOf(runner *Runner) string {
+	if runner.Spec == nil {
+		return ""
+	}
+	return runner.Spec.Pool
+}
+
+// queuedPools returns the names of the pools that have queued runners, sorted.
+func queuedPools(runners []*Runner) []string {
+	pools := make([]string, 0)
+	for _, r := range runners {
+		if r.State == RunnerStateQueued && !slices.Contains(pools, poolOf(r)) {
+			pools = append(pools, poolOf(r))
+		}
+	}
+	slices.Sort(pools)
+	return pools
+}
@@ -0,0 +1,230 @@
+package scaler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scheduling"
+	"github.com/stretchr/testify/mock"
+)
+
+// drainQueue starts the queued runners there is room for and waits for them to be provisioned.
+func (s *ServiceSuite) drainQueue() {
+	wg := new(sync.WaitGroup)
+	s.Require().NoError(s.svc.drainQueue(context.Background(), wg))
+	wg.Wait()
+}
+
+// state returns the state of the runner.
+func (s *ServiceSuite) state(name string) RunnerState {
+	runner, err := s.store.GetRunner(context.Background(), name)
+	s.Require().NoError(err)
+	return runner.State
+}
+
+func (s *ServiceSuite) TestProvisionQueuesOverPoolLimits() {
+	s.expectEncrypt()
+	s.withPools(routing.Pool{Name: "default", Labels: []string{"proxmox"}, Cores: 2, MemoryMB: 2048, MaxSize: 3, MaxCores: 4})
+
+	queuedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for id := range int64(4) {
+		job := &Job{ID: id + 1, Owner: "octo", QueuedAt: queuedAt.Add(time.Duration(id) * time.Second)}
+		if job.ID == 3 {
+			// Job 4 was queued on GitHub first, so it is admitted first.
+			job.QueuedAt = queuedAt.Add(time.Hour)
+		}
+		s.NoError(s.svc.Provision(context.Background(), job), "a job over the limits must be queued, not failed")
+	}
+
+	s.Contains(s.provider.provisioned, "pgr-1")
+	s.Contains(s.provider.provisioned, "pgr-2")
+	s.Equal(RunnerStateQueued, s.state("pgr-3"))
+	s.Equal(RunnerStateQueued, s.state("pgr-4"))
+	s.Len(s.gh.requests, 2, "a queued job must not be registered before it is admitted")
+
+	pools, err := s.svc.Pools(context.Background())
+	s.Require().NoError(err)
+	s.Equal([]PoolStatus{{Name: "default", Runners: 2, Cores: 4, MemoryMB: 4096, MaxSize: 3, MaxCores: 4, Queued: 2}}, pools)
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 3, Owner: "octo"}))
+	s.Equal(RunnerStateQueued, s.state("pgr-3"), "a retried event must leave the job queued while the pool is full")
+
+	s.NoError(s.svc.Complete(context.Background(), 1, "pgr-1"))
+	select {
+	case <-s.svc.drain:
+	default:
+		s.Fail("tearing a runner down must trigger the queue")
+	}
+
+	s.drainQueue()
+	s.Contains(s.provider.provisioned, "pgr-4")
+	s.Equal(RunnerStateQueued, s.state("pgr-3"))
+
+	s.NoError(s.svc.Complete(context.Background(), 2, "pgr-2"))
+	s.drainQueue()
+	s.Contains(s.provider.provisioned, "pgr-3")
+
+	pools, err = s.svc.Pools(context.Background())
+	s.Require().NoError(err)
+	s.Zero(pools[0].Queued)
+}
+
+func (s *ServiceSuite) TestProvisionRejectsJobOverPoolLimits() {
+	s.withPools(routing.Pool{Name: "sized", Labels: []string{"proxmox"}, Cores: 2, MaxCores: 4, Sizes: &routing.SizeLimits{
+		Max: routing.Size{Cores: 8},
+	}})
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Labels: []string{"proxmox-cpu8"}}))
+
+	_, err := s.store.GetRunner(context.Background(), "pgr-1")
+	s.ErrorIs(err, ErrRunnerNotFound, "a job that never fits its pool must not be queued")
+}
+
+func (s *ServiceSuite) TestProvisionQueuesWithoutNodeCapacity() {
+	s.expectEncrypt()
+	s.vc.On("TransitDecrypt", mock.Anything, "vault:v1:jit-pgr-1").Return("jit-pgr-1", nil)
+
+	s.provider.err = fmt.Errorf("place runner: %w", ErrNoCapacity)
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo"}))
+	s.Equal(RunnerStateQueued, s.state("pgr-1"))
+
+	s.drainQueue()
+	s.Equal(RunnerStateQueued, s.state("pgr-1"), "the runner must wait while no node has room")
+
+	s.provider.err = nil
+	s.drainQueue()
+
+	s.Equal(RunnerStateRunning, s.state("pgr-1"))
+	s.Len(s.gh.requests, 1, "a requeued runner must keep its registration")
+	s.Equal(map[string]string{"pgr-1": "jit-pgr-1"}, s.provider.provisioned)
+}
+
+// reservingProvider is a fakeProvider that places machines on nodes, with no room for the machines of full.
+type reservingProvider struct {
+	*fakeProvider
+
+	full     map[string]bool
+	reserved []string
+}
+
+func (f *reservingProvider) Reserve(_ context.Context, runner *Runner) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	if f.full[runner.Name] {
+		return fmt.Errorf("place runner %s: %w", runner.Name, ErrNoCapacity)
+	}
+	f.reserved = append(f.reserved, runner.Name)
+	return nil
+}
+
+func (f *reservingProvider) Release(runner *Runner) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.reserved = slices.DeleteFunc(f.reserved, func(name string) bool { return name == runner.Name })
+}
+
+func (s *ServiceSuite) TestQueueWaitsForNodeCapacity() {
+	s.expectEncrypt()
+	provider := &reservingProvider{fakeProvider: s.provider, full: map[string]bool{"pgr-2": true}}
+	s.svc = NewService(s.svc.cfg, s.store, s.gh, s.vc, provider)
+
+	queuedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for id := range int64(3) {
+		job := &Job{ID: id + 1, Owner: "octo", QueuedAt: queuedAt.Add(time.Duration(id) * time.Second)}
+		s.NoError(s.svc.Provision(context.Background(), job))
+	}
+
+	s.Equal(RunnerStateRunning, s.state("pgr-1"))
+	s.Equal(RunnerStateQueued, s.state("pgr-2"))
+	s.Equal(RunnerStateQueued, s.state("pgr-3"), "a job must not overtake the job ahead of it")
+	s.Len(s.gh.requests, 1, "a job must not be registered before a node has room for it")
+
+	s.drainQueue()
+	s.Equal(RunnerStateQueued, s.state("pgr-3"), "draining must stop at the first job that does not fit on a node")
+	s.Equal([]string{"pgr-1"}, provider.reserved)
+
+	jobs, err := s.svc.QueuedJobs(context.Background())
+	s.Require().NoError(err)
+	s.Require().Len(jobs, 2)
+	s.Equal("pgr-2", jobs[0].Runner)
+	s.Contains(jobs[0].Reason, ErrNoCapacity.Error())
+
+	provider.full = nil
+	s.drainQueue()
+	s.Equal(RunnerStateRunning, s.state("pgr-2"))
+	s.Equal(RunnerStateRunning, s.state("pgr-3"))
+	s.Len(s.gh.requests, 3)
+	s.Equal([]string{"pgr-1", "pgr-2", "pgr-3"}, provider.reserved)
+}
+
+func (s *ServiceSuite) TestSweepRemovesQueuedRunnerOfCancelledJob() {
+	s.withPools(routing.Pool{Name: "default", Labels: []string{"proxmox"}, MaxSize: 1})
+	s.Require().NoError(s.store.SaveRunner(context.Background(), &Runner{Name: "pgr-0", State: RunnerStateRunning, Spec: &MachineSpec{Pool: "default"}}))
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Repository: "octo/repo"}))
+	s.Equal(RunnerStateQueued, s.state("pgr-1"))
+
+	s.gh.jobs[1] = &github.WorkflowJob{ID: 1, Status: github.JobStatusQueued}
+	s.provider.stopped["pgr-1"] = true
+	s.Require().NoError(s.svc.sweep(context.Background()))
+	s.Equal(RunnerStateQueued, s.state("pgr-1"), "a queued runner has no machine to be stopped")
+
+	s.gh.jobs[1].Status = github.JobStatusCompleted
+	s.Require().NoError(s.svc.sweep(context.Background()))
+
+	_, err := s.store.GetRunner(context.Background(), "pgr-1")
+	s.ErrorIs(err, ErrRunnerNotFound)
+	s.Equal([]string{"pgr-1"}, s.provider.destroyed)
+}
+
+func (s *ServiceSuite) TestQueueIsFairAndPrioritised() {
+	s.expectEncrypt()
+	s.withPools(routing.Pool{Name: "default", Labels: []string{"proxmox"}, MaxSize: 1})
+	scheduler, err := scheduling.NewScheduler(scheduling.Config{Classes: []scheduling.PriorityClass{
+		{Name: "release", Priority: 10, Branches: []string{"release/*"}},
+	}})
+	s.Require().NoError(err)
+	s.svc.cfg.Scheduler = scheduler
+
+	queuedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	jobs := []*Job{
+		{ID: 1, Repository: "octo/mono"},
+		{ID: 2, Repository: "octo/mono"},
+		{ID: 3, Repository: "octo/mono"},
+		{ID: 4, Repository: "other/app"},
+		{ID: 5, Repository: "octo/mono", HeadBranch: "release/1.0"},
+	}
+	for i, job := range jobs {
+		job.Owner, _, _ = strings.Cut(job.Repository, "/")
+		job.QueuedAt = queuedAt.Add(time.Duration(i) * time.Second)
+		s.Require().NoError(s.svc.Provision(context.Background(), job))
+	}
+	s.Contains(s.provider.provisioned, "pgr-1")
+
+	queued, err := s.svc.QueuedJobs(context.Background())
+	s.Require().NoError(err)
+	s.Require().Len(queued, 4)
+
+	order := make([]string, len(queued))
+	for i, job := range queued {
+		order[i] = job.Runner
+		s.Equal(i+1, job.Position)
+	}
+	s.Equal([]string{"pgr-5", "pgr-4", "pgr-2", "pgr-3"}, order, "the release job goes first, then the repository without a runner")
+	s.Equal("release", queued[0].Class)
+	s.Equal("pool is full: default has 1 of 1 runners", queued[0].Reason)
+	s.Equal("behind 1 of the pool's jobs; first in line: pool is full: default has 1 of 1 runners", queued[1].Reason)
+
+	s.NoError(s.svc.Complete(context.Background(), 1, "pgr-1"))
+	s.drainQueue()
+	s.Contains(s.provider.provisioned, "pgr-5")
+	s.NotContains(s.provider.provisioned, "pgr-4")
+}
@@ -0,0 +1,66 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// RunnerServiceName is the systemd unit the runner runs as in its machine.
+const RunnerServiceName = "actions-runner.service"
+
+var (
+	// ErrCommandsUnsupported is returned when the machine of a runner cannot run commands without SSH.
+	ErrCommandsUnsupported = errors.New("the machine cannot run commands")
+
+	// ErrNoMachine is returned when the runner has no machine yet.
+	ErrNoMachine = errors.New("the runner has no machine")
+
+	// ErrUnknownServiceAction is returned for an action on the runner service other than status or start.
+	ErrUnknownServiceAction = errors.New("unknown service action")
+)
+
+// CommandRunner is implemented by the providers that can run commands in the machines of the runners without SSH,
+// e.g. through the QEMU guest agent.
+type CommandRunner interface {
+	// RunCommand runs the command in the machine of the runner and waits for it to exit. It returns
+	// ErrCommandsUnsupported if the machine cannot run commands.
+	RunCommand(ctx context.Context, runner *Runner, command []string) (*CommandResult, error)
+}
+
+// CommandResult is the outcome of a command run in the machine of a runner.
+type CommandResult struct {
+	// ExitCode is the exit code of the command.
+	ExitCode int `json:"exit_code"`
+
+	// Stdout is what the command wrote to its standard output.
+	Stdout string `json:"stdout"`
+
+	// Stderr is what the command wrote to its standard error.
+	Stderr string `json:"stderr"`
+}
+
+// RunnerService runs systemctl with the action on the runner service in the machine of the runner, so that it can be
+// inspected with status or started with start without SSH. A non-zero exit code is not an error, e.g. status exits
+// with 3 when the service is not running.
+func (s *Service) RunnerService(ctx context.Context, runnerName, action string) (*CommandResult, error) {
+	switch action {
+	case "status", "start":
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownServiceAction, action)
+	}
+
+	commands, ok := s.provider.(CommandRunner)
+	if !ok {
+		return nil, ErrCommandsUnsupported
+	}
+
+	runner, err := s.store.GetRunner(ctx, runnerName)
+	if err != nil {
+		return nil, err
+	} else if runner.VMID == 0 {
+		return nil, ErrNoMachine
+	}
+
+	return commands.RunCommand(ctx, runner, []string{"systemctl", action, "--no-pager", RunnerServiceName})
+}
@@ -0,0 +1,45 @@
+package scaler
+
+import (
+	"context"
+)
+
+// fakeCommandProvider is a provider whose machines run commands.
+type fakeCommandProvider struct {
+	*fakeProvider
+
+	commands [][]string
+}
+
+func (f *fakeCommandProvider) RunCommand(_ context.Context, _ *Runner, command []string) (*CommandResult, error) {
+	f.commands = append(f.commands, command)
+	return &CommandResult{ExitCode: 3, Stdout: "inactive (dead)\n"}, nil
+}
+
+func (s *ServiceSuite) TestRunnerService() {
+	provider := &fakeCommandProvider{fakeProvider: s.provider}
+	s.svc.provider = provider
+	s.Require().NoError(s.store.SaveRunner(context.Background(), &Runner{Name: "pgr-1", Node: "pve1", VMID: 101}))
+
+	res, err := s.svc.RunnerService(context.Background(), "pgr-1", "status")
+	s.Require().NoError(err)
+	s.Equal(3, res.ExitCode, "a stopped service is not an error")
+	s.Equal([][]string{{"systemctl", "status", "--no-pager", RunnerServiceName}}, provider.commands)
+
+	_, err = s.svc.RunnerService(context.Background(), "pgr-1", "stop")
+	s.ErrorIs(err, ErrUnknownServiceAction)
+
+	_, err = s.svc.RunnerService(context.Background(), "pgr-2", "start")
+	s.ErrorIs(err, ErrRunnerNotFound)
+
+	s.Require().NoError(s.store.SaveRunner(context.Background(), &Runner{Name: "pgr-3"}))
+	_, err = s.svc.RunnerService(context.Background(), "pgr-3", "start")
+	s.ErrorIs(err, ErrNoMachine)
+}
+
+func (s *ServiceSuite) TestRunnerServiceUnsupported() {
+	s.Require().NoError(s.store.SaveRunner(context.Background(), &Runner{Name: "pgr-1", Node: "pve1", VMID: 101}))
+
+	_, err := s.svc.RunnerService(context.Background(), "pgr-1", "status")
+	s.ErrorIs(err, ErrCommandsUnsupported)
+}
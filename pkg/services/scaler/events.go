@@ -0,0 +1,64 @@
+package scaler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github/webhook"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+)
+
+// Dispatch implements webhook.Dispatcher.
+func (s *Service) Dispatch(ctx context.Context, event webhook.Event) error {
+	switch e := event.(type) {
+	case *webhook.JobQueued:
+		err := s.Provision(ctx, jobFromEvent(e.WorkflowJobEvent))
+		if routing.Rejected(err) {
+			slog.Warn("job rejected",
+				slog.Int64(logging.KeyJobID, e.WorkflowJob.ID),
+				slog.String(logging.KeyRepository, e.Repository.FullName),
+				slog.String(logging.KeyError, err.Error()),
+			)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	case *webhook.JobInProgress:
+		slog.Info("job picked up by runner",
+			slog.Int64(logging.KeyJobID, e.WorkflowJob.ID),
+			slog.String(logging.KeyRunner, e.WorkflowJob.RunnerName),
+		)
+		if err := s.Assign(ctx, e.WorkflowJob.ID, e.WorkflowJob.RunnerName); err != nil {
+			return fmt.Errorf("assign job %d: %w", e.WorkflowJob.ID, err)
+		}
+	case *webhook.JobCompleted:
+		if err := s.Complete(ctx, e.WorkflowJob.ID, e.WorkflowJob.RunnerName); err != nil {
+			return fmt.Errorf("complete job %d: %w", e.WorkflowJob.ID, err)
+		}
+	default:
+		return fmt.Errorf("unsupported event type %T", event)
+	}
+
+	return nil
+}
+
+// jobFromEvent builds the Job for a workflow_job delivery.
+func jobFromEvent(e *webhook.WorkflowJobEvent) *Job {
+	job := &Job{
+		ID:         e.WorkflowJob.ID,
+		RunID:      e.WorkflowJob.RunID,
+		Repository: e.Repository.FullName,
+		Owner:      e.Repository.Owner.Login,
+		Labels:     e.WorkflowJob.Labels,
+		HeadBranch: e.WorkflowJob.HeadBranch,
+		QueuedAt:   e.WorkflowJob.CreatedAt,
+	}
+
+	if e.Installation != nil {
+		job.InstallationID = e.Installation.ID
+	}
+
+	return job
+}
@@ -0,0 +1,364 @@
+package scaler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+)
+
+// ImplicitLabels are the labels every just-in-time runner carries on top of the labels it is registered with. Every
+// pool serves them.
+var ImplicitLabels = []string{"self-hosted", "linux", "x64"}
+
+// RunWarmPools keeps the warm pools filled until the context is cancelled. The pools are refilled on an interval and
+// whenever a runner is claimed.
+func (s *Service) RunWarmPools(ctx context.Context) error {
+	if len(s.cfg.WarmPools) == 0 {
+		return nil
+	}
+
+	interval := s.cfg.WarmRefillInterval
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	wg := new(sync.WaitGroup)
+	defer wg.Wait()
+
+	for {
+		if err := s.refillPools(ctx, wg); err != nil {
+			slog.Error("unable to refill warm pools", slog.String(logging.KeyError, err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-s.refill:
+		}
+	}
+}
+
+// refillPools starts provisioning a runner for every runner the warm pools are short of. Warm runners that an earlier
+// attempt failed to provision are retried, and the recycling runners are recycled.
+func (s *Service) refillPools(ctx context.Context, wg *sync.WaitGroup) error {
+	if s.provider == nil {
+		return errors.New("no runner provider configured")
+	}
+
+	s.admitMut.Lock()
+	defer s.admitMut.Unlock()
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	runners, err := s.store.ListRunners(ctx)
+	if err != nil {
+		return fmt.Errorf("list runners: %w", err)
+	}
+
+	for i := range s.cfg.WarmPools {
+		pool := &s.cfg.WarmPools[i]
+
+		for _, r := range runners {
+			if _, ok := s.warming[r.Name]; ok || r.Pool != pool.Name {
+				continue
+			}
+			switch r.State {
+			case RunnerStateProvisioning:
+				s.warming[r.Name] = pool.Name
+				wg.Add(1)
+				go s.warm(ctx, wg, pool, r)
+			case RunnerStateRecycling:
+				s.warming[r.Name] = pool.Name
+				wg.Add(1)
+				go s.recycle(ctx, wg, pool, r)
+			}
+		}
+
+		// The new runners are counted as they are added to warming.
+		for s.poolShort(runners, pool) {
+			name, err := s.warmRunnerName(pool)
+			if err != nil {
+				return err
+			}
+
+			s.warming[name] = pool.Name
+			wg.Add(1)
+			go s.warm(ctx, wg, pool, &Runner{Name: name})
+		}
+	}
+
+	return nil
+}
+
+// warm registers the runner if it is new and provisions it as an idle runner of the pool.
+func (s *Service) warm(ctx context.Context, wg *sync.WaitGroup, pool *WarmPool, runner *Runner) {
+	defer wg.Done()
+	defer func() {
+		s.mut.Lock()
+		delete(s.warming, runner.Name)
+		s.mut.Unlock()
+	}()
+
+	l := slog.With(slog.String(logging.KeyRunner, runner.Name), slog.String("pool", pool.Name))
+
+	jitConfig, err := s.warmJITConfig(ctx, pool, runner)
+	if err != nil {
+		l.Error("unable to register warm runner", slog.String(logging.KeyError, err.Error()))
+		return
+	}
+
+	if err := s.provision(ctx, runner, jitConfig, RunnerStateIdle); err != nil {
+		l.Error("unable to provision warm runner", slog.String(logging.KeyError, err.Error()))
+		return
+	}
+
+	l.Info("warm runner ready")
+}
+
+// warmJITConfig registers a new warm runner, or decrypts the config of a runner an earlier attempt registered.
+func (s *Service) warmJITConfig(ctx context.Context, pool *WarmPool, runner *Runner) (string, error) {
+	if runner.JITConfig != "" {
+		jitConfig, err := s.vc.TransitDecrypt(ctx, runner.JITConfig)
+		if err != nil {
+			return "", fmt.Errorf("decrypt jit config: %w", err)
+		}
+		return jitConfig, nil
+	}
+
+	installationID, err := s.installation(ctx, pool.Scope)
+	if err != nil {
+		return "", err
+	}
+
+	runner.Pool = pool.Name
+	runner.Labels = append(append([]string(nil), pool.Labels...), s.cfg.RunnerLabels...)
+	runner.InstallationID = installationID
+	runner.Scope = pool.Scope
+	runner.Recyclable = pool.Recycle == RecycleSnapshot
+
+	if s.cfg.Router != nil {
+		if p, ok := s.cfg.Router.Pool(pool.Pool); ok {
+			if runner.Spec, err = specFor(p, pool.Labels); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return s.register(ctx, runner)
+}
+
+// claimIdle claims an idle runner of a warm pool that can serve the job. Only the warm pools of the pool the job was
+// routed to are considered, if it was. It returns true if the job is served, which includes a job that claimed a
+// runner before.
+func (s *Service) claimIdle(ctx context.Context, job *Job, routed *routing.Pool) (bool, error) {
+	if len(s.cfg.WarmPools) == 0 {
+		return false, nil
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	runners, err := s.store.ListRunners(ctx)
+	if err != nil {
+		return false, fmt.Errorf("list runners: %w", err)
+	}
+
+	for _, r := range runners {
+		if r.JobID == job.ID {
+			return true, nil
+		}
+	}
+
+	for i := range s.cfg.WarmPools {
+		pool := &s.cfg.WarmPools[i]
+		if routed != nil && pool.Pool != routed.Name {
+			continue
+		} else if !s.poolServes(pool, job) {
+			continue
+		}
+
+		for _, r := range runners {
+			if r.Pool != pool.Name || r.State != RunnerStateIdle {
+				continue
+			}
+
+			r.State = RunnerStateRunning
+			r.JobID = job.ID
+			r.Repository = job.Repository
+			r.UpdatedAt = time.Now().UTC()
+			if err := s.store.SaveRunner(ctx, r); err != nil {
+				return false, fmt.Errorf("save runner: %w", err)
+			}
+
+			s.triggerRefill()
+
+			slog.Info("job served by warm runner",
+				slog.Int64(logging.KeyJobID, job.ID),
+				slog.String(logging.KeyRunner, r.Name),
+				slog.String("pool", pool.Name),
+			)
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// release returns a claimed warm runner whose job was cancelled to its pool.
+func (s *Service) release(ctx context.Context, runner *Runner) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	runner.State = RunnerStateIdle
+	runner.JobID = 0
+	runner.Repository = ""
+	runner.UpdatedAt = time.Now().UTC()
+	if err := s.store.SaveRunner(ctx, runner); err != nil {
+		return fmt.Errorf("save runner: %w", err)
+	}
+
+	slog.Info("warm runner returned to its pool", slog.String(logging.KeyRunner, runner.Name))
+
+	return nil
+}
+
+// poolServes returns true if the runners of the pool can run the job: the job comes from the scope of the pool and
+// the runners carry every label of the job.
+func (s *Service) poolServes(pool *WarmPool, job *Job) bool {
+	switch pool.Scope.Kind {
+	case github.ScopeOrganization:
+		if !strings.EqualFold(pool.Scope.Name, job.Owner) {
+			return false
+		}
+	case github.ScopeRepository:
+		if !strings.EqualFold(pool.Scope.Name, job.Repository) {
+			return false
+		}
+	}
+
+	for _, label := range job.Labels {
+		has := func(l string) bool { return strings.EqualFold(l, label) }
+		if !slices.ContainsFunc(pool.Labels, has) && !slices.ContainsFunc(s.cfg.RunnerLabels, has) && !slices.ContainsFunc(ImplicitLabels, has) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// poolShort returns true if the warm pool holds fewer runners than it keeps, and its pool has room for another. The
+// caller must hold mut.
+func (s *Service) poolShort(runners []*Runner, warm *WarmPool) bool {
+	n := 0
+	for _, r := range runners {
+		if r.Pool == warm.Name && warm.holds(r) {
+			n++
+		}
+	}
+	// Runners that are being registered are not in the store yet.
+	for name, poolName := range s.warming {
+		if poolName == warm.Name && !slices.ContainsFunc(runners, func(r *Runner) bool { return r.Name == name }) {
+			n++
+		}
+	}
+
+	return n < warm.MinIdle && !s.poolFull(runners, warm)
+}
+
+// holds returns true if the runner of the warm pool is idle or on its way to be. In RecycleSnapshot mode, that includes
+// a runner running a job, as it returns to the pool after it, so that it is not replaced when it is claimed.
+func (p *WarmPool) holds(r *Runner) bool {
+	switch r.State {
+	case RunnerStateIdle, RunnerStateProvisioning, RunnerStateRecycling:
+		return true
+	case RunnerStateRunning:
+		return p.Recycle == RecycleSnapshot && r.Recyclable
+	default:
+		return false
+	}
+}
+
+// poolFull returns true if the pool the warm pool's runners belong to is at one of its limits, or has jobs waiting for
+// room. The caller must hold mut.
+func (s *Service) poolFull(runners []*Runner, warm *WarmPool) bool {
+	if s.cfg.Router == nil {
+		return false
+	}
+
+	pool, ok := s.cfg.Router.Pool(warm.Pool)
+	if !ok {
+		return false
+	}
+
+	for _, r := range runners {
+		if _, ok := s.admitting[r.Name]; !ok && r.State == RunnerStateQueued && poolOf(r) == pool.Name {
+			return true
+		}
+	}
+
+	return s.usage(runners, pool.Name).admits(pool, &MachineSpec{Cores: pool.Cores, MemoryMB: pool.MemoryMB}) != nil
+}
+
+// warmPool returns the warm pool with the given name.
+func (s *Service) warmPool(name string) *WarmPool {
+	for i := range s.cfg.WarmPools {
+		if s.cfg.WarmPools[i].Name == name {
+			return &s.cfg.WarmPools[i]
+		}
+	}
+	return nil
+}
+
+// installation returns the app installation ID of the scope.
+func (s *Service) installation(ctx context.Context, scope github.Scope) (int64, error) {
+	s.mut.Lock()
+	id, ok := s.installations[scope]
+	s.mut.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err := s.gh.Installation(ctx, scope)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mut.Lock()
+	s.installations[scope] = id
+	s.mut.Unlock()
+
+	return id, nil
+}
+
+// triggerRefill wakes up the warm pools without blocking.
+func (s *Service) triggerRefill() {
+	select {
+	case s.refill <- struct{}{}:
+	default:
+	}
+}
+
+// warmRunnerName returns a new name for a runner of the pool.
+func (s *Service) warmRunnerName(pool *WarmPool) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate runner name: %w", err)
+	}
+	return fmt.Sprintf("%s-%s-%s", s.cfg.RunnerPrefix, pool.Name, hex.EncodeToString(b)), nil
+}
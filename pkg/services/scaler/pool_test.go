@@ -0,0 +1,149 @@
+package scaler
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+)
+
+func (s *ServiceSuite) withWarmPool(minIdle int) {
+	s.svc.cfg.WarmPools = []WarmPool{{
+		Name:    "small",
+		Labels:  []string{"small"},
+		MinIdle: minIdle,
+		Scope:   github.Scope{Kind: github.ScopeOrganization, Name: "octo"},
+	}}
+}
+
+// fill refills the warm pools and waits for the runners to be provisioned.
+func (s *ServiceSuite) fill() {
+	wg := new(sync.WaitGroup)
+	s.Require().NoError(s.svc.refillPools(context.Background(), wg))
+	wg.Wait()
+}
+
+// idle returns the names of the idle runners.
+func (s *ServiceSuite) idle() []string {
+	runners, err := s.store.ListRunners(context.Background())
+	s.Require().NoError(err)
+
+	names := make([]string, 0)
+	for _, r := range runners {
+		if r.State == RunnerStateIdle {
+			names = append(names, r.Name)
+		}
+	}
+	return names
+}
+
+func (s *ServiceSuite) TestWarmPoolRefills() {
+	s.expectEncrypt()
+	s.withWarmPool(2)
+
+	s.fill()
+	s.fill()
+
+	s.Len(s.idle(), 2)
+	s.Len(s.gh.requests, 2, "a full pool must not register more runners")
+	s.Equal([]string{"small", "proxmox"}, s.gh.requests[0].Labels)
+	s.Equal(github.Scope{Kind: github.ScopeOrganization, Name: "octo"}, s.gh.scopes[0])
+}
+
+func (s *ServiceSuite) TestProvisionClaimsWarmRunner() {
+	s.expectEncrypt()
+	s.withWarmPool(1)
+	s.fill()
+	warm := s.idle()[0]
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Repository: "octo/repo", Labels: []string{"self-hosted", "small"}}))
+
+	s.Empty(s.idle())
+	s.NotContains(s.provider.provisioned, "pgr-1", "a job served by a warm runner must not be cloned")
+
+	runner, err := s.store.GetRunner(context.Background(), warm)
+	s.Require().NoError(err)
+	s.Equal(int64(1), runner.JobID)
+	s.Equal(RunnerStateRunning, runner.State)
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Labels: []string{"small"}}))
+	s.Len(s.gh.requests, 1, "a retried job must keep its warm runner")
+
+	select {
+	case <-s.svc.refill:
+	default:
+		s.Fail("claiming a warm runner must trigger a refill")
+	}
+}
+
+func (s *ServiceSuite) TestProvisionSkipsNonMatchingPool() {
+	s.expectEncrypt()
+	s.withWarmPool(1)
+	s.fill()
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Labels: []string{"large"}}))
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 2, Owner: "other", Labels: []string{"small"}}))
+
+	s.Len(s.idle(), 1)
+	s.Contains(s.provider.provisioned, "pgr-1")
+	s.Contains(s.provider.provisioned, "pgr-2")
+}
+
+func (s *ServiceSuite) TestAssignSwapsRunners() {
+	s.expectEncrypt()
+	s.withWarmPool(2)
+	s.fill()
+	idle := s.idle()
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Labels: []string{"small"}}))
+
+	claimed, err := s.store.GetRunner(context.Background(), idle[0])
+	s.Require().NoError(err)
+	s.Require().Equal(int64(1), claimed.JobID)
+
+	// GitHub hands the job to the other idle runner.
+	s.NoError(s.svc.Assign(context.Background(), 1, idle[1]))
+
+	s.Equal([]string{idle[0]}, s.idle())
+	picked, err := s.store.GetRunner(context.Background(), idle[1])
+	s.Require().NoError(err)
+	s.Equal(int64(1), picked.JobID)
+	s.Equal(RunnerStateRunning, picked.State)
+}
+
+func (s *ServiceSuite) TestCancelledJobReleasesWarmRunner() {
+	s.expectEncrypt()
+	s.withWarmPool(1)
+	s.fill()
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Labels: []string{"small"}}))
+	s.NoError(s.svc.Complete(context.Background(), 1, ""))
+
+	s.Len(s.idle(), 1)
+	s.Empty(s.provider.destroyed)
+}
+
+func (s *ServiceSuite) TestWarmPoolOfRoutedPool() {
+	s.expectEncrypt()
+	s.withPools(
+		routing.Pool{Name: "small", Labels: []string{"small"}, Cores: 2, MaxSize: 2},
+		routing.Pool{Name: "other", Labels: []string{"small", "other"}},
+	)
+	s.withWarmPool(3)
+	s.svc.cfg.WarmPools[0].Pool = "small"
+
+	s.fill()
+	s.Len(s.idle(), 2, "the warm pool must not grow its pool beyond its max size")
+
+	runners, err := s.store.ListRunners(context.Background())
+	s.Require().NoError(err)
+	s.Equal(&MachineSpec{Pool: "small", Cores: 2}, runners[0].Spec)
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Labels: []string{"other"}}))
+	s.Len(s.idle(), 2, "a job routed to another pool must not claim the warm runners")
+	s.Contains(s.provider.provisioned, "pgr-1")
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 2, Owner: "octo", Labels: []string{"small"}}))
+	s.Len(s.idle(), 1)
+}
@@ -0,0 +1,430 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github/webhook"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+)
+
+// ReconcileReport is the outcome of a reconciliation pass.
+type ReconcileReport struct {
+	// StartedAt is when the pass started.
+	StartedAt time.Time `json:"started_at"`
+
+	// Duration is how long the pass took.
+	Duration time.Duration `json:"duration"`
+
+	// Runners is the number of runners in the store.
+	Runners int `json:"runners"`
+
+	// Machines is the number of machines the provider manages.
+	Machines int `json:"machines"`
+
+	// Registrations is the number of runners registered with GitHub under the runner prefix.
+	Registrations int `json:"registrations"`
+
+	// OrphanedMachines are the machines that had no runner and were destroyed.
+	OrphanedMachines []string `json:"orphaned_machines"`
+
+	// OfflineRunners are the offline runners without a machine whose registration was removed.
+	OfflineRunners []string `json:"offline_runners"`
+
+	// StuckRunners are the runners that were stuck provisioning and were provisioned again or torn down.
+	StuckRunners []string `json:"stuck_runners"`
+
+	// LostJobs are the jobs that were queued without a runner, e.g. because their delivery was lost, and were
+	// dispatched again.
+	LostJobs []int64 `json:"lost_jobs"`
+
+	// Errors are the errors of the pass. A pass carries on after an error.
+	Errors []string `json:"errors"`
+}
+
+// LogValue implements slog.LogValuer.
+func (r *ReconcileReport) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Time("started_at", r.StartedAt),
+		slog.Duration("duration", r.Duration),
+		slog.Int("runners", r.Runners),
+		slog.Int("machines", r.Machines),
+		slog.Int("registrations", r.Registrations),
+		slog.Any("orphaned_machines", r.OrphanedMachines),
+		slog.Any("offline_runners", r.OfflineRunners),
+		slog.Any("stuck_runners", r.StuckRunners),
+		slog.Any("lost_jobs", r.LostJobs),
+		slog.Any("errors", r.Errors),
+	)
+}
+
+// Drift returns true if the pass found anything to fix.
+func (r *ReconcileReport) Drift() bool {
+	return len(r.OrphanedMachines)+len(r.OfflineRunners)+len(r.StuckRunners)+len(r.LostJobs) > 0
+}
+
+// addError records an error of the pass.
+func (r *ReconcileReport) addError(format string, args ...any) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// RunReconciler reconciles the runners on an interval until the context is cancelled.
+func (s *Service) RunReconciler(ctx context.Context) error {
+	interval := s.cfg.ReconcileInterval
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if _, err := s.Reconcile(ctx); err != nil {
+			slog.Error("unable to reconcile runners", slog.String(logging.KeyError, err.Error()))
+		}
+	}
+}
+
+// LastReport returns the report of the last reconciliation pass, or nil if none has run yet.
+func (s *Service) LastReport() *ReconcileReport {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.lastReport
+}
+
+// Reconcile compares the runners in the store with the machines of the provider and the runners registered with
+// GitHub, and fixes the drift between them:
+//
+//   - machines without a runner are destroyed,
+//   - offline runners without a machine are removed from GitHub and the store,
+//   - runners stuck provisioning are provisioned again, or torn down if their job is over,
+//   - jobs queued without a runner are dispatched again.
+//
+// The report of the pass is logged.
+func (s *Service) Reconcile(ctx context.Context) (*ReconcileReport, error) {
+	if s.provider == nil {
+		return nil, errors.New("no runner provider configured")
+	}
+
+	report := &ReconcileReport{
+		StartedAt:        time.Now().UTC(),
+		OrphanedMachines: make([]string, 0),
+		OfflineRunners:   make([]string, 0),
+		StuckRunners:     make([]string, 0),
+		LostJobs:         make([]int64, 0),
+		Errors:           make([]string, 0),
+	}
+
+	runners, err := s.store.ListRunners(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list runners: %w", err)
+	}
+	report.Runners = len(runners)
+
+	byName := make(map[string]*Runner, len(runners))
+	for _, r := range runners {
+		byName[r.Name] = r
+	}
+
+	machines, err := s.provider.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list machines: %w", err)
+	}
+	report.Machines = len(machines)
+
+	machineNames := make(map[string]struct{}, len(machines))
+	for _, m := range machines {
+		machineNames[m.Name] = struct{}{}
+	}
+
+	scopes := s.scopes(ctx, report, byName)
+
+	s.reconcileMachines(ctx, report, byName, machines)
+	s.reconcileRegistrations(ctx, report, scopes, byName, machineNames)
+	s.reconcileStuck(ctx, report, runners)
+	s.reconcileQueued(ctx, report, scopes, runners)
+
+	report.Duration = time.Since(report.StartedAt)
+
+	s.mut.Lock()
+	s.lastReport = report
+	s.mut.Unlock()
+
+	level := slog.LevelInfo
+	if len(report.Errors) > 0 {
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "reconciliation pass finished", slog.Bool("drift", report.Drift()), slog.Any("report", report))
+
+	return report, nil
+}
+
+// reconcileMachines destroys the machines that have no runner.
+func (s *Service) reconcileMachines(ctx context.Context, report *ReconcileReport, runners map[string]*Runner, machines []*Machine) {
+	for _, m := range machines {
+		if _, ok := runners[m.Name]; ok {
+			continue
+		}
+
+		// The spec carries the backend, so that the machine is destroyed by the backend that created it.
+		orphan := &Runner{Name: m.Name, Node: m.Node, VMID: m.VMID, Spec: &MachineSpec{Backend: m.Backend}}
+		if err := s.provider.Destroy(ctx, orphan); err != nil {
+			report.addError("destroy orphaned machine %s: %s", m.Name, err)
+			continue
+		}
+		report.OrphanedMachines = append(report.OrphanedMachines, m.Name)
+	}
+}
+
+// reconcileRegistrations removes the offline runners that have no machine from GitHub.
+func (s *Service) reconcileRegistrations(ctx context.Context, report *ReconcileReport, scopes map[github.Scope]int64, runners map[string]*Runner, machines map[string]struct{}) {
+	for scope, installationID := range scopes {
+		registrations, err := s.gh.ListRunners(ctx, installationID, scope)
+		if err != nil {
+			report.addError("list runners of %s: %s", scope, err)
+			continue
+		}
+
+		for _, reg := range registrations {
+			if !strings.HasPrefix(reg.Name, s.cfg.RunnerPrefix+"-") {
+				continue
+			}
+			report.Registrations++
+
+			if reg.Status != github.RunnerStatusOffline {
+				continue
+			} else if _, ok := machines[reg.Name]; ok {
+				continue
+			}
+
+			runner, known := runners[reg.Name]
+			switch {
+			case known && (runner.State == RunnerStateProvisioning || runner.State == RunnerStateRecycling || runner.State == RunnerStateQueued):
+				// The machine is still being created or recycled, or waits for room.
+				continue
+			case known:
+				if err := s.teardown(ctx, runner, "runner offline without machine"); err != nil {
+					report.addError("tear down offline runner %s: %s", reg.Name, err)
+					continue
+				}
+			default:
+				err := s.gh.DeleteRunner(ctx, installationID, scope, reg.ID)
+				if err != nil && !errors.Is(err, utils.NewHttpError(http.StatusNotFound, "")) {
+					report.addError("delete offline runner %s: %s", reg.Name, err)
+					continue
+				}
+			}
+			report.OfflineRunners = append(report.OfflineRunners, reg.Name)
+		}
+	}
+}
+
+// reconcileStuck provisions the runners that have been provisioning for too long again. Warm pool runners are left to
+// the pool refill, and runners that are still being started are left to the attempt that is starting them.
+func (s *Service) reconcileStuck(ctx context.Context, report *ReconcileReport, runners []*Runner) {
+	stuckAfter := s.stuckAfter()
+
+	for _, r := range runners {
+		if r.State != RunnerStateProvisioning || r.Pool != "" || time.Since(r.UpdatedAt) < stuckAfter {
+			continue
+		} else if !s.claimStart(r.Name) {
+			continue
+		}
+
+		if s.reprovision(ctx, report, stuckAfter, r.Name) {
+			report.StuckRunners = append(report.StuckRunners, r.Name)
+		}
+		s.unadmit(r.Name)
+	}
+}
+
+// reprovision provisions a stuck runner again, or tears it down if its job is over. The runner is read again, as an
+// attempt that was starting it may have finished since the runners were listed.
+func (s *Service) reprovision(ctx context.Context, report *ReconcileReport, stuckAfter time.Duration, name string) bool {
+	r, err := s.store.GetRunner(ctx, name)
+	if errors.Is(err, ErrRunnerNotFound) {
+		return false
+	} else if err != nil {
+		report.addError("get stuck runner %s: %s", name, err)
+		return false
+	} else if r.State != RunnerStateProvisioning || time.Since(r.UpdatedAt) < stuckAfter {
+		return false
+	}
+
+	job, err := s.gh.GetWorkflowJob(ctx, r.InstallationID, r.Repository, r.JobID)
+	if errors.Is(err, utils.NewHttpError(http.StatusNotFound, "")) || (err == nil && job.IsCompleted()) {
+		if err := s.teardown(ctx, r, "job of stuck runner is over"); err != nil {
+			report.addError("tear down stuck runner %s: %s", r.Name, err)
+		}
+		return true
+	} else if err != nil {
+		report.addError("get job of stuck runner %s: %s", r.Name, err)
+		return true
+	}
+
+	jitConfig, err := s.vc.TransitDecrypt(ctx, r.JITConfig)
+	if err != nil {
+		report.addError("decrypt jit config of stuck runner %s: %s", r.Name, err)
+		return true
+	}
+
+	err = s.provision(ctx, r, jitConfig, RunnerStateRunning)
+	if errors.Is(err, ErrNoCapacity) {
+		err = s.requeue(ctx, r, err)
+	}
+	if err != nil {
+		report.addError("provision stuck runner %s: %s", r.Name, err)
+	}
+	return true
+}
+
+// reconcileQueued dispatches the jobs that have been queued for longer than StuckAfter without a runner again. The
+// repositories of the repository and organization scopes are checked. Jobs the router rejects are left alone.
+func (s *Service) reconcileQueued(ctx context.Context, report *ReconcileReport, scopes map[github.Scope]int64, runners []*Runner) {
+	served := make(map[int64]struct{}, len(runners))
+	for _, r := range runners {
+		if r.JobID != 0 {
+			served[r.JobID] = struct{}{}
+		}
+	}
+
+	dispatcher := s.cfg.Dispatcher
+	if dispatcher == nil {
+		dispatcher = s
+	}
+
+	for scope, installationID := range scopes {
+		repos, err := s.repositories(ctx, installationID, scope)
+		if err != nil {
+			report.addError("list repositories of %s: %s", scope, err)
+			continue
+		}
+
+		for _, repo := range repos {
+			jobs, err := s.queuedJobs(ctx, installationID, repo)
+			if err != nil {
+				report.addError("list queued jobs of %s: %s", repo, err)
+				continue
+			}
+
+			for _, job := range jobs {
+				if _, ok := served[job.ID]; ok || time.Since(job.CreatedAt) < s.stuckAfter() {
+					continue
+				} else if s.cfg.Router != nil {
+					if _, err := s.cfg.Router.Route(job.Labels); err != nil {
+						continue
+					}
+				}
+				served[job.ID] = struct{}{}
+
+				key := fmt.Sprintf("reconcile-%d-%d", job.ID, job.RunAttempt)
+				if err := dispatcher.Dispatch(ctx, webhook.NewJobQueued(key, installationID, repo, job)); err != nil {
+					report.addError("dispatch lost job %d: %s", job.ID, err)
+					continue
+				}
+				report.LostJobs = append(report.LostJobs, job.ID)
+			}
+		}
+	}
+}
+
+// repositories returns the repositories whose jobs the runners of the scope serve. Those of an enterprise cannot be
+// listed, so there are none.
+func (s *Service) repositories(ctx context.Context, installationID int64, scope github.Scope) ([]string, error) {
+	switch scope.Kind {
+	case github.ScopeRepository:
+		return []string{scope.Name}, nil
+	case github.ScopeOrganization:
+		all, _, err := s.gh.ListInstallationRepositories(ctx, installationID, "")
+		if err != nil {
+			return nil, err
+		}
+
+		repos := make([]string, 0, len(all))
+		for _, repo := range all {
+			if strings.HasPrefix(repo, scope.Name+"/") {
+				repos = append(repos, repo)
+			}
+		}
+		return repos, nil
+	default:
+		return nil, nil
+	}
+}
+
+// queuedJobs returns the queued jobs of the repository. A run that is in progress can still have queued jobs, e.g.
+// those of a matrix, so both queued and in progress runs are checked.
+func (s *Service) queuedJobs(ctx context.Context, installationID int64, repo string) ([]*github.WorkflowJob, error) {
+	queued := make([]*github.WorkflowJob, 0)
+	for _, status := range []string{github.RunStatusQueued, github.RunStatusInProgress} {
+		runs, _, err := s.gh.ListWorkflowRuns(ctx, installationID, repo, status, "")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, run := range runs {
+			jobs, _, err := s.gh.ListWorkflowRunJobs(ctx, installationID, repo, run.ID, "")
+			if err != nil {
+				return nil, err
+			}
+
+			for _, job := range jobs {
+				if job.Status == github.JobStatusQueued {
+					queued = append(queued, job)
+				}
+			}
+		}
+	}
+
+	return queued, nil
+}
+
+// stuckAfter returns how long a runner can be provisioning, or a job queued without a runner, before the reconciler
+// acts on it.
+func (s *Service) stuckAfter() time.Duration {
+	if s.cfg.StuckAfter == 0 {
+		return 15 * time.Minute
+	}
+	return s.cfg.StuckAfter
+}
+
+// scopes returns the scopes runners are registered with, mapped to the app installation of the scope: the scopes of
+// the runners in the store, of the warm pools, and every scope a runner was registered with since the start.
+func (s *Service) scopes(ctx context.Context, report *ReconcileReport, runners map[string]*Runner) map[github.Scope]int64 {
+	scopes := make(map[github.Scope]int64)
+	for _, r := range runners {
+		scopes[r.Scope] = r.InstallationID
+	}
+
+	for _, pool := range s.cfg.WarmPools {
+		id, err := s.installation(ctx, pool.Scope)
+		if err != nil {
+			report.addError("get installation of %s: %s", pool.Scope, err)
+			continue
+		}
+		scopes[pool.Scope] = id
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for scope, id := range s.installations {
+		scopes[scope] = id
+	}
+
+	return scopes
+}
@@ -0,0 +1,160 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/stretchr/testify/mock"
+)
+
+func (s *ServiceSuite) TestReconcileDestroysOrphanedMachines() {
+	s.expectEncrypt()
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Repository: "octo/repo"}))
+
+	s.provider.machines = []*Machine{
+		{Name: "pgr-1", Node: "pve1", VMID: 101, Running: true},
+		{Name: "pgr-9", Node: "pve2", VMID: 109, Running: true},
+	}
+
+	report, err := s.svc.Reconcile(context.Background())
+	s.Require().NoError(err)
+
+	s.Equal([]string{"pgr-9"}, report.OrphanedMachines)
+	s.Equal([]string{"pgr-9"}, s.provider.destroyed)
+	s.Equal(1, report.Runners)
+	s.Equal(2, report.Machines)
+	s.True(report.Drift())
+	s.Same(report, s.svc.LastReport())
+}
+
+func (s *ServiceSuite) TestReconcileRemovesOfflineRunners() {
+	s.expectEncrypt()
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Repository: "octo/repo"}))
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 2, Owner: "octo", Repository: "octo/repo"}))
+
+	// pgr-1 lost its machine, pgr-2 is fine, pgr-8 is unknown to the store and other-1 is not ours.
+	s.provider.machines = []*Machine{{Name: "pgr-2", Node: "pve1", VMID: 102, Running: true}}
+	s.gh.runners = []*github.Runner{
+		{ID: 1, Name: "pgr-1", Status: github.RunnerStatusOffline},
+		{ID: 2, Name: "pgr-2", Status: "online"},
+		{ID: 8, Name: "pgr-8", Status: github.RunnerStatusOffline},
+		{ID: 9, Name: "other-1", Status: github.RunnerStatusOffline},
+	}
+
+	report, err := s.svc.Reconcile(context.Background())
+	s.Require().NoError(err)
+
+	s.ElementsMatch([]string{"pgr-1", "pgr-8"}, report.OfflineRunners)
+	s.Equal(3, report.Registrations)
+	s.ElementsMatch([]int64{1, 8}, s.gh.deleted)
+	s.Equal([]string{"pgr-1"}, s.provider.destroyed)
+
+	_, err = s.store.GetRunner(context.Background(), "pgr-1")
+	s.ErrorIs(err, ErrRunnerNotFound)
+}
+
+func (s *ServiceSuite) TestReconcileReprovisionsStuckRunners() {
+	s.expectEncrypt()
+	s.vc.On("TransitDecrypt", mock.Anything, "vault:v1:jit-pgr-1").Return("jit-pgr-1", nil)
+
+	s.provider.err = errors.New("boom")
+	for id := int64(1); id <= 3; id++ {
+		s.Error(s.svc.Provision(context.Background(), &Job{ID: id, Owner: "octo", Repository: "octo/repo"}))
+	}
+	s.provider.err = nil
+
+	// Job 1 is still queued, job 2 completed and job 3 is stuck but not for long enough.
+	for _, name := range []string{"pgr-1", "pgr-2"} {
+		runner, err := s.store.GetRunner(context.Background(), name)
+		s.Require().NoError(err)
+		runner.UpdatedAt = time.Now().Add(-time.Hour)
+		s.Require().NoError(s.store.SaveRunner(context.Background(), runner))
+	}
+	s.gh.jobs[1] = &github.WorkflowJob{ID: 1, Status: "queued"}
+	s.gh.jobs[2] = &github.WorkflowJob{ID: 2, Status: github.JobStatusCompleted}
+
+	report, err := s.svc.Reconcile(context.Background())
+	s.Require().NoError(err)
+
+	s.ElementsMatch([]string{"pgr-1", "pgr-2"}, report.StuckRunners)
+	s.Empty(report.Errors)
+	s.Equal(map[string]string{"pgr-1": "jit-pgr-1"}, s.provider.provisioned)
+	s.Equal([]string{"pgr-2"}, s.provider.destroyed)
+
+	runner, err := s.store.GetRunner(context.Background(), "pgr-1")
+	s.Require().NoError(err)
+	s.Equal(RunnerStateRunning, runner.State)
+
+	runner, err = s.store.GetRunner(context.Background(), "pgr-3")
+	s.Require().NoError(err)
+	s.Equal(RunnerStateProvisioning, runner.State)
+}
+
+func (s *ServiceSuite) TestReconcileDispatchesLostJobs() {
+	s.expectEncrypt()
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Repository: "octo/repo"}))
+
+	old := time.Now().Add(-time.Hour)
+	s.gh.repos = []string{"octo/repo", "other/repo"}
+	s.gh.queued = map[string][]*github.WorkflowJob{
+		"octo/repo": {
+			{ID: 1, Status: github.JobStatusQueued, CreatedAt: old},
+			{ID: 2, Status: github.JobStatusQueued, CreatedAt: old},
+			{ID: 3, Status: github.JobStatusQueued, CreatedAt: time.Now()},
+		},
+		"other/repo": {{ID: 4, Status: github.JobStatusQueued, CreatedAt: old}},
+	}
+
+	report, err := s.svc.Reconcile(context.Background())
+	s.Require().NoError(err)
+
+	s.Equal([]int64{2}, report.LostJobs, "job 1 has a runner, job 3 may still be delivered and job 4 is not in the scope")
+	s.Empty(report.Errors)
+	s.Contains(s.provider.provisioned, "pgr-2")
+
+	report, err = s.svc.Reconcile(context.Background())
+	s.Require().NoError(err)
+	s.Empty(report.LostJobs)
+}
+
+// blockingProvider is a provider whose provisioning blocks until it is released.
+type blockingProvider struct {
+	*fakeProvider
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingProvider) Provision(ctx context.Context, runner *Runner, jitConfig string) error {
+	b.started <- struct{}{}
+	<-b.release
+	return b.fakeProvider.Provision(ctx, runner, jitConfig)
+}
+
+func (s *ServiceSuite) TestReconcileLeavesRunnersBeingProvisioned() {
+	s.expectEncrypt()
+	provider := &blockingProvider{fakeProvider: s.provider, started: make(chan struct{}), release: make(chan struct{})}
+	s.svc.provider = provider
+	s.svc.cfg.StuckAfter = time.Nanosecond
+	s.gh.jobs[1] = &github.WorkflowJob{ID: 1, Status: "queued"}
+
+	done := make(chan error)
+	go func() {
+		done <- s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Repository: "octo/repo"})
+	}()
+	<-provider.started
+
+	report, err := s.svc.Reconcile(context.Background())
+	s.Require().NoError(err)
+	s.Empty(report.StuckRunners, "a runner whose provisioning outlasts StuckAfter must not be provisioned twice")
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Repository: "octo/repo"}))
+
+	close(provider.release)
+	s.Require().NoError(<-done)
+
+	s.Len(s.gh.requests, 1)
+	s.Equal(map[string]string{"pgr-1": "jit-pgr-1"}, s.provider.provisioned)
+}
@@ -0,0 +1,165 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+)
+
+const (
+	// RecycleDestroy destroys the machine of a warm pool runner after its job. It is the default.
+	RecycleDestroy = "destroy"
+
+	// RecycleSnapshot rolls the machine of a warm pool runner back to the snapshot taken before it first booted after
+	// its job, and returns it to its pool with a new registration. A claimed runner is not replaced, as it returns.
+	RecycleSnapshot = "snapshot"
+)
+
+// ErrRecycleUnsupported is returned when the machine of a runner cannot be recycled.
+var ErrRecycleUnsupported = errors.New("the machine cannot be recycled")
+
+// Recycler is implemented by the providers that can return the machine of a runner to the state it first booted in,
+// which is faster than destroying it and creating another.
+type Recycler interface {
+	// Recycle returns the machine of the runner to the state it first booted in and starts the runner on it again with
+	// the just-in-time config. It returns ErrRecycleUnsupported if the machine cannot be recycled.
+	Recycle(ctx context.Context, runner *Runner, jitConfig string) error
+}
+
+// markRecycling marks a runner of a warm pool in RecycleSnapshot mode as recycling, for the warm pools to return it to
+// its pool. It returns false if the runner is to be torn down instead, e.g. because its pool has enough idle runners
+// without it. A runner that is recycling already is left to it.
+func (s *Service) markRecycling(ctx context.Context, runner *Runner, reason string) bool {
+	if !runner.Recyclable || runner.VMID == 0 {
+		return false
+	} else if _, ok := s.provider.(Recycler); !ok {
+		return false
+	}
+
+	pool := s.warmPool(runner.Pool)
+	if pool == nil || pool.Recycle != RecycleSnapshot {
+		return false
+	}
+
+	l := slog.With(slog.String(logging.KeyRunner, runner.Name), slog.String("pool", pool.Name))
+
+	s.admitMut.Lock()
+	defer s.admitMut.Unlock()
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	runners, err := s.store.ListRunners(ctx)
+	if err != nil {
+		l.Warn("unable to list runners to recycle runner", slog.String(logging.KeyError, err.Error()))
+		return false
+	}
+
+	stored := false
+	others := make([]*Runner, 0, len(runners))
+	for _, r := range runners {
+		switch {
+		case r.Name != runner.Name:
+			others = append(others, r)
+		case r.State == RunnerStateRecycling:
+			return true
+		default:
+			stored = true
+		}
+	}
+	if !stored || !s.poolShort(others, pool) {
+		return false
+	}
+
+	// The config was for the job that is over. The runner is registered again before its machine is recycled.
+	runner.State = RunnerStateRecycling
+	runner.JobID = 0
+	runner.Repository = ""
+	runner.JITConfig = ""
+	runner.UpdatedAt = time.Now().UTC()
+	if err := s.store.SaveRunner(ctx, runner); err != nil {
+		l.Warn("unable to save runner to recycle", slog.String(logging.KeyError, err.Error()))
+		return false
+	}
+
+	l.Info("recycling runner", slog.String("reason", reason))
+
+	s.triggerRefill()
+
+	return true
+}
+
+// recycle registers the recycling runner again and has the provider return its machine to its pool. A runner that
+// cannot be recycled is torn down, and the pool is refilled with a new runner.
+func (s *Service) recycle(ctx context.Context, wg *sync.WaitGroup, pool *WarmPool, runner *Runner) {
+	defer wg.Done()
+	defer func() {
+		s.mut.Lock()
+		delete(s.warming, runner.Name)
+		s.mut.Unlock()
+	}()
+
+	l := slog.With(slog.String(logging.KeyRunner, runner.Name), slog.String("pool", pool.Name))
+
+	if err := s.recycleMachine(ctx, pool, runner); err != nil {
+		l.Error("unable to recycle runner, tearing it down", slog.String(logging.KeyError, err.Error()))
+
+		if err := s.destroy(ctx, runner, "recycling failed"); err != nil {
+			l.Error("unable to tear down runner", slog.String(logging.KeyError, err.Error()))
+		}
+		return
+	}
+
+	l.Info("runner recycled")
+}
+
+// recycleMachine registers the runner again, unless an earlier attempt did, and recycles its machine with the new
+// config.
+func (s *Service) recycleMachine(ctx context.Context, pool *WarmPool, runner *Runner) error {
+	recycler, ok := s.provider.(Recycler)
+	if !ok {
+		return ErrRecycleUnsupported
+	}
+
+	registered := runner.JITConfig != ""
+	if !registered && runner.GitHubRunnerID != 0 {
+		// GitHub removes a just-in-time runner once it has run its job, so the registration is usually gone already.
+		err := s.gh.DeleteRunner(ctx, runner.InstallationID, runner.Scope, runner.GitHubRunnerID)
+		if err != nil && !errors.Is(err, utils.NewHttpError(http.StatusNotFound, "")) {
+			return fmt.Errorf("delete runner registration: %w", err)
+		}
+		runner.GitHubRunnerID = 0
+	}
+
+	jitConfig, err := s.warmJITConfig(ctx, pool, runner)
+	if err != nil {
+		return err
+	}
+
+	if !registered {
+		// The machine still holds the disk of the last job until it is recycled, so the runner must not be taken for
+		// a provisioning one should the scaler stop here.
+		runner.State = RunnerStateRecycling
+		if err := s.store.SaveRunner(ctx, runner); err != nil {
+			return fmt.Errorf("save runner: %w", err)
+		}
+	}
+
+	if err := recycler.Recycle(ctx, runner, jitConfig); err != nil {
+		return fmt.Errorf("recycle runner %s: %w", runner.Name, err)
+	}
+
+	runner.State = RunnerStateIdle
+	runner.UpdatedAt = time.Now().UTC()
+	if err := s.store.SaveRunner(ctx, runner); err != nil {
+		return fmt.Errorf("save runner: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+)
+
+// fakeRecycler is a provider that recycles machines.
+type fakeRecycler struct {
+	*fakeProvider
+
+	recycled map[string]string
+	err      error
+}
+
+func (f *fakeRecycler) Recycle(_ context.Context, runner *Runner, jitConfig string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+	f.recycled[runner.Name] = jitConfig
+	return nil
+}
+
+// withRecyclingWarmPool sets up a warm pool in RecycleSnapshot mode, filled with a single runner that is claimed by
+// job 1, and returns the name of the runner.
+func (s *ServiceSuite) withRecyclingWarmPool() (*fakeRecycler, string) {
+	s.expectEncrypt()
+	recycler := &fakeRecycler{fakeProvider: s.provider, recycled: make(map[string]string)}
+	s.svc.provider = recycler
+	s.withWarmPool(1)
+	s.svc.cfg.WarmPools[0].Recycle = RecycleSnapshot
+
+	s.fill()
+	warm := s.idle()[0]
+
+	s.Require().NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Labels: []string{"small"}}))
+	s.Require().Empty(s.idle())
+
+	return recycler, warm
+}
+
+func (s *ServiceSuite) TestWarmRunnerIsRecycledAfterItsJob() {
+	recycler, warm := s.withRecyclingWarmPool()
+
+	s.fill()
+	s.Len(s.gh.requests, 1, "a claimed runner that is recycled must not be replaced")
+
+	s.NoError(s.svc.Complete(context.Background(), 1, warm))
+	s.Empty(s.provider.destroyed)
+
+	runner, err := s.store.GetRunner(context.Background(), warm)
+	s.Require().NoError(err)
+	s.Equal(RunnerStateRecycling, runner.State)
+	s.Zero(runner.JobID)
+
+	s.NoError(s.svc.Complete(context.Background(), 1, warm), "a runner that is recycling already must be left to it")
+	s.NoError(s.svc.Exited(context.Background(), warm, "token-of-the-last-job"), "the callback of the last job must be ignored")
+
+	s.fill()
+
+	s.Equal([]string{warm}, s.idle())
+	s.Empty(s.provider.destroyed)
+	s.Equal([]int64{1}, s.gh.deleted, "the registration of the last job must be removed")
+	s.Require().Len(s.gh.requests, 2)
+	s.Equal(warm, s.gh.requests[1].Name)
+	s.Equal("jit-"+warm, recycler.recycled[warm])
+
+	runner, err = s.store.GetRunner(context.Background(), warm)
+	s.Require().NoError(err)
+	s.Equal(int64(2), runner.GitHubRunnerID)
+	s.Equal("vault:v1:jit-"+warm, runner.JITConfig)
+}
+
+func (s *ServiceSuite) TestWarmRunnerIsTornDownWhenRecyclingFails() {
+	recycler, warm := s.withRecyclingWarmPool()
+	recycler.err = errors.New("roll back vm 100: snapshot base does not exist")
+
+	s.NoError(s.svc.Complete(context.Background(), 1, warm))
+	s.fill()
+
+	s.Equal([]string{warm}, s.provider.destroyed)
+	s.Equal([]int64{1, 2}, s.gh.deleted, "the new registration must be removed with the machine")
+	_, err := s.store.GetRunner(context.Background(), warm)
+	s.ErrorIs(err, ErrRunnerNotFound)
+
+	s.fill()
+	s.Len(s.idle(), 1, "the pool must be refilled with a new runner")
+}
+
+func (s *ServiceSuite) TestWarmRunnerIsDestroyedWhenPoolHasEnough() {
+	_, warm := s.withRecyclingWarmPool()
+	s.svc.cfg.WarmPools[0].MinIdle = 0
+
+	s.NoError(s.svc.Complete(context.Background(), 1, warm))
+
+	s.Equal([]string{warm}, s.provider.destroyed)
+	_, err := s.store.GetRunner(context.Background(), warm)
+	s.ErrorIs(err, ErrRunnerNotFound)
+}
@@ -0,0 +1,433 @@
+package scaler
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github/webhook"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/scheduling"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+)
+
+var (
+	// ErrPoolFull is why a job is queued while its pool is at one of its limits.
+	ErrPoolFull = errors.New("pool is full")
+
+	// ErrNoCapacity is returned by a Provider when no node has room for the machine of a runner. The runner is queued
+	// until there is.
+	ErrNoCapacity = errors.New("no capacity for the machine")
+)
+
+// Config is the configuration for the Service.
+type Config struct {
+	// RunnerScope is the level the runners are registered at.
+	RunnerScope github.ScopeKind
+
+	// Enterprise is the enterprise slug the runners are registered with when RunnerScope is enterprise.
+	Enterprise string
+
+	// RunnerGroupID is the runner group the runners are added to.
+	RunnerGroupID int64
+
+	// RunnerLabels are added to the job labels of every runner.
+	RunnerLabels []string
+
+	// RunnerPrefix is the prefix of the runner names.
+	RunnerPrefix string
+
+	// Router routes the jobs to pools by their labels. Every job gets the provider's default machine when it is nil.
+	Router *routing.Router
+
+	// Scheduler orders the queued jobs. Defaults to fair queueing with equal weights and no priority classes.
+	Scheduler *scheduling.Scheduler
+
+	// WarmPools are the pools of idle runners kept booted.
+	WarmPools []WarmPool
+
+	// WarmRefillInterval is how often the warm pools are checked for missing runners. Claiming a runner also triggers a
+	// refill. Defaults to 30 seconds.
+	WarmRefillInterval time.Duration
+
+	// ReconcileInterval is how often the runners are reconciled with the machines and the GitHub registrations.
+	// Defaults to 5 minutes.
+	ReconcileInterval time.Duration
+
+	// StuckAfter is how long a runner can be provisioning before the reconciler provisions it again, and how long a
+	// job can be queued without a runner before the reconciler dispatches it again. Defaults to 15 minutes.
+	StuckAfter time.Duration
+
+	// Dispatcher is handed the queued jobs the reconciler finds without a runner. Defaults to the Service itself.
+	Dispatcher webhook.Dispatcher
+
+	// QueueInterval is how often the queued jobs are checked for room. Tearing a runner down also triggers a check.
+	// Defaults to 15 seconds.
+	QueueInterval time.Duration
+
+	// SweepInterval is how often the runners are checked for jobs that ended or machines that stopped without the
+	// scaler hearing about it. Defaults to 1 minute.
+	SweepInterval time.Duration
+}
+
+// Service turns queued jobs into runners and tears the runners down once their job has completed.
+type Service struct {
+	cfg Config
+
+	// store persists the runner state.
+	store Store
+
+	// gh is the GitHub App client the runners are registered through.
+	gh github.Client
+
+	// vc encrypts the just-in-time configs while they are in the store.
+	vc vault.Client
+
+	// provider creates and destroys the runner machines.
+	provider Provider
+
+	// mut serialises changes to which job a runner serves, and guards warming, admitting, installations and lastReport.
+	mut sync.Mutex
+
+	// admitMut serialises counting what the runners of a pool use with adding a runner to it. It is taken before mut.
+	admitMut sync.Mutex
+
+	// warming are the warm pool runners that are being provisioned, mapped to their pool.
+	warming map[string]string
+
+	// admitting are the runners that are being started, queued runners that were admitted and runners whose
+	// provisioning is retried.
+	admitting map[string]struct{}
+
+	// installations are the app installation IDs of the scopes runners were registered with.
+	installations map[github.Scope]int64
+
+	// lastReport is the report of the last reconciliation pass.
+	lastReport *ReconcileReport
+
+	// refill wakes the warm pools up to replace claimed runners.
+	refill chan struct{}
+
+	// drain wakes the queue up to start the runners there is room for.
+	drain chan struct{}
+}
+
+// NewService creates a new Service.
+func NewService(cfg Config, store Store, gh github.Client, vc vault.Client, provider Provider) *Service {
+	if cfg.Scheduler == nil {
+		cfg.Scheduler = new(scheduling.Scheduler)
+	}
+
+	return &Service{
+		cfg:      cfg,
+		store:    store,
+		gh:       gh,
+		vc:       vc,
+		provider: provider,
+
+		warming:       make(map[string]string),
+		admitting:     make(map[string]struct{}),
+		installations: make(map[github.Scope]int64),
+		refill:        make(chan struct{}, 1),
+		drain:         make(chan struct{}, 1),
+	}
+}
+
+// Provision provisions a runner for the job, or claims an idle warm pool runner that can serve it. A job whose pool
+// is at one of its limits, or whose machine fits on no node, is queued and provisioned once there is room for it.
+// Otherwise it blocks until the provider has finished, so that a failure is retried with the event that queued the
+// job. It is safe to call again for the same job, an earlier attempt is picked up where it left off.
+func (s *Service) Provision(ctx context.Context, job *Job) error {
+	if s.provider == nil {
+		return errors.New("no runner provider configured")
+	}
+
+	name := s.runnerName(job.ID)
+
+	runner, err := s.store.GetRunner(ctx, name)
+	switch {
+	case errors.Is(err, ErrRunnerNotFound):
+		pool, err := s.route(job)
+		if err != nil {
+			return err
+		}
+
+		claimed, err := s.claimIdle(ctx, job, pool)
+		if err != nil {
+			return err
+		} else if claimed {
+			return nil
+		}
+
+		scope, err := s.scopeFor(job)
+		if err != nil {
+			return err
+		}
+
+		runner = &Runner{
+			Name:           name,
+			JobID:          job.ID,
+			Repository:     job.Repository,
+			Labels:         append(append([]string(nil), job.Labels...), s.cfg.RunnerLabels...),
+			InstallationID: job.InstallationID,
+			Scope:          scope,
+			QueuedAt:       job.QueuedAt.UTC(),
+		}
+		if runner.QueuedAt.IsZero() {
+			runner.QueuedAt = time.Now().UTC()
+		}
+		if class := s.cfg.Scheduler.Classify(job.Repository, job.HeadBranch); class != nil {
+			runner.Class = class.Name
+			runner.Priority = class.Priority
+		}
+		if runner.Spec, err = specFor(pool, job.Labels); err != nil {
+			return err
+		}
+
+		queued, err := s.enqueue(ctx, pool, runner)
+		if err != nil || !queued {
+			return err
+		}
+		return s.startQueued(ctx, runner)
+	case err != nil:
+		return fmt.Errorf("get runner: %w", err)
+	case runner.State == RunnerStateQueued:
+		return s.startQueued(ctx, runner)
+	case runner.State != RunnerStateProvisioning:
+		slog.Debug("job already has a runner", slog.Int64(logging.KeyJobID, job.ID), slog.String(logging.KeyRunner, name))
+		return nil
+	default:
+		if !s.claimStart(name) {
+			slog.Debug("runner is being started already", slog.Int64(logging.KeyJobID, job.ID), slog.String(logging.KeyRunner, name))
+			return nil
+		}
+		defer s.unadmit(name)
+
+		return s.start(ctx, runner)
+	}
+}
+
+// Assign records that the job was picked up by the runner. GitHub hands a job to any idle runner with matching
+// labels, so the runner may not be the one the job was provisioned for or claimed. The runners then swap jobs.
+func (s *Service) Assign(ctx context.Context, jobID int64, runnerName string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	runner, err := s.store.GetRunner(ctx, runnerName)
+	if errors.Is(err, ErrRunnerNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("get runner: %w", err)
+	}
+
+	if runner.JobID != jobID {
+		runners, err := s.store.ListRunners(ctx)
+		if err != nil {
+			return fmt.Errorf("list runners: %w", err)
+		}
+
+		for _, other := range runners {
+			if other.JobID != jobID || other.Name == runner.Name {
+				continue
+			}
+
+			other.JobID = runner.JobID
+			if other.JobID == 0 && other.Pool != "" && other.State == RunnerStateRunning {
+				other.State = RunnerStateIdle
+			}
+			other.UpdatedAt = time.Now().UTC()
+			if err := s.store.SaveRunner(ctx, other); err != nil {
+				return fmt.Errorf("save runner: %w", err)
+			}
+
+			slog.Info("job picked up by another runner than expected",
+				slog.Int64(logging.KeyJobID, jobID),
+				slog.String(logging.KeyRunner, runner.Name),
+				slog.String("expected_runner", other.Name),
+			)
+		}
+	}
+
+	runner.JobID = jobID
+	runner.State = RunnerStateRunning
+	runner.UpdatedAt = time.Now().UTC()
+	if err := s.store.SaveRunner(ctx, runner); err != nil {
+		return fmt.Errorf("save runner: %w", err)
+	}
+
+	return nil
+}
+
+// Complete tears down the runner that ran the job. The runner name is empty when the job was cancelled before a
+// runner picked it up, the runner the job was provisioned for or claimed is then torn down or returned to its pool.
+func (s *Service) Complete(ctx context.Context, jobID int64, runnerName string) error {
+	runner, err := s.runnerForJob(ctx, jobID, runnerName)
+	if errors.Is(err, ErrRunnerNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if runnerName == "" && runner.Pool != "" {
+		return s.release(ctx, runner)
+	}
+
+	return s.teardown(ctx, runner, "job completed")
+}
+
+// runnerForJob returns the runner that ran the job, or the runner that was expected to when no runner picked it up.
+func (s *Service) runnerForJob(ctx context.Context, jobID int64, runnerName string) (*Runner, error) {
+	if runnerName != "" {
+		runner, err := s.store.GetRunner(ctx, runnerName)
+		if err != nil && !errors.Is(err, ErrRunnerNotFound) {
+			return nil, fmt.Errorf("get runner: %w", err)
+		}
+		return runner, err
+	}
+
+	runners, err := s.store.ListRunners(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list runners: %w", err)
+	}
+
+	for _, r := range runners {
+		if r.JobID == jobID {
+			return r, nil
+		}
+	}
+
+	return nil, ErrRunnerNotFound
+}
+
+// provision hands the runner to the provider and saves it with the given state once its machine is running.
+func (s *Service) provision(ctx context.Context, runner *Runner, jitConfig string, state RunnerState) error {
+	if err := s.provider.Provision(ctx, runner, jitConfig); err != nil {
+		// The provider may have recorded a machine before failing, keep it so that the retry picks it up rather than
+		// leaking it.
+		runner.UpdatedAt = time.Now().UTC()
+		if saveErr := s.store.SaveRunner(ctx, runner); saveErr != nil {
+			slog.Error("unable to save runner after failed provision",
+				slog.String(logging.KeyRunner, runner.Name),
+				slog.String(logging.KeyError, saveErr.Error()),
+			)
+		}
+		return fmt.Errorf("provision runner %s: %w", runner.Name, err)
+	}
+
+	runner.State = state
+	runner.UpdatedAt = time.Now().UTC()
+	if err := s.store.SaveRunner(ctx, runner); err != nil {
+		return fmt.Errorf("save runner: %w", err)
+	}
+
+	return nil
+}
+
+// route returns the pool the job is routed to, or nil if the jobs are not routed to pools.
+func (s *Service) route(job *Job) (*routing.Pool, error) {
+	if s.cfg.Router == nil {
+		return nil, nil
+	}
+
+	pool, err := s.cfg.Router.Route(job.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("route job %d: %w", job.ID, err)
+	}
+
+	return pool, nil
+}
+
+// specFor returns the machine spec of a runner of the pool with the given labels, or nil if there is no pool. The
+// size a size label asks for overrides the resources of the pool.
+func specFor(pool *routing.Pool, labels []string) (*MachineSpec, error) {
+	if pool == nil {
+		return nil, nil
+	}
+
+	spec := &MachineSpec{
+		Pool:         pool.Name,
+		Backend:      pool.Backend,
+		TemplateID:   pool.TemplateID,
+		Cores:        pool.Cores,
+		MemoryMB:     pool.MemoryMB,
+		NodeSelector: pool.NodeSelector,
+		VMIDRange:    pool.VMIDRange,
+	}
+
+	size, err := pool.Size(labels)
+	if err != nil {
+		return nil, err
+	} else if size != nil {
+		spec.Cores = cmp.Or(size.Cores, spec.Cores)
+		spec.MemoryMB = cmp.Or(size.MemoryMB, spec.MemoryMB)
+		spec.DiskGB = size.DiskGB
+	}
+
+	return spec, nil
+}
+
+// register registers the runner with GitHub as a just-in-time runner and saves it to the store with the config
+// encrypted. It returns the plaintext config so that it does not need to be decrypted again straight away.
+func (s *Service) register(ctx context.Context, runner *Runner) (string, error) {
+	jit, err := s.gh.GenerateJITConfig(ctx, runner.InstallationID, runner.Scope, &github.JITConfigRequest{
+		Name:          runner.Name,
+		RunnerGroupID: s.cfg.RunnerGroupID,
+		Labels:        runner.Labels,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := s.vc.TransitEncrypt(ctx, jit.EncodedJITConfig)
+	if err != nil {
+		return "", fmt.Errorf("encrypt jit config: %w", err)
+	}
+
+	ciphertext, ok := encrypted.Get("ciphertext").(string)
+	if !ok || ciphertext == "" {
+		return "", errors.New("vault transit returned no ciphertext")
+	}
+
+	s.mut.Lock()
+	s.installations[runner.Scope] = runner.InstallationID
+	s.mut.Unlock()
+
+	now := time.Now().UTC()
+	runner.State = RunnerStateProvisioning
+	runner.GitHubRunnerID = jit.Runner.ID
+	runner.JITConfig = ciphertext
+	runner.CreatedAt = now
+	runner.UpdatedAt = now
+
+	if err := s.store.SaveRunner(ctx, runner); err != nil {
+		return "", fmt.Errorf("save runner: %w", err)
+	}
+
+	return jit.EncodedJITConfig, nil
+}
+
+// scopeFor returns where the runner for the job is registered.
+func (s *Service) scopeFor(job *Job) (github.Scope, error) {
+	switch s.cfg.RunnerScope {
+	case github.ScopeOrganization:
+		return github.Scope{Kind: github.ScopeOrganization, Name: job.Owner}, nil
+	case github.ScopeRepository:
+		return github.Scope{Kind: github.ScopeRepository, Name: job.Repository}, nil
+	case github.ScopeEnterprise:
+		return github.Scope{Kind: github.ScopeEnterprise, Name: s.cfg.Enterprise}, nil
+	default:
+		return github.Scope{}, fmt.Errorf("unknown runner scope %q", s.cfg.RunnerScope)
+	}
+}
+
+// runnerName returns the name of the runner for the job. It is derived from the job ID so that a retried event finds
+// the runner an earlier attempt registered.
+func (s *Service) runnerName(jobID int64) string {
+	return fmt.Sprintf("%s-%d", s.cfg.RunnerPrefix, jobID)
+}
@@ -0,0 +1,303 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github/webhook"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/vault"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type fakeProvider struct {
+	mut         sync.Mutex
+	provisioned map[string]string
+	destroyed   []string
+	stopped     map[string]bool
+	machines    []*Machine
+	err         error
+}
+
+func (f *fakeProvider) Provision(_ context.Context, runner *Runner, jitConfig string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	runner.Node = "pve1"
+	runner.VMID = 100 + int(runner.JobID)
+	if f.err != nil {
+		return f.err
+	}
+	f.provisioned[runner.Name] = jitConfig
+	return nil
+}
+
+func (f *fakeProvider) Destroy(_ context.Context, runner *Runner) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.destroyed = append(f.destroyed, runner.Name)
+	return nil
+}
+
+func (f *fakeProvider) Stopped(_ context.Context, runner *Runner) (bool, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	return f.stopped[runner.Name], nil
+}
+
+func (f *fakeProvider) List(context.Context) ([]*Machine, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	return f.machines, nil
+}
+
+type fakeGitHub struct {
+	github.Client
+
+	mut      sync.Mutex
+	requests []*github.JITConfigRequest
+	scopes   []github.Scope
+	deleted  []int64
+	jobs     map[int64]*github.WorkflowJob
+	runners  []*github.Runner
+	repos    []string
+	queued   map[string][]*github.WorkflowJob
+}
+
+func (f *fakeGitHub) GenerateJITConfig(_ context.Context, _ int64, scope github.Scope, req *github.JITConfigRequest) (*github.JITConfig, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.requests = append(f.requests, req)
+	f.scopes = append(f.scopes, scope)
+	return &github.JITConfig{
+		Runner:           github.Runner{ID: int64(len(f.requests)), Name: req.Name},
+		EncodedJITConfig: "jit-" + req.Name,
+	}, nil
+}
+
+func (f *fakeGitHub) ListRunners(context.Context, int64, github.Scope) ([]*github.Runner, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	return f.runners, nil
+}
+
+func (f *fakeGitHub) DeleteRunner(_ context.Context, _ int64, _ github.Scope, runnerID int64) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.deleted = append(f.deleted, runnerID)
+	return nil
+}
+
+func (f *fakeGitHub) GetWorkflowJob(_ context.Context, _ int64, _ string, jobID int64) (*github.WorkflowJob, error) {
+	job, ok := f.jobs[jobID]
+	if !ok {
+		return nil, utils.NewHttpError(http.StatusNotFound, "Not Found")
+	}
+	return job, nil
+}
+
+func (f *fakeGitHub) ListInstallationRepositories(context.Context, int64, string) ([]string, *github.Response, error) {
+	return f.repos, nil, nil
+}
+
+// ListWorkflowRuns returns a single queued run for every repository with queued jobs.
+func (f *fakeGitHub) ListWorkflowRuns(_ context.Context, _ int64, repository, status, _ string) ([]*github.WorkflowRun, *github.Response, error) {
+	if status != github.RunStatusQueued || len(f.queued[repository]) == 0 {
+		return nil, nil, nil
+	}
+	return []*github.WorkflowRun{{ID: 1, Status: status}}, nil, nil
+}
+
+func (f *fakeGitHub) ListWorkflowRunJobs(_ context.Context, _ int64, repository string, _ int64, _ string) ([]*github.WorkflowJob, *github.Response, error) {
+	return f.queued[repository], nil, nil
+}
+
+func (f *fakeGitHub) Installation(context.Context, github.Scope) (int64, error) {
+	return 7, nil
+}
+
+type ServiceSuite struct {
+	suite.Suite
+
+	store    Store
+	gh       *fakeGitHub
+	vc       *vault.MockClient
+	provider *fakeProvider
+	svc      *Service
+}
+
+func TestServiceSuite(t *testing.T) {
+	suite.Run(t, new(ServiceSuite))
+}
+
+func (s *ServiceSuite) SetupTest() {
+	store, err := NewFileStore(filepath.Join(s.T().TempDir(), "state.json"))
+	s.Require().NoError(err)
+
+	s.store = store
+	s.gh = &fakeGitHub{jobs: make(map[int64]*github.WorkflowJob)}
+	s.vc = vault.NewMockClient(s.T())
+	s.provider = &fakeProvider{provisioned: make(map[string]string), stopped: make(map[string]bool)}
+	s.svc = NewService(Config{
+		RunnerScope:  github.ScopeOrganization,
+		RunnerLabels: []string{"proxmox"},
+		RunnerPrefix: "pgr",
+	}, s.store, s.gh, s.vc, s.provider)
+}
+
+func (s *ServiceSuite) expectEncrypt() {
+	s.vc.On("TransitEncrypt", mock.Anything, mock.Anything).
+		Return(func(_ context.Context, data string) (*vault.Secrets, error) {
+			return vault.CreateMockSecret("ciphertext", "vault:v1:"+data), nil
+		})
+}
+
+func (s *ServiceSuite) TestProvision() {
+	s.expectEncrypt()
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Labels: []string{"self-hosted"}}))
+
+	s.Equal(map[string]string{"pgr-1": "jit-pgr-1"}, s.provider.provisioned)
+	s.Equal([]github.Scope{{Kind: github.ScopeOrganization, Name: "octo"}}, s.gh.scopes)
+	s.Equal([]string{"self-hosted", "proxmox"}, s.gh.requests[0].Labels)
+
+	runner, err := s.store.GetRunner(context.Background(), "pgr-1")
+	s.Require().NoError(err)
+	s.Equal(RunnerStateRunning, runner.State)
+	s.Equal("vault:v1:jit-pgr-1", runner.JITConfig, "the jit config must only be stored encrypted")
+}
+
+func (s *ServiceSuite) TestProvisionIsIdempotent() {
+	s.expectEncrypt()
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo"}))
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo"}))
+
+	s.Len(s.gh.requests, 1)
+	s.Len(s.provider.provisioned, 1)
+}
+
+func (s *ServiceSuite) TestProvisionRetryReusesRegistration() {
+	s.expectEncrypt()
+	s.vc.On("TransitDecrypt", mock.Anything, "vault:v1:jit-pgr-1").Return("jit-pgr-1", nil)
+
+	s.provider.err = errors.New("boom")
+	s.Error(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo"}))
+
+	runner, err := s.store.GetRunner(context.Background(), "pgr-1")
+	s.Require().NoError(err)
+	s.Equal(101, runner.VMID, "the machine of a failed attempt must be kept for the retry")
+
+	s.provider.err = nil
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo"}))
+
+	s.Len(s.gh.requests, 1, "a retry must not register a second runner")
+	s.Equal(map[string]string{"pgr-1": "jit-pgr-1"}, s.provider.provisioned)
+}
+
+func (s *ServiceSuite) TestCompleteDestroysRunner() {
+	s.expectEncrypt()
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo"}))
+
+	s.NoError(s.svc.Complete(context.Background(), 1, "pgr-1"))
+
+	s.Equal([]string{"pgr-1"}, s.provider.destroyed)
+	s.Equal([]int64{1}, s.gh.deleted)
+	_, err := s.store.GetRunner(context.Background(), "pgr-1")
+	s.ErrorIs(err, ErrRunnerNotFound)
+}
+
+func (s *ServiceSuite) TestCompleteUnknownJob() {
+	s.NoError(s.svc.Complete(context.Background(), 1, ""))
+	s.Empty(s.provider.destroyed)
+}
+
+func (s *ServiceSuite) TestDispatchQueuedProvisionsJob() {
+	s.expectEncrypt()
+
+	event := &webhook.JobQueued{WorkflowJobEvent: &webhook.WorkflowJobEvent{
+		Action:       webhook.ActionQueued,
+		WorkflowJob:  webhook.WorkflowJob{ID: 42, Labels: []string{"self-hosted"}},
+		Repository:   webhook.Repository{FullName: "octo/repo", Owner: webhook.Account{Login: "octo"}},
+		Installation: &webhook.Installation{ID: 99},
+	}}
+
+	s.NoError(s.svc.Dispatch(context.Background(), event))
+
+	s.Contains(s.provider.provisioned, "pgr-42")
+}
+
+func (s *ServiceSuite) withPools(pools ...routing.Pool) {
+	router, err := routing.NewRouter(pools, ImplicitLabels)
+	s.Require().NoError(err)
+	s.svc.cfg.Router = router
+}
+
+func (s *ServiceSuite) TestProvisionRoutesToPool() {
+	s.expectEncrypt()
+	s.withPools(
+		routing.Pool{Name: "large", Labels: []string{"large"}, TemplateID: 9100, Cores: 8, MemoryMB: 16384},
+		routing.Pool{Name: "default", Labels: []string{"proxmox-*"}},
+	)
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Labels: []string{"self-hosted", "large"}}))
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 2, Owner: "octo", Labels: []string{"proxmox-small"}}))
+
+	runner, err := s.store.GetRunner(context.Background(), "pgr-1")
+	s.Require().NoError(err)
+	s.Equal(&MachineSpec{Pool: "large", TemplateID: 9100, Cores: 8, MemoryMB: 16384}, runner.Spec)
+
+	runner, err = s.store.GetRunner(context.Background(), "pgr-2")
+	s.Require().NoError(err)
+	s.Equal("default", runner.Spec.Pool)
+}
+
+func (s *ServiceSuite) TestDispatchRejectsUnroutedJob() {
+	s.withPools(routing.Pool{Name: "default", Labels: []string{"proxmox"}})
+
+	event := &webhook.JobQueued{WorkflowJobEvent: &webhook.WorkflowJobEvent{
+		Action:      webhook.ActionQueued,
+		WorkflowJob: webhook.WorkflowJob{ID: 42, Labels: []string{"ubuntu-latest"}},
+		Repository:  webhook.Repository{FullName: "octo/repo", Owner: webhook.Account{Login: "octo"}},
+	}}
+
+	s.NoError(s.svc.Dispatch(context.Background(), event), "a rejected job must not be retried")
+
+	s.Empty(s.provider.provisioned)
+	s.Empty(s.gh.requests)
+}
+
+func (s *ServiceSuite) TestProvisionAppliesSizeLabel() {
+	s.expectEncrypt()
+	s.withPools(routing.Pool{Name: "sized", Labels: []string{"proxmox"}, Cores: 2, MemoryMB: 4096, Sizes: &routing.SizeLimits{
+		Max: routing.Size{Cores: 8, MemoryMB: 16384, DiskGB: 100},
+	}})
+
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Labels: []string{"proxmox-cpu4-disk50g"}}))
+
+	runner, err := s.store.GetRunner(context.Background(), "pgr-1")
+	s.Require().NoError(err)
+	s.Equal(&MachineSpec{Pool: "sized", Cores: 4, MemoryMB: 4096, DiskGB: 50}, runner.Spec)
+	s.Equal([]string{"proxmox-cpu4-disk50g", "proxmox"}, runner.Labels)
+
+	event := &webhook.JobQueued{WorkflowJobEvent: &webhook.WorkflowJobEvent{
+		Action:      webhook.ActionQueued,
+		WorkflowJob: webhook.WorkflowJob{ID: 2, Labels: []string{"proxmox-cpu64"}},
+		Repository:  webhook.Repository{FullName: "octo/repo", Owner: webhook.Account{Login: "octo"}},
+	}}
+	s.NoError(s.svc.Dispatch(context.Background(), event), "a job asking for too large a size must be rejected")
+	s.NotContains(s.provider.provisioned, "pgr-2")
+}
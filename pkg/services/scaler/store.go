@@ -0,0 +1,364 @@
+package scaler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRunnerNotFound is returned when the runner is not in the store.
+	ErrRunnerNotFound = errors.New("runner not found")
+
+	// ErrNotLeader is returned by a fenced Store when the replica writing to it no longer leads.
+	ErrNotLeader = errors.New("the replica no longer leads")
+)
+
+// Store persists the state of the runners the scaler manages.
+type Store interface {
+	// SaveRunner creates or replaces the runner.
+	SaveRunner(ctx context.Context, runner *Runner) error
+
+	// GetRunner returns the runner with the given name, or ErrRunnerNotFound.
+	GetRunner(ctx context.Context, name string) (*Runner, error)
+
+	// ListRunners returns all the runners ordered by name.
+	ListRunners(ctx context.Context) ([]*Runner, error)
+
+	// DeleteRunner removes the runner. Removing a runner that does not exist is not an error.
+	DeleteRunner(ctx context.Context, name string) error
+
+	// ReserveVMID reserves the VMID for the owner until the given time, so that no other runner is given it while its
+	// machine is cloned. It returns false if another owner holds a reservation of the VMID that has not expired. The
+	// owner can renew its own reservation.
+	ReserveVMID(ctx context.Context, vmid int, owner string, until time.Time) (bool, error)
+
+	// ReleaseVMID drops the owner's reservation of the VMID. Releasing a reservation the owner does not hold is not an
+	// error.
+	ReleaseVMID(ctx context.Context, vmid int, owner string) error
+
+	// Reload discards what the store holds in memory and reads the runners and reservations again, picking up the
+	// changes another replica made while it led.
+	Reload(ctx context.Context) error
+}
+
+// VMIDReservation is a VMID held for a runner whose machine is about to be cloned.
+type VMIDReservation struct {
+	// Owner is the name of the runner the VMID is reserved for.
+	Owner string `json:"owner"`
+
+	// Until is when the reservation expires.
+	Until time.Time `json:"until"`
+}
+
+// stateVersion is the version of the layout of the state file. The first layout, without a version, held nothing but
+// the runners keyed by name.
+const stateVersion = 2
+
+// stateFile is the layout of the state file.
+type stateFile struct {
+	Version      int                      `json:"version"`
+	Runners      map[string]*Runner       `json:"runners"`
+	Reservations map[int]*VMIDReservation `json:"vmid_reservations,omitempty"`
+}
+
+type fileStore struct {
+	// path is the path of the state file.
+	path string
+
+	// mu guards runners, reservations and writes to the state file.
+	mu sync.Mutex
+
+	// runners are the runners keyed by name.
+	runners map[string]*Runner
+
+	// reservations are the VMID reservations keyed by VMID.
+	reservations map[int]*VMIDReservation
+}
+
+// NewFileStore creates a Store that keeps the runners and VMID reservations in memory and writes them to a JSON file on
+// every change.
+func NewFileStore(path string) (Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create state directory: %w", err)
+	}
+
+	s := &fileStore{
+		path: path,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *fileStore) SaveRunner(_ context.Context, runner *Runner) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.runners[runner.Name]
+	s.runners[runner.Name] = copyRunner(runner)
+
+	if err := s.flush(); err != nil {
+		// Keep memory in step with the file.
+		if existed {
+			s.runners[runner.Name] = prev
+		} else {
+			delete(s.runners, runner.Name)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *fileStore) GetRunner(_ context.Context, name string) (*Runner, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runner, ok := s.runners[name]
+	if !ok {
+		return nil, ErrRunnerNotFound
+	}
+
+	return copyRunner(runner), nil
+}
+
+func (s *fileStore) ListRunners(_ context.Context) ([]*Runner, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*Runner, 0, len(s.runners))
+	for _, runner := range s.runners {
+		out = append(out, copyRunner(runner))
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out, nil
+}
+
+func (s *fileStore) DeleteRunner(_ context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.runners[name]
+	if !ok {
+		return nil
+	}
+	delete(s.runners, name)
+
+	if err := s.flush(); err != nil {
+		s.runners[name] = prev
+		return err
+	}
+
+	return nil
+}
+
+func (s *fileStore) Reload(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load()
+}
+
+func (s *fileStore) ReserveVMID(_ context.Context, vmid int, owner string, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, reserved := s.reservations[vmid]
+	if reserved && prev.Owner != owner && time.Now().Before(prev.Until) {
+		return false, nil
+	}
+
+	s.reservations[vmid] = &VMIDReservation{Owner: owner, Until: until}
+	expired := s.pruneReservations()
+
+	if err := s.flush(); err != nil {
+		// Keep memory in step with the file.
+		for id, r := range expired {
+			s.reservations[id] = r
+		}
+		if reserved {
+			s.reservations[vmid] = prev
+		} else {
+			delete(s.reservations, vmid)
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *fileStore) ReleaseVMID(_ context.Context, vmid int, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.reservations[vmid]
+	if !ok || prev.Owner != owner {
+		return nil
+	}
+	delete(s.reservations, vmid)
+
+	if err := s.flush(); err != nil {
+		s.reservations[vmid] = prev
+		return err
+	}
+
+	return nil
+}
+
+// pruneReservations drops the expired reservations and returns them. The caller must hold the lock.
+func (s *fileStore) pruneReservations() map[int]*VMIDReservation {
+	expired := make(map[int]*VMIDReservation)
+	now := time.Now()
+	for vmid, r := range s.reservations {
+		if !now.Before(r.Until) {
+			expired[vmid] = r
+			delete(s.reservations, vmid)
+		}
+	}
+	return expired
+}
+
+// load reads the runners and reservations from the state file. A missing file holds neither. The caller must hold the
+// lock, or be the constructor.
+func (s *fileStore) load() error {
+	state := &stateFile{
+		Runners:      make(map[string]*Runner),
+		Reservations: make(map[int]*VMIDReservation),
+	}
+
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.runners, s.reservations = state.Runners, state.Reservations
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read state file: %w", err)
+	}
+
+	// A runner is an object, so a numeric version can only be the version of the layout.
+	var probe struct {
+		Version json.RawMessage `json:"version"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return fmt.Errorf("decode state file: %w", err)
+	}
+
+	if len(probe.Version) == 0 || probe.Version[0] == '{' {
+		err = json.Unmarshal(b, &state.Runners)
+	} else {
+		err = json.Unmarshal(b, state)
+	}
+	if err != nil {
+		return fmt.Errorf("decode state file: %w", err)
+	}
+
+	s.runners = state.Runners
+	s.reservations = state.Reservations
+	if s.runners == nil {
+		s.runners = make(map[string]*Runner)
+	}
+	if s.reservations == nil {
+		s.reservations = make(map[int]*VMIDReservation)
+	}
+
+	return nil
+}
+
+// flush atomically writes the runners and reservations to the state file. The caller must hold the lock.
+func (s *fileStore) flush() error {
+	b, err := json.Marshal(&stateFile{
+		Version:      stateVersion,
+		Runners:      s.runners,
+		Reservations: s.reservations,
+	})
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create state file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename has succeeded.
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replace state file: %w", err)
+	}
+
+	return nil
+}
+
+// fencedStore rejects the writes of a replica that no longer leads.
+type fencedStore struct {
+	Store
+
+	// leads returns true while the replica leads.
+	leads func() bool
+}
+
+// NewFencedStore wraps the store so that it rejects every write with ErrNotLeader while leads returns false. The
+// runners a replica was still starting or tearing down when it stepped down then cannot overwrite the state the new
+// leader writes. Reads and Reload are passed through.
+func NewFencedStore(store Store, leads func() bool) Store {
+	return &fencedStore{Store: store, leads: leads}
+}
+
+func (s *fencedStore) SaveRunner(ctx context.Context, runner *Runner) error {
+	if !s.leads() {
+		return fmt.Errorf("save runner %s: %w", runner.Name, ErrNotLeader)
+	}
+	return s.Store.SaveRunner(ctx, runner)
+}
+
+func (s *fencedStore) DeleteRunner(ctx context.Context, name string) error {
+	if !s.leads() {
+		return fmt.Errorf("delete runner %s: %w", name, ErrNotLeader)
+	}
+	return s.Store.DeleteRunner(ctx, name)
+}
+
+func (s *fencedStore) ReserveVMID(ctx context.Context, vmid int, owner string, until time.Time) (bool, error) {
+	if !s.leads() {
+		return false, fmt.Errorf("reserve vmid %d: %w", vmid, ErrNotLeader)
+	}
+	return s.Store.ReserveVMID(ctx, vmid, owner, until)
+}
+
+func (s *fencedStore) ReleaseVMID(ctx context.Context, vmid int, owner string) error {
+	if !s.leads() {
+		return fmt.Errorf("release vmid %d: %w", vmid, ErrNotLeader)
+	}
+	return s.Store.ReleaseVMID(ctx, vmid, owner)
+}
+
+// copyRunner returns a copy of the runner so callers cannot change the stored copy.
+func copyRunner(runner *Runner) *Runner {
+	c := *runner
+	c.Labels = append([]string(nil), runner.Labels...)
+	return &c
+}
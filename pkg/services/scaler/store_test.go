@@ -0,0 +1,153 @@
+package scaler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	ctx := context.Background()
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	require.NoError(t, store.SaveRunner(ctx, &Runner{Name: "b", JobID: 2}))
+	require.NoError(t, store.SaveRunner(ctx, &Runner{Name: "a", JobID: 1}))
+	require.NoError(t, store.SaveRunner(ctx, &Runner{Name: "c", JobID: 3}))
+	require.NoError(t, store.DeleteRunner(ctx, "c"))
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	runners, err := reopened.ListRunners(ctx)
+	require.NoError(t, err)
+	require.Len(t, runners, 2)
+	require.Equal(t, "a", runners[0].Name)
+	require.Equal(t, "b", runners[1].Name)
+
+	_, err = reopened.GetRunner(ctx, "c")
+	require.ErrorIs(t, err, ErrRunnerNotFound)
+}
+
+func TestFileStoreReloadPicksUpChangesOfAnotherReplica(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	ctx := context.Background()
+
+	follower, err := NewFileStore(path)
+	require.NoError(t, err)
+	require.NoError(t, follower.SaveRunner(ctx, &Runner{Name: "a", JobID: 1}))
+
+	leader, err := NewFileStore(path)
+	require.NoError(t, err)
+	require.NoError(t, leader.DeleteRunner(ctx, "a"))
+	require.NoError(t, leader.SaveRunner(ctx, &Runner{Name: "b", JobID: 2}))
+
+	require.NoError(t, follower.Reload(ctx))
+
+	runners, err := follower.ListRunners(ctx)
+	require.NoError(t, err)
+	require.Len(t, runners, 1)
+	require.Equal(t, "b", runners[0].Name)
+}
+
+func TestFencedStoreRejectsWritesOnceNotLeading(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	ctx := context.Background()
+
+	fileStore, err := NewFileStore(path)
+	require.NoError(t, err)
+	leads := true
+	store := NewFencedStore(fileStore, func() bool { return leads })
+
+	require.NoError(t, store.SaveRunner(ctx, &Runner{Name: "a", State: RunnerStateRunning}))
+	ok, err := store.ReserveVMID(ctx, 100, "a", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	leads = false
+	require.ErrorIs(t, store.SaveRunner(ctx, &Runner{Name: "a", State: RunnerStateIdle}), ErrNotLeader)
+	require.ErrorIs(t, store.SaveRunner(ctx, &Runner{Name: "b"}), ErrNotLeader)
+	require.ErrorIs(t, store.DeleteRunner(ctx, "a"), ErrNotLeader)
+	_, err = store.ReserveVMID(ctx, 101, "b", time.Now().Add(time.Minute))
+	require.ErrorIs(t, err, ErrNotLeader)
+	require.ErrorIs(t, store.ReleaseVMID(ctx, 100, "a"), ErrNotLeader)
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+	runners, err := reopened.ListRunners(ctx)
+	require.NoError(t, err)
+	require.Len(t, runners, 1, "the state file must not change once the replica no longer leads")
+	require.Equal(t, RunnerStateRunning, runners[0].State)
+
+	ok, err = reopened.ReserveVMID(ctx, 100, "b", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.False(t, ok, "the reservation must be kept")
+
+	runner, err := store.GetRunner(ctx, "a")
+	require.NoError(t, err, "reads must be passed through")
+	require.Equal(t, RunnerStateRunning, runner.State)
+}
+
+func TestFileStoreReservesVMIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	ctx := context.Background()
+	until := time.Now().Add(time.Minute)
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	ok, err := store.ReserveVMID(ctx, 9000, "a", until)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = store.ReserveVMID(ctx, 9000, "b", until)
+	require.NoError(t, err)
+	require.False(t, ok, "the vmid is reserved by a")
+
+	ok, err = store.ReserveVMID(ctx, 9000, "a", until.Add(time.Minute))
+	require.NoError(t, err)
+	require.True(t, ok, "a may renew its own reservation")
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+	ok, err = reopened.ReserveVMID(ctx, 9000, "b", until)
+	require.NoError(t, err)
+	require.False(t, ok, "the reservation must survive a restart")
+
+	require.NoError(t, store.ReleaseVMID(ctx, 9000, "b"), "releasing a reservation of another owner is a no-op")
+	ok, err = store.ReserveVMID(ctx, 9000, "b", until)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, store.ReleaseVMID(ctx, 9000, "a"))
+	ok, err = store.ReserveVMID(ctx, 9000, "b", until)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = store.ReserveVMID(ctx, 9001, "c", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = store.ReserveVMID(ctx, 9001, "d", until)
+	require.NoError(t, err)
+	require.True(t, ok, "an expired reservation is free")
+}
+
+func TestFileStoreReadsUnversionedStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":{"name":"version","job_id":1},"a":{"name":"a","job_id":2}}`), 0o600))
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	runners, err := store.ListRunners(context.Background())
+	require.NoError(t, err)
+	require.Len(t, runners, 2)
+	require.Equal(t, "a", runners[0].Name)
+	require.Equal(t, "version", runners[1].Name, "a runner may be called version")
+}
@@ -0,0 +1,184 @@
+package scaler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/logging"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/utils"
+)
+
+// ErrInvalidCallbackToken is returned when a runner reports its exit with the wrong token.
+var ErrInvalidCallbackToken = errors.New("invalid callback token")
+
+// CallbackToken returns the token the runner authenticates its exit callback with. It is derived from the
+// just-in-time config, which only the runner's machine and the scaler know, so no further secret has to be stored.
+func CallbackToken(jitConfig, runnerName string) string {
+	mac := hmac.New(sha256.New, []byte(jitConfig))
+	mac.Write([]byte(runnerName))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Exited tears down the runner after its machine reported that the runner process exited. A runner that is recycling
+// has no config to check the token against, the machine's report is then ignored.
+func (s *Service) Exited(ctx context.Context, runnerName, token string) error {
+	runner, err := s.store.GetRunner(ctx, runnerName)
+	if err != nil {
+		return err
+	} else if runner.State == RunnerStateRecycling {
+		// The runner is being returned to its pool already, after the job the callback is for.
+		return nil
+	}
+
+	jitConfig, err := s.vc.TransitDecrypt(ctx, runner.JITConfig)
+	if err != nil {
+		return fmt.Errorf("decrypt jit config: %w", err)
+	}
+
+	if !hmac.Equal([]byte(CallbackToken(jitConfig, runner.Name)), []byte(token)) {
+		return ErrInvalidCallbackToken
+	}
+
+	return s.teardown(ctx, runner, "runner exited")
+}
+
+// RunSweep tears down runners whose job ended or whose machine stopped without the scaler hearing about it, until
+// the context is cancelled.
+func (s *Service) RunSweep(ctx context.Context) error {
+	interval := s.cfg.SweepInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := s.sweep(ctx); err != nil {
+			slog.Error("unable to sweep runners", slog.String(logging.KeyError, err.Error()))
+		}
+	}
+}
+
+// sweep checks every runner with a running machine, and every queued runner, once.
+func (s *Service) sweep(ctx context.Context) error {
+	if s.provider == nil {
+		return errors.New("no runner provider configured")
+	}
+
+	runners, err := s.store.ListRunners(ctx)
+	if err != nil {
+		return fmt.Errorf("list runners: %w", err)
+	}
+
+	for _, r := range runners {
+		if r.State != RunnerStateRunning && r.State != RunnerStateIdle && r.State != RunnerStateQueued {
+			continue
+		}
+
+		reason, err := s.finished(ctx, r)
+		if err != nil {
+			slog.Warn("unable to check runner",
+				slog.String(logging.KeyRunner, r.Name),
+				slog.String(logging.KeyError, err.Error()),
+			)
+			continue
+		} else if reason == "" {
+			continue
+		}
+
+		if err := s.teardown(ctx, r, reason); err != nil {
+			slog.Error("unable to tear down runner",
+				slog.String(logging.KeyRunner, r.Name),
+				slog.String(logging.KeyError, err.Error()),
+			)
+		}
+	}
+
+	return nil
+}
+
+// finished returns why the runner should be torn down, or an empty string if it is still needed.
+func (s *Service) finished(ctx context.Context, runner *Runner) (string, error) {
+	if runner.JobID != 0 && runner.Repository != "" {
+		job, err := s.gh.GetWorkflowJob(ctx, runner.InstallationID, runner.Repository, runner.JobID)
+		switch {
+		case errors.Is(err, utils.NewHttpError(http.StatusNotFound, "")):
+			return "job no longer exists", nil
+		case err != nil:
+			return "", err
+		case job.IsCompleted() && (job.RunnerName == "" || job.RunnerName == runner.Name):
+			// A job that ran on another runner leaves this one free to pick up the next job.
+			return "job completed", nil
+		}
+	}
+
+	if runner.State == RunnerStateQueued {
+		// A queued runner has no running machine yet.
+		return "", nil
+	}
+
+	stopped, err := s.provider.Stopped(ctx, runner)
+	if err != nil {
+		return "", err
+	} else if stopped {
+		return "machine stopped", nil
+	}
+
+	return "", nil
+}
+
+// teardown returns the runner to its warm pool if its machine is recycled, or destroys it otherwise.
+func (s *Service) teardown(ctx context.Context, runner *Runner, reason string) error {
+	if s.markRecycling(ctx, runner, reason) {
+		return nil
+	}
+	return s.destroy(ctx, runner, reason)
+}
+
+// destroy destroys the machine of the runner, removes its registration in case it never ran a job, and forgets it.
+func (s *Service) destroy(ctx context.Context, runner *Runner, reason string) error {
+	if s.provider != nil {
+		if err := s.provider.Destroy(ctx, runner); err != nil {
+			return fmt.Errorf("destroy runner %s: %w", runner.Name, err)
+		}
+	}
+
+	// GitHub removes a just-in-time runner once it has run its job, so the registration is usually gone already.
+	if runner.GitHubRunnerID != 0 {
+		err := s.gh.DeleteRunner(ctx, runner.InstallationID, runner.Scope, runner.GitHubRunnerID)
+		if err != nil && !errors.Is(err, utils.NewHttpError(http.StatusNotFound, "")) {
+			return fmt.Errorf("delete runner registration: %w", err)
+		}
+	}
+
+	if err := s.store.DeleteRunner(ctx, runner.Name); err != nil {
+		return fmt.Errorf("delete runner: %w", err)
+	}
+
+	slog.Info("runner torn down",
+		slog.String(logging.KeyRunner, runner.Name),
+		slog.Int64(logging.KeyJobID, runner.JobID),
+		slog.String("reason", reason),
+	)
+
+	if runner.Pool != "" {
+		s.triggerRefill()
+	}
+	s.triggerDrain()
+
+	return nil
+}
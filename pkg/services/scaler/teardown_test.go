@@ -0,0 +1,55 @@
+package scaler
+
+import (
+	"context"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/stretchr/testify/mock"
+)
+
+func (s *ServiceSuite) TestExitedTearsDownRunner() {
+	s.expectEncrypt()
+	s.vc.On("TransitDecrypt", mock.Anything, "vault:v1:jit-pgr-1").Return("jit-pgr-1", nil)
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Repository: "octo/repo"}))
+
+	s.ErrorIs(s.svc.Exited(context.Background(), "pgr-1", CallbackToken("other", "pgr-1")), ErrInvalidCallbackToken)
+	s.Empty(s.provider.destroyed)
+
+	s.NoError(s.svc.Exited(context.Background(), "pgr-1", CallbackToken("jit-pgr-1", "pgr-1")))
+	s.Equal([]string{"pgr-1"}, s.provider.destroyed)
+
+	_, err := s.store.GetRunner(context.Background(), "pgr-1")
+	s.ErrorIs(err, ErrRunnerNotFound)
+}
+
+func (s *ServiceSuite) TestSweep() {
+	s.expectEncrypt()
+	for id := int64(1); id <= 4; id++ {
+		s.NoError(s.svc.Provision(context.Background(), &Job{ID: id, Owner: "octo", Repository: "octo/repo"}))
+	}
+
+	// Job 1 completed on its runner, job 2 is still running, job 3 is gone and the runner of job 4 powered off.
+	s.gh.jobs[1] = &github.WorkflowJob{ID: 1, Status: github.JobStatusCompleted, RunnerName: "pgr-1"}
+	s.gh.jobs[2] = &github.WorkflowJob{ID: 2, Status: "in_progress", RunnerName: "pgr-2"}
+	s.gh.jobs[4] = &github.WorkflowJob{ID: 4, Status: "queued"}
+	s.provider.stopped["pgr-4"] = true
+
+	s.NoError(s.svc.sweep(context.Background()))
+
+	s.ElementsMatch([]string{"pgr-1", "pgr-3", "pgr-4"}, s.provider.destroyed)
+
+	runners, err := s.store.ListRunners(context.Background())
+	s.Require().NoError(err)
+	s.Require().Len(runners, 1)
+	s.Equal("pgr-2", runners[0].Name)
+}
+
+func (s *ServiceSuite) TestSweepKeepsRunnerOfJobThatRanElsewhere() {
+	s.expectEncrypt()
+	s.NoError(s.svc.Provision(context.Background(), &Job{ID: 1, Owner: "octo", Repository: "octo/repo"}))
+	s.gh.jobs[1] = &github.WorkflowJob{ID: 1, Status: github.JobStatusCompleted, RunnerName: "pgr-9"}
+
+	s.NoError(s.svc.sweep(context.Background()))
+
+	s.Empty(s.provider.destroyed, "the runner is free to pick up the next job")
+}
@@ -0,0 +1,214 @@
+package scaler
+
+import (
+	"context"
+	"time"
+
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/github"
+	"github.com/Jacobbrewer1/proxmox-github-runners/pkg/services/routing"
+)
+
+// Provider creates and destroys the machines that runners execute on.
+type Provider interface {
+	// Provision creates a machine for the runner and starts the runner on it with the just-in-time config. The
+	// provider records where the machine is on the runner.
+	Provision(ctx context.Context, runner *Runner, jitConfig string) error
+
+	// Destroy tears down the machine of the given runner.
+	Destroy(ctx context.Context, runner *Runner) error
+
+	// Stopped returns true if the machine of the runner is no longer running, e.g. because the runner powered it off
+	// after its job, or if it no longer exists.
+	Stopped(ctx context.Context, runner *Runner) (bool, error)
+
+	// List returns the machines the provider manages, whether or not the scaler still knows about them.
+	List(ctx context.Context) ([]*Machine, error)
+}
+
+// Machine is a machine managed by a provider.
+type Machine struct {
+	// Name is the name of the machine, which is the name of its runner.
+	Name string `json:"name"`
+
+	// Node is the Proxmox node the machine is on.
+	Node string `json:"node"`
+
+	// VMID is the ID of the machine.
+	VMID int `json:"vmid"`
+
+	// Running is true if the machine is running.
+	Running bool `json:"running"`
+
+	// Backend is the backend of the machine, e.g. qemu or lxc.
+	Backend string `json:"backend"`
+}
+
+// MachineSpec is the shape of the machine of a runner, taken from the pool its job was routed to. The provider's
+// defaults are used for the fields that are not set.
+type MachineSpec struct {
+	// Pool is the name of the pool the runner belongs to.
+	Pool string `json:"pool"`
+
+	// Backend is the backend of the machine, e.g. qemu or lxc. The provider's default is used when it is empty.
+	Backend string `json:"backend,omitempty"`
+
+	// TemplateID is the VMID of the template the machine is cloned from.
+	TemplateID int `json:"template_id,omitempty"`
+
+	// Cores is the number of vCPUs of the machine.
+	Cores int `json:"cores,omitempty"`
+
+	// MemoryMB is the memory of the machine in MiB.
+	MemoryMB int `json:"memory_mb,omitempty"`
+
+	// DiskGB is the size the boot disk of the machine is grown to in GiB.
+	DiskGB int `json:"disk_gb,omitempty"`
+
+	// NodeSelector are the placement labels the node of the machine must carry.
+	NodeSelector []string `json:"node_selector,omitempty"`
+
+	// VMIDRange is the range the VMID of the machine is taken from. The provider's default is used when it is nil.
+	VMIDRange *routing.VMIDRange `json:"vmid_range,omitempty"`
+}
+
+// Job is a GitHub workflow job that needs a runner.
+type Job struct {
+	// ID is the workflow job ID.
+	ID int64
+
+	// RunID is the workflow run ID the job belongs to.
+	RunID int64
+
+	// Repository is the full name of the repository, e.g. owner/name.
+	Repository string
+
+	// Owner is the login of the user or organization that owns the repository.
+	Owner string
+
+	// InstallationID is the GitHub App installation the job was delivered for.
+	InstallationID int64
+
+	// Labels are the runs-on labels of the job.
+	Labels []string
+
+	// HeadBranch is the branch the workflow run of the job is for.
+	HeadBranch string
+
+	// QueuedAt is when the job was queued.
+	QueuedAt time.Time
+}
+
+// RunnerState is the lifecycle state of a runner.
+type RunnerState string
+
+const (
+	// RunnerStateQueued is a runner whose job waits for room in its pool or on a node. It is registered with GitHub
+	// once it is admitted, or already is when its machine found no room after it was.
+	RunnerStateQueued RunnerState = "queued"
+
+	// RunnerStateProvisioning is a runner that has been registered with GitHub but whose machine is not yet running.
+	RunnerStateProvisioning RunnerState = "provisioning"
+
+	// RunnerStateRunning is a runner whose machine is running.
+	RunnerStateRunning RunnerState = "running"
+
+	// RunnerStateIdle is a warm pool runner whose machine is running and that is waiting for a job.
+	RunnerStateIdle RunnerState = "idle"
+
+	// RunnerStateRecycling is a warm pool runner whose job is over and whose machine is being returned to the state it
+	// first booted in, to wait for another job.
+	RunnerStateRecycling RunnerState = "recycling"
+)
+
+// Runner is the state the scaler keeps about a runner it manages.
+type Runner struct {
+	// Name is the name the runner is registered with GitHub as.
+	Name string `json:"name"`
+
+	// State is the lifecycle state of the runner.
+	State RunnerState `json:"state"`
+
+	// JobID is the workflow job the runner was provisioned for or has been claimed by. It is zero for an idle warm
+	// pool runner.
+	JobID int64 `json:"job_id"`
+
+	// Pool is the warm pool the runner belongs to. It is empty for a runner provisioned for a job.
+	Pool string `json:"pool,omitempty"`
+
+	// Repository is the full name of the repository the job belongs to.
+	Repository string `json:"repository"`
+
+	// Labels are the labels the runner was registered with.
+	Labels []string `json:"labels"`
+
+	// InstallationID is the GitHub App installation the runner was registered through.
+	InstallationID int64 `json:"installation_id"`
+
+	// Scope is where the runner is registered.
+	Scope github.Scope `json:"scope"`
+
+	// GitHubRunnerID is the ID GitHub gave the runner.
+	GitHubRunnerID int64 `json:"github_runner_id"`
+
+	// JITConfig is the just-in-time config of the runner, encrypted with Vault transit. It is only ever decrypted to be
+	// handed to the runner's machine.
+	JITConfig string `json:"jit_config"`
+
+	// Spec is the shape of the runner's machine. It is nil when the jobs are not routed to pools.
+	Spec *MachineSpec `json:"spec,omitempty"`
+
+	// Node is the Proxmox node the runner's machine is on.
+	Node string `json:"node,omitempty"`
+
+	// VMID is the ID of the runner's machine. It is set before the machine is created, so that a retry can find a
+	// machine an earlier attempt created.
+	VMID int `json:"vmid,omitempty"`
+
+	// Recyclable is true if the runner's machine is rolled back to a snapshot and returned to its warm pool after each
+	// job, rather than destroyed. The provider takes the snapshot before the machine first boots.
+	Recyclable bool `json:"recyclable,omitempty"`
+
+	// Address is the IP address of the runner's machine, when the provider learnt it, e.g. from the QEMU guest agent.
+	Address string `json:"address,omitempty"`
+
+	// QueuedAt is when the job of the runner was queued.
+	QueuedAt time.Time `json:"queued_at"`
+
+	// Class is the priority class of the job of the runner. It is empty when the job belongs to none.
+	Class string `json:"class,omitempty"`
+
+	// Priority is the priority of the class of the job. The queued jobs of higher priority are admitted first.
+	Priority int `json:"priority,omitempty"`
+
+	// WaitReason is why the runner was last put back in the queue.
+	WaitReason string `json:"wait_reason,omitempty"`
+
+	// CreatedAt is when the runner was registered.
+	CreatedAt time.Time `json:"created_at"`
+
+	// UpdatedAt is when the runner state last changed.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WarmPool keeps a number of idle runners with the same labels booted, so that jobs do not wait for a machine.
+type WarmPool struct {
+	// Name is the name of the pool. It is part of the runner names.
+	Name string `mapstructure:"name"`
+
+	// Labels are the labels the runners of the pool are registered with.
+	Labels []string `mapstructure:"labels"`
+
+	// MinIdle is the number of idle runners the pool is refilled to.
+	MinIdle int `mapstructure:"min_idle"`
+
+	// Scope is where the runners of the pool are registered. Only jobs from the scope are served by the pool.
+	Scope github.Scope `mapstructure:"scope"`
+
+	// Recycle is what happens to the machine of a runner of the pool after its job, RecycleDestroy or RecycleSnapshot.
+	// Defaults to RecycleDestroy.
+	Recycle string `mapstructure:"recycle"`
+
+	// Pool is the pool the runners belong to, and whose machines they get. Only jobs routed to the pool are served by
+	// its warm runners. It is required when jobs are routed to pools.
+	Pool string `mapstructure:"pool"`
+}
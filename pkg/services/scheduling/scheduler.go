@@ -0,0 +1,209 @@
+package scheduling
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PriorityClass is a class of jobs that is admitted before the jobs of lower classes, e.g. the jobs of release
+// branches. A job belongs to the first class whose repositories and branches it matches.
+type PriorityClass struct {
+	// Name is the name of the class.
+	Name string `mapstructure:"name"`
+
+	// Priority orders the classes, the highest first. The jobs of no class have priority zero.
+	Priority int `mapstructure:"priority"`
+
+	// Repositories are the repositories of the class as owner/name, wildcard patterns as understood by path.Match,
+	// e.g. octo-org/*. Every repository matches when it is empty.
+	Repositories []string `mapstructure:"repositories"`
+
+	// Branches are the head branches of the class, wildcard patterns as understood by path.Match, e.g. release/*.
+	// Every branch matches when it is empty.
+	Branches []string `mapstructure:"branches"`
+}
+
+// Weight is the share of the capacity an owner or a repository gets relative to the others.
+type Weight struct {
+	// Name is an owner, e.g. octo-org, or a repository as owner/name.
+	Name string `mapstructure:"name"`
+
+	// Weight is the share. The owners and repositories without a weight have a weight of one.
+	Weight int `mapstructure:"weight"`
+}
+
+// Config is the configuration for the Scheduler.
+type Config struct {
+	// Classes are the priority classes, tried in order.
+	Classes []PriorityClass
+
+	// Weights are the weights of owners and repositories.
+	Weights []Weight
+}
+
+// Job is a job as the Scheduler sees it.
+type Job struct {
+	// Name identifies the job, e.g. the name of its runner.
+	Name string
+
+	// Repository is the full name of the repository of the job, e.g. owner/name.
+	Repository string
+
+	// Priority is the priority of the class of the job.
+	Priority int
+
+	// QueuedAt is when the job was queued.
+	QueuedAt time.Time
+}
+
+// owner returns the owner of the repository of the job.
+func (j *Job) owner() string {
+	owner, _, _ := strings.Cut(j.Repository, "/")
+	return owner
+}
+
+// Scheduler orders the jobs waiting for capacity. Higher priority classes go first. Within a class the jobs are
+// queued fairly: the next job is from the owner, and then the repository of that owner, with the fewest admitted jobs
+// relative to its weight, so that the jobs of one busy repository do not starve everyone else. The jobs of a
+// repository are taken in the order they were queued.
+//
+// The zero Scheduler queues fairly with equal weights and no priority classes.
+type Scheduler struct {
+	classes []PriorityClass
+
+	// weights are the weights by lower-case owner or repository name.
+	weights map[string]int
+}
+
+// NewScheduler creates a new Scheduler.
+func NewScheduler(cfg Config) (*Scheduler, error) {
+	s := &Scheduler{
+		classes: cfg.Classes,
+		weights: make(map[string]int, len(cfg.Weights)),
+	}
+
+	for i, c := range cfg.Classes {
+		if c.Name == "" {
+			return nil, fmt.Errorf("priority class %d has no name", i)
+		}
+		for _, pattern := range slices.Concat(c.Repositories, c.Branches) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("priority class %q: pattern %q: %w", c.Name, pattern, err)
+			}
+		}
+	}
+
+	for _, w := range cfg.Weights {
+		if w.Name == "" {
+			return nil, errors.New("weight has no name")
+		} else if w.Weight < 1 {
+			return nil, fmt.Errorf("weight of %q must be at least one", w.Name)
+		}
+		s.weights[strings.ToLower(w.Name)] = w.Weight
+	}
+
+	return s, nil
+}
+
+// Classify returns the priority class of a job of the repository for the branch, or nil if it belongs to none.
+func (s *Scheduler) Classify(repository, branch string) *PriorityClass {
+	for i := range s.classes {
+		c := &s.classes[i]
+		if matches(c.Repositories, repository) && matches(c.Branches, branch) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Order returns the queued jobs in the order they are to be admitted, given the jobs that already are.
+func (s *Scheduler) Order(queued, admitted []Job) []Job {
+	used := make(map[string]int)
+	for i := range admitted {
+		used[strings.ToLower(admitted[i].owner())]++
+		used[strings.ToLower(admitted[i].Repository)]++
+	}
+
+	pending := slices.Clone(queued)
+	slices.SortStableFunc(pending, func(a, b Job) int {
+		return cmp.Or(cmp.Compare(b.Priority, a.Priority), a.QueuedAt.Compare(b.QueuedAt), cmp.Compare(a.Name, b.Name))
+	})
+
+	order := make([]Job, 0, len(pending))
+	for len(pending) > 0 {
+		// Only the jobs of the highest priority left compete.
+		class := pending[:1]
+		for len(class) < len(pending) && pending[len(class)].Priority == pending[0].Priority {
+			class = pending[:len(class)+1]
+		}
+
+		owner := s.fairest(class, used, func(j *Job) string { return j.owner() })
+		repository := s.fairest(class, used, func(j *Job) string {
+			if !strings.EqualFold(j.owner(), owner) {
+				return ""
+			}
+			return j.Repository
+		})
+
+		// The jobs are sorted by when they were queued, so the first job of the repository is its earliest.
+		next := slices.IndexFunc(class, func(j Job) bool { return strings.EqualFold(j.Repository, repository) })
+		if next < 0 {
+			// No job of the class has a key to be fair on, so the earliest goes.
+			next = 0
+		}
+		job := pending[next]
+		pending = slices.Delete(pending, next, next+1)
+
+		order = append(order, job)
+		used[strings.ToLower(job.owner())]++
+		used[strings.ToLower(job.Repository)]++
+	}
+
+	return order
+}
+
+// fairest returns the key of the jobs, owner or repository, that has the fewest admitted jobs relative to its weight.
+// Ties go to the key with the earliest job. Jobs whose key is empty are left out.
+func (s *Scheduler) fairest(jobs []Job, used map[string]int, key func(*Job) string) string {
+	best := ""
+	for i := range jobs {
+		k := key(&jobs[i])
+		if k == "" || strings.EqualFold(k, best) {
+			continue
+		}
+
+		// a/wa < b/wb without leaving the integers.
+		if best == "" || used[strings.ToLower(k)]*s.weight(best) < used[strings.ToLower(best)]*s.weight(k) {
+			best = k
+		}
+	}
+	return best
+}
+
+// weight returns the weight of the owner or repository.
+func (s *Scheduler) weight(name string) int {
+	if w, ok := s.weights[strings.ToLower(name)]; ok {
+		return w
+	}
+	return 1
+}
+
+// matches returns true if the value matches one of the patterns, or if there are none. Patterns are matched
+// case-insensitively.
+func matches(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value)); ok {
+			return true
+		}
+	}
+	return false
+}
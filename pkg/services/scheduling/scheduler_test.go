@@ -0,0 +1,124 @@
+package scheduling
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// jobs returns a job for every repository, queued a second apart in the given order.
+func jobs(repositories ...string) []Job {
+	js := make([]Job, len(repositories))
+	for i, repo := range repositories {
+		js[i] = Job{Name: string(rune('a' + i)), Repository: repo, QueuedAt: start.Add(time.Duration(i) * time.Second)}
+	}
+	return js
+}
+
+// names returns the names of the jobs.
+func names(js []Job) string {
+	out := ""
+	for _, j := range js {
+		out += j.Name
+	}
+	return out
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		queued   []Job
+		admitted []Job
+		want     string
+	}{
+		{
+			name:   "single repository is first come first served",
+			queued: jobs("octo/mono", "octo/mono", "octo/mono"),
+			want:   "abc",
+		},
+		{
+			name:   "owners take turns",
+			queued: jobs("octo/mono", "octo/mono", "octo/mono", "other/app", "other/app"),
+			want:   "adbec",
+		},
+		{
+			name:   "repositories of an owner take turns",
+			queued: jobs("octo/mono", "octo/mono", "octo/app"),
+			want:   "acb",
+		},
+		{
+			name:     "admitted jobs count",
+			queued:   jobs("octo/mono", "other/app"),
+			admitted: jobs("octo/mono", "octo/mono"),
+			want:     "ba",
+		},
+		{
+			name:   "weights",
+			cfg:    Config{Weights: []Weight{{Name: "Octo", Weight: 2}}},
+			queued: jobs("octo/mono", "octo/mono", "octo/mono", "other/app", "other/app"),
+			want:   "adbce",
+		},
+		{
+			name: "priority goes first",
+			queued: func() []Job {
+				js := jobs("octo/mono", "octo/mono", "other/app")
+				js[1].Priority = 10
+				return js
+			}(),
+			want: "bca",
+		},
+		{
+			name:   "jobs without a repository",
+			queued: jobs("", "octo/mono", ""),
+			want:   "bac",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewScheduler(tt.cfg)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, names(s.Order(tt.queued, tt.admitted)))
+		})
+	}
+}
+
+func TestZeroSchedulerIsFair(t *testing.T) {
+	require.Equal(t, "acb", names(new(Scheduler).Order(jobs("octo/mono", "octo/mono", "other/app"), nil)))
+}
+
+func TestClassify(t *testing.T) {
+	s, err := NewScheduler(Config{Classes: []PriorityClass{
+		{Name: "release", Priority: 100, Branches: []string{"release/*", "main"}},
+		{Name: "infra", Priority: 50, Repositories: []string{"octo/infra-*"}},
+	}})
+	require.NoError(t, err)
+
+	require.Equal(t, "release", s.Classify("octo/mono", "release/1.2").Name)
+	require.Equal(t, "release", s.Classify("octo/infra-dns", "main").Name, "the first matching class wins")
+	require.Equal(t, "infra", s.Classify("Octo/Infra-DNS", "feature").Name)
+	require.Nil(t, s.Classify("octo/mono", "feature/release/1"))
+}
+
+func TestNewSchedulerValidates(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "class without name", cfg: Config{Classes: []PriorityClass{{Priority: 1}}}},
+		{name: "bad pattern", cfg: Config{Classes: []PriorityClass{{Name: "a", Branches: []string{"[a"}}}}},
+		{name: "zero weight", cfg: Config{Weights: []Weight{{Name: "octo"}}}},
+		{name: "weight without name", cfg: Config{Weights: []Weight{{Weight: 2}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewScheduler(tt.cfg)
+			require.Error(t, err)
+		})
+	}
+}